@@ -0,0 +1,358 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/mailer"
+	"github.com/amosehiguese/ecommerce-api/pkg/utils"
+	"github.com/amosehiguese/ecommerce-api/pkg/validator"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// currentUser loads the caller of the request. It answers and returns nil
+// when that fails.
+func (api *API) currentUser(ctx context.Context, c *gin.Context) *query.User {
+	principal := auth.CurrentPrincipal(c)
+
+	u, err := api.Q.GetUserByID(ctx, principal.UserID)
+	if err != nil {
+		logger.Get().Error("Error fetching current user", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the request"})
+		return nil
+	}
+	if u == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": true, "msg": "unauthorized"})
+		return nil
+	}
+	return u
+}
+
+// confirmPassword checks the password a user re-entered to confirm a
+// sensitive change. Wrong passwords count as failed logins, so that a stolen
+// access token cannot be used to guess the password.
+func (api *API) confirmPassword(ctx context.Context, c *gin.Context, u *query.User, password string) bool {
+	account, client := loginAccount(u.Email), c.ClientIP()
+	if !api.checkLoginLockout(ctx, c, account, client) {
+		return false
+	}
+	if !u.ComparePasswordHash(password) {
+		api.recordLoginFailure(ctx, account, client)
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "current password is incorrect"})
+		return false
+	}
+	api.recordLoginSuccess(ctx, account)
+	return true
+}
+
+// GetProfile godoc
+// @Summary Get the current user
+// @Description Retrieve the account of the logged in user
+// @Tags account
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} gin.H{"error": false, "user": query.User}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/me [get]
+func (api *API) GetProfile(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	u := api.currentUser(ctx, c)
+	if u == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"error": false, "user": u})
+}
+
+// UpdateProfile godoc
+// @Summary Update the current user
+// @Description Change the first or last name of the logged in user. Omitted fields stay the same; an empty last name removes it.
+// @Tags account
+// @Accept json
+// @Produce json
+// @Param profile body payload.UpdateProfilePayload true "Names to change"
+// @Security BearerAuth
+// @Success 200 {object} gin.H{"error": false, "user": query.User}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/me [patch]
+func (api *API) UpdateProfile(c *gin.Context) {
+	log := logger.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var profilePayload payload.UpdateProfilePayload
+	if err := c.ShouldBindJSON(&profilePayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(profilePayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	u := api.currentUser(ctx, c)
+	if u == nil {
+		return
+	}
+
+	if profilePayload.FirstName != nil {
+		u.FirstName = strings.TrimSpace(*profilePayload.FirstName)
+	}
+	if profilePayload.LastName != nil {
+		u.LastName = nil
+		if lastName := strings.TrimSpace(*profilePayload.LastName); lastName != "" {
+			u.LastName = &lastName
+		}
+	}
+	if len(u.FirstName) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "first name must be at least 2 characters long"})
+		return
+	}
+
+	if err := api.Q.UpdateUserNames(ctx, u.ID, u.FirstName, u.LastName); err != nil {
+		log.Error("Error updating profile", zap.String("user_id", u.ID.String()), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to update profile"})
+		return
+	}
+
+	log.Info("Profile updated", zap.String("user_id", u.ID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "user": u})
+}
+
+// ChangeEmail godoc
+// @Summary Change the email address
+// @Description Change the email of the logged in user after checking their password. The new address must be verified again through the link emailed to it, and the previous address is told about the change.
+// @Tags account
+// @Accept json
+// @Produce json
+// @Param email body payload.ChangeEmailPayload true "New email and current password"
+// @Security BearerAuth
+// @Success 200 {object} gin.H{"error": false, "msg": "email changed, check your inbox to verify it"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 409 {object} gin.H{"error": true, "msg": "an account with this email already exists"}
+// @Failure 429 {object} gin.H{"error": true, "msg": "too many failed login attempts, try again later"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/me/email [post]
+func (api *API) ChangeEmail(c *gin.Context) {
+	log := logger.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var emailPayload payload.ChangeEmailPayload
+	if err := c.ShouldBindJSON(&emailPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(emailPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	u := api.currentUser(ctx, c)
+	if u == nil {
+		return
+	}
+	if !api.confirmPassword(ctx, c, u, emailPayload.Password) {
+		return
+	}
+
+	email := strings.TrimSpace(emailPayload.Email)
+	if email == u.Email {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "this is already your email address"})
+		return
+	}
+
+	if err := api.Q.ChangeUserEmail(ctx, u.ID, email); err != nil {
+		if errors.Is(err, query.ErrEmailTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": true, "msg": err.Error()})
+			return
+		}
+		log.Error("Error changing email", zap.String("user_id", u.ID.String()), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to change email"})
+		return
+	}
+
+	previous := u.Email
+	u.Email, u.EmailVerifiedAt = email, nil
+
+	// The change is made either way; a lost email can be sent again.
+	if err := api.sendVerificationEmail(ctx, u); err != nil {
+		log.Error("Error sending verification email", zap.String("user_id", u.ID.String()), zap.Error(err))
+	}
+	notice := mailer.Message{
+		To:      previous,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe email address of your account was changed to %s. "+
+			"If you did not make this change, please contact support right away.\n",
+			u.FirstName, email),
+	}
+	if err := api.Mailer.Send(ctx, notice); err != nil {
+		log.Error("Error sending email change notice", zap.String("user_id", u.ID.String()), zap.Error(err))
+	}
+
+	log.Info("Email changed", zap.String("user_id", u.ID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "email changed, check your inbox to verify it"})
+}
+
+// ChangePassword godoc
+// @Summary Change the password
+// @Description Change the password of the logged in user after checking the current one. Every session of the account is ended.
+// @Tags account
+// @Accept json
+// @Produce json
+// @Param password body payload.ChangePasswordPayload true "Current and new password"
+// @Security BearerAuth
+// @Success 200 {object} gin.H{"error": false, "msg": "password changed, please log in again"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 429 {object} gin.H{"error": true, "msg": "too many failed login attempts, try again later"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/me/password [post]
+func (api *API) ChangePassword(c *gin.Context) {
+	log := logger.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var passwordPayload payload.ChangePasswordPayload
+	if err := c.ShouldBindJSON(&passwordPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(passwordPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	if err := api.Passwords.Check(passwordPayload.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	u := api.currentUser(ctx, c)
+	if u == nil {
+		return
+	}
+	if !api.confirmPassword(ctx, c, u, passwordPayload.CurrentPassword) {
+		return
+	}
+
+	passwordHash, err := utils.HashPassword(passwordPayload.NewPassword)
+	if err != nil {
+		log.Error("Error hashing new password", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to change password"})
+		return
+	}
+
+	if err := api.Q.ChangeUserPassword(ctx, u.ID, passwordHash); err != nil {
+		log.Error("Error changing password", zap.String("user_id", u.ID.String()), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to change password"})
+		return
+	}
+
+	// Whoever knew the old password may still hold a session.
+	if err := api.Sessions.RevokeUser(ctx, u.ID); err != nil {
+		log.Error("Error revoking sessions after password change", zap.String("user_id", u.ID.String()), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "password was changed but existing sessions could not be ended"})
+		return
+	}
+	auth.InvalidateTokenCookies(c)
+
+	log.Info("Password changed", zap.String("user_id", u.ID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "password changed, please log in again"})
+}
+
+// DeleteAccount godoc
+// @Summary Delete the current user
+// @Description Delete the account of the logged in user after checking their password. Personal data is removed, while orders are kept, without it, for accounting. Every session of the account is ended. The last active admin cannot delete their account.
+// @Tags account
+// @Accept json
+// @Produce json
+// @Param account body payload.DeleteAccountPayload true "Current password"
+// @Security BearerAuth
+// @Success 200 {object} gin.H{"error": false, "msg": "account deleted"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 409 {object} gin.H{"error": true, "msg": "the last active admin cannot be demoted or suspended"}
+// @Failure 429 {object} gin.H{"error": true, "msg": "too many failed login attempts, try again later"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/me [delete]
+func (api *API) DeleteAccount(c *gin.Context) {
+	log := logger.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var deletePayload payload.DeleteAccountPayload
+	if err := c.ShouldBindJSON(&deletePayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(deletePayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	u := api.currentUser(ctx, c)
+	if u == nil {
+		return
+	}
+	if !api.confirmPassword(ctx, c, u, deletePayload.Password) {
+		return
+	}
+
+	if err := api.Q.DeleteUser(ctx, u.ID); err != nil {
+		if errors.Is(err, query.ErrLastAdmin) {
+			c.JSON(http.StatusConflict, gin.H{"error": true, "msg": err.Error()})
+			return
+		}
+		log.Error("Error deleting account", zap.String("user_id", u.ID.String()), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to delete account"})
+		return
+	}
+
+	api.audit(c, AuditUserDelete, "user", u.ID.String(), nil)
+
+	if err := api.Sessions.RevokeUser(ctx, u.ID); err != nil {
+		log.Error("Error revoking sessions of deleted account", zap.String("user_id", u.ID.String()), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "account was deleted but existing sessions could not be ended"})
+		return
+	}
+	auth.InvalidateTokenCookies(c)
+
+	log.Info("Account deleted", zap.String("user_id", u.ID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "account deleted"})
+}
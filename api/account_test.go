@@ -0,0 +1,134 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/pkg/mailer"
+	"github.com/amosehiguese/ecommerce-api/routes"
+	"github.com/amosehiguese/ecommerce-api/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountSelfService(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	outbox := mailer.NewMemoryMailer()
+	router := routes.SetUp(ta.DB, config.Get(), routes.WithMailer(outbox))
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	const email, newEmail = "self@example.com", "renamed@example.com"
+	login := payload.LoginPayload{Email: email, Password: "password123"}
+	w := postJSON(router, "/api/auth/register", payload.RegisterPayload{FirstName: "Self", Email: email, Password: login.Password})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var res struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+		User struct {
+			ID              string  `json:"id"`
+			FirstName       string  `json:"first_name"`
+			LastName        *string `json:"last_name"`
+			Email           string  `json:"email"`
+			EmailVerifiedAt *string `json:"email_verified_at"`
+		} `json:"user"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		res.User.LastName, res.User.EmailVerifiedAt = nil, nil
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	}
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, createJSONRequestBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+res.Tokens.Access)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w = postJSON(router, "/api/auth/login", login)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+
+	w = send("PATCH", "/api/me", map[string]string{"last_name": "Service"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("GET", "/api/me", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, "Self", res.User.FirstName)
+	require.NotNil(t, res.User.LastName)
+	assert.Equal(t, "Service", *res.User.LastName)
+
+	// A reset link sent to the previous address stops working with the change
+	w = postJSON(router, "/api/auth/password/forgot", payload.ForgotPasswordPayload{Email: email})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Eventually(t, func() bool {
+		msg, ok := outbox.Last(email)
+		return ok && strings.Contains(msg.Body, "reset-password")
+	}, 5*time.Second, 10*time.Millisecond)
+	resetToken := lastEmailToken(t, outbox, email)
+
+	// Changing the email needs the password and a new verification
+	w = send("POST", "/api/me/email", payload.ChangeEmailPayload{Email: newEmail, Password: "wrongpassword"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = send("POST", "/api/me/email", payload.ChangeEmailPayload{Email: newEmail, Password: login.Password})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	_, notified := outbox.Last(email)
+	assert.True(t, notified, "the previous address is told about the change")
+
+	w = send("GET", "/api/me", nil)
+	decode(w)
+	assert.Equal(t, newEmail, res.User.Email)
+	assert.Nil(t, res.User.EmailVerifiedAt)
+
+	w = send("GET", "/api/auth/verify?token="+lastEmailToken(t, outbox, newEmail), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = postJSON(router, "/api/auth/password/reset", payload.ResetPasswordPayload{Token: resetToken, Password: "hijacked123"})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "reset links sent to the previous address are invalidated")
+
+	// Changing the password ends every session
+	w = send("POST", "/api/me/password", payload.ChangePasswordPayload{CurrentPassword: login.Password, NewPassword: "newpassword456"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("GET", "/api/me", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	login = payload.LoginPayload{Email: newEmail, Password: "newpassword456"}
+	w = postJSON(router, "/api/auth/login", login)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	userID := res.User.ID
+
+	w = send("DELETE", "/api/me", payload.DeleteAccountPayload{Password: login.Password})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = postJSON(router, "/api/auth/login", login)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// The row stays for the orders that point at it, without personal data
+	var firstName, storedEmail string
+	var lastName *string
+	require.NoError(t, ta.DB.QueryRow(`SELECT first_name, last_name, email FROM "user" WHERE id = $1`, userID).
+		Scan(&firstName, &lastName, &storedEmail))
+	assert.Equal(t, "Deleted", firstName)
+	assert.Nil(t, lastName)
+	assert.NotContains(t, storedEmail, "renamed")
+}
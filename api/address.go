@@ -0,0 +1,306 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/address"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/validator"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ListAddresses godoc
+// @Summary List addresses
+// @Description Retrieve the address book of the logged in user, default addresses first
+// @Tags account
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} gin.H{"error": false, "addresses": []query.Address}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/me/addresses [get]
+func (api *API) ListAddresses(c *gin.Context) {
+	log := logger.Get()
+
+	principal := auth.CurrentPrincipal(c)
+
+	addresses, err := api.Q.ListAddresses(c, principal.UserID)
+	if err != nil {
+		log.Error("Error retrieving addresses", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"error": false, "addresses": addresses})
+}
+
+// GetAddress godoc
+// @Summary Get an address
+// @Description Retrieve an address of the logged in user's address book
+// @Tags account
+// @Produce json
+// @Param id path string true "Address ID"
+// @Security BearerAuth
+// @Success 200 {object} gin.H{"error": false, "address": query.Address}
+// @Failure 400 {object} gin.H{"error": true, "msg": "invalid address id"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 404 {object} gin.H{"error": true, "msg": "address not found"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/me/addresses/{id} [get]
+func (api *API) GetAddress(c *gin.Context) {
+	log := logger.Get()
+
+	principal := auth.CurrentPrincipal(c)
+
+	addressID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid address id"})
+		return
+	}
+
+	a, err := api.Q.GetAddress(c, principal.UserID, addressID)
+	if err != nil {
+		log.Error("Error retrieving address", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+	if a == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": query.ErrAddressNotFound.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"error": false, "address": a})
+}
+
+// CreateAddress godoc
+// @Summary Add an address
+// @Description Add an address to the logged in user's address book. The first address becomes the default shipping and billing address; a later one does when it is saved as a default.
+// @Tags account
+// @Accept json
+// @Produce json
+// @Param address body payload.AddressPayload true "Address"
+// @Security BearerAuth
+// @Success 200 {object} gin.H{"error": false, "address": query.Address}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 409 {object} gin.H{"error": true, "msg": "address book is full"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/me/addresses [post]
+func (api *API) CreateAddress(c *gin.Context) {
+	log := logger.Get()
+
+	principal := auth.CurrentPrincipal(c)
+
+	a, ok := bindAddress(c)
+	if !ok {
+		return
+	}
+	a.ID = uuid.New()
+	a.UserID = principal.UserID
+	a.CreatedAt = time.Now()
+	a.UpdatedAt = a.CreatedAt
+
+	if err := api.Q.CreateAddress(c, a); err != nil {
+		respondAddressError(c, err)
+		return
+	}
+
+	log.Info("Address created", zap.String("address_id", a.ID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "address": a})
+}
+
+// UpdateAddress godoc
+// @Summary Update an address
+// @Description Replace an address of the logged in user's address book. Orders already placed keep the address they were placed with.
+// @Tags account
+// @Accept json
+// @Produce json
+// @Param id path string true "Address ID"
+// @Param address body payload.AddressPayload true "Address"
+// @Security BearerAuth
+// @Success 200 {object} gin.H{"error": false, "address": query.Address}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 404 {object} gin.H{"error": true, "msg": "address not found"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/me/addresses/{id} [put]
+func (api *API) UpdateAddress(c *gin.Context) {
+	log := logger.Get()
+
+	principal := auth.CurrentPrincipal(c)
+
+	addressID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid address id"})
+		return
+	}
+
+	a, ok := bindAddress(c)
+	if !ok {
+		return
+	}
+	a.ID = addressID
+	a.UserID = principal.UserID
+	a.UpdatedAt = time.Now()
+
+	if err := api.Q.UpdateAddress(c, a); err != nil {
+		respondAddressError(c, err)
+		return
+	}
+
+	log.Info("Address updated", zap.String("address_id", a.ID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "address": a})
+}
+
+// DeleteAddress godoc
+// @Summary Delete an address
+// @Description Remove an address from the logged in user's address book. Orders already placed keep the address they were placed with.
+// @Tags account
+// @Param id path string true "Address ID"
+// @Security BearerAuth
+// @Success 200 {object} gin.H{"error": false, "msg": "address deleted"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "invalid address id"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 404 {object} gin.H{"error": true, "msg": "address not found"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/me/addresses/{id} [delete]
+func (api *API) DeleteAddress(c *gin.Context) {
+	log := logger.Get()
+
+	principal := auth.CurrentPrincipal(c)
+
+	addressID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid address id"})
+		return
+	}
+
+	if err := api.Q.DeleteAddress(c, principal.UserID, addressID); err != nil {
+		respondAddressError(c, err)
+		return
+	}
+
+	log.Info("Address deleted", zap.String("address_id", addressID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "address deleted"})
+}
+
+// bindAddress reads and validates an address from the request body. It
+// answers and returns false when the address is invalid.
+func bindAddress(c *gin.Context) (*query.Address, bool) {
+	var addressPayload payload.AddressPayload
+	if err := c.ShouldBindJSON(&addressPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return nil, false
+	}
+	addressPayload.Country = strings.ToUpper(strings.TrimSpace(addressPayload.Country))
+	addressPayload.PostalCode = address.NormalizePostalCode(addressPayload.PostalCode)
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(addressPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return nil, false
+	}
+
+	if err := address.ValidatePostalCode(addressPayload.Country, addressPayload.PostalCode); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return nil, false
+	}
+
+	return &query.Address{
+		Label: optionalString(addressPayload.Label),
+		PostalAddress: query.PostalAddress{
+			FullName:   strings.TrimSpace(addressPayload.FullName),
+			Company:    optionalString(addressPayload.Company),
+			Line1:      strings.TrimSpace(addressPayload.Line1),
+			Line2:      optionalString(addressPayload.Line2),
+			City:       strings.TrimSpace(addressPayload.City),
+			Region:     optionalString(addressPayload.Region),
+			PostalCode: addressPayload.PostalCode,
+			Country:    addressPayload.Country,
+			Phone:      optionalString(addressPayload.Phone),
+		},
+		DefaultShipping: addressPayload.DefaultShipping,
+		DefaultBilling:  addressPayload.DefaultBilling,
+	}, true
+}
+
+// optionalString returns nil for a blank string.
+func optionalString(s string) *string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// orderAddresses picks the shipping and billing addresses of a new order from
+// the user's address book: the chosen ones, else the defaults. Billing falls
+// back to the shipping address. It answers and returns false when no shipping
+// address can be found.
+func (api *API) orderAddresses(c *gin.Context, userID uuid.UUID, shippingID, billingID *uuid.UUID) (shipping, billing *query.PostalAddress, ok bool) {
+	defaultShipping, defaultBilling, err := api.Q.GetDefaultAddresses(c, userID)
+	if err != nil {
+		logger.Get().Error("Error retrieving default addresses", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the request"})
+		return nil, nil, false
+	}
+
+	pick := func(id *uuid.UUID, fallback *query.Address) (*query.PostalAddress, bool) {
+		a := fallback
+		if id != nil {
+			var err error
+			a, err = api.Q.GetAddress(c, userID, *id)
+			if err != nil {
+				logger.Get().Error("Error retrieving address", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the request"})
+				return nil, false
+			}
+			if a == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": query.ErrAddressNotFound.Error()})
+				return nil, false
+			}
+		}
+		if a == nil {
+			return nil, true
+		}
+		return &a.PostalAddress, true
+	}
+
+	if shipping, ok = pick(shippingID, defaultShipping); !ok {
+		return nil, nil, false
+	}
+	if shipping == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "add a shipping address to your address book before placing orders"})
+		return nil, nil, false
+	}
+	if billing, ok = pick(billingID, defaultBilling); !ok {
+		return nil, nil, false
+	}
+	if billing == nil {
+		billing = shipping
+	}
+	return shipping, billing, true
+}
+
+func respondAddressError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, query.ErrAddressNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": err.Error()})
+	case errors.Is(err, query.ErrAddressLimit):
+		c.JSON(http.StatusConflict, gin.H{"error": true, "msg": err.Error()})
+	default:
+		logger.Get().Error("Error saving address", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+	}
+}
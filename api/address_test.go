@@ -0,0 +1,137 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/amosehiguese/ecommerce-api/routes"
+	"github.com/amosehiguese/ecommerce-api/server"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddressBookAndOrderSnapshots(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	router := routes.SetUp(ta.DB, config.Get())
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	productID := uuid.New()
+	_, err = ta.DB.Exec(`
+		INSERT INTO "product" (id, name, description, price, units_in_stock)
+		VALUES ($1, 'Lamp', 'A desk lamp', 25.00, 10)
+	`, productID)
+	require.NoError(t, err)
+	_, err = ta.DB.Exec(`
+		INSERT INTO "product_variant" (product_id, sku, units_in_stock, is_default)
+		VALUES ($1, 'LAMP', 10, TRUE)
+	`, productID)
+	require.NoError(t, err)
+
+	login := payload.LoginPayload{Email: "shopper@example.com", Password: "password123"}
+	w := postJSON(router, "/api/auth/register", payload.RegisterPayload{FirstName: "Shopper", Email: login.Email, Password: login.Password})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var res struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+		Address   query.Address   `json:"address"`
+		Addresses []query.Address `json:"addresses"`
+		Orders    []query.Order   `json:"orders"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	}
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, createJSONRequestBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+res.Tokens.Access)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w = postJSON(router, "/api/auth/login", login)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+
+	order := payload.OrderPayload{Items: []payload.OrderItemPayload{{ProductID: productID, Quantity: 1}}}
+	w = send("POST", "/api/orders", order)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "orders need a shipping address")
+
+	home := payload.AddressPayload{
+		FullName:   "Shopper Home",
+		Line1:      "10 Downing Street",
+		City:       "London",
+		PostalCode: "sw1a 2aa",
+		Country:    "gb",
+	}
+	w = send("POST", "/api/me/addresses", payload.AddressPayload{FullName: "X", Line1: "1 Road", City: "Berlin", PostalCode: "1234", Country: "DE"})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "German postal codes have five digits")
+
+	w = send("POST", "/api/me/addresses", home)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	homeID := res.Address.ID
+	assert.Equal(t, "SW1A 2AA", res.Address.PostalCode)
+	assert.True(t, res.Address.DefaultShipping, "the first address becomes the default")
+	assert.True(t, res.Address.DefaultBilling)
+
+	office := payload.AddressPayload{
+		FullName:        "Shopper Office",
+		Line1:           "1 Infinite Loop",
+		City:            "Cupertino",
+		Region:          "CA",
+		PostalCode:      "95014",
+		Country:         "US",
+		DefaultShipping: true,
+	}
+	w = send("POST", "/api/me/addresses", office)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	officeID := res.Address.ID
+
+	w = send("GET", "/api/me/addresses", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	require.Len(t, res.Addresses, 2)
+	assert.Equal(t, officeID, res.Addresses[0].ID, "the new default shipping address comes first")
+	assert.False(t, res.Addresses[1].DefaultShipping)
+	assert.True(t, res.Addresses[1].DefaultBilling)
+
+	w = send("POST", "/api/orders", order)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Editing and deleting addresses leaves the order as it was placed
+	office.Line1 = "1 Apple Park Way"
+	w = send("PUT", "/api/me/addresses/"+officeID.String(), office)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("DELETE", "/api/me/addresses/"+homeID.String(), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = send("GET", "/api/orders", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	require.Len(t, res.Orders, 1)
+	require.NotNil(t, res.Orders[0].ShippingAddress)
+	require.NotNil(t, res.Orders[0].BillingAddress)
+	assert.Equal(t, "1 Infinite Loop", res.Orders[0].ShippingAddress.Line1)
+	assert.Equal(t, "10 Downing Street", res.Orders[0].BillingAddress.Line1)
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/validator"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// errAccountSuspended is the answer to a suspended account trying to log in.
+var errAccountSuspended = errors.New("this account has been suspended")
+
+// ListUsers godoc
+// @Summary List users
+// @Description Retrieve one page of user accounts, newest first, optionally searched by name or email and filtered by role or status. Pass next_cursor back as cursor to fetch the following page.
+// @Tags admin
+// @Param limit query int false "Page size (1-100, default 20)"
+// @Param cursor query string false "Cursor from the previous page"
+// @Param q query string false "Name or email contains"
+// @Param role query string false "Role name"
+// @Param status query string false "active, suspended or deleted"
+// @Security CookieAuth
+// @Success 200 {object} gin.H{"error": false, "users": []query.UserSummary, "next_cursor": "cursor"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/admin/users [get]
+func (api *API) ListUsers(c *gin.Context) {
+	log := logger.Get()
+
+	var listQuery payload.UserListQuery
+	if err := c.ShouldBindQuery(&listQuery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(listQuery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	page, err := api.Q.ListUsers(c, query.UserFilter{
+		Search: listQuery.Q,
+		Role:   listQuery.Role,
+		Status: query.UserStatus(listQuery.Status),
+		Limit:  listQuery.Limit,
+		Cursor: listQuery.Cursor,
+	})
+	if err != nil {
+		if errors.Is(err, query.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+			return
+		}
+		log.Error("Error retrieving users", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"error": false, "users": page.Users, "next_cursor": page.NextCursor})
+}
+
+// GetUser godoc
+// @Summary Get a user
+// @Description Retrieve a user account, including its role and suspension
+// @Tags admin
+// @Param id path string true "User ID"
+// @Security CookieAuth
+// @Success 200 {object} gin.H{"error": false, "user": query.UserSummary}
+// @Failure 400 {object} gin.H{"error": true, "msg": "invalid user id"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 404 {object} gin.H{"error": true, "msg": "user not found"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/admin/users/{id} [get]
+func (api *API) GetUser(c *gin.Context) {
+	log := logger.Get()
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid user id"})
+		return
+	}
+
+	u, err := api.Q.GetUserSummary(c, userID)
+	if err != nil {
+		log.Error("Error retrieving user", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+	if u == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": "user not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"error": false, "user": u})
+}
+
+// SuspendUser godoc
+// @Summary Suspend a user
+// @Description Suspend an account and end every session of it. A suspended account cannot log in until the suspension is lifted. Admins cannot suspend themselves or the last active admin.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param suspension body payload.SuspendUserPayload false "Reason for the suspension"
+// @Security CookieAuth
+// @Success 200 {object} gin.H{"error": false, "msg": "user suspended"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 404 {object} gin.H{"error": true, "msg": "user not found"}
+// @Failure 409 {object} gin.H{"error": true, "msg": "user is already suspended"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/admin/users/{id}/suspend [post]
+func (api *API) SuspendUser(c *gin.Context) {
+	log := logger.Get()
+
+	principal := auth.CurrentPrincipal(c)
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid user id"})
+		return
+	}
+	if userID == principal.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "you cannot suspend your own account"})
+		return
+	}
+
+	var suspendPayload payload.SuspendUserPayload
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&suspendPayload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+			return
+		}
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(suspendPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	if err := api.Q.SuspendUser(c, userID, suspendPayload.Reason); err != nil {
+		respondUserAdminError(c, err)
+		return
+	}
+
+	api.audit(c, AuditUserSuspend, "user", userID.String(), gin.H{"reason": suspendPayload.Reason})
+
+	if err := api.Sessions.RevokeUser(c, userID); err != nil {
+		log.Error("Error revoking sessions of suspended user", zap.String("user_id", userID.String()), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "user was suspended but existing sessions could not be ended"})
+		return
+	}
+
+	log.Info("User suspended",
+		zap.String("user_id", userID.String()),
+		zap.String("suspended_by", principal.UserID.String()),
+	)
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "user suspended"})
+}
+
+// UnsuspendUser godoc
+// @Summary Lift the suspension of a user
+// @Description Let a suspended account log in again
+// @Tags admin
+// @Param id path string true "User ID"
+// @Security CookieAuth
+// @Success 200 {object} gin.H{"error": false, "msg": "user suspension lifted"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "invalid user id"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 404 {object} gin.H{"error": true, "msg": "user not found"}
+// @Failure 409 {object} gin.H{"error": true, "msg": "user is not suspended"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/admin/users/{id}/unsuspend [post]
+func (api *API) UnsuspendUser(c *gin.Context) {
+	log := logger.Get()
+
+	principal := auth.CurrentPrincipal(c)
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid user id"})
+		return
+	}
+
+	if err := api.Q.UnsuspendUser(c, userID); err != nil {
+		respondUserAdminError(c, err)
+		return
+	}
+
+	api.audit(c, AuditUserUnsuspend, "user", userID.String(), nil)
+
+	log.Info("User suspension lifted",
+		zap.String("user_id", userID.String()),
+		zap.String("lifted_by", principal.UserID.String()),
+	)
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "user suspension lifted"})
+}
+
+func respondUserAdminError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, query.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": err.Error()})
+	case errors.Is(err, query.ErrUserSuspended), errors.Is(err, query.ErrNotSuspended), errors.Is(err, query.ErrLastAdmin):
+		c.JSON(http.StatusConflict, gin.H{"error": true, "msg": err.Error()})
+	default:
+		logger.Get().Error("Error updating user", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+	}
+}
@@ -0,0 +1,147 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/amosehiguese/ecommerce-api/routes"
+	"github.com/amosehiguese/ecommerce-api/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminBootstrapAndUserManagement(t *testing.T) {
+	// Set up the application with a setup token
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	cfg := *config.Get()
+	account := *cfg.Account
+	account.AdminSetupToken = strings.Repeat("s", 32)
+	cfg.Account = &account
+	router := routes.SetUp(ta.DB, &cfg)
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	member := payload.LoginPayload{Email: "member@example.com", Password: "password123"}
+	w := postJSON(router, "/api/auth/register", payload.RegisterPayload{FirstName: "Member", Email: member.Email, Password: member.Password})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	setup := payload.SetupAdminPayload{
+		SetupToken: "wrong",
+		FirstName:  "Root",
+		Email:      "root@example.com",
+		Password:   "rootpassword123",
+	}
+	w = postJSON(router, "/api/auth/setup-admin", setup)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	setup.SetupToken = account.AdminSetupToken
+	w = postJSON(router, "/api/auth/setup-admin", setup)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// The token only works once
+	setup.Email = "second@example.com"
+	w = postJSON(router, "/api/auth/setup-admin", setup)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	var res struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+		Users   []query.UserSummary `json:"users"`
+		Entries []query.AuditEntry  `json:"entries"`
+		Role    query.Role          `json:"role"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	}
+	send := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, createJSONRequestBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w = postJSON(router, "/api/auth/login", payload.LoginPayload{Email: "root@example.com", Password: setup.Password})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	admin, adminID := res.Tokens.Access, res.User.ID
+
+	w = postJSON(router, "/api/auth/login", member)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	memberToken := res.Tokens.Access
+
+	w = send("GET", "/api/admin/users", memberToken, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = send("GET", "/api/admin/users?q=MEMB", admin, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	require.Len(t, res.Users, 1)
+	memberID := res.Users[0].ID.String()
+
+	w = send("POST", "/api/admin/users/"+adminID+"/suspend", admin, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "admins cannot suspend themselves")
+	w = send("PUT", "/api/admin/users/"+adminID+"/role", admin, payload.UserRolePayload{Role: "user"})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "admins cannot change their own role")
+
+	w = send("POST", "/api/admin/users/"+memberID+"/suspend", admin, payload.SuspendUserPayload{Reason: "chargebacks"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = postJSON(router, "/api/auth/login", member)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = send("GET", "/api/cart", memberToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "suspension ends existing sessions")
+
+	w = send("GET", "/api/admin/users?status=suspended", admin, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	require.Len(t, res.Users, 1)
+	assert.Equal(t, memberID, res.Users[0].ID.String())
+
+	w = send("POST", "/api/admin/users/"+memberID+"/unsuspend", admin, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = postJSON(router, "/api/auth/login", member)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	memberToken = res.Tokens.Access
+
+	// Changing a role revokes the access tokens of its members
+	w = send("GET", "/api/cart", memberToken, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("PUT", "/api/admin/roles/user", admin, payload.RoleUpdatePayload{Permissions: []string{auth.OrderCreateCredential}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, []string{auth.OrderCreateCredential}, res.Role.Permissions)
+	w = send("GET", "/api/cart", memberToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "the member renews their token to get the new permissions")
+
+	w = send("GET", "/api/admin/audit-log?target_id="+memberID, admin, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	require.Len(t, res.Entries, 2)
+	assert.Equal(t, "user.unsuspend", res.Entries[0].Action)
+	assert.Equal(t, "user.suspend", res.Entries[1].Action)
+	assert.Equal(t, adminID, res.Entries[1].ActorID.String())
+}
@@ -1,18 +1,37 @@
 package api
 
 import (
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
 	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/pkg/lockout"
+	"github.com/amosehiguese/ecommerce-api/pkg/mailer"
+	"github.com/amosehiguese/ecommerce-api/pkg/payment"
+	"github.com/amosehiguese/ecommerce-api/pkg/session"
+	"github.com/amosehiguese/ecommerce-api/pkg/utils"
 	"github.com/amosehiguese/ecommerce-api/query"
 )
 
 type API struct {
-	Q   query.Query
-	Cfg *config.Config
+	Q         query.Query
+	Cfg       *config.Config
+	Sessions  *session.Manager
+	Keys      *auth.Keyring
+	Mailer    mailer.Mailer
+	Lockout   *lockout.Guard
+	Passwords *utils.PasswordPolicy
+	Payments  payment.Provider
 }
 
-func NewAPI(q query.Query, cfg *config.Config) API {
+func NewAPI(q query.Query, cfg *config.Config, sessions *session.Manager, keys *auth.Keyring, m mailer.Mailer, lockout *lockout.Guard,
+	passwords *utils.PasswordPolicy, payments payment.Provider) API {
 	return API{
-		Q:   q,
-		Cfg: cfg,
+		Q:         q,
+		Cfg:       cfg,
+		Sessions:  sessions,
+		Keys:      keys,
+		Mailer:    m,
+		Lockout:   lockout,
+		Passwords: passwords,
+		Payments:  payments,
 	}
 }
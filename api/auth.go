@@ -2,12 +2,14 @@ package api
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/amosehiguese/ecommerce-api/api/payload"
 	"github.com/amosehiguese/ecommerce-api/pkg/auth"
 	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/session"
 	"github.com/amosehiguese/ecommerce-api/pkg/utils"
 	"github.com/amosehiguese/ecommerce-api/pkg/validator"
 	"github.com/amosehiguese/ecommerce-api/query"
@@ -18,7 +20,7 @@ import (
 
 // Register godoc
 // @Summary Register a new user
-// @Description Registers a new user with the provided details
+// @Description Registers a new user with the provided details and emails a link to verify the address
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -39,7 +41,7 @@ func (a *API) Register(c *gin.Context) {
 		})
 		return
 	}
-	registerPayload.Role = "user"
+	registerPayload.Role = auth.UserRole.String()
 
 	validate := validator.NewValidator()
 	if err := validate.Struct(registerPayload); err != nil {
@@ -50,11 +52,20 @@ func (a *API) Register(c *gin.Context) {
 		return
 	}
 
-	role, err := auth.VerifyRole(registerPayload.Role)
-	if err != nil {
+	if err := a.Passwords.Check(registerPayload.Password); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": true,
-			"msg":   validator.ValidatorErrors(err),
+			"msg":   err.Error(),
+		})
+		return
+	}
+
+	passwordHash, err := utils.HashPassword(registerPayload.Password)
+	if err != nil {
+		logger.Get().Error("Failed to hash password", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": true,
+			"msg":   "Unable to create user",
 		})
 		return
 	}
@@ -66,8 +77,8 @@ func (a *API) Register(c *gin.Context) {
 		FirstName:    registerPayload.FirstName,
 		LastName:     &registerPayload.LastName,
 		Email:        registerPayload.Email,
-		PasswordHash: utils.HashPassword(registerPayload.Password),
-		Role:         role.String(),
+		PasswordHash: passwordHash,
+		Role:         registerPayload.Role,
 	}
 
 	validate = validator.NewValidator()
@@ -90,6 +101,11 @@ func (a *API) Register(c *gin.Context) {
 
 	user.PasswordHash = ""
 
+	// The account exists either way; a lost email can be sent again.
+	if err := a.sendVerificationEmail(ctx, user); err != nil {
+		logger.Get().Error("Error sending verification email", zap.String("user_id", user.ID.String()), zap.Error(err))
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"error": false,
 		"msg":   "User created successfully",
@@ -99,13 +115,16 @@ func (a *API) Register(c *gin.Context) {
 
 // Login godoc
 // @Summary User Login
-// @Description Logs in an existing user and returns the access token
+// @Description Logs in an existing user and returns the access token. Accounts with two-factor authentication, and admins when it is mandatory for them, get a short-lived mfa_token instead, to be completed at /api/auth/login/mfa.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param loginPayload body payload.LoginPayload true "User Login Data"
 // @Success 200 {object} gin.H{"error": false, "tokens": {"access": "access_token"}, "user": {"id": "user_id", "email": "email", "role": "role"}}
-// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Success 202 {object} gin.H{"error": false, "mfa_required": true, "mfa_enrollment_required": false, "mfa_token": "challenge_token"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "Invalid credentials"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "this account has been suspended"}
+// @Failure 429 {object} gin.H{"error": true, "msg": "too many failed login attempts, try again later"}
 // @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
 // @Router /api/auth/login [post]
 func (api *API) Login(c *gin.Context) {
@@ -131,46 +150,76 @@ func (api *API) Login(c *gin.Context) {
 		return
 	}
 
-	u, err := api.Q.GetUserByEmail(ctx, loginPayload.Email)
-	if err != nil {
-		log.Error("user with the given email not found", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": true,
-			"msg":   "User with the given email is not found",
-		})
+	account, client := loginAccount(loginPayload.Email), c.ClientIP()
+	if !api.checkLoginLockout(ctx, c, account, client) {
 		return
 	}
 
-	role, err := auth.VerifyRole(u.Role)
+	u, err := api.Q.GetUserByEmail(ctx, loginPayload.Email)
 	if err != nil {
+		log.Error("Failed to fetch user for login", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": true,
+			"msg":   "Failed to generate tokens",
 		})
 		return
 	}
 
-	if !u.ComparePasswordHash(loginPayload.Password) {
-		log.Error("Password mismatch", zap.Error(err))
+	// Unknown emails cost as much as wrong passwords and get the same answer,
+	// so neither the response nor its timing tells which accounts exist.
+	if !checkPassword(u, loginPayload.Password) {
+		api.recordLoginFailure(ctx, account, client)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": true,
 			"msg":   "Invalid credentials",
 		})
 		return
 	}
+	api.recordLoginSuccess(ctx, account)
+
+	if u.SuspendedAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": true,
+			"msg":   errAccountSuspended.Error(),
+		})
+		return
+	}
+
+	if err := api.Q.SaveRehashedPassword(ctx, u); err != nil {
+		log.Error("Failed to save upgraded password hash", zap.String("user_id", u.ID.String()), zap.Error(err))
+	}
 
-	token, err := auth.GenerateNewToken(u.ID.String(), role)
+	mfa, err := api.Q.GetMFA(ctx, u.ID)
 	if err != nil {
-		log.Error("Failed to generate tokens", zap.Error(err))
+		log.Error("Failed to fetch two-factor settings", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": true,
 			"msg":   "Failed to generate tokens",
 		})
 		return
 	}
+	if mfa.Enabled() || api.mfaRequired(u) {
+		api.challengeMFA(c, u, deviceID(c, loginPayload.DeviceID), !mfa.Enabled())
+		return
+	}
 
-	auth.AttachToCookie(c, token.Refresh)
+	api.completeLogin(ctx, c, u, deviceID(c, loginPayload.DeviceID), nil)
+}
 
-	c.JSON(http.StatusOK, gin.H{
+// completeLogin issues tokens for u and answers the login request with them,
+// along with any extra fields.
+func (api *API) completeLogin(ctx context.Context, c *gin.Context, u *query.User, deviceID string, extra gin.H) {
+	token, err := api.issueTokens(ctx, c, u, deviceID)
+	if err != nil {
+		logger.Get().Error("Failed to generate tokens", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": true,
+			"msg":   "Failed to generate tokens",
+		})
+		return
+	}
+
+	res := gin.H{
 		"error": false,
 		"tokens": gin.H{
 			"access":  token.Access,
@@ -181,90 +230,103 @@ func (api *API) Login(c *gin.Context) {
 			"email": u.Email,
 			"role":  u.Role,
 		},
-	})
+	}
+	for k, v := range extra {
+		res[k] = v
+	}
+	c.JSON(http.StatusOK, res)
 }
 
-// CreateAdmin godoc
-// @Summary      Create an admin user
-// @Description  Creates a new admin user with a hashed password.
-// @Tags         Admin
-// @Accept       json
-// @Produce      json
-// @Param        admin body payload.RegisterPayload true "Admin creation payload"
-// @Success      200 {object} map[string]interface{} "Admin user created successfully"
-// @Failure      400 {object} map[string]interface{} "Invalid request data"
-// @Failure      500 {object} map[string]interface{} "Internal server error"
-// @Security     BearerAuth
-// @Router       api/auth/create-admin [post]
-func (api *API) CreateAdmin(c *gin.Context) {
-	log := logger.Get()
+// RenewTokens godoc
+// @Summary Renew tokens
+// @Description Exchanges a refresh token, from the body or the refresh cookie, for a new access token and a new refresh token. Each refresh token can be used once; presenting a used one revokes every token of that login.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param renew body Renew false "Refresh token"
+// @Success 200 {object} gin.H{"error": false, "tokens": {"access": "access_token", "refresh": "refresh_token"}}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "this account has been suspended"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/renew-token [post]
+func (api *API) RenewTokens(c *gin.Context) {
+	logger := logger.Get()
 
-	var adminPayload payload.RegisterPayload
-	if err := c.ShouldBindJSON(&adminPayload); err != nil {
-		log.Error("Invalid JSON for creating admin", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
-		return
-	}
-	adminPayload.Role = "admin"
+	renew := &Renew{}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(renew); err != nil {
+			logger.Error("Error binding JSON body", zap.String("error", err.Error()))
 
-	validate := validator.NewValidator()
-	if err := validate.Struct(adminPayload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": true,
+				"msg":   err.Error(),
+			})
+			return
+		}
+	}
+	if renew.RefreshToken == "" {
+		renew.RefreshToken, _ = c.Cookie("refresh")
+	}
+	if renew.RefreshToken == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": true,
-			"msg":   validator.ValidatorErrors(err),
+			"msg":   "refresh token is required",
 		})
 		return
 	}
 
-	role, err := auth.VerifyRole(adminPayload.Role)
+	refreshToken, record, err := api.Sessions.Rotate(c, renew.RefreshToken)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": true,
-			"msg":   validator.ValidatorErrors(err),
-		})
+		switch {
+		case errors.Is(err, session.ErrTokenReused):
+			logger.Warn("Refresh token reuse detected, token family revoked")
+			auth.InvalidateTokenCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": true,
+				"msg":   "unauthorized, refresh token was already used, please log in again",
+			})
+		case errors.Is(err, session.ErrTokenNotFound),
+			errors.Is(err, session.ErrTokenExpired),
+			errors.Is(err, session.ErrTokenRevoked):
+			logger.Warn("Unauthorized, session ended earlier", zap.Error(err))
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": true,
+				"msg":   "unauthorized, your session was ended earlier",
+			})
+		default:
+			logger.Error("Error rotating refresh token", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": true,
+				"msg":   err.Error(),
+			})
+		}
 		return
 	}
 
-	admin := &query.User{
-		ID:           uuid.New(),
-		UpdatedAt:    time.Now(),
-		CreatedAt:    time.Now(),
-		FirstName:    adminPayload.FirstName,
-		LastName:     &adminPayload.LastName,
-		Email:        adminPayload.Email,
-		PasswordHash: utils.HashPassword(adminPayload.Password),
-		Role:         role.String(),
-	}
+	userID := record.UserID
+	u, err := api.Q.GetUserByID(c, userID)
+	if err != nil || u == nil {
+		logger.Error("User not found", zap.String("user_id", userID.String()))
 
-	validate = validator.NewValidator()
-	if err := validate.Struct(admin); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
+		c.JSON(http.StatusNotFound, gin.H{
 			"error": true,
-			"msg":   validator.ValidatorErrors(err),
+			"msg":   "user with the given ID is not found",
 		})
 		return
 	}
-
-	admin, err = api.Q.CreateUser(c, admin)
-	if err != nil {
-		log.Error("Error creating admin user", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+	if u.SuspendedAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": true,
+			"msg":   errAccountSuspended.Error(),
+		})
 		return
 	}
 
-	admin.PasswordHash = ""
-
-	log.Info("Admin user created successfully", zap.String("username", admin.FirstName))
-	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "Admin user created successfully"})
-}
-
-func (api *API) RenewTokens(c *gin.Context) {
-	logger := logger.Get()
-	now := time.Now().Unix()
-
-	claims, err := auth.ExtractTokenMetadata(c)
+	accessToken, err := api.accessToken(c, u, record.FamilyID)
 	if err != nil {
-		logger.Error("Error extracting token metadata", zap.String("error", err.Error()))
+		logger.Error("Error generating new tokens", zap.String("error", err.Error()))
+
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": true,
 			"msg":   err.Error(),
@@ -272,104 +334,118 @@ func (api *API) RenewTokens(c *gin.Context) {
 		return
 	}
 
-	expiresAccessToken := claims.Exp
-
-	if now > expiresAccessToken {
-		logger.Warn("Access token expired", zap.Int64("expires_at", expiresAccessToken))
+	auth.AttachToCookie(c, refreshToken, record.ExpiresAt)
 
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": true,
-			"msg":   "unauthorized, check expiration time of your token",
-		})
-		return
-	}
+	// Log the successful token renewal
+	logger.Info("Token renewal successful", zap.String("user_id", userID.String()))
 
-	renew := &Renew{}
+	// Return tokens
+	c.JSON(http.StatusOK, gin.H{
+		"error": false,
+		"msg":   nil,
+		"tokens": gin.H{
+			"access":  accessToken,
+			"refresh": refreshToken,
+		},
+	})
+}
 
-	if err := c.ShouldBindJSON(renew); err != nil {
-		logger.Error("Error binding JSON body", zap.String("error", err.Error()))
+type Renew struct {
+	RefreshToken string `json:"refresh_token"`
+}
 
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": true,
-			"msg":   err.Error(),
-		})
-		return
+// issueTokens signs an access token and starts a new refresh token family for
+// a login, and sets the refresh cookie.
+func (api *API) issueTokens(ctx context.Context, c *gin.Context, u *query.User, deviceID string) (*auth.Token, error) {
+	refreshToken, record, err := api.Sessions.Issue(ctx, u.ID, deviceID)
+	if err != nil {
+		return nil, err
 	}
 
-	expiresRefreshToken, err := auth.ParseRefreshToken(renew.RefreshToken)
+	accessToken, err := api.accessToken(ctx, u, record.FamilyID)
 	if err != nil {
-		logger.Error("Error parsing refresh token", zap.String("error", err.Error()))
-
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": true,
-			"msg":   err.Error(),
-		})
-		return
+		return nil, err
 	}
 
-	if now < expiresRefreshToken {
-		userID := claims.UserID
+	auth.AttachToCookie(c, refreshToken, record.ExpiresAt)
+	return &auth.Token{Access: accessToken, Refresh: refreshToken}, nil
+}
 
-		u, err := api.Q.GetUserByID(c, userID)
-		if err != nil {
-			logger.Error("User not found", zap.String("user_id", userID.String()))
+// accessToken signs an access token carrying the current permissions of the
+// user's role.
+func (api *API) accessToken(ctx context.Context, u *query.User, sessionID uuid.UUID) (string, error) {
+	permissions, err := api.Q.GetRolePermissions(ctx, u.Role)
+	if err != nil {
+		return "", err
+	}
+	return auth.GenerateAccessToken(api.Keys, u.ID.String(), u.Role, permissions, sessionID)
+}
 
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": true,
-				"msg":   "user with the given ID is not found",
-			})
-			return
+// deviceID identifies the device a login comes from: the one named in the
+// request, else the X-Device-ID header.
+func deviceID(c *gin.Context, requested string) string {
+	if requested != "" {
+		return requested
+	}
+	if header := c.GetHeader("X-Device-ID"); header != "" {
+		if len(header) > 255 {
+			header = header[:255]
 		}
+		return header
+	}
+	return "default"
+}
 
-		role, err := auth.VerifyRole(u.Role)
-		if err != nil {
-			logger.Error("Error getting credentials by role", zap.String("role", u.Role), zap.String("error", err.Error()))
+// Logout godoc
+// @Summary Log out
+// @Description Ends the current session: revokes its refresh tokens, revokes the access token used for this request and clears the refresh cookie
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} gin.H{"error": false, "msg": "logged out"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/auth/logout [post]
+func (api *API) Logout(c *gin.Context) {
+	log := logger.Get()
 
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": true,
-				"msg":   err.Error(),
-			})
-			return
-		}
+	principal := auth.CurrentPrincipal(c)
 
-		tokens, err := auth.GenerateNewToken(userID.String(), role)
-		if err != nil {
-			logger.Error("Error generating new tokens", zap.String("error", err.Error()))
+	if err := api.Sessions.RevokeSession(c, principal.SessionID, principal.TokenID, principal.ExpiresAt); err != nil {
+		log.Error("Error revoking session", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
 
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": true,
-				"msg":   err.Error(),
-			})
-			return
-		}
+	auth.InvalidateTokenCookies(c)
 
-		// Create a new Redis connection
-		auth.AttachToCookie(c, tokens.Refresh)
+	log.Info("User logged out", zap.String("user_id", principal.UserID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "logged out"})
+}
 
-		// Log the successful token renewal
-		logger.Info("Token renewal successful", zap.String("user_id", userID.String()))
+// LogoutAll godoc
+// @Summary Log out everywhere
+// @Description Ends every session of the user: revokes all refresh tokens and every access token issued so far, and clears the refresh cookie
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} gin.H{"error": false, "msg": "logged out of all sessions"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/auth/logout-all [post]
+func (api *API) LogoutAll(c *gin.Context) {
+	log := logger.Get()
 
-		// Return tokens
-		c.JSON(http.StatusOK, gin.H{
-			"error": false,
-			"msg":   nil,
-			"tokens": gin.H{
-				"access":  tokens.Access,
-				"refresh": tokens.Refresh,
-			},
-		})
-	} else {
-		// Log the session ended error
-		logger.Warn("Unauthorized, session ended earlier")
+	principal := auth.CurrentPrincipal(c)
 
-		// Return status 401 and unauthorized error message
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": true,
-			"msg":   "unauthorized, your session was ended earlier",
-		})
+	if err := api.Sessions.RevokeUser(c, principal.UserID); err != nil {
+		log.Error("Error revoking sessions", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
 	}
-}
 
-type Renew struct {
-	RefreshToken string `json:"refresh_token"`
+	auth.InvalidateTokenCookies(c)
+
+	log.Info("User logged out of all sessions", zap.String("user_id", principal.UserID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "logged out of all sessions"})
 }
@@ -0,0 +1,289 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/validator"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// GetCart godoc
+// @Summary      View the cart
+// @Description  Retrieve the authenticated user's cart with live product prices
+// @Tags         Cart
+// @Produce      json
+// @Success      200 {object} map[string]interface{} "Cart retrieved successfully"
+// @Failure      401 {object} map[string]interface{} "Unauthorized, token expired"
+// @Failure      403 {object} map[string]interface{} "Permission denied"
+// @Failure      500 {object} map[string]interface{} "Internal server error"
+// @Router       /api/cart [get]
+func (api *API) GetCart(c *gin.Context) {
+	log := logger.Get()
+
+	principal := auth.CurrentPrincipal(c)
+
+	cart, err := api.Q.GetCart(c, principal.UserID)
+	if err != nil {
+		log.Error("Error fetching cart", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"error": false, "cart": cart})
+}
+
+// AddCartItem godoc
+// @Summary      Add an item to the cart
+// @Description  Add a product variant (the default variant if none is given) to the authenticated user's cart, increasing the quantity if it is already there
+// @Tags         Cart
+// @Accept       json
+// @Produce      json
+// @Param        cartItemPayload body payload.CartItemPayload true "Cart Item Payload"
+// @Success      200 {object} map[string]interface{} "Item added to cart"
+// @Failure      400 {object} map[string]interface{} "Invalid request body, validation error or too many units of the item"
+// @Failure      401 {object} map[string]interface{} "Unauthorized, token expired"
+// @Failure      403 {object} map[string]interface{} "Permission denied"
+// @Failure      404 {object} map[string]interface{} "Product not found"
+// @Failure      500 {object} map[string]interface{} "Internal server error"
+// @Router       /api/cart/items [post]
+func (api *API) AddCartItem(c *gin.Context) {
+	log := logger.Get()
+
+	principal := auth.CurrentPrincipal(c)
+
+	var cartItemPayload payload.CartItemPayload
+	if err := c.ShouldBindJSON(&cartItemPayload); err != nil {
+		log.Error("Invalid JSON for cart item", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(cartItemPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	// Lines without a variant refer to the product's default variant
+	variant, err := api.Q.ResolveVariant(c, cartItemPayload.ProductID, cartItemPayload.VariantID)
+	if err != nil {
+		log.Error("Error retrieving product variant", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+	if variant == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": "product variant not found"})
+		return
+	}
+
+	if err := api.Q.AddCartItem(c, principal.UserID, variant, cartItemPayload.Quantity); err != nil {
+		if errors.Is(err, query.ErrCartItemQuantity) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+			return
+		}
+		log.Error("Error adding cart item", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	log.Info("Item added to cart", zap.String("user_id", principal.UserID.String()), zap.String("variant_id", variant.ID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "Item added to cart"})
+}
+
+// UpdateCartItem godoc
+// @Summary      Change an item's quantity
+// @Description  Set the quantity of a variant already in the authenticated user's cart
+// @Tags         Cart
+// @Accept       json
+// @Produce      json
+// @Param        variant_id path string true "Variant ID"
+// @Param        cartItemUpdatePayload body payload.CartItemUpdatePayload true "Cart Item Update Payload"
+// @Success      200 {object} map[string]interface{} "Cart item updated"
+// @Failure      400 {object} map[string]interface{} "Invalid request body or validation error"
+// @Failure      401 {object} map[string]interface{} "Unauthorized, token expired"
+// @Failure      403 {object} map[string]interface{} "Permission denied"
+// @Failure      404 {object} map[string]interface{} "Item not in cart"
+// @Failure      500 {object} map[string]interface{} "Internal server error"
+// @Router       /api/cart/items/{variant_id} [patch]
+func (api *API) UpdateCartItem(c *gin.Context) {
+	log := logger.Get()
+
+	principal := auth.CurrentPrincipal(c)
+
+	variantID, err := uuid.Parse(c.Param("variant_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid variant id"})
+		return
+	}
+
+	var updatePayload payload.CartItemUpdatePayload
+	if err := c.ShouldBindJSON(&updatePayload); err != nil {
+		log.Error("Invalid JSON for cart item update", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(updatePayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	if err := api.Q.UpdateCartItemQuantity(c, principal.UserID, variantID, updatePayload.Quantity); err != nil {
+		if errors.Is(err, query.ErrCartItemNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": err.Error()})
+			return
+		}
+		log.Error("Error updating cart item", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "Cart item updated"})
+}
+
+// RemoveCartItem godoc
+// @Summary      Remove an item from the cart
+// @Description  Remove a variant from the authenticated user's cart
+// @Tags         Cart
+// @Produce      json
+// @Param        variant_id path string true "Variant ID"
+// @Success      200 {object} map[string]interface{} "Cart item removed"
+// @Failure      400 {object} map[string]interface{} "Invalid product id"
+// @Failure      401 {object} map[string]interface{} "Unauthorized, token expired"
+// @Failure      403 {object} map[string]interface{} "Permission denied"
+// @Failure      404 {object} map[string]interface{} "Item not in cart"
+// @Failure      500 {object} map[string]interface{} "Internal server error"
+// @Router       /api/cart/items/{variant_id} [delete]
+func (api *API) RemoveCartItem(c *gin.Context) {
+	log := logger.Get()
+
+	principal := auth.CurrentPrincipal(c)
+
+	variantID, err := uuid.Parse(c.Param("variant_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid variant id"})
+		return
+	}
+
+	if err := api.Q.RemoveCartItem(c, principal.UserID, variantID); err != nil {
+		if errors.Is(err, query.ErrCartItemNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": err.Error()})
+			return
+		}
+		log.Error("Error removing cart item", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "Cart item removed"})
+}
+
+// ClearCart godoc
+// @Summary      Clear the cart
+// @Description  Remove every item from the authenticated user's cart
+// @Tags         Cart
+// @Produce      json
+// @Success      200 {object} map[string]interface{} "Cart cleared"
+// @Failure      401 {object} map[string]interface{} "Unauthorized, token expired"
+// @Failure      403 {object} map[string]interface{} "Permission denied"
+// @Failure      500 {object} map[string]interface{} "Internal server error"
+// @Router       /api/cart [delete]
+func (api *API) ClearCart(c *gin.Context) {
+	log := logger.Get()
+
+	principal := auth.CurrentPrincipal(c)
+
+	if err := api.Q.ClearCart(c, principal.UserID); err != nil {
+		log.Error("Error clearing cart", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "Cart cleared"})
+}
+
+// CheckoutCart godoc
+// @Summary      Check out the cart
+// @Description  Turn the authenticated user's cart into an order and empty the cart. The order keeps a copy of its shipping and billing addresses, by default the default addresses of the address book. Promotion codes given are applied to it.
+// @Tags         Cart
+// @Accept       json
+// @Produce      json
+// @Param        checkoutPayload body payload.CheckoutPayload false "Addresses and promotion codes"
+// @Success      200 {object} map[string]interface{} "Order placed successfully"
+// @Failure      400 {object} map[string]interface{} "Cart is empty, validation error, no shipping address or a promotion code that cannot be applied"
+// @Failure      401 {object} map[string]interface{} "Unauthorized, token expired"
+// @Failure      403 {object} map[string]interface{} "Permission denied or email not verified"
+// @Failure      409 {object} map[string]interface{} "Insufficient stock"
+// @Failure      500 {object} map[string]interface{} "Internal server error"
+// @Router       /api/cart/checkout [post]
+func (api *API) CheckoutCart(c *gin.Context) {
+	log := logger.Get()
+
+	principal := auth.CurrentPrincipal(c)
+	if !api.requireVerifiedEmail(c, principal.UserID) {
+		return
+	}
+
+	var checkoutPayload payload.CheckoutPayload
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&checkoutPayload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+			return
+		}
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(checkoutPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	shipping, billing, ok := api.orderAddresses(c, principal.UserID, checkoutPayload.ShippingAddressID, checkoutPayload.BillingAddressID)
+	if !ok {
+		return
+	}
+
+	order := &query.Order{
+		ID:              uuid.New(),
+		UserID:          principal.UserID,
+		ShippingAddress: shipping,
+		BillingAddress:  billing,
+		ShippingAmount:  api.Cfg.Shipping.FlatRate,
+		PromotionCodes:  checkoutPayload.PromotionCodes,
+	}
+
+	quote, err := api.Q.CheckoutCart(c, order)
+	if err != nil {
+		if errors.Is(err, query.ErrCartEmpty) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+			return
+		}
+		respondOrderError(c, err)
+		return
+	}
+
+	log.Info("Cart checked out successfully", zap.String("order_id", order.ID.String()))
+	c.JSON(http.StatusOK, gin.H{
+		"error":    false,
+		"msg":      "Order placed successfully",
+		"order_id": order.ID.String(),
+		"quote":    quote,
+	})
+}
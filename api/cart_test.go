@@ -0,0 +1,192 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/amosehiguese/ecommerce-api/routes"
+	"github.com/amosehiguese/ecommerce-api/server"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCart(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	router := routes.SetUp(ta.DB, config.Get())
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	kettle, mug := uuid.New(), uuid.New()
+	for _, product := range []struct {
+		id    uuid.UUID
+		name  string
+		price string
+	}{{kettle, "Kettle", "25.00"}, {mug, "Mug", "5.00"}} {
+		_, err = ta.DB.Exec(`
+			INSERT INTO "product" (id, name, description, price, units_in_stock)
+			VALUES ($1, $2, 'A kitchen item', $3, 10)
+		`, product.id, product.name, product.price)
+		require.NoError(t, err)
+		_, err = ta.DB.Exec(`
+			INSERT INTO "product_variant" (product_id, sku, units_in_stock, is_default)
+			VALUES ($1, $2, 10, TRUE)
+		`, product.id, product.name)
+		require.NoError(t, err)
+	}
+
+	var res struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+		Cart    query.Cart    `json:"cart"`
+		OrderID string        `json:"order_id"`
+		Quote   query.Quote   `json:"quote"`
+		Orders  []query.Order `json:"orders"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		res.Cart, res.Quote = query.Cart{}, query.Quote{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	}
+	send := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, createJSONRequestBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	login := func() string {
+		w := send("POST", "/api/auth/login", "", payload.LoginPayload{Email: "shopper@example.com", Password: "password123"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		decode(w)
+		return res.Tokens.Access
+	}
+	getCart := func(token string) query.Cart {
+		w := send("GET", "/api/cart", token, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		decode(w)
+		return res.Cart
+	}
+	amount := func(s string) decimal.Decimal {
+		return decimal.RequireFromString(s)
+	}
+
+	w := send("POST", "/api/auth/register", "", payload.RegisterPayload{FirstName: "Shopper", Email: "shopper@example.com", Password: "password123"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	token := login()
+
+	cart := getCart(token)
+	assert.Empty(t, cart.Items)
+	assert.True(t, cart.Subtotal.IsZero(), cart.Subtotal.String())
+
+	// Adding a product twice adds up its quantity on its default variant
+	w = send("POST", "/api/cart/items", token, payload.CartItemPayload{ProductID: kettle, Quantity: 2})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("POST", "/api/cart/items", token, payload.CartItemPayload{ProductID: kettle, Quantity: 1})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("POST", "/api/cart/items", token, payload.CartItemPayload{ProductID: mug, Quantity: 1})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = send("POST", "/api/cart/items", token, payload.CartItemPayload{ProductID: uuid.New(), Quantity: 1})
+	assert.Equal(t, http.StatusNotFound, w.Code, "unknown products cannot be added")
+	w = send("POST", "/api/cart/items", token, payload.CartItemPayload{ProductID: kettle, Quantity: 0})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "quantities must be positive")
+	w = send("POST", "/api/cart/items", token, payload.CartItemPayload{ProductID: kettle, Quantity: 1001})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "quantities are bounded")
+	w = send("POST", "/api/cart/items", token, payload.CartItemPayload{ProductID: kettle, Quantity: 998})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "the quantity an item adds up to is bounded too")
+
+	cart = getCart(token)
+	require.Len(t, cart.Items, 2)
+	assert.Equal(t, kettle, cart.Items[0].ProductID)
+	assert.Equal(t, 3, cart.Items[0].Quantity)
+	assert.True(t, cart.Items[0].LineTotal.Equal(amount("75.00")), cart.Items[0].LineTotal.String())
+	assert.True(t, cart.Subtotal.Equal(amount("80.00")), cart.Subtotal.String())
+	kettleVariant, mugVariant := cart.Items[0].VariantID.String(), cart.Items[1].VariantID.String()
+
+	w = send("PATCH", "/api/cart/items/"+kettleVariant, token, payload.CartItemUpdatePayload{Quantity: 4})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("PATCH", "/api/cart/items/"+uuid.New().String(), token, payload.CartItemUpdatePayload{Quantity: 1})
+	assert.Equal(t, http.StatusNotFound, w.Code, "only items in the cart can be updated")
+	w = send("PATCH", "/api/cart/items/not-a-uuid", token, payload.CartItemUpdatePayload{Quantity: 1})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = send("DELETE", "/api/cart/items/"+mugVariant, token, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("DELETE", "/api/cart/items/"+mugVariant, token, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code, "the mug is no longer in the cart")
+
+	// The cart shows live prices and follows the user to another login
+	_, err = ta.DB.Exec(`UPDATE "product" SET price = 20.00 WHERE id = $1`, kettle)
+	require.NoError(t, err)
+	otherDevice := login()
+	cart = getCart(otherDevice)
+	require.Len(t, cart.Items, 1)
+	assert.Equal(t, 4, cart.Items[0].Quantity)
+	assert.True(t, cart.Items[0].UnitPrice.Equal(amount("20.00")), cart.Items[0].UnitPrice.String())
+	assert.True(t, cart.Subtotal.Equal(amount("80.00")), cart.Subtotal.String())
+
+	w = send("POST", "/api/cart/checkout", token, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "checkout needs a shipping address")
+
+	w = send("POST", "/api/me/addresses", token, payload.AddressPayload{
+		FullName:   "Shopper",
+		Line1:      "1 Market Street",
+		City:       "San Francisco",
+		PostalCode: "94105",
+		Country:    "US",
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// A checkout short on stock fails and leaves the cart as it was
+	w = send("PATCH", "/api/cart/items/"+kettleVariant, token, payload.CartItemUpdatePayload{Quantity: 11})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("POST", "/api/cart/checkout", token, nil)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	cart = getCart(token)
+	require.Len(t, cart.Items, 1)
+	assert.False(t, cart.Items[0].InStock)
+
+	w = send("PATCH", "/api/cart/items/"+kettleVariant, token, payload.CartItemUpdatePayload{Quantity: 4})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("POST", "/api/cart/checkout", token, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	assert.NotEmpty(t, res.OrderID)
+	assert.True(t, res.Quote.Subtotal.Equal(amount("80.00")), res.Quote.Subtotal.String())
+
+	assert.Empty(t, getCart(otherDevice).Items, "checkout empties the cart")
+	w = send("POST", "/api/cart/checkout", token, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "an empty cart cannot be checked out")
+
+	w = send("GET", "/api/orders", token, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	require.Len(t, res.Orders, 1)
+	assert.Equal(t, res.OrderID, res.Orders[0].ID.String())
+	require.Len(t, res.Orders[0].Items, 1)
+	assert.Equal(t, 4, res.Orders[0].Items[0].Quantity)
+	assert.True(t, res.Orders[0].Items[0].Price.Equal(amount("20.00")), res.Orders[0].Items[0].Price.String())
+
+	var unitsInStock int
+	err = ta.DB.QueryRow(`SELECT units_in_stock FROM "product_variant" WHERE product_id = $1`, kettle).Scan(&unitsInStock)
+	require.NoError(t, err)
+	assert.Equal(t, 6, unitsInStock)
+}
@@ -0,0 +1,345 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/utils"
+	"github.com/amosehiguese/ecommerce-api/pkg/validator"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// CreateCategory godoc
+// @Summary Create a category
+// @Description Create a category, optionally nested under a parent category
+// @Tags categories
+// @Accept json
+// @Produce json
+// @Param category body payload.CategoryPayload true "Category data"
+// @Security CookieAuth
+// @Success 200 {object} gin.H{"error": false, "category": query.Category}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 409 {object} gin.H{"error": true, "msg": "category slug already in use"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/categories [post]
+func (api *API) CreateCategory(c *gin.Context) {
+	log := logger.Get()
+
+	var categoryPayload payload.CategoryPayload
+	if err := c.ShouldBindJSON(&categoryPayload); err != nil {
+		log.Error("Invalid JSON for category", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(categoryPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	category := &query.Category{
+		ID:        uuid.New(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if !applyCategoryPayload(c, category, categoryPayload) {
+		return
+	}
+
+	if err := api.Q.CreateCategory(c, category); err != nil {
+		respondCategoryError(c, err)
+		return
+	}
+
+	log.Info("Category created successfully", zap.String("category_id", category.ID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "category": category})
+}
+
+// UpdateCategory godoc
+// @Summary Update or move a category
+// @Description Update a category's details. Changing parent_id moves the category with its whole subtree.
+// @Tags categories
+// @Accept json
+// @Produce json
+// @Param id path string true "Category ID"
+// @Param category body payload.CategoryPayload true "Category data"
+// @Security CookieAuth
+// @Success 200 {object} gin.H{"error": false, "category": query.Category}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 404 {object} gin.H{"error": true, "msg": "category not found"}
+// @Failure 409 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/categories/{id} [put]
+func (api *API) UpdateCategory(c *gin.Context) {
+	log := logger.Get()
+
+	categoryID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid category id"})
+		return
+	}
+
+	var categoryPayload payload.CategoryPayload
+	if err := c.ShouldBindJSON(&categoryPayload); err != nil {
+		log.Error("Invalid JSON for category", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(categoryPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	category, err := api.Q.GetCategoryByID(c, categoryID)
+	if err != nil {
+		log.Error("Error retrieving category", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+	if category == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": "category not found"})
+		return
+	}
+
+	if !applyCategoryPayload(c, category, categoryPayload) {
+		return
+	}
+	category.UpdatedAt = time.Now()
+
+	if err := api.Q.UpdateCategory(c, category); err != nil {
+		respondCategoryError(c, err)
+		return
+	}
+
+	log.Info("Category updated successfully", zap.String("category_id", category.ID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "category": category})
+}
+
+// DeleteCategory godoc
+// @Summary Delete a category
+// @Description Delete a category. Its child categories move up to its parent.
+// @Tags categories
+// @Param id path string true "Category ID"
+// @Security CookieAuth
+// @Success 200 {object} gin.H{"error": false, "msg": "category deleted successfully"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "invalid category id"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 404 {object} gin.H{"error": true, "msg": "category not found"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/categories/{id} [delete]
+func (api *API) DeleteCategory(c *gin.Context) {
+	log := logger.Get()
+
+	categoryID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid category id"})
+		return
+	}
+
+	if err := api.Q.DeleteCategory(c, categoryID); err != nil {
+		respondCategoryError(c, err)
+		return
+	}
+
+	log.Info("Category deleted successfully", zap.String("category_id", categoryID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "category deleted successfully"})
+}
+
+// ListCategories godoc
+// @Summary List categories
+// @Description Retrieve the whole category tree for storefront navigation
+// @Tags categories
+// @Security CookieAuth
+// @Success 200 {object} gin.H{"error": false, "categories": []query.Category}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/categories [get]
+func (api *API) ListCategories(c *gin.Context) {
+	log := logger.Get()
+
+	tree, err := api.Q.GetCategoryTree(c)
+	if err != nil {
+		log.Error("Error retrieving categories", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"error": false, "categories": tree})
+}
+
+// ListCategoryProducts godoc
+// @Summary List products in a category
+// @Description Retrieve one page of products in a category or any of its descendant categories. Accepts the same filters as /api/products.
+// @Tags categories
+// @Param slug path string true "Category slug"
+// @Param limit query int false "Page size (1-100, default 20)"
+// @Param cursor query string false "Cursor from the previous page"
+// @Param sort query string false "newest, price_asc, price_desc, name_asc or name_desc"
+// @Security CookieAuth
+// @Success 200 {object} gin.H{"error": false, "category": query.Category, "products": []query.Product, "next_cursor": "cursor"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 404 {object} gin.H{"error": true, "msg": "category not found"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/categories/{slug}/products [get]
+func (api *API) ListCategoryProducts(c *gin.Context) {
+	log := logger.Get()
+
+	category, err := api.Q.GetCategoryBySlug(c, c.Param("slug"))
+	if err != nil {
+		log.Error("Error retrieving category", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+	if category == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": "category not found"})
+		return
+	}
+
+	var listQuery payload.ProductListQuery
+	if err := c.ShouldBindQuery(&listQuery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(listQuery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	filter := productFilterFromQuery(listQuery)
+	filter.CategoryID = &category.ID
+
+	page, err := api.Q.ListProducts(c, filter)
+	if err != nil {
+		if errors.Is(err, query.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+			return
+		}
+		log.Error("Error retrieving products", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"error":       false,
+		"category":    category,
+		"products":    page.Products,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+// SetProductCategories godoc
+// @Summary Set a product's categories
+// @Description Replace the categories a product belongs to
+// @Tags categories
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param categories body payload.ProductCategoriesPayload true "Category IDs"
+// @Security CookieAuth
+// @Success 200 {object} gin.H{"error": false, "msg": "product categories updated"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 404 {object} gin.H{"error": true, "msg": "product or category not found"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/products/{id}/categories [put]
+func (api *API) SetProductCategories(c *gin.Context) {
+	log := logger.Get()
+
+	productID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid product id"})
+		return
+	}
+
+	var categoriesPayload payload.ProductCategoriesPayload
+	if err := c.ShouldBindJSON(&categoriesPayload); err != nil {
+		log.Error("Invalid JSON for product categories", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(categoriesPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	if err := api.Q.SetProductCategories(c, productID, categoriesPayload.CategoryIDs); err != nil {
+		respondCategoryError(c, err)
+		return
+	}
+
+	log.Info("Product categories updated", zap.String("product_id", productID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "product categories updated"})
+}
+
+// applyCategoryPayload copies the payload onto category, deriving the slug
+// from the name when none is given. It writes a 400 response and returns
+// false if the payload is not usable.
+func applyCategoryPayload(c *gin.Context, category *query.Category, categoryPayload payload.CategoryPayload) bool {
+	slug := categoryPayload.Slug
+	if slug == "" {
+		slug = utils.Slugify(categoryPayload.Name)
+	}
+	if !utils.IsSlug(slug) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   "slug may only contain lowercase letters, digits and single hyphens",
+		})
+		return false
+	}
+
+	category.Name = categoryPayload.Name
+	category.Slug = slug
+	category.ParentID = categoryPayload.ParentID
+	category.Position = categoryPayload.Position
+	category.Description = nil
+	if categoryPayload.Description != "" {
+		category.Description = &categoryPayload.Description
+	}
+	return true
+}
+
+func respondCategoryError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, query.ErrCategoryNotFound), errors.Is(err, query.ErrProductNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": err.Error()})
+	case errors.Is(err, query.ErrCategorySlugTaken), errors.Is(err, query.ErrCategoryCycle):
+		c.JSON(http.StatusConflict, gin.H{"error": true, "msg": err.Error()})
+	default:
+		logger.Get().Error("Error saving category", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+	}
+}
@@ -0,0 +1,181 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/amosehiguese/ecommerce-api/routes"
+	"github.com/amosehiguese/ecommerce-api/server"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCategoryTree(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	router := routes.SetUp(ta.DB, config.Get())
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	mug, kettle, rake := uuid.New(), uuid.New(), uuid.New()
+	for id, name := range map[uuid.UUID]string{mug: "Mug", kettle: "Kettle", rake: "Rake"} {
+		_, err = ta.DB.Exec(`
+			INSERT INTO "product" (id, name, description, price, units_in_stock)
+			VALUES ($1, $2, 'A household item', 10.00, 5)
+		`, id, name)
+		require.NoError(t, err)
+	}
+
+	var res struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+		Category   query.Category   `json:"category"`
+		Categories []query.Category `json:"categories"`
+		Products   []query.Product  `json:"products"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		res.Categories, res.Products = nil, nil
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	}
+	send := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, createJSONRequestBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/api/auth/register", "", payload.RegisterPayload{FirstName: "Staff", Email: "staff@example.com", Password: "password123"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	_, err = ta.DB.Exec(`UPDATE "user" SET role = 'admin' WHERE email = 'staff@example.com'`)
+	require.NoError(t, err)
+	w = send("POST", "/api/auth/login", "", payload.LoginPayload{Email: "staff@example.com", Password: "password123"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	admin := res.Tokens.Access
+
+	create := func(name string, parentID *uuid.UUID) uuid.UUID {
+		w := send("POST", "/api/categories", admin, payload.CategoryPayload{Name: name, ParentID: parentID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		decode(w)
+		return res.Category.ID
+	}
+	move := func(id uuid.UUID, name string, parentID *uuid.UUID) *httptest.ResponseRecorder {
+		return send("PUT", "/api/categories/"+id.String(), admin, payload.CategoryPayload{Name: name, ParentID: parentID})
+	}
+	products := func(slug string) []uuid.UUID {
+		w := send("GET", "/api/categories/"+slug+"/products", admin, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		decode(w)
+		ids := []uuid.UUID{}
+		for _, p := range res.Products {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+	// unreachable counts the categories that cannot be reached by walking
+	// down from the top-level categories.
+	unreachable := func() int {
+		var n int
+		err := ta.DB.QueryRow(`
+			WITH RECURSIVE tree AS (
+				SELECT id FROM "category" WHERE parent_id IS NULL
+				UNION ALL
+				SELECT c.id FROM "category" c JOIN tree ON c.parent_id = tree.id
+			)
+			SELECT (SELECT COUNT(*) FROM "category") - (SELECT COUNT(*) FROM tree)
+		`).Scan(&n)
+		require.NoError(t, err)
+		return n
+	}
+
+	home := create("Home", nil)
+	kitchen := create("Kitchen", &home)
+	drinkware := create("Drinkware", &kitchen)
+	garden := create("Garden", nil)
+	assert.Equal(t, "drinkware", res.Category.Slug, "slugs are derived from names")
+
+	w = send("POST", "/api/categories", admin, payload.CategoryPayload{Name: "Kitchen"})
+	assert.Equal(t, http.StatusConflict, w.Code, "slugs are unique")
+	w = send("POST", "/api/categories", admin, payload.CategoryPayload{Name: "Tools", Slug: "Power Tools"})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "slugs are validated")
+	unknown := uuid.New()
+	w = send("POST", "/api/categories", admin, payload.CategoryPayload{Name: "Tools", ParentID: &unknown})
+	assert.Equal(t, http.StatusNotFound, w.Code, "the parent must exist")
+
+	for product, categories := range map[uuid.UUID][]uuid.UUID{mug: {drinkware}, kettle: {kitchen}, rake: {garden}} {
+		w = send("PUT", "/api/products/"+product.String()+"/categories", admin, payload.ProductCategoriesPayload{CategoryIDs: categories})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	w = send("PUT", "/api/products/"+rake.String()+"/categories", admin, payload.ProductCategoriesPayload{CategoryIDs: []uuid.UUID{unknown}})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "category not found")
+	w = send("PUT", "/api/products/"+unknown.String()+"/categories", admin, payload.ProductCategoriesPayload{CategoryIDs: []uuid.UUID{garden}})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "product not found")
+	w = send("PUT", "/api/products/"+rake.String()+"/categories", admin, map[string]interface{}{})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "the category list is required")
+
+	// Listings include the products of descendant categories
+	assert.ElementsMatch(t, []uuid.UUID{mug, kettle}, products("home"))
+	assert.ElementsMatch(t, []uuid.UUID{mug, kettle}, products("kitchen"))
+	assert.ElementsMatch(t, []uuid.UUID{mug}, products("drinkware"))
+	assert.ElementsMatch(t, []uuid.UUID{rake}, products("garden"))
+	w = send("GET", "/api/categories/attic/products", admin, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// A category cannot move under itself or its own subtree
+	w = move(home, "Home", &drinkware)
+	assert.Equal(t, http.StatusConflict, w.Code, "drinkware is below home")
+	w = move(kitchen, "Kitchen", &kitchen)
+	assert.Equal(t, http.StatusConflict, w.Code, "a category cannot be its own parent")
+	assert.Zero(t, unreachable())
+
+	// Moving a category takes its whole subtree along
+	w = move(kitchen, "Kitchen", &garden)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Zero(t, unreachable(), "no category is orphaned by the move")
+	assert.Empty(t, products("home"))
+	assert.ElementsMatch(t, []uuid.UUID{rake, kettle, mug}, products("garden"))
+
+	w = send("GET", "/api/categories", admin, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	require.Len(t, res.Categories, 2)
+	assert.Equal(t, "Garden", res.Categories[0].Name)
+	require.Len(t, res.Categories[0].Children, 1)
+	assert.Equal(t, kitchen, res.Categories[0].Children[0].ID)
+	require.Len(t, res.Categories[0].Children[0].Children, 1)
+	assert.Equal(t, drinkware, res.Categories[0].Children[0].Children[0].ID)
+	assert.Equal(t, "Home", res.Categories[1].Name)
+	assert.Empty(t, res.Categories[1].Children)
+
+	// Deleting a category moves its children up to its parent
+	w = send("DELETE", "/api/categories/"+kitchen.String(), admin, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Zero(t, unreachable(), "no category is orphaned by the delete")
+	var parentID uuid.UUID
+	require.NoError(t, ta.DB.QueryRow(`SELECT parent_id FROM "category" WHERE id = $1`, drinkware).Scan(&parentID))
+	assert.Equal(t, garden, parentID)
+	assert.ElementsMatch(t, []uuid.UUID{rake, mug}, products("garden"))
+
+	w = send("DELETE", "/api/categories/"+kitchen.String(), admin, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,19 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS godoc
+// @Summary Token signing keys
+// @Description Public keys that verify access tokens, as a JSON Web Key Set. Tokens name their key in the kid header.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} auth.JWKSet
+// @Router /.well-known/jwks.json [get]
+func (api *API) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, api.Keys.JWKS())
+}
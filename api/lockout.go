@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/utils"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// checkPassword reports whether password is the password of u, which may be
+// nil. Without a user the password is checked against a dummy hash, which
+// keeps unknown emails as slow as known ones.
+func checkPassword(u *query.User, password string) bool {
+	if u == nil {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = utils.HashPassword("not the password of any account")
+		})
+		utils.VerifyPassword(password, dummyHash)
+		return false
+	}
+	return u.ComparePasswordHash(password)
+}
+
+// loginAccount is the name failed logins are counted under for an email.
+func loginAccount(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// checkLoginLockout answers 429 and returns false while account or client is
+// locked out. Logins go ahead when the counters cannot be read.
+func (api *API) checkLoginLockout(ctx context.Context, c *gin.Context, account, client string) bool {
+	wait, err := api.Lockout.Check(ctx, account, client)
+	if err != nil {
+		logger.Get().Error("Error checking login lockout", zap.Error(err))
+		return true
+	}
+	if wait == 0 {
+		return true
+	}
+
+	logger.Get().Warn("Login refused during lockout",
+		zap.String("account", account), zap.String("client_ip", client), zap.Duration("retry_after", wait))
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error": true,
+		"msg":   "too many failed login attempts, try again later",
+	})
+	return false
+}
+
+// recordLoginFailure counts a failed login and logs the lockouts it causes.
+func (api *API) recordLoginFailure(ctx context.Context, account, client string) {
+	log := logger.Get()
+
+	locks, err := api.Lockout.Fail(ctx, account, client)
+	if err != nil {
+		log.Error("Error recording failed login", zap.Error(err))
+	}
+	log.Warn("Failed login", zap.String("account", account), zap.String("client_ip", client))
+	for _, lock := range locks {
+		log.Warn("Login locked",
+			zap.String("scope", string(lock.Scope)),
+			zap.String("key", lock.Key),
+			zap.Int("failures", lock.Failures),
+			zap.Duration("duration", lock.Duration),
+			zap.Time("until", time.Now().Add(lock.Duration)))
+	}
+}
+
+// recordLoginSuccess clears the failures of account and logs the end of its
+// lockout, if it had one.
+func (api *API) recordLoginSuccess(ctx context.Context, account string) {
+	unlocked, err := api.Lockout.Succeed(ctx, account)
+	if err != nil {
+		logger.Get().Error("Error clearing failed logins", zap.Error(err))
+		return
+	}
+	if unlocked {
+		logger.Get().Info("Login unlocked", zap.String("scope", "account"), zap.String("key", account))
+	}
+}
@@ -0,0 +1,52 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/routes"
+	"github.com/amosehiguese/ecommerce-api/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginLockout(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	cfg := config.Get()
+	router := routes.SetUp(ta.DB, cfg)
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	const email = "target@example.com"
+	w := postJSON(router, "/api/auth/register", payload.RegisterPayload{FirstName: "Target", Email: email, Password: "password123"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Unknown accounts and wrong passwords look the same
+	unknown := postJSON(router, "/api/auth/login", payload.LoginPayload{Email: "ghost@example.com", Password: "password123"})
+	wrong := postJSON(router, "/api/auth/login", payload.LoginPayload{Email: email, Password: "guess-1"})
+	assert.Equal(t, http.StatusBadRequest, unknown.Code)
+	assert.Equal(t, unknown.Code, wrong.Code)
+	assert.Equal(t, unknown.Body.String(), wrong.Body.String())
+
+	for i := 1; i < cfg.Lockout.AccountMaxFailures; i++ {
+		w = postJSON(router, "/api/auth/login", payload.LoginPayload{Email: email, Password: "guess"})
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	}
+
+	// Locked out, even with the right password
+	w = postJSON(router, "/api/auth/login", payload.LoginPayload{Email: email, Password: "password123"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+}
@@ -0,0 +1,620 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/session"
+	"github.com/amosehiguese/ecommerce-api/pkg/totp"
+	"github.com/amosehiguese/ecommerce-api/pkg/utils"
+	"github.com/amosehiguese/ecommerce-api/pkg/validator"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	recoveryCodeCount = 10
+	// mfaMaxAttempts wrong codes in a row lock the second factor for
+	// mfaLockout, which keeps codes from being guessed.
+	mfaMaxAttempts = 5
+	mfaLockout     = 15 * time.Minute
+)
+
+var (
+	errInvalidMFACode = errors.New("invalid authentication code")
+	errMFALocked      = errors.New("too many invalid authentication codes, try again later")
+	errMFAMandatory   = errors.New("two-factor authentication is mandatory for your role")
+)
+
+// mfaRequired reports whether u may only log in with a second factor.
+func (api *API) mfaRequired(u *query.User) bool {
+	return api.Cfg.Account.RequireMFAForAdmins && u.Role == auth.AdminRole.String()
+}
+
+// challengeMFA answers a login whose password was accepted with a token for
+// the second step. enroll asks the user to set up a second factor first.
+func (api *API) challengeMFA(c *gin.Context, u *query.User, deviceID string, enroll bool) {
+	token, err := auth.GenerateMFAChallenge(api.Keys, auth.MFAChallenge{UserID: u.ID, DeviceID: deviceID, Enroll: enroll})
+	if err != nil {
+		logger.Get().Error("Failed to generate two-factor challenge", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "Failed to generate tokens"})
+		return
+	}
+
+	msg := "enter the code from your authenticator app"
+	if enroll {
+		msg = "two-factor authentication is mandatory for your role, please set it up"
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"error":                   false,
+		"msg":                     msg,
+		"mfa_required":            true,
+		"mfa_enrollment_required": enroll,
+		"mfa_token":               token,
+	})
+}
+
+// newRecoveryCodes returns recoveryCodeCount codes and their hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = s[:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case and separators, which users tend to retype
+// differently.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return utils.GetHash(code)
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery
+// code of an enabled second factor.
+func (api *API) checkSecondFactor(ctx context.Context, mfa *query.MFA, code, recoveryCode string) error {
+	now := time.Now()
+	if mfa.LockedUntil != nil && mfa.LockedUntil.After(now) {
+		return errMFALocked
+	}
+
+	var err error
+	if code != "" {
+		step, ok := totp.Validate(mfa.Secret, code, now, mfa.LastUsedStep)
+		if !ok {
+			err = errInvalidMFACode
+		} else {
+			err = api.Q.UseMFAStep(ctx, mfa.UserID, step)
+		}
+	} else {
+		err = api.Q.UseRecoveryCode(ctx, mfa.UserID, hashRecoveryCode(recoveryCode))
+	}
+
+	if errors.Is(err, errInvalidMFACode) || errors.Is(err, query.ErrMFACodeUsed) || errors.Is(err, query.ErrInvalidRecoveryCode) {
+		if ferr := api.Q.RecordMFAFailure(ctx, mfa.UserID, mfaMaxAttempts, now.Add(mfaLockout)); ferr != nil {
+			return ferr
+		}
+	}
+	return err
+}
+
+// startMFAEnrollment stores a new secret for u and answers with what an
+// authenticator app needs to add it, along with any extra fields.
+func (api *API) startMFAEnrollment(ctx context.Context, c *gin.Context, u *query.User, extra gin.H) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		logger.Get().Error("Error generating two-factor secret", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to set up two-factor authentication"})
+		return
+	}
+
+	if err := api.Q.StartMFAEnrollment(ctx, u.ID, secret); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	res := gin.H{
+		"error":       false,
+		"msg":         "add the secret to your authenticator app, then confirm with a code from it",
+		"secret":      secret,
+		"otpauth_uri": totp.URI(api.Cfg.Account.MFAIssuer, u.Email, secret),
+	}
+	for k, v := range extra {
+		res[k] = v
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// enableMFA checks a code from the pending secret of userID, turns the second
+// factor on and returns new recovery codes.
+func (api *API) enableMFA(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	mfa, err := api.Q.GetMFA(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa == nil {
+		return nil, query.ErrMFANotStarted
+	}
+	if mfa.Enabled() {
+		return nil, query.ErrMFAAlreadyEnabled
+	}
+
+	step, ok := totp.Validate(mfa.Secret, code, time.Now(), mfa.LastUsedStep)
+	if !ok {
+		return nil, errInvalidMFACode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := api.Q.EnableMFA(ctx, userID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// MFAStatus godoc
+// @Summary Two-factor authentication status
+// @Description Tells whether two-factor authentication is enabled for the current user and how many recovery codes are left
+// @Tags auth
+// @Produce json
+// @Success 200 {object} gin.H{"error": false, "enabled": true, "required": false, "recovery_codes_remaining": 10}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Security BearerAuth
+// @Router /api/auth/mfa [get]
+func (api *API) MFAStatus(c *gin.Context) {
+	principal := auth.CurrentPrincipal(c)
+
+	mfa, err := api.Q.GetMFA(c, principal.UserID)
+	if err != nil {
+		logger.Get().Error("Error fetching two-factor settings", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to fetch two-factor settings"})
+		return
+	}
+
+	remaining := 0
+	if mfa.Enabled() {
+		remaining, err = api.Q.CountRecoveryCodes(c, principal.UserID)
+		if err != nil {
+			logger.Get().Error("Error counting recovery codes", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to fetch two-factor settings"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"error":                    false,
+		"enabled":                  mfa.Enabled(),
+		"required":                 api.Cfg.Account.RequireMFAForAdmins && principal.Role == auth.AdminRole.String(),
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// SetupMFA godoc
+// @Summary Start two-factor authentication setup
+// @Description Creates a TOTP secret for the current user, returned with an otpauth URI to show as a QR code. It takes effect once confirmed at /api/auth/mfa/enable.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} gin.H{"error": false, "secret": "base32 secret", "otpauth_uri": "otpauth://totp/..."}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 409 {object} gin.H{"error": true, "msg": "two-factor authentication is already enabled"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Security BearerAuth
+// @Router /api/auth/mfa/setup [post]
+func (api *API) SetupMFA(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	principal := auth.CurrentPrincipal(c)
+
+	u, err := api.Q.GetUserByID(ctx, principal.UserID)
+	if err != nil || u == nil {
+		logger.Get().Error("Error fetching user for two-factor setup", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to set up two-factor authentication"})
+		return
+	}
+
+	api.startMFAEnrollment(ctx, c, u, nil)
+}
+
+// EnableMFA godoc
+// @Summary Confirm two-factor authentication setup
+// @Description Turns on two-factor authentication with a code from the authenticator app and returns single-use recovery codes, which are shown only once
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param code body payload.MFACodePayload true "Authenticator code"
+// @Success 200 {object} gin.H{"error": false, "recovery_codes": []string{}}
+// @Failure 400 {object} gin.H{"error": true, "msg": "invalid authentication code"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 409 {object} gin.H{"error": true, "msg": "two-factor authentication is already enabled"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Security BearerAuth
+// @Router /api/auth/mfa/enable [post]
+func (api *API) EnableMFA(c *gin.Context) {
+	log := logger.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	principal := auth.CurrentPrincipal(c)
+
+	var codePayload payload.MFACodePayload
+	if err := c.ShouldBindJSON(&codePayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(codePayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	codes, err := api.enableMFA(ctx, principal.UserID, codePayload.Code)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	log.Info("Two-factor authentication enabled", zap.String("user_id", principal.UserID.String()))
+	c.JSON(http.StatusOK, gin.H{
+		"error":          false,
+		"msg":            "two-factor authentication enabled, store the recovery codes somewhere safe",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableMFA godoc
+// @Summary Turn off two-factor authentication
+// @Description Turns off two-factor authentication after checking the password and a code or recovery code. Not allowed for roles it is mandatory for.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param disable body payload.MFADisablePayload true "Password and second factor"
+// @Success 200 {object} gin.H{"error": false, "msg": "two-factor authentication disabled"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "two-factor authentication is mandatory for your role"}
+// @Failure 429 {object} gin.H{"error": true, "msg": "too many invalid authentication codes, try again later"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Security BearerAuth
+// @Router /api/auth/mfa/disable [post]
+func (api *API) DisableMFA(c *gin.Context) {
+	log := logger.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	principal := auth.CurrentPrincipal(c)
+
+	var disablePayload payload.MFADisablePayload
+	if err := c.ShouldBindJSON(&disablePayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(disablePayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	u, err := api.Q.GetUserByID(ctx, principal.UserID)
+	if err != nil || u == nil {
+		log.Error("Error fetching user to disable two-factor authentication", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to disable two-factor authentication"})
+		return
+	}
+	if api.mfaRequired(u) {
+		respondMFAError(c, errMFAMandatory)
+		return
+	}
+	if !u.ComparePasswordHash(disablePayload.Password) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "Invalid credentials"})
+		return
+	}
+
+	mfa, err := api.Q.GetMFA(ctx, u.ID)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+	if !mfa.Enabled() {
+		respondMFAError(c, query.ErrMFANotEnabled)
+		return
+	}
+	if err := api.checkSecondFactor(ctx, mfa, disablePayload.Code, disablePayload.RecoveryCode); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	if err := api.Q.DisableMFA(ctx, u.ID); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	log.Info("Two-factor authentication disabled", zap.String("user_id", u.ID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Replace recovery codes
+// @Description Replaces every recovery code of the current user with new ones after checking a code from the authenticator app
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param code body payload.MFACodePayload true "Authenticator code"
+// @Success 200 {object} gin.H{"error": false, "recovery_codes": []string{}}
+// @Failure 400 {object} gin.H{"error": true, "msg": "invalid authentication code"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 429 {object} gin.H{"error": true, "msg": "too many invalid authentication codes, try again later"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Security BearerAuth
+// @Router /api/auth/mfa/recovery-codes [post]
+func (api *API) RegenerateRecoveryCodes(c *gin.Context) {
+	log := logger.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	principal := auth.CurrentPrincipal(c)
+
+	var codePayload payload.MFACodePayload
+	if err := c.ShouldBindJSON(&codePayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(codePayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	mfa, err := api.Q.GetMFA(ctx, principal.UserID)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+	if !mfa.Enabled() {
+		respondMFAError(c, query.ErrMFANotEnabled)
+		return
+	}
+	if err := api.checkSecondFactor(ctx, mfa, codePayload.Code, ""); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+	if err := api.Q.ReplaceRecoveryCodes(ctx, principal.UserID, hashes); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	log.Info("Recovery codes replaced", zap.String("user_id", principal.UserID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "recovery codes replaced", "recovery_codes": codes})
+}
+
+// SetupMFAChallenge godoc
+// @Summary Start mandatory two-factor authentication setup at login
+// @Description For an mfa_token returned with mfa_enrollment_required, creates the TOTP secret the user must confirm at /api/auth/login/mfa with the mfa_token returned here. Each challenge can start one setup.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param challenge body payload.MFAChallengePayload true "Login challenge"
+// @Success 200 {object} gin.H{"error": false, "secret": "base32 secret", "otpauth_uri": "otpauth://totp/...", "mfa_token": "new login challenge"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "invalid or expired login challenge"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/auth/login/mfa/setup [post]
+func (api *API) SetupMFAChallenge(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var challengePayload payload.MFAChallengePayload
+	if err := c.ShouldBindJSON(&challengePayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(challengePayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	challenge, u := api.parseMFAChallenge(ctx, c, challengePayload.MFAToken)
+	if challenge == nil {
+		return
+	}
+	if !challenge.Enroll {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": query.ErrMFAAlreadyEnabled.Error()})
+		return
+	}
+
+	// The challenge is used up here and a new one answers the setup, so that
+	// each challenge starts at most one enrollment.
+	if !api.consumeMFAChallenge(ctx, c, challenge) {
+		return
+	}
+	token, err := auth.GenerateMFAChallenge(api.Keys, auth.MFAChallenge{UserID: u.ID, DeviceID: challenge.DeviceID, Enroll: true})
+	if err != nil {
+		logger.Get().Error("Failed to generate two-factor challenge", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "Failed to generate tokens"})
+		return
+	}
+
+	api.startMFAEnrollment(ctx, c, u, gin.H{"mfa_token": token})
+}
+
+// VerifyMFAChallenge godoc
+// @Summary Complete a two-factor login
+// @Description Exchanges an mfa_token from /api/auth/login and a code from the authenticator app, or a recovery code, for access and refresh tokens. When setup was mandatory, the code confirms it and recovery codes are returned too.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param login body payload.MFALoginPayload true "Login challenge and second factor"
+// @Success 200 {object} gin.H{"error": false, "tokens": {"access": "access_token", "refresh": "refresh_token"}, "user": {"id": "user_id", "email": "email", "role": "role"}}
+// @Failure 400 {object} gin.H{"error": true, "msg": "invalid authentication code"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "invalid or expired login challenge"}
+// @Failure 429 {object} gin.H{"error": true, "msg": "too many invalid authentication codes, try again later"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/auth/login/mfa [post]
+func (api *API) VerifyMFAChallenge(c *gin.Context) {
+	log := logger.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var loginPayload payload.MFALoginPayload
+	if err := c.ShouldBindJSON(&loginPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(loginPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	challenge, u := api.parseMFAChallenge(ctx, c, loginPayload.MFAToken)
+	if challenge == nil {
+		return
+	}
+
+	mfa, err := api.Q.GetMFA(ctx, u.ID)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	if !mfa.Enabled() {
+		if !challenge.Enroll || loginPayload.Code == "" {
+			respondMFAError(c, query.ErrMFANotStarted)
+			return
+		}
+		codes, err := api.enableMFA(ctx, u.ID, loginPayload.Code)
+		if err != nil {
+			respondMFAError(c, err)
+			return
+		}
+		log.Info("Two-factor authentication enabled at login", zap.String("user_id", u.ID.String()))
+		if !api.consumeMFAChallenge(ctx, c, challenge) {
+			return
+		}
+		api.completeLogin(ctx, c, u, challenge.DeviceID, gin.H{"recovery_codes": codes})
+		return
+	}
+
+	if err := api.checkSecondFactor(ctx, mfa, loginPayload.Code, loginPayload.RecoveryCode); err != nil {
+		log.Warn("Second factor rejected", zap.String("user_id", u.ID.String()), zap.Error(err))
+		respondMFAError(c, err)
+		return
+	}
+	if !api.consumeMFAChallenge(ctx, c, challenge) {
+		return
+	}
+
+	api.completeLogin(ctx, c, u, challenge.DeviceID, nil)
+}
+
+// parseMFAChallenge verifies a login challenge and loads its user. On failure
+// it answers the request and returns nil.
+func (api *API) parseMFAChallenge(ctx context.Context, c *gin.Context, token string) (*auth.MFAChallenge, *query.User) {
+	challenge, err := auth.ParseMFAChallenge(api.Keys, token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": true, "msg": "invalid or expired login challenge"})
+		return nil, nil
+	}
+
+	u, err := api.Q.GetUserByID(ctx, challenge.UserID)
+	if err != nil {
+		logger.Get().Error("Error fetching user for login challenge", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the request"})
+		return nil, nil
+	}
+	if u == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": true, "msg": "invalid or expired login challenge"})
+		return nil, nil
+	}
+	if u.SuspendedAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": true, "msg": errAccountSuspended.Error()})
+		return nil, nil
+	}
+	return challenge, u
+}
+
+// consumeMFAChallenge uses up a login challenge that was answered, so that it
+// cannot be answered again. On failure it answers the request and returns
+// false.
+func (api *API) consumeMFAChallenge(ctx context.Context, c *gin.Context, challenge *auth.MFAChallenge) bool {
+	err := api.Sessions.ConsumeTokenID(ctx, challenge.ID, challenge.ExpiresAt)
+	if errors.Is(err, session.ErrTokenIDUsed) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": true, "msg": "invalid or expired login challenge"})
+		return false
+	}
+	if err != nil {
+		logger.Get().Error("Error using up login challenge", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the request"})
+		return false
+	}
+	return true
+}
+
+func respondMFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errInvalidMFACode),
+		errors.Is(err, query.ErrMFACodeUsed),
+		errors.Is(err, query.ErrInvalidRecoveryCode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": errInvalidMFACode.Error()})
+	case errors.Is(err, query.ErrMFANotEnabled),
+		errors.Is(err, query.ErrMFANotStarted):
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+	case errors.Is(err, query.ErrMFAAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": true, "msg": err.Error()})
+	case errors.Is(err, errMFAMandatory):
+		c.JSON(http.StatusForbidden, gin.H{"error": true, "msg": err.Error()})
+	case errors.Is(err, errMFALocked):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": true, "msg": err.Error()})
+	default:
+		logger.Get().Error("Two-factor authentication error", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the request"})
+	}
+}
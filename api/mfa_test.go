@@ -0,0 +1,111 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/pkg/totp"
+	"github.com/amosehiguese/ecommerce-api/routes"
+	"github.com/amosehiguese/ecommerce-api/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTwoFactorLogin(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	router := routes.SetUp(ta.DB, config.Get())
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	const email = "careful@example.com"
+	login := payload.LoginPayload{Email: email, Password: "password123"}
+	w := postJSON(router, "/api/auth/register", payload.RegisterPayload{FirstName: "Careful", Email: email, Password: login.Password})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var res struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+		Secret        string   `json:"secret"`
+		RecoveryCodes []string `json:"recovery_codes"`
+		MFAToken      string   `json:"mfa_token"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		res.Secret, res.RecoveryCodes, res.MFAToken = "", nil, ""
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	}
+	authorized := func(path string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, createJSONRequestBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+res.Tokens.Access)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w = postJSON(router, "/api/auth/login", login)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+
+	w = authorized("/api/auth/mfa/setup", struct{}{})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	secret := res.Secret
+
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+	w = authorized("/api/auth/mfa/enable", payload.MFACodePayload{Code: code})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	recoveryCodes := res.RecoveryCodes
+	require.Len(t, recoveryCodes, 10)
+
+	// The password alone now only yields a challenge
+	w = postJSON(router, "/api/auth/login", login)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	decode(w)
+	require.NotEmpty(t, res.MFAToken)
+	challenge := res.MFAToken
+
+	// The challenge is not an access token
+	res.Tokens.Access = challenge
+	w = authorized("/api/auth/logout", struct{}{})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = postJSON(router, "/api/auth/login/mfa", payload.MFALoginPayload{MFAToken: challenge, Code: "000000"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// The code used to enable MFA cannot be replayed
+	w = postJSON(router, "/api/auth/login/mfa", payload.MFALoginPayload{MFAToken: challenge, Code: code})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = postJSON(router, "/api/auth/login/mfa", payload.MFALoginPayload{MFAToken: challenge, RecoveryCode: recoveryCodes[0]})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	assert.NotEmpty(t, res.Tokens.Access)
+
+	// An answered challenge cannot be answered again
+	w = postJSON(router, "/api/auth/login/mfa", payload.MFALoginPayload{MFAToken: challenge, RecoveryCode: recoveryCodes[1]})
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "challenges are single-use")
+
+	w = postJSON(router, "/api/auth/login", login)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	decode(w)
+	w = postJSON(router, "/api/auth/login/mfa", payload.MFALoginPayload{MFAToken: res.MFAToken, RecoveryCode: recoveryCodes[0]})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "recovery codes are single-use")
+}
@@ -1,12 +1,14 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/amosehiguese/ecommerce-api/api/payload"
 	"github.com/amosehiguese/ecommerce-api/pkg/auth"
 	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/promotion"
 	"github.com/amosehiguese/ecommerce-api/pkg/validator"
 	"github.com/amosehiguese/ecommerce-api/query"
 	"github.com/gin-gonic/gin"
@@ -17,36 +19,23 @@ import (
 
 // CreateOrder godoc
 // @Summary      Create an Order
-// @Description  Create a new order for the authenticated user
+// @Description  Create a new order for the authenticated user. The order keeps a copy of its shipping and billing addresses, by default the default addresses of the address book. Promotion codes given are applied to it.
 // @Tags         Orders
 // @Accept       json
 // @Produce      json
 // @Param        orderPayload body payload.OrderPayload true "Order Payload"
 // @Success      200 {object} map[string]interface{} "Order created successfully"
-// @Failure      400 {object} map[string]interface{} "Invalid request body or validation error"
+// @Failure      400 {object} map[string]interface{} "Invalid request body, validation error, no shipping address or a promotion code that cannot be applied"
 // @Failure      401 {object} map[string]interface{} "Unauthorized, token expired"
-// @Failure      403 {object} map[string]interface{} "Permission denied"
+// @Failure      403 {object} map[string]interface{} "Permission denied or email not verified"
+// @Failure      409 {object} map[string]interface{} "Insufficient stock"
 // @Failure      500 {object} map[string]interface{} "Internal server error"
 // @Router       /api/orders [post]
 func (api *API) CreateOrder(c *gin.Context) {
 	log := logger.Get()
 
-	claims, err := auth.ExtractTokenMetadata(c)
-	if err != nil {
-		log.Error("Error extracting token metadata", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
-		return
-	}
-
-	if time.Now().Unix() > claims.Exp {
-		log.Warn("Token expired", zap.Int64("expiration", claims.Exp))
-		c.JSON(http.StatusUnauthorized, gin.H{"error": true, "msg": "unauthorized, token expired"})
-		return
-	}
-
-	if !claims.Credentials[auth.OrderCreateCredential] {
-		log.Warn("Permission denied for order create", zap.String("role", claims.Role))
-		c.JSON(http.StatusForbidden, gin.H{"error": true, "msg": "permission denied"})
+	principal := auth.CurrentPrincipal(c)
+	if !api.requireVerifiedEmail(c, principal.UserID) {
 		return
 	}
 
@@ -66,51 +55,89 @@ func (api *API) CreateOrder(c *gin.Context) {
 		return
 	}
 
+	shipping, billing, ok := api.orderAddresses(c, principal.UserID, orderPayload.ShippingAddressID, orderPayload.BillingAddressID)
+	if !ok {
+		return
+	}
+
 	order := &query.Order{
-		ID:          uuid.New(),
-		UserID:      claims.UserID,
-		TotalAmount: orderPayload.CalculateOrderTotal(),
+		ID:              uuid.New(),
+		UserID:          principal.UserID,
+		Items:           orderItemsFromPayload(orderPayload),
+		ShippingAddress: shipping,
+		BillingAddress:  billing,
+		ShippingAmount:  api.Cfg.Shipping.FlatRate,
+		PromotionCodes:  orderPayload.PromotionCodes,
 	}
 
-	for _, itemPayload := range orderPayload.Items {
-		product, err := api.Q.GetProductByID(c, itemPayload.ProductID)
-		itemPrice := decimal.NewFromFloat(itemPayload.Price)
-
-		if itemPayload.Quantity > product.UnitsInStock {
-			log.Error("Product quantity greater than units in stock", zap.Error(err))
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": true,
-				"msg":   "product quantity greater than units in stock: " + err.Error(),
-			})
-			return
-		}
+	// Items are priced and stock is reserved atomically while the order is placed.
+	quote, err := api.Q.CreateOrder(c, order)
+	if err != nil {
+		respondOrderError(c, err)
+		return
+	}
 
-		if itemPrice != product.Price {
-			log.Error("price inconsistency", zap.Error(err))
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": true,
-				"msg":   "item price differ from product price: " + err.Error(),
-			})
-			return
+	log.Info("Order created successfully", zap.String("order_id", order.ID.String()))
+	c.JSON(http.StatusOK, gin.H{
+		"error":    false,
+		"msg":      "Order placed successfully",
+		"order_id": order.ID.String(),
+		"quote":    quote,
+		"warnings": priceMismatchWarnings(orderPayload, quote),
+	})
+}
 
-		}
+// QuoteOrder godoc
+// @Summary      Quote an Order
+// @Description  Price a set of order lines against the catalog and apply promotion codes without placing an order
+// @Tags         Orders
+// @Accept       json
+// @Produce      json
+// @Param        orderPayload body payload.OrderPayload true "Order Payload"
+// @Success      200 {object} map[string]interface{} "Order quoted successfully"
+// @Failure      400 {object} map[string]interface{} "Invalid request body, validation error or a promotion code that cannot be applied"
+// @Failure      401 {object} map[string]interface{} "Unauthorized, token expired"
+// @Failure      403 {object} map[string]interface{} "Permission denied"
+// @Failure      409 {object} map[string]interface{} "Insufficient stock"
+// @Failure      500 {object} map[string]interface{} "Internal server error"
+// @Router       /api/orders/quote [post]
+func (api *API) QuoteOrder(c *gin.Context) {
+	log := logger.Get()
 
-		order.Items = append(order.Items, query.OrderItem{
-			ProductID: itemPayload.ProductID,
-			Quantity:  itemPayload.Quantity,
-			Price:     itemPrice,
-			CreatedAt: time.Now(),
+	var orderPayload payload.OrderPayload
+	if err := c.ShouldBindJSON(&orderPayload); err != nil {
+		log.Error("Invalid JSON for order quote", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(orderPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
 		})
+		return
 	}
 
-	if _, err := api.Q.CreateOrder(c, order); err != nil {
-		log.Error("Error placing order", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+	order := &query.Order{
+		UserID:         auth.CurrentPrincipal(c).UserID,
+		Items:          orderItemsFromPayload(orderPayload),
+		ShippingAmount: api.Cfg.Shipping.FlatRate,
+		PromotionCodes: orderPayload.PromotionCodes,
+	}
+
+	quote, err := api.Q.QuoteOrder(c, order)
+	if err != nil {
+		respondOrderError(c, err)
 		return
 	}
 
-	log.Info("Order created successfully", zap.String("order_id", order.ID.String()))
-	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "Order placed successfully", "order_id": order.ID.String()})
+	c.JSON(http.StatusOK, gin.H{
+		"error":    false,
+		"quote":    quote,
+		"warnings": priceMismatchWarnings(orderPayload, quote),
+	})
 }
 
 // ListUserOrders godoc
@@ -126,26 +153,9 @@ func (api *API) CreateOrder(c *gin.Context) {
 func (api *API) ListUserOrders(c *gin.Context) {
 	log := logger.Get()
 
-	claims, err := auth.ExtractTokenMetadata(c)
-	if err != nil {
-		log.Error("Error extracting token metadata", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
-		return
-	}
-
-	if time.Now().Unix() > claims.Exp {
-		log.Warn("Token expired", zap.Int64("expiration", claims.Exp))
-		c.JSON(http.StatusUnauthorized, gin.H{"error": true, "msg": "unauthorized, token expired"})
-		return
-	}
-
-	if !claims.Credentials[auth.OrderReadCredential] {
-		log.Warn("Permission denied for order read", zap.String("role", claims.Role))
-		c.JSON(http.StatusForbidden, gin.H{"error": true, "msg": "permission denied"})
-		return
-	}
+	principal := auth.CurrentPrincipal(c)
 
-	orders, err := api.Q.GetOrdersByUserID(c, claims.UserID)
+	orders, err := api.Q.GetOrdersByUserID(c, principal.UserID)
 	if err != nil {
 		log.Error("Error fetching orders", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
@@ -158,42 +168,46 @@ func (api *API) ListUserOrders(c *gin.Context) {
 
 // CancelOrder godoc
 // @Summary      Cancel an Order
-// @Description  Cancel a specific order if it is still pending
+// @Description  Cancel one of the authenticated user's orders if it is still pending
 // @Tags         Orders
 // @Param        id path string true "Order ID"
 // @Produce      json
 // @Success      200 {object} map[string]interface{} "Order cancelled successfully"
+// @Failure      400 {object} map[string]interface{} "Invalid order id"
 // @Failure      401 {object} map[string]interface{} "Unauthorized, token expired"
 // @Failure      403 {object} map[string]interface{} "Permission denied"
+// @Failure      404 {object} map[string]interface{} "Order not found"
+// @Failure      409 {object} map[string]interface{} "Order can no longer be cancelled"
 // @Failure      500 {object} map[string]interface{} "Internal server error"
-// @Router       /api/orders/{id}/cancel [patch]
+// @Router       /api/orders/{id}/cancel [put]
 func (api *API) CancelOrder(c *gin.Context) {
 	log := logger.Get()
 
-	claims, err := auth.ExtractTokenMetadata(c)
+	principal := auth.CurrentPrincipal(c)
+
+	orderID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		log.Error("Error extracting token metadata", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid order id"})
 		return
 	}
 
-	if time.Now().Unix() > claims.Exp {
-		log.Warn("Token expired", zap.Int64("expiration", claims.Exp))
-		c.JSON(http.StatusUnauthorized, gin.H{"error": true, "msg": "unauthorized, token expired"})
+	order, err := api.Q.GetOrderByID(c, orderID)
+	if err != nil {
+		log.Error("Error fetching order", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
 		return
 	}
-
-	if !claims.Credentials[auth.OrderCancelCredential] {
-		log.Warn("Permission denied for update read", zap.String("role", claims.Role))
-		c.JSON(http.StatusForbidden, gin.H{"error": true, "msg": "permission denied"})
+	if order == nil || order.UserID != principal.UserID {
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": "order not found"})
 		return
 	}
 
-	orderID := uuid.MustParse(c.Param("id"))
-
-	if err := api.Q.CancelOrderIfPending(c, orderID); err != nil {
-		log.Error("Error canceling order", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+	// Customers may only cancel orders that have not been paid for yet. The
+	// status is checked in the same transaction as the cancel, so a payment
+	// captured in the meantime wins and the order is not restocked.
+	err = api.Q.TransitionOrderStatusFrom(c, orderID, query.OrderPending, query.OrderCancelled, &principal.UserID, "cancelled by customer")
+	if err != nil {
+		respondTransitionError(c, err)
 		return
 	}
 
@@ -203,7 +217,7 @@ func (api *API) CancelOrder(c *gin.Context) {
 
 // UpdateOrderStatus godoc
 // @Summary      Update Order Status
-// @Description  Update the status of a specific order
+// @Description  Move an order through fulfilment, or cancel it while it is still pending
 // @Tags         Orders
 // @Param        id path string true "Order ID"
 // @Param        orderUpdatePayload body payload.OrderUpdatePayload true "Order Update Payload"
@@ -212,23 +226,14 @@ func (api *API) CancelOrder(c *gin.Context) {
 // @Failure      400 {object} map[string]interface{} "Invalid request body or validation error"
 // @Failure      401 {object} map[string]interface{} "Unauthorized"
 // @Failure      403 {object} map[string]interface{} "Permission denied"
+// @Failure      404 {object} map[string]interface{} "Order not found"
+// @Failure      409 {object} map[string]interface{} "Invalid status transition"
 // @Failure      500 {object} map[string]interface{} "Internal server error"
-// @Router       /orders/{id}/status [put]
+// @Router       /api/orders/{id}/status [put]
 func (api *API) UpdateOrderStatus(c *gin.Context) {
 	log := logger.Get()
 
-	claims, err := auth.ExtractTokenMetadata(c)
-	if err != nil {
-		log.Error("Error extracting token metadata", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
-		return
-	}
-
-	if !claims.Credentials[auth.OrderUpdateCredential] {
-		log.Warn("Permission denied for update read", zap.String("role", claims.Role))
-		c.JSON(http.StatusForbidden, gin.H{"error": true, "msg": "permission denied"})
-		return
-	}
+	principal := auth.CurrentPrincipal(c)
 
 	var orderUpdatePayload payload.OrderUpdatePayload
 	if err := c.ShouldBindJSON(&orderUpdatePayload); err != nil {
@@ -246,13 +251,165 @@ func (api *API) UpdateOrderStatus(c *gin.Context) {
 		return
 	}
 
-	orderID := uuid.MustParse(c.Param("id"))
-	if err := api.Q.UpdateOrderStatus(c, orderID, orderUpdatePayload.Status); err != nil {
-		log.Error("Error updating order status", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+	orderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid order id"})
+		return
+	}
+
+	// Orders that were paid for are cancelled through a refund, so the money
+	// goes back along with the units.
+	status := query.OrderStatus(orderUpdatePayload.Status)
+	if status == query.OrderCancelled {
+		err = api.Q.TransitionOrderStatusFrom(c, orderID, query.OrderPending, status, &principal.UserID, orderUpdatePayload.Reason)
+	} else {
+		err = api.Q.TransitionOrderStatus(c, orderID, status, &principal.UserID, orderUpdatePayload.Reason)
+	}
+	if err != nil {
+		respondTransitionError(c, err)
 		return
 	}
 
 	log.Info("Order status updated successfully", zap.String("order_id", orderID.String()), zap.String("status", orderUpdatePayload.Status))
 	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "Order status updated successfully"})
 }
+
+// GetOrderHistory godoc
+// @Summary      Order Status History
+// @Description  List every status change of an order, who made it, when and why
+// @Tags         Orders
+// @Param        id path string true "Order ID"
+// @Produce      json
+// @Success      200 {object} map[string]interface{} "Order history retrieved successfully"
+// @Failure      400 {object} map[string]interface{} "Invalid order id"
+// @Failure      401 {object} map[string]interface{} "Unauthorized, token expired"
+// @Failure      403 {object} map[string]interface{} "Permission denied"
+// @Failure      404 {object} map[string]interface{} "Order not found"
+// @Failure      500 {object} map[string]interface{} "Internal server error"
+// @Router       /api/orders/{id}/history [get]
+func (api *API) GetOrderHistory(c *gin.Context) {
+	log := logger.Get()
+
+	principal := auth.CurrentPrincipal(c)
+
+	orderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid order id"})
+		return
+	}
+
+	order, err := api.Q.GetOrderByID(c, orderID)
+	if err != nil {
+		log.Error("Error fetching order", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	// Customers can only see their own orders; order managers can see all.
+	if order == nil || (order.UserID != principal.UserID && !principal.Can(auth.OrderUpdateCredential)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": "order not found"})
+		return
+	}
+
+	history, err := api.Q.GetOrderStatusHistory(c, orderID)
+	if err != nil {
+		log.Error("Error fetching order history", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"error":   false,
+		"status":  order.Status,
+		"allowed": query.OrderStatus(order.Status).AllowedTransitions(),
+		"history": history,
+	})
+}
+
+func orderItemsFromPayload(orderPayload payload.OrderPayload) []query.OrderItem {
+	items := make([]query.OrderItem, 0, len(orderPayload.Items))
+	for _, itemPayload := range orderPayload.Items {
+		items = append(items, query.OrderItem{
+			ProductID: itemPayload.ProductID,
+			VariantID: itemPayload.VariantID,
+			Quantity:  itemPayload.Quantity,
+			CreatedAt: time.Now(),
+		})
+	}
+	return items
+}
+
+// PriceWarning reports a line whose client-side expected price differs from
+// the catalog price it was quoted at.
+type PriceWarning struct {
+	ProductID     uuid.UUID       `json:"product_id"`
+	VariantID     uuid.UUID       `json:"variant_id"`
+	ExpectedPrice decimal.Decimal `json:"expected_price"`
+	UnitPrice     decimal.Decimal `json:"unit_price"`
+	Msg           string          `json:"msg"`
+}
+
+func priceMismatchWarnings(orderPayload payload.OrderPayload, quote *query.Quote) []PriceWarning {
+	warnings := []PriceWarning{}
+	for i, itemPayload := range orderPayload.Items {
+		if itemPayload.ExpectedPrice == nil || i >= len(quote.Lines) {
+			continue
+		}
+
+		line := quote.Lines[i]
+		if !itemPayload.ExpectedPrice.Equal(line.UnitPrice) {
+			warnings = append(warnings, PriceWarning{
+				ProductID:     line.ProductID,
+				VariantID:     line.VariantID,
+				ExpectedPrice: *itemPayload.ExpectedPrice,
+				UnitPrice:     line.UnitPrice,
+				Msg:           "item price differs from the current product price",
+			})
+		}
+	}
+	return warnings
+}
+
+// respondOrderError maps an error from placing an order to its HTTP response.
+func respondOrderError(c *gin.Context, err error) {
+	log := logger.Get()
+
+	switch {
+	case errors.Is(err, query.ErrInsufficientStock):
+		log.Warn("Insufficient stock for order", zap.Error(err))
+		c.JSON(http.StatusConflict, gin.H{"error": true, "msg": err.Error()})
+	case errors.Is(err, query.ErrProductNotFound), errors.Is(err, query.ErrVariantNotFound), errors.Is(err, promotion.ErrInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+	default:
+		log.Error("Error placing order", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+	}
+}
+
+// respondTransitionError maps an error from an order status change to its
+// HTTP response. Invalid transitions report the statuses that are allowed.
+func respondTransitionError(c *gin.Context, err error) {
+	var transitionErr *query.InvalidTransitionError
+	var statusErr *query.UnexpectedStatusError
+
+	switch {
+	case errors.As(err, &statusErr):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  true,
+			"msg":    statusErr.Error(),
+			"status": statusErr.Actual,
+		})
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   true,
+			"msg":     transitionErr.Error(),
+			"status":  transitionErr.From,
+			"allowed": transitionErr.Allowed,
+		})
+	case errors.Is(err, query.ErrOrderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": err.Error()})
+	default:
+		logger.Get().Error("Error updating order status", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+	}
+}
@@ -0,0 +1,599 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/amosehiguese/ecommerce-api/api"
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/pkg/payment"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/amosehiguese/ecommerce-api/routes"
+	"github.com/amosehiguese/ecommerce-api/server"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentOrdersDoNotOversell(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", ta.Addr)
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	// Seed a product whose default variant has only one unit left
+	productID := uuid.New()
+	_, err = ta.DB.Exec(`
+		INSERT INTO "product" (id, name, description, price, units_in_stock)
+		VALUES ($1, 'Last Unit', 'Only one left', 19.99, 1)
+	`, productID)
+	if err != nil {
+		t.Fatalf("failed to seed product: %v", err)
+	}
+	_, err = ta.DB.Exec(`
+		INSERT INTO "product_variant" (product_id, sku, units_in_stock, is_default)
+		VALUES ($1, 'LAST-UNIT', 1, TRUE)
+	`, productID)
+	if err != nil {
+		t.Fatalf("failed to seed product variant: %v", err)
+	}
+
+	const buyers = 10
+	tokens := make([]string, buyers)
+	for i := range tokens {
+		tokens[i] = registerAndLogin(t, baseURL, fmt.Sprintf("buyer%d@example.com", i))
+	}
+
+	orderPayload := payload.OrderPayload{
+		Items: []payload.OrderItemPayload{
+			{ProductID: productID, Quantity: 1},
+		},
+	}
+
+	var wg sync.WaitGroup
+	statuses := make(chan int, buyers)
+	for _, token := range tokens {
+		wg.Add(1)
+		go func(token string) {
+			defer wg.Done()
+
+			req, err := http.NewRequest("POST", baseURL+"/api/orders", createJSONRequestBody(orderPayload))
+			if err != nil {
+				t.Errorf("Failed to create request: %v", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+token)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Errorf("Failed to place order: %v", err)
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}(token)
+	}
+	wg.Wait()
+	close(statuses)
+
+	var placed, rejected int
+	for status := range statuses {
+		switch status {
+		case http.StatusOK:
+			placed++
+		case http.StatusConflict:
+			rejected++
+		default:
+			t.Errorf("unexpected status code: %d", status)
+		}
+	}
+
+	assert.Equal(t, 1, placed)
+	assert.Equal(t, buyers-1, rejected)
+
+	var unitsInStock int
+	err = ta.DB.QueryRow(`SELECT units_in_stock FROM "product" WHERE id = $1`, productID).Scan(&unitsInStock)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, unitsInStock)
+
+	var orderedUnits int
+	err = ta.DB.QueryRow(`SELECT COALESCE(SUM(quantity), 0) FROM "order_item" WHERE product_id = $1`, productID).Scan(&orderedUnits)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, orderedUnits)
+}
+
+func TestOrdersArePricedServerSide(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	router := routes.SetUp(ta.DB, config.Get())
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	productID := uuid.New()
+	_, err = ta.DB.Exec(`
+		INSERT INTO "product" (id, name, description, price, units_in_stock)
+		VALUES ($1, 'Teapot', 'A ceramic teapot', 19.99, 10)
+	`, productID)
+	require.NoError(t, err)
+	_, err = ta.DB.Exec(`
+		INSERT INTO "product_variant" (product_id, sku, units_in_stock, is_default)
+		VALUES ($1, 'TEAPOT', 10, TRUE)
+	`, productID)
+	require.NoError(t, err)
+
+	var res struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+		Quote    query.Quote        `json:"quote"`
+		Orders   []query.Order      `json:"orders"`
+		Warnings []api.PriceWarning `json:"warnings"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		res.Warnings = nil
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	}
+	send := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, createJSONRequestBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	amount := func(s string) decimal.Decimal {
+		return decimal.RequireFromString(s)
+	}
+	price := func(s string) *decimal.Decimal {
+		d := amount(s)
+		return &d
+	}
+
+	login := payload.LoginPayload{Email: "pricing@example.com", Password: "password123"}
+	w := send("POST", "/api/auth/register", "", payload.RegisterPayload{FirstName: "Pricing", Email: login.Email, Password: login.Password})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("POST", "/api/auth/login", "", login)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	token := res.Tokens.Access
+
+	w = send("POST", "/api/me/addresses", token, payload.AddressPayload{
+		FullName:   "Pricing",
+		Line1:      "1 Market Street",
+		City:       "San Francisco",
+		PostalCode: "94105",
+		Country:    "US",
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// The quote prices the lines from the catalog and reserves nothing
+	items := []payload.OrderItemPayload{{ProductID: productID, Quantity: 2, ExpectedPrice: price("19.99")}}
+	w = send("POST", "/api/orders/quote", token, payload.OrderPayload{Items: items})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	require.Len(t, res.Quote.Lines, 1)
+	assert.True(t, res.Quote.Lines[0].UnitPrice.Equal(amount("19.99")), res.Quote.Lines[0].UnitPrice.String())
+	assert.True(t, res.Quote.Lines[0].LineTotal.Equal(amount("39.98")), res.Quote.Lines[0].LineTotal.String())
+	assert.True(t, res.Quote.Subtotal.Equal(amount("39.98")), res.Quote.Subtotal.String())
+	assert.True(t, res.Quote.Total.Equal(res.Quote.Subtotal.Add(res.Quote.Shipping)), res.Quote.Total.String())
+	assert.Empty(t, res.Warnings, "the expected price matches")
+
+	var unitsInStock int
+	err = ta.DB.QueryRow(`SELECT units_in_stock FROM "product_variant" WHERE product_id = $1`, productID).Scan(&unitsInStock)
+	require.NoError(t, err)
+	assert.Equal(t, 10, unitsInStock)
+
+	// A stale client price is only a warning; the order uses the catalog price
+	items[0].ExpectedPrice = price("0.01")
+	w = send("POST", "/api/orders", token, payload.OrderPayload{Items: items})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	require.Len(t, res.Warnings, 1)
+	assert.Equal(t, productID, res.Warnings[0].ProductID)
+	assert.True(t, res.Warnings[0].ExpectedPrice.Equal(amount("0.01")), res.Warnings[0].ExpectedPrice.String())
+	assert.True(t, res.Warnings[0].UnitPrice.Equal(amount("19.99")), res.Warnings[0].UnitPrice.String())
+
+	w = send("GET", "/api/orders", token, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	require.Len(t, res.Orders, 1)
+	order := res.Orders[0]
+	require.Len(t, order.Items, 1)
+	assert.True(t, order.Items[0].Price.Equal(amount("19.99")), order.Items[0].Price.String())
+	assert.True(t, order.SubtotalAmount.Equal(amount("39.98")), order.SubtotalAmount.String())
+	assert.True(t, order.TotalAmount.Equal(order.SubtotalAmount.Add(order.ShippingAmount)), order.TotalAmount.String())
+
+	// Prices are only ever read from the catalog
+	w = send("POST", "/api/orders", token, payload.OrderPayload{
+		Items: []payload.OrderItemPayload{{ProductID: uuid.New(), Quantity: 1, ExpectedPrice: price("1.00")}},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "unknown products cannot be ordered at any price")
+	w = send("POST", "/api/orders", token, payload.OrderPayload{
+		Items: []payload.OrderItemPayload{{ProductID: productID, Quantity: 1001}},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "quantities are bounded")
+}
+
+func TestOrderLifecycle(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	router := routes.SetUp(ta.DB, config.Get(), routes.WithPaymentProvider(payment.NewFakeProvider()))
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	productID := uuid.New()
+	_, err = ta.DB.Exec(`
+		INSERT INTO "product" (id, name, description, price, units_in_stock)
+		VALUES ($1, 'Kettle', 'An electric kettle', 25.99, 10)
+	`, productID)
+	require.NoError(t, err)
+	_, err = ta.DB.Exec(`
+		INSERT INTO "product_variant" (product_id, sku, units_in_stock, is_default)
+		VALUES ($1, 'KETTLE', 10, TRUE)
+	`, productID)
+	require.NoError(t, err)
+
+	var res struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+		OrderID string                    `json:"order_id"`
+		Status  query.OrderStatus         `json:"status"`
+		Allowed []query.OrderStatus       `json:"allowed"`
+		History []query.OrderStatusChange `json:"history"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		res.Allowed, res.History = nil, nil
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	}
+	send := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, createJSONRequestBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	login := func(email string) string {
+		w := send("POST", "/api/auth/login", "", payload.LoginPayload{Email: email, Password: "password123"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		decode(w)
+		return res.Tokens.Access
+	}
+	unitsInStock := func() (variant, product int) {
+		err := ta.DB.QueryRow(`
+			SELECT v.units_in_stock, p.units_in_stock
+			FROM "product_variant" v JOIN "product" p ON p.id = v.product_id
+			WHERE p.id = $1
+		`, productID).Scan(&variant, &product)
+		require.NoError(t, err)
+		return variant, product
+	}
+
+	for _, email := range []string{"customer@example.com", "other@example.com", "staff@example.com"} {
+		w := send("POST", "/api/auth/register", "", payload.RegisterPayload{FirstName: "Test", Email: email, Password: "password123"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	_, err = ta.DB.Exec(`UPDATE "user" SET role = 'admin' WHERE email = 'staff@example.com'`)
+	require.NoError(t, err)
+	customer, other, admin := login("customer@example.com"), login("other@example.com"), login("staff@example.com")
+
+	w := send("POST", "/api/me/addresses", customer, payload.AddressPayload{
+		FullName:   "Customer",
+		Line1:      "1 Market Street",
+		City:       "San Francisco",
+		PostalCode: "94105",
+		Country:    "US",
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	placeOrder := func(quantity int) string {
+		w := send("POST", "/api/orders", customer, payload.OrderPayload{
+			Items: []payload.OrderItemPayload{{ProductID: productID, Quantity: quantity}},
+		})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		decode(w)
+		return res.OrderID
+	}
+	setStatus := func(orderID, status, reason string) *httptest.ResponseRecorder {
+		return send("PUT", "/api/orders/"+orderID+"/status", admin, payload.OrderUpdatePayload{Status: status, Reason: reason})
+	}
+
+	orderID := placeOrder(3)
+	variant, product := unitsInStock()
+	assert.Equal(t, 7, variant)
+	assert.Equal(t, 7, product)
+
+	w = send("PUT", "/api/orders/"+orderID+"/status", customer, payload.OrderUpdatePayload{Status: "fulfilling"})
+	assert.Equal(t, http.StatusForbidden, w.Code, "customers cannot change order statuses")
+
+	// Skipping a step is rejected with the statuses that are allowed
+	w = setStatus(orderID, "shipped", "")
+	require.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, query.OrderPending, res.Status)
+	assert.ElementsMatch(t, []query.OrderStatus{query.OrderPaid, query.OrderCancelled}, res.Allowed)
+
+	// Statuses that follow money are only set by the payment flows
+	for _, status := range []string{"pending", "paid", "refunded", "partially_refunded", "disputed"} {
+		w = setStatus(orderID, status, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, status)
+	}
+	w = send("POST", "/api/orders/"+orderID+"/pay", customer, payload.PayOrderPayload{PaymentMethod: payment.FakeCardOK})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = setStatus(orderID, "fulfilling", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = send("PUT", "/api/orders/"+orderID+"/cancel", customer, nil)
+	assert.Equal(t, http.StatusConflict, w.Code, "customers can only cancel pending orders")
+
+	w = send("GET", "/api/orders/"+orderID+"/history", other, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code, "other customers cannot see the order")
+
+	w = send("GET", "/api/orders/"+orderID+"/history", customer, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, query.OrderFulfilling, res.Status)
+	assert.Contains(t, res.Allowed, query.OrderShipped)
+	require.Len(t, res.History, 3)
+	assert.Nil(t, res.History[0].FromStatus)
+	assert.Equal(t, query.OrderPending, res.History[0].ToStatus)
+	require.NotNil(t, res.History[1].FromStatus)
+	assert.Equal(t, query.OrderPending, *res.History[1].FromStatus)
+	assert.Equal(t, query.OrderPaid, res.History[1].ToStatus)
+	require.NotNil(t, res.History[1].Reason)
+	assert.Equal(t, "payment captured", *res.History[1].Reason)
+	require.NotNil(t, res.History[1].ChangedBy)
+	assert.Equal(t, query.OrderFulfilling, res.History[2].ToStatus)
+
+	// Paid orders are cancelled through a refund, not by hand
+	w = setStatus(orderID, "cancelled", "out of stock at the warehouse")
+	require.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, query.OrderFulfilling, res.Status)
+	variant, product = unitsInStock()
+	assert.Equal(t, 7, variant)
+	assert.Equal(t, 7, product)
+
+	// Cancelling puts the units back, and cancelled orders stay cancelled
+	cancelledID := placeOrder(2)
+	w = setStatus(cancelledID, "cancelled", "out of stock at the warehouse")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	variant, product = unitsInStock()
+	assert.Equal(t, 7, variant)
+	assert.Equal(t, 7, product)
+
+	w = setStatus(cancelledID, "fulfilling", "")
+	require.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, query.OrderCancelled, res.Status)
+	assert.Empty(t, res.Allowed)
+
+	w = setStatus(uuid.New().String(), "fulfilling", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Customers can cancel their pending orders themselves
+	orderID = placeOrder(2)
+	w = send("PUT", "/api/orders/"+orderID+"/cancel", customer, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	variant, product = unitsInStock()
+	assert.Equal(t, 7, variant)
+	assert.Equal(t, 7, product)
+
+	w = send("GET", "/api/orders/"+orderID+"/history", admin, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	require.Len(t, res.History, 2)
+	assert.Equal(t, query.OrderCancelled, res.History[1].ToStatus)
+}
+
+// registerAndLogin creates an account with a default address and returns an
+// access token for it.
+func registerAndLogin(t *testing.T, baseURL, email string) string {
+	t.Helper()
+
+	registerPayload := payload.RegisterPayload{
+		FirstName: "Buyer",
+		LastName:  "Test",
+		Email:     email,
+		Password:  "securepassword123",
+	}
+	resp, err := http.Post(baseURL+"/api/auth/register", "application/json", createJSONRequestBody(registerPayload))
+	if err != nil {
+		t.Fatalf("Failed to register %s: %v", email, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to register %s: status %d", email, resp.StatusCode)
+	}
+
+	loginPayload := payload.LoginPayload{
+		Email:    email,
+		Password: "securepassword123",
+	}
+	resp, err = http.Post(baseURL+"/api/auth/login", "application/json", createJSONRequestBody(loginPayload))
+	if err != nil {
+		t.Fatalf("Failed to login %s: %v", email, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode login response: %v", err)
+	}
+
+	// Orders ship to the default address
+	addressPayload := payload.AddressPayload{
+		FullName:   "Buyer Test",
+		Line1:      "1 Market Street",
+		City:       "San Francisco",
+		Region:     "CA",
+		PostalCode: "94105",
+		Country:    "US",
+	}
+	req, err := http.NewRequest("POST", baseURL+"/api/me/addresses", createJSONRequestBody(addressPayload))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+body.Tokens.Access)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to add address for %s: %v", email, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to add address for %s: status %d", email, resp.StatusCode)
+	}
+
+	return body.Tokens.Access
+}
+
+func TestCancelRacingPayment(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	router := routes.SetUp(ta.DB, config.Get(), routes.WithPaymentProvider(payment.NewFakeProvider()))
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	productID := uuid.New()
+	_, err = ta.DB.Exec(`
+		INSERT INTO "product" (id, name, description, price, units_in_stock)
+		VALUES ($1, 'Kettle', 'An electric kettle', 25.99, 100)
+	`, productID)
+	require.NoError(t, err)
+	_, err = ta.DB.Exec(`
+		INSERT INTO "product_variant" (product_id, sku, units_in_stock, is_default)
+		VALUES ($1, 'KETTLE', 100, TRUE)
+	`, productID)
+	require.NoError(t, err)
+
+	var res struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+		OrderID string `json:"order_id"`
+	}
+	send := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, createJSONRequestBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/api/auth/register", "", payload.RegisterPayload{FirstName: "Test", Email: "customer@example.com", Password: "password123"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("POST", "/api/auth/login", "", payload.LoginPayload{Email: "customer@example.com", Password: "password123"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	customer := res.Tokens.Access
+
+	w = send("POST", "/api/me/addresses", customer, payload.AddressPayload{
+		FullName:   "Customer",
+		Line1:      "1 Market Street",
+		City:       "San Francisco",
+		PostalCode: "94105",
+		Country:    "US",
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Each round pays and cancels the same order at once. Whichever comes
+	// second must fail, and a paid order is never restocked.
+	const rounds = 10
+	for i := 0; i < rounds; i++ {
+		w := send("POST", "/api/orders", customer, payload.OrderPayload{
+			Items: []payload.OrderItemPayload{{ProductID: productID, Quantity: 1}},
+		})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		orderPath := "/api/orders/" + res.OrderID
+
+		var wg sync.WaitGroup
+		var paid, cancelled *httptest.ResponseRecorder
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			paid = send("POST", orderPath+"/pay", customer, payload.PayOrderPayload{PaymentMethod: payment.FakeCardOK})
+		}()
+		go func() {
+			defer wg.Done()
+			cancelled = send("PUT", orderPath+"/cancel", customer, nil)
+		}()
+		wg.Wait()
+
+		var status query.OrderStatus
+		require.NoError(t, ta.DB.QueryRow(`SELECT status FROM "order" WHERE id = $1`, res.OrderID).Scan(&status))
+		switch status {
+		case query.OrderPaid:
+			assert.Equal(t, http.StatusOK, paid.Code, paid.Body.String())
+			assert.Equal(t, http.StatusConflict, cancelled.Code, "a paid order cannot be cancelled by the customer")
+		case query.OrderCancelled:
+			assert.Equal(t, http.StatusOK, cancelled.Code, cancelled.Body.String())
+			assert.NotEqual(t, http.StatusOK, paid.Code, "a cancelled order cannot be paid")
+		default:
+			t.Fatalf("unexpected order status %s", status)
+		}
+	}
+
+	// Every order kept its unit unless it ended up cancelled
+	var unitsInStock, paidUnits int
+	err = ta.DB.QueryRow(`SELECT units_in_stock FROM "product_variant" WHERE product_id = $1`, productID).Scan(&unitsInStock)
+	require.NoError(t, err)
+	err = ta.DB.QueryRow(`
+		SELECT COALESCE(SUM(i.quantity), 0)
+		FROM "order_item" i JOIN "order" o ON o.id = i.order_id
+		WHERE o.status = 'paid'
+	`).Scan(&paidUnits)
+	require.NoError(t, err)
+	assert.Equal(t, 100-paidUnits, unitsInStock)
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/mailer"
+	"github.com/amosehiguese/ecommerce-api/pkg/utils"
+	"github.com/amosehiguese/ecommerce-api/pkg/validator"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	passwordResetTTL = time.Hour
+	// passwordResetLimit caps the reset emails an account receives per hour.
+	passwordResetLimit = 3
+)
+
+// ForgotPassword godoc
+// @Summary Request a password reset
+// @Description Emails a single-use password reset link valid for one hour. The response is the same whether or not the email belongs to an account, and an account receives at most 3 reset emails per hour.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param forgot body payload.ForgotPasswordPayload true "Account email"
+// @Success 200 {object} gin.H{"error": false, "msg": "if an account exists for this email, a password reset link has been sent"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/auth/password/forgot [post]
+func (api *API) ForgotPassword(c *gin.Context) {
+	log := logger.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var forgotPayload payload.ForgotPasswordPayload
+	if err := c.ShouldBindJSON(&forgotPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(forgotPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	// Whatever happens below, the caller must not learn whether the email
+	// belongs to an account.
+	sent := gin.H{"error": false, "msg": "if an account exists for this email, a password reset link has been sent"}
+
+	u, err := api.Q.GetUserByEmail(ctx, forgotPayload.Email)
+	if err != nil {
+		log.Error("Error fetching user for password reset", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the request"})
+		return
+	}
+	if u == nil {
+		c.JSON(http.StatusOK, sent)
+		return
+	}
+
+	now := time.Now()
+	count, err := api.Q.CountUserTokensSince(ctx, u.ID, query.TokenPurposePasswordReset, now.Add(-time.Hour))
+	if err != nil {
+		log.Error("Error counting password reset requests", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the request"})
+		return
+	}
+	if count >= passwordResetLimit {
+		log.Warn("Password reset rate limit reached", zap.String("user_id", u.ID.String()))
+		c.JSON(http.StatusOK, sent)
+		return
+	}
+
+	token, err := utils.GetURLTokenStr(32)
+	if err != nil {
+		log.Error("Error generating password reset token", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the request"})
+		return
+	}
+
+	err = api.Q.CreateUserToken(ctx, &query.UserToken{
+		ID:        uuid.New(),
+		UserID:    u.ID,
+		Purpose:   query.TokenPurposePasswordReset,
+		TokenHash: utils.GetHash(token),
+		ExpiresAt: now.Add(passwordResetTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		log.Error("Error saving password reset token", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the request"})
+		return
+	}
+
+	link := api.Cfg.Mail.AppURL + "/reset-password?token=" + url.QueryEscape(token)
+	msg := mailer.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. "+
+			"If it was you, open the link below within the next hour:\n\n%s\n\n"+
+			"If it was not you, you can ignore this email; your password stays the same.\n",
+			u.FirstName, link),
+	}
+	// The email goes out in the background: waiting for the mail server would
+	// make requests for existing accounts measurably slower than the others.
+	go func(userID uuid.UUID) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := api.Mailer.Send(ctx, msg); err != nil {
+			log.Error("Error sending password reset email", zap.String("user_id", userID.String()), zap.Error(err))
+		}
+	}(u.ID)
+
+	log.Info("Password reset requested", zap.String("user_id", u.ID.String()))
+	c.JSON(http.StatusOK, sent)
+}
+
+// ResetPassword godoc
+// @Summary Reset a password
+// @Description Sets a new password using a token from a password reset email. The token works once, and every session of the account is ended.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param reset body payload.ResetPasswordPayload true "Reset token and new password"
+// @Success 200 {object} gin.H{"error": false, "msg": "password has been reset, please log in again"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "invalid or expired token"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/auth/password/reset [post]
+func (api *API) ResetPassword(c *gin.Context) {
+	log := logger.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var resetPayload payload.ResetPasswordPayload
+	if err := c.ShouldBindJSON(&resetPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(resetPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	if err := api.Passwords.Check(resetPayload.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	passwordHash, err := utils.HashPassword(resetPayload.Password)
+	if err != nil {
+		log.Error("Error hashing new password", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to reset password"})
+		return
+	}
+
+	userID, err := api.Q.ResetPassword(ctx, utils.GetHash(resetPayload.Token), passwordHash)
+	if err != nil {
+		if errors.Is(err, query.ErrInvalidUserToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+			return
+		}
+		log.Error("Error resetting password", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to reset password"})
+		return
+	}
+
+	// Whoever knew the old password may still hold a session.
+	if err := api.Sessions.RevokeUser(ctx, userID); err != nil {
+		log.Error("Error revoking sessions after password reset", zap.String("user_id", userID.String()), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "password was reset but existing sessions could not be ended"})
+		return
+	}
+
+	log.Info("Password reset", zap.String("user_id", userID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "password has been reset, please log in again"})
+}
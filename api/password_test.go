@@ -0,0 +1,134 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/pkg/mailer"
+	"github.com/amosehiguese/ecommerce-api/routes"
+	"github.com/amosehiguese/ecommerce-api/server"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordReset(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	outbox := mailer.NewMemoryMailer()
+	router := routes.SetUp(ta.DB, config.Get(), routes.WithMailer(outbox))
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	const email = "forgetful@example.com"
+	w := postJSON(router, "/api/auth/register", payload.RegisterPayload{
+		FirstName: "Forgetful",
+		Email:     email,
+		Password:  "oldpassword123",
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = postJSON(router, "/api/auth/password/forgot", payload.ForgotPasswordPayload{Email: email})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// The email is sent in the background
+	require.Eventually(t, func() bool {
+		return len(outbox.Messages()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Unknown emails get the same answer and no email; the other message is
+	// the verification email sent on register.
+	w = postJSON(router, "/api/auth/password/forgot", payload.ForgotPasswordPayload{Email: "nobody@example.com"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, outbox.Messages(), 2)
+
+	token := lastEmailToken(t, outbox, email)
+
+	reset := payload.ResetPasswordPayload{Token: token, Password: "newpassword456"}
+	w = postJSON(router, "/api/auth/password/reset", reset)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// The token is single-use
+	w = postJSON(router, "/api/auth/password/reset", reset)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = postJSON(router, "/api/auth/login", payload.LoginPayload{Email: email, Password: "oldpassword123"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = postJSON(router, "/api/auth/login", payload.LoginPayload{Email: email, Password: "newpassword456"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestPasswordUpgradeOnLogin(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	router := routes.SetUp(ta.DB, config.Get())
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	const email = "oldtimer@example.com"
+	w := postJSON(router, "/api/auth/register", payload.RegisterPayload{FirstName: "Old", Email: email, Password: "short"})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "the password policy applies on register")
+
+	w = postJSON(router, "/api/auth/register", payload.RegisterPayload{FirstName: "Old", Email: email, Password: "password123"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Pretend the account predates Argon2id
+	legacy, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	require.NoError(t, err)
+	_, err = ta.DB.Exec(`UPDATE "user" SET password_hash = $1 WHERE email = $2`, string(legacy), email)
+	require.NoError(t, err)
+
+	w = postJSON(router, "/api/auth/login", payload.LoginPayload{Email: email, Password: "password123"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var hash string
+	require.NoError(t, ta.DB.QueryRow(`SELECT password_hash FROM "user" WHERE email = $1`, email).Scan(&hash))
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$"), hash)
+}
+
+// lastEmailToken returns the token of the link in the last email sent to addr.
+func lastEmailToken(t *testing.T, outbox *mailer.MemoryMailer, addr string) string {
+	t.Helper()
+	msg, ok := outbox.Last(addr)
+	require.True(t, ok, "no email sent to %s", addr)
+	link := regexp.MustCompile(`https?://\S+`).FindString(msg.Body)
+	parsed, err := url.Parse(link)
+	require.NoError(t, err)
+	token := parsed.Query().Get("token")
+	require.NotEmpty(t, token)
+	return token
+}
+
+func postJSON(router *gin.Engine, path string, body interface{}) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", path, createJSONRequestBody(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
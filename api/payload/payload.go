@@ -1,6 +1,8 @@
 package payload
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 )
@@ -9,13 +11,47 @@ type RegisterPayload struct {
 	FirstName string `json:"first_name" binding:"required"`
 	LastName  string `json:"last_name,omitempty"`
 	Email     string `json:"email" binding:"required,email"`
-	Password  string `json:"password" binding:"required,min=8"`
+	Password  string `json:"password" binding:"required"`
 	Role      string `json:"role,omitempty"`
 }
 
 type LoginPayload struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
+	DeviceID string `json:"device_id,omitempty" validate:"omitempty,max=255"`
+}
+
+type ForgotPasswordPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+type ResetPasswordPayload struct {
+	Token    string `json:"token" validate:"required,max=255"`
+	Password string `json:"password" validate:"required"`
+}
+
+type ResendVerificationPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+type MFACodePayload struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type MFADisablePayload struct {
+	Password     string `json:"password" validate:"required,max=72"`
+	Code         string `json:"code,omitempty" validate:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recovery_code,omitempty" validate:"omitempty,max=32"`
+}
+
+type MFAChallengePayload struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+}
+
+type MFALoginPayload struct {
+	MFAToken     string `json:"mfa_token" validate:"required"`
+	Code         string `json:"code,omitempty" validate:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recovery_code,omitempty" validate:"omitempty,max=32"`
 }
 
 type ProductPayload struct {
@@ -23,30 +59,236 @@ type ProductPayload struct {
 	Description  string  `json:"description" binding:"required"`
 	Price        float64 `json:"price" binding:"required,gt=0"`
 	UnitsInStock int     `json:"units_in_stock" binding:"required,gt=0"`
+	SKU          string  `json:"sku,omitempty" validate:"omitempty,max=64"`
+	Barcode      string  `json:"barcode,omitempty" validate:"omitempty,max=64"`
+}
+
+// ProductVariantsPayload is the full option and variant matrix of a product.
+type ProductVariantsPayload struct {
+	Options  []ProductOptionPayload  `json:"options" validate:"dive"`
+	Variants []ProductVariantPayload `json:"variants" validate:"required,min=1,dive"`
 }
 
+type ProductOptionPayload struct {
+	Name     string   `json:"name" validate:"required,max=50"`
+	Values   []string `json:"values" validate:"required,min=1,dive,required,max=50"`
+	Position int      `json:"position" validate:"gte=0"`
+}
+
+type ProductVariantPayload struct {
+	ID           *uuid.UUID        `json:"id,omitempty"`
+	SKU          string            `json:"sku" validate:"required,max=64"`
+	Barcode      string            `json:"barcode,omitempty" validate:"omitempty,max=64"`
+	Price        *decimal.Decimal  `json:"price,omitempty"`
+	UnitsInStock int               `json:"units_in_stock" validate:"gte=0"`
+	Options      map[string]string `json:"options"`
+	IsDefault    bool              `json:"is_default"`
+}
+
+// ProductListQuery holds the query string of a product listing.
+type ProductListQuery struct {
+	Limit        int       `form:"limit" validate:"omitempty,min=1,max=100"`
+	Cursor       string    `form:"cursor"`
+	Sort         string    `form:"sort" validate:"omitempty,oneof=newest price_asc price_desc name_asc name_desc"`
+	MinPrice     string    `form:"min_price" validate:"omitempty,numeric"`
+	MaxPrice     string    `form:"max_price" validate:"omitempty,numeric"`
+	InStock      bool      `form:"in_stock"`
+	Name         string    `form:"name" validate:"omitempty,max=255"`
+	CreatedSince time.Time `form:"created_since" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+type ProductSearchQuery struct {
+	Q     string `form:"q" validate:"required,max=200"`
+	Limit int    `form:"limit" validate:"omitempty,min=1,max=50"`
+}
+
+type CategoryPayload struct {
+	Name        string     `json:"name" validate:"required,min=2,max=100"`
+	Slug        string     `json:"slug,omitempty" validate:"omitempty,max=120"`
+	Description string     `json:"description,omitempty" validate:"omitempty,max=1000"`
+	ParentID    *uuid.UUID `json:"parent_id,omitempty"`
+	Position    int        `json:"position" validate:"gte=0"`
+}
+
+// PromotionPayload creates or replaces a promotion. Value is the percentage
+// off for percentage promotions and the amount off for fixed ones; buy_x_get_y
+// promotions need BuyQuantity and GetQuantity instead. A promotion without
+// ProductIDs or CategoryIDs applies to every product.
+type PromotionPayload struct {
+	Code         string          `json:"code" validate:"required,min=3,max=50"`
+	Description  string          `json:"description,omitempty" validate:"omitempty,max=1000"`
+	Type         string          `json:"type" validate:"required,oneof=percentage fixed buy_x_get_y free_shipping"`
+	Value        decimal.Decimal `json:"value"`
+	BuyQuantity  *int            `json:"buy_quantity,omitempty" validate:"omitempty,gt=0,max=1000"`
+	GetQuantity  *int            `json:"get_quantity,omitempty" validate:"omitempty,gt=0,max=1000"`
+	MinSubtotal  decimal.Decimal `json:"min_subtotal"`
+	StartsAt     *time.Time      `json:"starts_at,omitempty"`
+	EndsAt       *time.Time      `json:"ends_at,omitempty"`
+	UsageLimit   *int            `json:"usage_limit,omitempty" validate:"omitempty,gt=0"`
+	PerUserLimit *int            `json:"per_user_limit,omitempty" validate:"omitempty,gt=0"`
+	Stackable    bool            `json:"stackable"`
+	// Active defaults to true.
+	Active      *bool       `json:"active,omitempty"`
+	ProductIDs  []uuid.UUID `json:"product_ids,omitempty" validate:"max=500"`
+	CategoryIDs []uuid.UUID `json:"category_ids,omitempty" validate:"max=500"`
+}
+
+type ProductCategoriesPayload struct {
+	CategoryIDs []uuid.UUID `json:"category_ids" validate:"required"`
+}
+
+type RolePayload struct {
+	Name        string   `json:"name" validate:"required,min=2,max=50"`
+	Description string   `json:"description,omitempty" validate:"omitempty,max=1000"`
+	Permissions []string `json:"permissions" validate:"dive,required,max=100"`
+}
+
+type RoleUpdatePayload struct {
+	Description string   `json:"description,omitempty" validate:"omitempty,max=1000"`
+	Permissions []string `json:"permissions" validate:"required,dive,required,max=100"`
+}
+
+type UserRolePayload struct {
+	Role string `json:"role" validate:"required,max=50"`
+}
+
+// UpdateProfilePayload changes the names of the current user. Omitted fields
+// stay the same; an empty last name removes it.
+type UpdateProfilePayload struct {
+	FirstName *string `json:"first_name,omitempty" validate:"omitempty,min=2,max=100"`
+	LastName  *string `json:"last_name,omitempty" validate:"omitempty,max=100"`
+}
+
+type ChangeEmailPayload struct {
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required"`
+}
+
+type ChangePasswordPayload struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+type DeleteAccountPayload struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// SetupAdminPayload creates the first admin account with the setup token from
+// the configuration.
+type SetupAdminPayload struct {
+	SetupToken string `json:"setup_token" validate:"required,max=255"`
+	FirstName  string `json:"first_name" validate:"required,max=100"`
+	LastName   string `json:"last_name,omitempty" validate:"omitempty,max=100"`
+	Email      string `json:"email" validate:"required,email"`
+	Password   string `json:"password" validate:"required"`
+}
+
+// UserListQuery holds the query string of the admin user listing.
+type UserListQuery struct {
+	Limit  int    `form:"limit" validate:"omitempty,min=1,max=100"`
+	Cursor string `form:"cursor"`
+	Q      string `form:"q" validate:"omitempty,max=255"`
+	Role   string `form:"role" validate:"omitempty,max=50"`
+	Status string `form:"status" validate:"omitempty,oneof=active suspended deleted"`
+}
+
+type SuspendUserPayload struct {
+	Reason string `json:"reason,omitempty" validate:"omitempty,max=500"`
+}
+
+// AuditLogQuery holds the query string of an audit log listing.
+type AuditLogQuery struct {
+	Limit      int    `form:"limit" validate:"omitempty,min=1,max=200"`
+	Cursor     string `form:"cursor"`
+	ActorID    string `form:"actor_id" validate:"omitempty,max=36"`
+	TargetType string `form:"target_type" validate:"omitempty,max=50"`
+	TargetID   string `form:"target_id" validate:"omitempty,max=255"`
+	Action     string `form:"action" validate:"omitempty,max=100"`
+}
+
+// RefundPayload refunds an order. Without items, everything not refunded yet
+// is refunded.
+type RefundPayload struct {
+	Items   []RefundItemPayload `json:"items,omitempty" validate:"omitempty,dive"`
+	Reason  string              `json:"reason" validate:"required,max=500"`
+	Restock bool                `json:"restock"`
+}
+
+type RefundItemPayload struct {
+	OrderItemID uuid.UUID `json:"order_item_id" validate:"required"`
+	Quantity    int       `json:"quantity" validate:"required,gt=0"`
+}
+
+// PaymentEventQuery holds the query string of a payment event listing.
+type PaymentEventQuery struct {
+	Limit       int  `form:"limit" validate:"omitempty,min=1,max=200"`
+	Unprocessed bool `form:"unprocessed"`
+}
+
+// OrderUpdatePayload moves an order by hand. The statuses that follow money,
+// such as paid and refunded, are only set by the payment and refund flows.
 type OrderUpdatePayload struct {
-	Status string `json:"status" validate:"required,oneof=pending completed cancelled"`
+	Status string `json:"status" validate:"required,oneof=fulfilling shipped delivered cancelled"`
+	Reason string `json:"reason,omitempty" validate:"omitempty,max=500"`
 }
 
+// OrderPayload places an order. Addresses omitted are taken from the default
+// addresses of the address book; billing falls back to the shipping address.
+// PromotionCodes are applied to the order, case-insensitively.
 type OrderPayload struct {
-	Items []OrderItemPayload `json:"items" validate:"required,dive"`
+	Items             []OrderItemPayload `json:"items" validate:"required,min=1,dive"`
+	ShippingAddressID *uuid.UUID         `json:"shipping_address_id,omitempty"`
+	BillingAddressID  *uuid.UUID         `json:"billing_address_id,omitempty"`
+	PromotionCodes    []string           `json:"promotion_codes,omitempty" validate:"omitempty,max=5,dive,required,max=50"`
 }
 
-type OrderItemPayload struct {
-	ProductID uuid.UUID `json:"product_id" validate:"required,uuid4"`
-	Quantity  int       `json:"quantity" validate:"required,gt=0"`
-	Price     float64   `json:"price" validate:"required,gt=0"`
+// CheckoutPayload chooses the addresses and promotion codes of a cart
+// checkout, the same way as OrderPayload.
+type CheckoutPayload struct {
+	ShippingAddressID *uuid.UUID `json:"shipping_address_id,omitempty"`
+	BillingAddressID  *uuid.UUID `json:"billing_address_id,omitempty"`
+	PromotionCodes    []string   `json:"promotion_codes,omitempty" validate:"omitempty,max=5,dive,required,max=50"`
+}
+
+// PayOrderPayload pays an order. PaymentMethod is the payment provider's
+// token for the customer's card, created client side.
+type PayOrderPayload struct {
+	PaymentMethod string `json:"payment_method" validate:"required,max=255"`
+}
+
+// AddressPayload is an entry of the address book. Country is an ISO 3166-1
+// alpha-2 code, and the postal code must match its format.
+type AddressPayload struct {
+	Label           string `json:"label,omitempty" validate:"omitempty,max=50"`
+	FullName        string `json:"full_name" validate:"required,max=200"`
+	Company         string `json:"company,omitempty" validate:"omitempty,max=200"`
+	Line1           string `json:"line1" validate:"required,max=200"`
+	Line2           string `json:"line2,omitempty" validate:"omitempty,max=200"`
+	City            string `json:"city" validate:"required,max=100"`
+	Region          string `json:"region,omitempty" validate:"omitempty,max=100"`
+	PostalCode      string `json:"postal_code,omitempty" validate:"omitempty,max=20"`
+	Country         string `json:"country" validate:"required,iso3166_1_alpha2"`
+	Phone           string `json:"phone,omitempty" validate:"omitempty,max=32"`
+	DefaultShipping bool   `json:"default_shipping"`
+	DefaultBilling  bool   `json:"default_billing"`
 }
 
-func (o *OrderPayload) CalculateOrderTotal() decimal.Decimal {
-	total := decimal.NewFromInt(0)
+// OrderItemPayload identifies a product and quantity to order. Prices are
+// always taken from the catalog; ExpectedPrice is only compared against the
+// catalog price to warn the client when the two differ.
+type OrderItemPayload struct {
+	ProductID     uuid.UUID        `json:"product_id" validate:"required"`
+	VariantID     *uuid.UUID       `json:"variant_id,omitempty"`
+	Quantity      int              `json:"quantity" validate:"required,gt=0,max=1000"`
+	ExpectedPrice *decimal.Decimal `json:"price,omitempty"`
+}
 
-	for _, item := range o.Items {
-		price := decimal.NewFromFloat(item.Price)
-		itemTotal := price.Mul(decimal.NewFromInt(int64(item.Quantity)))
-		total = total.Add(itemTotal)
-	}
+type CartItemPayload struct {
+	ProductID uuid.UUID  `json:"product_id" validate:"required"`
+	VariantID *uuid.UUID `json:"variant_id,omitempty"`
+	Quantity  int        `json:"quantity" validate:"required,gt=0,max=1000"`
+}
 
-	return total
+type CartItemUpdatePayload struct {
+	Quantity int `json:"quantity" validate:"required,gt=0,max=1000"`
 }
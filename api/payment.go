@@ -0,0 +1,298 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/payment"
+	"github.com/amosehiguese/ecommerce-api/pkg/validator"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// PayOrder godoc
+// @Summary      Pay for an Order
+// @Description  Charge a pending order of the authenticated user to a payment method tokenized by the payment provider. The order total is authorized and captured, and the order becomes paid. When the provider needs the customer to authenticate the payment first, the response is 202 with a client secret and the order stays pending; paying again abandons that payment.
+// @Tags         Orders
+// @Accept       json
+// @Produce      json
+// @Param        id path string true "Order ID"
+// @Param        payOrderPayload body payload.PayOrderPayload true "Payment method"
+// @Success      200 {object} map[string]interface{} "Order paid successfully"
+// @Success      202 {object} map[string]interface{} "Payment requires customer action"
+// @Failure      400 {object} map[string]interface{} "Invalid order id, request body or validation error"
+// @Failure      401 {object} map[string]interface{} "Unauthorized, token expired"
+// @Failure      402 {object} map[string]interface{} "Payment declined"
+// @Failure      403 {object} map[string]interface{} "Permission denied"
+// @Failure      404 {object} map[string]interface{} "Order not found"
+// @Failure      409 {object} map[string]interface{} "Order is not pending or already has a payment in progress"
+// @Failure      500 {object} map[string]interface{} "Internal server error"
+// @Failure      502 {object} map[string]interface{} "Payment provider error"
+// @Router       /api/orders/{id}/pay [post]
+func (api *API) PayOrder(c *gin.Context) {
+	log := logger.Get()
+	// Once money moves, the payment is finished even if the client goes away.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	principal := auth.CurrentPrincipal(c)
+
+	orderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid order id"})
+		return
+	}
+
+	var payPayload payload.PayOrderPayload
+	if err := c.ShouldBindJSON(&payPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(payPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	order, err := api.Q.GetOrderByID(ctx, orderID)
+	if err != nil {
+		log.Error("Error fetching order", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+	if order == nil || order.UserID != principal.UserID {
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": "order not found"})
+		return
+	}
+	if query.OrderStatus(order.Status) != query.OrderPending {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  true,
+			"msg":    "only pending orders can be paid",
+			"status": order.Status,
+		})
+		return
+	}
+
+	if !api.abandonPayment(ctx, c, orderID) {
+		return
+	}
+
+	p := &query.Payment{
+		ID:       uuid.New(),
+		OrderID:  orderID,
+		Provider: api.Payments.Name(),
+		Amount:   order.TotalAmount,
+		Currency: api.Cfg.Payment.Currency,
+	}
+	if err := api.Q.CreatePayment(ctx, p); err != nil {
+		if errors.Is(err, query.ErrPaymentInProgress) {
+			c.JSON(http.StatusConflict, gin.H{"error": true, "msg": err.Error()})
+			return
+		}
+		log.Error("Error recording payment", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the payment"})
+		return
+	}
+
+	// The payment ID makes a retried request reuse the intent it created.
+	intent, err := api.Payments.CreateIntent(ctx, payment.IntentRequest{
+		Amount:         payment.MinorUnits(order.TotalAmount),
+		Currency:       p.Currency,
+		PaymentMethod:  payPayload.PaymentMethod,
+		Reference:      orderID.String(),
+		IdempotencyKey: p.ID.String(),
+	})
+	if err != nil {
+		api.recordPaymentFailure(ctx, p, err)
+		respondPaymentError(c, p, providerError(err))
+		return
+	}
+	p.ProviderRef = &intent.ID
+
+	switch intent.Status {
+	case payment.StatusAuthorized:
+	case payment.StatusRequiresAction:
+		// The payment webhook captures the payment once the customer has
+		// authenticated it.
+		p.Status = query.PaymentRequiresAction
+		if err := api.Q.UpdatePayment(ctx, p); err != nil {
+			log.Error("Error saving payment", zap.String("payment_id", p.ID.String()), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the payment"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{
+			"error":         false,
+			"msg":           "the payment must be authenticated by the customer",
+			"payment":       p,
+			"client_secret": intent.ClientSecret,
+		})
+		return
+	default:
+		err := &payment.DeclineError{Code: string(intent.Status), Message: intent.FailureReason}
+		api.recordPaymentFailure(ctx, p, err)
+		respondPaymentError(c, p, err)
+		return
+	}
+
+	p.Status = query.PaymentAuthorized
+	if err := api.Q.UpdatePayment(ctx, p); err != nil {
+		log.Error("Error saving payment", zap.String("payment_id", p.ID.String()), zap.Error(err))
+	}
+
+	if err := api.capturePayment(ctx, p, &principal.UserID); err != nil {
+		respondPaymentError(c, p, err)
+		return
+	}
+
+	log.Info("Order paid", zap.String("order_id", orderID.String()), zap.String("payment_id", p.ID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "Order paid successfully", "payment": p})
+}
+
+// errPaymentProvider wraps errors of the payment provider other than declines,
+// such as the provider being unreachable.
+var errPaymentProvider = errors.New("payment provider error")
+
+func providerError(err error) error {
+	if errors.Is(err, payment.ErrDeclined) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", errPaymentProvider, err)
+}
+
+// capturePayment captures an authorized payment and marks its order paid. A
+// capture that fails voids the authorization and records the failure, and an
+// order that can no longer be paid gets its money back.
+func (api *API) capturePayment(ctx context.Context, p *query.Payment, changedBy *uuid.UUID) error {
+	log := logger.Get()
+
+	captured, err := api.Payments.Capture(ctx, *p.ProviderRef)
+	if err == nil && captured.Status != payment.StatusCaptured {
+		err = &payment.DeclineError{Code: string(captured.Status), Message: captured.FailureReason}
+	}
+	if err != nil {
+		// Release the hold on the customer's card.
+		if _, voidErr := api.Payments.Void(ctx, *p.ProviderRef); voidErr != nil && !errors.Is(voidErr, payment.ErrInvalidState) {
+			log.Error("Error voiding payment", zap.String("payment_id", p.ID.String()), zap.Error(voidErr))
+		}
+		api.recordPaymentFailure(ctx, p, err)
+		return providerError(err)
+	}
+
+	if err := api.Q.CompletePayment(ctx, p, changedBy); err != nil {
+		// The order was not marked paid, so the money goes back.
+		api.refundPayment(ctx, p, captured.Amount)
+		return err
+	}
+	return nil
+}
+
+// abandonPayment voids a payment of the order still waiting for the customer
+// to authenticate, so that it can be paid another way. It returns false after
+// responding if the order has a payment that is further along.
+func (api *API) abandonPayment(ctx context.Context, c *gin.Context, orderID uuid.UUID) bool {
+	log := logger.Get()
+
+	active, err := api.Q.GetActivePayment(ctx, orderID)
+	if err != nil {
+		log.Error("Error fetching payment", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the payment"})
+		return false
+	}
+	if active == nil {
+		return true
+	}
+
+	if active.Status != query.PaymentRequiresAction || active.Provider != api.Payments.Name() || active.ProviderRef == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": true, "msg": query.ErrPaymentInProgress.Error()})
+		return false
+	}
+
+	// Voiding fails if the customer completed the payment meanwhile.
+	if _, err := api.Payments.Void(ctx, *active.ProviderRef); err != nil {
+		log.Warn("Error voiding abandoned payment", zap.String("payment_id", active.ID.String()), zap.Error(err))
+		c.JSON(http.StatusConflict, gin.H{"error": true, "msg": query.ErrPaymentInProgress.Error()})
+		return false
+	}
+
+	active.Status = query.PaymentVoided
+	if err := api.Q.UpdatePayment(ctx, active); err != nil {
+		log.Error("Error saving payment", zap.String("payment_id", active.ID.String()), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the payment"})
+		return false
+	}
+	return true
+}
+
+// recordPaymentFailure marks a payment as failed and saves why.
+func (api *API) recordPaymentFailure(ctx context.Context, p *query.Payment, err error) {
+	log := logger.Get()
+
+	var decline *payment.DeclineError
+	if errors.As(err, &decline) {
+		log.Info("Payment declined", zap.String("payment_id", p.ID.String()), zap.String("code", decline.Code))
+	} else {
+		log.Error("Payment provider error", zap.String("payment_id", p.ID.String()), zap.Error(err))
+	}
+
+	reason := err.Error()
+	p.Status = query.PaymentFailed
+	p.FailureReason = &reason
+	if updateErr := api.Q.UpdatePayment(ctx, p); updateErr != nil {
+		log.Error("Error saving payment", zap.String("payment_id", p.ID.String()), zap.Error(updateErr))
+	}
+}
+
+// respondPaymentError responds with 402 to a declined payment, with 502 when
+// the provider could not be reached or refused the request, and otherwise as
+// to a failed order status change.
+func respondPaymentError(c *gin.Context, p *query.Payment, err error) {
+	var decline *payment.DeclineError
+	switch {
+	case errors.As(err, &decline):
+		c.JSON(http.StatusPaymentRequired, gin.H{
+			"error":        true,
+			"msg":          decline.Error(),
+			"decline_code": decline.Code,
+			"payment":      p,
+		})
+	case errors.Is(err, errPaymentProvider):
+		c.JSON(http.StatusBadGateway, gin.H{"error": true, "msg": "the payment could not be processed, please try again"})
+	default:
+		respondTransitionError(c, err)
+	}
+}
+
+// refundPayment gives back the captured amount of a payment whose order could
+// not be marked paid. A payment that cannot be refunded stays captured, which
+// keeps the order from being charged again until someone looks into it.
+func (api *API) refundPayment(ctx context.Context, p *query.Payment, amount int64) {
+	log := logger.Get()
+
+	_, err := api.Payments.Refund(ctx, payment.RefundRequest{
+		IntentID:       *p.ProviderRef,
+		Amount:         amount,
+		IdempotencyKey: p.ID.String() + "-refund",
+	})
+	if err != nil {
+		log.Error("Error refunding payment of an unpaid order", zap.String("payment_id", p.ID.String()), zap.Error(err))
+		p.Status = query.PaymentCaptured
+	} else {
+		p.Status = query.PaymentRefunded
+	}
+
+	if err := api.Q.UpdatePayment(ctx, p); err != nil {
+		log.Error("Error saving payment", zap.String("payment_id", p.ID.String()), zap.Error(err))
+	}
+}
@@ -0,0 +1,148 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/pkg/payment"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/amosehiguese/ecommerce-api/routes"
+	"github.com/amosehiguese/ecommerce-api/server"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPayOrder(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	gateway := payment.NewFakeProvider()
+	router := routes.SetUp(ta.DB, config.Get(), routes.WithPaymentProvider(gateway))
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	productID := uuid.New()
+	_, err = ta.DB.Exec(`
+		INSERT INTO "product" (id, name, description, price, units_in_stock)
+		VALUES ($1, 'Kettle', 'An electric kettle', 25.99, 10)
+	`, productID)
+	require.NoError(t, err)
+	_, err = ta.DB.Exec(`
+		INSERT INTO "product_variant" (product_id, sku, units_in_stock, is_default)
+		VALUES ($1, 'KETTLE', 10, TRUE)
+	`, productID)
+	require.NoError(t, err)
+
+	login := payload.LoginPayload{Email: "payer@example.com", Password: "password123"}
+	w := postJSON(router, "/api/auth/register", payload.RegisterPayload{FirstName: "Payer", Email: login.Email, Password: login.Password})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var res struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+		OrderID      string        `json:"order_id"`
+		Status       string        `json:"status"`
+		Payment      query.Payment `json:"payment"`
+		ClientSecret string        `json:"client_secret"`
+		DeclineCode  string        `json:"decline_code"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	}
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, createJSONRequestBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+res.Tokens.Access)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w = postJSON(router, "/api/auth/login", login)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+
+	w = send("POST", "/api/me/addresses", payload.AddressPayload{
+		FullName:   "Payer",
+		Line1:      "1 Market Street",
+		City:       "San Francisco",
+		PostalCode: "94105",
+		Country:    "US",
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = send("POST", "/api/orders", payload.OrderPayload{Items: []payload.OrderItemPayload{{ProductID: productID, Quantity: 2}}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	payPath := "/api/orders/" + res.OrderID + "/pay"
+	historyPath := "/api/orders/" + res.OrderID + "/history"
+
+	w = send("POST", payPath, payload.PayOrderPayload{PaymentMethod: payment.FakeCardDeclined})
+	require.Equal(t, http.StatusPaymentRequired, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, "card_declined", res.DeclineCode)
+	assert.Equal(t, query.PaymentFailed, res.Payment.Status)
+
+	w = send("POST", payPath, payload.PayOrderPayload{PaymentMethod: payment.FakeCardCaptureDeclined})
+	require.Equal(t, http.StatusPaymentRequired, w.Code, w.Body.String())
+	decode(w)
+	require.NotNil(t, res.Payment.ProviderRef)
+	intent, _ := gateway.Intent(*res.Payment.ProviderRef)
+	assert.Equal(t, payment.StatusFailed, intent.Status)
+
+	w = send("POST", payPath, payload.PayOrderPayload{PaymentMethod: payment.FakeCardRequiresAction})
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	decode(w)
+	assert.NotEmpty(t, res.ClientSecret)
+	abandoned := *res.Payment.ProviderRef
+
+	w = send("GET", historyPath, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, "pending", res.Status, "the order is not paid until the payment is captured")
+
+	// Paying again abandons the payment waiting for authentication
+	w = send("POST", payPath, payload.PayOrderPayload{PaymentMethod: payment.FakeCardOK})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, query.PaymentCaptured, res.Payment.Status)
+	assert.True(t, res.Payment.Amount.Equal(payment.FromMinorUnits(5198)), res.Payment.Amount.String())
+	intent, _ = gateway.Intent(*res.Payment.ProviderRef)
+	assert.Equal(t, payment.StatusCaptured, intent.Status)
+	assert.Equal(t, int64(5198), intent.Amount)
+	intent, _ = gateway.Intent(abandoned)
+	assert.Equal(t, payment.StatusVoided, intent.Status)
+
+	w = send("GET", historyPath, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, "paid", res.Status)
+
+	w = send("POST", payPath, payload.PayOrderPayload{PaymentMethod: payment.FakeCardOK})
+	assert.Equal(t, http.StatusConflict, w.Code, "paid orders cannot be paid again")
+
+	var statuses []string
+	rows, err := ta.DB.Query(`SELECT status FROM "payment" WHERE order_id = $1 ORDER BY created_at`, res.OrderID)
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		require.NoError(t, rows.Scan(&status))
+		statuses = append(statuses, status)
+	}
+	assert.Equal(t, []string{"failed", "failed", "voided", "captured"}, statuses)
+}
@@ -1,12 +1,11 @@
 package api
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/amosehiguese/ecommerce-api/api/payload"
-	"github.com/amosehiguese/ecommerce-api/pkg/auth"
 	"github.com/amosehiguese/ecommerce-api/pkg/logger"
 	"github.com/amosehiguese/ecommerce-api/pkg/validator"
 	"github.com/amosehiguese/ecommerce-api/query"
@@ -31,33 +30,8 @@ import (
 // @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
 // @Router /api/products [post]
 func (api *API) CreateProduct(c *gin.Context) {
-	now := time.Now().Unix()
-	fmt.Println("Now", now)
 	log := logger.Get()
 
-	// Extract claims from the token
-	claims, err := auth.ExtractTokenMetadata(c)
-	if err != nil {
-		log.Error("Error extracting token metadata", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
-		return
-	}
-	fmt.Println("claim exp", claims.Exp)
-	fmt.Println("Greater", now > claims.Exp)
-	// Check token expiration
-	if now > claims.Exp {
-		log.Warn("Token expired", zap.Int64("expiration", claims.Exp))
-		c.JSON(http.StatusUnauthorized, gin.H{"error": true, "msg": "unauthorized, token expired"})
-		return
-	}
-
-	// Check if the user has permission to create a product
-	if !claims.Credentials[auth.ProductCreateCredential] {
-		log.Warn("Permission denied for product creation", zap.String("role", claims.Role))
-		c.JSON(http.StatusForbidden, gin.H{"error": true, "msg": "permission denied"})
-		return
-	}
-
 	// Bind the JSON request to the product struct
 	var productPayload payload.ProductPayload
 	if err := c.ShouldBindJSON(&productPayload); err != nil {
@@ -85,8 +59,17 @@ func (api *API) CreateProduct(c *gin.Context) {
 		UnitsInStock: productPayload.UnitsInStock,
 	}
 
-	// Perform the DB operation to create the product
-	if err := api.Q.CreateProduct(c, product); err != nil {
+	var barcode *string
+	if productPayload.Barcode != "" {
+		barcode = &productPayload.Barcode
+	}
+
+	// Perform the DB operation to create the product and its default variant
+	if err := api.Q.CreateProduct(c, product, productPayload.SKU, barcode); err != nil {
+		if errors.Is(err, query.ErrSKUTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": true, "msg": err.Error()})
+			return
+		}
 		log.Error("Error creating product", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
 		return
@@ -109,36 +92,19 @@ func (api *API) CreateProduct(c *gin.Context) {
 // @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
 // @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
 // @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 404 {object} gin.H{"error": true, "msg": "product not found"}
 // @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
 // @Router /api/products/{id} [put]
 func (api *API) UpdateProduct(c *gin.Context) {
 	log := logger.Get()
 
-	// Extract claims from the token
-	claims, err := auth.ExtractTokenMetadata(c)
+	// Get the product ID from the URL parameter
+	productID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		log.Error("Error extracting token metadata", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
-		return
-	}
-
-	// Check token expiration
-	if time.Now().Unix() > claims.Exp {
-		log.Warn("Token expired", zap.Int64("expiration", claims.Exp))
-		c.JSON(http.StatusUnauthorized, gin.H{"error": true, "msg": "unauthorized, token expired"})
-		return
-	}
-
-	// Check if the user has permission to update the product
-	if !claims.Credentials[auth.ProductUpdateCredential] {
-		log.Warn("Permission denied for product update", zap.String("role", claims.Role))
-		c.JSON(http.StatusForbidden, gin.H{"error": true, "msg": "permission denied"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid product id"})
 		return
 	}
 
-	// Get the product ID from the URL parameter
-	productID := uuid.MustParse(c.Param("id"))
-
 	// Bind the JSON request to the product struct
 	var productPayload payload.ProductPayload
 	if err := c.ShouldBindJSON(&productPayload); err != nil {
@@ -165,6 +131,10 @@ func (api *API) UpdateProduct(c *gin.Context) {
 		})
 		return
 	}
+	if product == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": "product not found"})
+		return
+	}
 
 	// Set the updated timestamp
 	product.Name = productPayload.Name
@@ -175,6 +145,10 @@ func (api *API) UpdateProduct(c *gin.Context) {
 
 	// Perform the DB operation to update the product
 	if err := api.Q.UpdateProduct(c, product); err != nil {
+		if errors.Is(err, query.ErrVariantStockManaged) {
+			c.JSON(http.StatusConflict, gin.H{"error": true, "msg": err.Error()})
+			return
+		}
 		log.Error("Error updating product", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
 		return
@@ -191,6 +165,7 @@ func (api *API) UpdateProduct(c *gin.Context) {
 // @Param id path string true "Product ID"
 // @Security CookieAuth
 // @Success 200 {object} gin.H{"error": false, "msg": "product deleted successfully"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "invalid product id"}
 // @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
 // @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
 // @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
@@ -198,48 +173,39 @@ func (api *API) UpdateProduct(c *gin.Context) {
 func (api *API) DeleteProduct(c *gin.Context) {
 	log := logger.Get()
 
-	// Extract claims from the token
-	claims, err := auth.ExtractTokenMetadata(c)
+	// Get the product ID from the URL parameter
+	productID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		log.Error("Error extracting token metadata", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
-		return
-	}
-
-	// Check token expiration
-	if time.Now().Unix() > claims.Exp {
-		log.Warn("Token expired", zap.Int64("expiration", claims.Exp))
-		c.JSON(http.StatusUnauthorized, gin.H{"error": true, "msg": "unauthorized, token expired"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid product id"})
 		return
 	}
 
-	// Check if the user has permission to delete the product
-	if !claims.Credentials[auth.ProductDeleteCredential] {
-		log.Warn("Permission denied for product deletion", zap.String("role", claims.Role))
-		c.JSON(http.StatusForbidden, gin.H{"error": true, "msg": "permission denied"})
-		return
-	}
-
-	// Get the product ID from the URL parameter
-	productID := c.Param("id")
-
 	// Perform the DB operation to delete the product
-	if err := api.Q.DeleteProduct(c, uuid.MustParse(productID)); err != nil {
+	if err := api.Q.DeleteProduct(c, productID); err != nil {
 		log.Error("Error deleting product", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
 		return
 	}
 
-	log.Info("Product deleted successfully", zap.String("product_id", productID))
+	log.Info("Product deleted successfully", zap.String("product_id", productID.String()))
 	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "product deleted successfully"})
 }
 
 // ListProducts godoc
-// @Summary List all products
-// @Description Retrieve a list of all products from the database
+// @Summary List products
+// @Description Retrieve one page of products, optionally filtered and sorted. Pass next_cursor back as cursor to fetch the following page.
 // @Tags products
+// @Param limit query int false "Page size (1-100, default 20)"
+// @Param cursor query string false "Cursor from the previous page"
+// @Param sort query string false "newest, price_asc, price_desc, name_asc or name_desc"
+// @Param min_price query number false "Minimum price"
+// @Param max_price query number false "Maximum price"
+// @Param in_stock query bool false "Only products with units in stock"
+// @Param name query string false "Name contains"
+// @Param created_since query string false "Created at or after (RFC 3339)"
 // @Security CookieAuth
-// @Success 200 {object} gin.H{"error": false, "products": []query.Product}
+// @Success 200 {object} gin.H{"error": false, "products": []query.Product, "next_cursor": "cursor"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
 // @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
 // @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
 // @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
@@ -247,53 +213,86 @@ func (api *API) DeleteProduct(c *gin.Context) {
 func (api *API) ListProducts(c *gin.Context) {
 	log := logger.Get()
 
-	// Extract claims from the token
-	claims, err := auth.ExtractTokenMetadata(c)
+	var listQuery payload.ProductListQuery
+	if err := c.ShouldBindQuery(&listQuery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(listQuery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	// Retrieve one page of products from the DB
+	page, err := api.Q.ListProducts(c, productFilterFromQuery(listQuery))
 	if err != nil {
-		log.Error("Error extracting token metadata", zap.Error(err))
+		if errors.Is(err, query.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+			return
+		}
+		log.Error("Error retrieving products", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
 		return
 	}
 
-	// Check token expiration
-	if time.Now().Unix() > claims.Exp {
-		log.Warn("Token expired", zap.Int64("expiration", claims.Exp))
-		c.JSON(http.StatusUnauthorized, gin.H{"error": true, "msg": "unauthorized, token expired"})
+	log.Info("Products retrieved successfully", zap.Int("product_count", len(page.Products)))
+	c.JSON(http.StatusOK, gin.H{"error": false, "products": page.Products, "next_cursor": page.NextCursor})
+}
+
+// SearchProducts godoc
+// @Summary Search products
+// @Description Full-text search over product names and descriptions with prefix matching, ranked by relevance
+// @Tags products
+// @Param q query string true "Search terms"
+// @Param limit query int false "Maximum results (1-50, default 20)"
+// @Security CookieAuth
+// @Success 200 {object} gin.H{"error": false, "results": []query.ProductSearchResult}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/products/search [get]
+func (api *API) SearchProducts(c *gin.Context) {
+	log := logger.Get()
+
+	var searchQuery payload.ProductSearchQuery
+	if err := c.ShouldBindQuery(&searchQuery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
 		return
 	}
 
-	// Check if the user has permission to view products
-	if !claims.Credentials[auth.ProductDeleteCredential] {
-		log.Warn("Permission denied for product listing", zap.String("role", claims.Role))
-		c.JSON(http.StatusForbidden, gin.H{"error": true, "msg": "permission denied"})
+	validate := validator.NewValidator()
+	if err := validate.Struct(searchQuery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
 		return
 	}
 
-	// Retrieve all products from the DB
-	products, err := api.Q.GetAllProducts(c)
+	results, err := api.Q.SearchProducts(c, searchQuery.Q, searchQuery.Limit)
 	if err != nil {
-		log.Error("Error retrieving products", zap.Error(err))
+		log.Error("Error searching products", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
 		return
 	}
 
-	if len(products) == 0 {
-		log.Warn("No products found")
-		c.JSON(http.StatusOK, gin.H{"error": false, "msg": "no products found"})
-		return
-	}
-
-	log.Info("Products retrieved successfully", zap.Int("product_count", len(products)))
-	c.JSON(http.StatusOK, gin.H{"error": false, "products": products})
+	c.JSON(http.StatusOK, gin.H{"error": false, "results": results})
 }
 
 // GetProduct godoc
 // @Summary Get a product by ID
-// @Description Retrieve a product by its ID from the database
+// @Description Retrieve a product by its ID, with its categories and its option and variant matrix
 // @Tags products
 // @Param id path string true "Product ID"
 // @Security CookieAuth
 // @Success 200 {object} query.Product
+// @Failure 400 {object} gin.H{"error": true, "msg": "invalid product id"}
 // @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
 // @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
 // @Failure 404 {object} gin.H{"error": true, "msg": "product not found"}
@@ -302,44 +301,76 @@ func (api *API) ListProducts(c *gin.Context) {
 func (api *API) GetProduct(c *gin.Context) {
 	log := logger.Get()
 
-	// Extract claims from the token
-	claims, err := auth.ExtractTokenMetadata(c)
+	// Get the product ID from the URL parameter
+	productID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		log.Error("Error extracting token metadata", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid product id"})
 		return
 	}
 
-	// Check token expiration
-	if time.Now().Unix() > claims.Exp {
-		log.Warn("Token expired", zap.Int64("expiration", claims.Exp))
-		c.JSON(http.StatusUnauthorized, gin.H{"error": true, "msg": "unauthorized, token expired"})
+	// Retrieve the product from the DB
+	product, err := api.Q.GetProductByID(c, productID)
+	if err != nil {
+		log.Error("Error retrieving product", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
 		return
 	}
-
-	// Check if the user has permission to view the product
-	if !claims.Credentials[auth.ProductReadCredential] {
-		log.Warn("Permission denied for product read", zap.String("role", claims.Role))
-		c.JSON(http.StatusForbidden, gin.H{"error": true, "msg": "permission denied"})
+	if product == nil {
+		log.Warn("Product not found", zap.String("product_id", productID.String()))
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": "product not found"})
 		return
 	}
 
-	// Get the product ID from the URL parameter
-	productID := c.Param("id")
+	categories, err := api.Q.GetProductCategories(c, product.ID)
+	if err != nil {
+		log.Error("Error retrieving product categories", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
 
-	// Retrieve the product from the DB
-	product, err := api.Q.GetProductByID(c, uuid.MustParse(productID))
+	options, err := api.Q.GetProductOptions(c, product.ID)
 	if err != nil {
-		log.Error("Error retrieving product", zap.Error(err))
+		log.Error("Error retrieving product options", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
 		return
 	}
-	if product == nil {
-		log.Warn("Product not found", zap.String("product_id", productID))
-		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": "product not found"})
+
+	variants, err := api.Q.GetProductVariants(c, product.ID)
+	if err != nil {
+		log.Error("Error retrieving product variants", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
 		return
 	}
 
-	log.Info("Product retrieved successfully", zap.String("product_id", productID))
-	c.JSON(http.StatusOK, gin.H{"error": false, "product": product})
+	log.Info("Product retrieved successfully", zap.String("product_id", productID.String()))
+	c.JSON(http.StatusOK, gin.H{
+		"error":      false,
+		"product":    product,
+		"categories": categories,
+		"options":    options,
+		"variants":   variants,
+	})
+}
+
+func productFilterFromQuery(listQuery payload.ProductListQuery) query.ProductFilter {
+	filter := query.ProductFilter{
+		InStockOnly:  listQuery.InStock,
+		NameContains: listQuery.Name,
+		Sort:         query.ProductSort(listQuery.Sort),
+		Limit:        listQuery.Limit,
+		Cursor:       listQuery.Cursor,
+	}
+	if listQuery.MinPrice != "" {
+		minPrice := decimal.RequireFromString(listQuery.MinPrice)
+		filter.MinPrice = &minPrice
+	}
+	if listQuery.MaxPrice != "" {
+		maxPrice := decimal.RequireFromString(listQuery.MaxPrice)
+		filter.MaxPrice = &maxPrice
+	}
+	if !listQuery.CreatedSince.IsZero() {
+		createdSince := listQuery.CreatedSince.UTC()
+		filter.CreatedSince = &createdSince
+	}
+	return filter
 }
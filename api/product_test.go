@@ -0,0 +1,294 @@
+package api_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/amosehiguese/ecommerce-api/routes"
+	"github.com/amosehiguese/ecommerce-api/server"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListProducts(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	router := routes.SetUp(ta.DB, config.Get())
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	type seed struct {
+		id      uuid.UUID
+		name    string
+		price   string
+		stock   int
+		created string
+	}
+	seeds := []seed{
+		{uuid.New(), "Kettle", "40.00", 5, "2025-01-01 10:00:00"},
+		{uuid.New(), "Mug", "5.00", 0, "2025-01-02 10:00:00"},
+		{uuid.New(), "Teapot", "19.99", 3, "2025-01-03 10:00:00"},
+		{uuid.New(), "Tea Cup", "5.00", 7, "2025-01-04 10:00:00"},
+		{uuid.New(), "Electric Kettle", "60.00", 2, "2025-01-05 10:00:00"},
+	}
+	for _, s := range seeds {
+		_, err = ta.DB.Exec(`
+			INSERT INTO "product" (id, name, description, price, units_in_stock, created_at, updated_at)
+			VALUES ($1, $2, 'A kitchen item', $3, $4, $5, $5)
+		`, s.id, s.name, s.price, s.stock, s.created)
+		require.NoError(t, err)
+	}
+
+	var res struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+		Products   []query.Product `json:"products"`
+		NextCursor string          `json:"next_cursor"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		res.Products, res.NextCursor = nil, ""
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	}
+
+	send := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, createJSONRequestBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Browsing the catalog needs the product read permission
+	w := send("POST", "/api/auth/register", "", payload.RegisterPayload{FirstName: "Staff", Email: "staff@example.com", Password: "password123"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	_, err = ta.DB.Exec(`UPDATE "user" SET role = 'admin' WHERE email = 'staff@example.com'`)
+	require.NoError(t, err)
+	w = send("POST", "/api/auth/login", "", payload.LoginPayload{Email: "staff@example.com", Password: "password123"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	token := res.Tokens.Access
+
+	get := func(path string) *httptest.ResponseRecorder {
+		return send("GET", path, token, nil)
+	}
+	list := func(params string) []uuid.UUID {
+		w := get("/api/products?" + params)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		decode(w)
+		ids := make([]uuid.UUID, 0, len(res.Products))
+		for _, p := range res.Products {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+	// listAll follows next_cursor to the last page and returns every product
+	// in the order the pages listed them.
+	listAll := func(params string) []uuid.UUID {
+		var ids []uuid.UUID
+		cursor := ""
+		for pages := 0; pages < len(seeds); pages++ {
+			ids = append(ids, list(params+"&cursor="+cursor)...)
+			if res.NextCursor == "" {
+				return ids
+			}
+			cursor = res.NextCursor
+		}
+		t.Fatalf("pagination did not end after %d pages", len(seeds))
+		return nil
+	}
+	ids := func(indexes ...int) []uuid.UUID {
+		out := make([]uuid.UUID, 0, len(indexes))
+		for _, i := range indexes {
+			out = append(out, seeds[i].id)
+		}
+		return out
+	}
+
+	// Pages of two walk the whole catalog without gaps or repeats; products
+	// with the same price are ordered by ID
+	byPrice := append([]seed(nil), seeds...)
+	sort.SliceStable(byPrice, func(i, j int) bool {
+		pi, pj := decimal.RequireFromString(byPrice[i].price), decimal.RequireFromString(byPrice[j].price)
+		if !pi.Equal(pj) {
+			return pi.LessThan(pj)
+		}
+		return byPrice[i].id.String() < byPrice[j].id.String()
+	})
+	expected := make([]uuid.UUID, 0, len(byPrice))
+	for _, s := range byPrice {
+		expected = append(expected, s.id)
+	}
+	assert.Equal(t, expected, listAll("sort=price_asc&limit=2"))
+
+	assert.Equal(t, ids(4, 3, 2, 1, 0), listAll("limit=2"), "the newest products come first by default")
+	assert.Equal(t, ids(4, 3, 2, 1, 0), list("sort=newest"))
+	assert.Empty(t, res.NextCursor, "the last page has no cursor")
+
+	assert.ElementsMatch(t, ids(1, 2, 3), list("min_price=5&max_price=20"))
+	assert.ElementsMatch(t, ids(0, 2, 3, 4), list("in_stock=true"))
+	assert.ElementsMatch(t, ids(0, 4), list("name=KETTLE"))
+	assert.ElementsMatch(t, ids(3, 4), list("created_since=2025-01-04T00:00:00Z"))
+	assert.Equal(t, ids(0), list("name=kettle&max_price=50"))
+
+	// Cursors are only valid for the sort they were issued for, and forged
+	// cursors are rejected rather than passed on to the database
+	list("sort=price_asc&limit=2")
+	priceCursor := res.NextCursor
+	require.NotEmpty(t, priceCursor)
+
+	forge := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+	for name, params := range map[string]string{
+		"other sort":     "sort=name_asc&cursor=" + priceCursor,
+		"not base64":     "cursor=not-a-cursor!",
+		"not json":       "cursor=" + forge("price_asc"),
+		"bad price":      "sort=price_asc&cursor=" + forge(`{"s":"price_asc","v":"abc","id":"`+uuid.NewString()+`"}`),
+		"bad timestamp":  "sort=newest&cursor=" + forge(`{"s":"newest","v":"yesterday","id":"`+uuid.NewString()+`"}`),
+		"nul in name":    "sort=name_asc&cursor=" + forge(`{"s":"name_asc","v":"Mug\u0000","id":"`+uuid.NewString()+`"}`),
+		"unknown sort":   "sort=popular",
+		"oversized page": "limit=101",
+	} {
+		w := get("/api/products?" + params)
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+	}
+
+	// A well-formed cursor only marks a position in the listing
+	cursor := forge(`{"s":"price_asc","v":"19.99","id":"` + seeds[2].id.String() + `"}`)
+	assert.Equal(t, ids(0, 4), list("sort=price_asc&cursor="+cursor))
+}
+
+func TestSearchProducts(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	router := routes.SetUp(ta.DB, config.Get())
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	var res struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+		Product query.Product               `json:"product"`
+		Results []query.ProductSearchResult `json:"results"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		res.Results = nil
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	}
+	send := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, createJSONRequestBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/api/auth/register", "", payload.RegisterPayload{FirstName: "Staff", Email: "staff@example.com", Password: "password123"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	_, err = ta.DB.Exec(`UPDATE "user" SET role = 'admin' WHERE email = 'staff@example.com'`)
+	require.NoError(t, err)
+	w = send("POST", "/api/auth/login", "", payload.LoginPayload{Email: "staff@example.com", Password: "password123"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	token := res.Tokens.Access
+
+	// Products are created through the API, so the search column is kept up
+	// to date by the same path as in production
+	create := func(name, description string) uuid.UUID {
+		w := send("POST", "/api/products/", token, payload.ProductPayload{Name: name, Description: description, Price: 20, UnitsInStock: 5})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		decode(w)
+		return res.Product.ID
+	}
+	kettle := create("Stainless Kettle", "Boils water fast for tea and coffee.")
+	teapot := create("Teapot", "A ceramic pot for loose leaf tea. Pairs well with any kettle.")
+	grinder := create("Coffee Grinder", "Burr grinder for whole coffee beans.")
+
+	search := func(q string) []query.ProductSearchResult {
+		w := send("GET", "/api/products/search?q="+q, token, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		decode(w)
+		return res.Results
+	}
+
+	// Name matches rank above description matches, and matches are marked
+	results := search("kettle")
+	require.Len(t, results, 2)
+	assert.Equal(t, kettle, results[0].ID)
+	assert.Equal(t, teapot, results[1].ID)
+	assert.Greater(t, results[0].Rank, results[1].Rank)
+	assert.Contains(t, results[0].NameHighlight, "<mark>Kettle</mark>")
+	assert.Contains(t, results[1].Snippet, "<mark>kettle</mark>")
+	assert.Equal(t, "Teapot", results[1].NameHighlight)
+
+	// Every word is a prefix, and all of them must match
+	results = search("ket")
+	require.Len(t, results, 2, "partial words match for type-ahead")
+	results = search("coffee+bea")
+	require.Len(t, results, 1)
+	assert.Equal(t, grinder, results[0].ID)
+	assert.Contains(t, results[0].Snippet, "<mark>beans</mark>")
+
+	// Operators in the input are treated as separators, never as syntax
+	assert.Len(t, search("kettle%20%26%20!water"), 1)
+	assert.Empty(t, search("%27%29%3A*%7C"))
+
+	w = send("GET", "/api/products/search", token, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "a search needs terms")
+
+	// Updating a product updates what it is found by
+	w = send("PUT", "/api/products/"+teapot.String(), token, payload.ProductPayload{
+		Name:         "Cast Iron Teapot",
+		Description:  "Keeps tea hot for hours.",
+		Price:        20,
+		UnitsInStock: 5,
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	results = search("kettle")
+	require.Len(t, results, 1)
+	assert.Equal(t, kettle, results[0].ID)
+	results = search("iron")
+	require.Len(t, results, 1)
+	assert.Equal(t, teapot, results[0].ID)
+
+	// Product text is escaped, only the highlights are markup
+	create("<img src=x onerror=alert(1)> Whistling Kettle", "Whistles <b>loudly</b> when the water boils.")
+	results = search("whistl")
+	require.Len(t, results, 1)
+	assert.NotContains(t, results[0].NameHighlight, "<img")
+	assert.Contains(t, results[0].NameHighlight, "&lt;img")
+	assert.Contains(t, results[0].NameHighlight, "<mark>Whistling</mark>")
+	assert.NotContains(t, results[0].Snippet, "<b>")
+	assert.Contains(t, results[0].Snippet, "<mark>Whistles</mark>")
+}
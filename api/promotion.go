@@ -0,0 +1,303 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/promotion"
+	"github.com/amosehiguese/ecommerce-api/pkg/validator"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+// promotionCodePattern matches promotion codes once uppercased.
+var promotionCodePattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9_-]*$`)
+
+// CreatePromotion godoc
+// @Summary Create a promotion
+// @Description Create a promotion customers apply to their orders by its code: a percentage or fixed amount off, buy X get Y free, or free shipping. Codes are stored uppercase and matched regardless of case.
+// @Tags promotions
+// @Accept json
+// @Produce json
+// @Param promotion body payload.PromotionPayload true "Promotion data"
+// @Security CookieAuth
+// @Success 200 {object} gin.H{"error": false, "promotion": query.Promotion}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 409 {object} gin.H{"error": true, "msg": "a promotion with this code already exists"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/admin/promotions [post]
+func (api *API) CreatePromotion(c *gin.Context) {
+	log := logger.Get()
+
+	var promotionPayload payload.PromotionPayload
+	if err := c.ShouldBindJSON(&promotionPayload); err != nil {
+		log.Error("Invalid JSON for promotion", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(promotionPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	p := &query.Promotion{ID: uuid.New()}
+	if !applyPromotionPayload(c, p, promotionPayload) {
+		return
+	}
+
+	if err := api.Q.CreatePromotion(c, p); err != nil {
+		respondPromotionError(c, err)
+		return
+	}
+
+	api.audit(c, AuditPromotionCreate, "promotion", p.ID.String(), gin.H{"code": p.Code, "type": p.Type})
+
+	log.Info("Promotion created successfully", zap.String("promotion_id", p.ID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "promotion": p})
+}
+
+// ListPromotions godoc
+// @Summary List promotions
+// @Description Retrieve every promotion, newest first, with the number of orders placed with it
+// @Tags promotions
+// @Produce json
+// @Security CookieAuth
+// @Success 200 {object} gin.H{"error": false, "promotions": []query.Promotion}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/admin/promotions [get]
+func (api *API) ListPromotions(c *gin.Context) {
+	log := logger.Get()
+
+	promotions, err := api.Q.ListPromotions(c)
+	if err != nil {
+		log.Error("Error retrieving promotions", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"error": false, "promotions": promotions})
+}
+
+// GetPromotion godoc
+// @Summary Get a promotion
+// @Description Retrieve a promotion with the number of orders placed with it
+// @Tags promotions
+// @Produce json
+// @Param id path string true "Promotion ID"
+// @Security CookieAuth
+// @Success 200 {object} gin.H{"error": false, "promotion": query.Promotion}
+// @Failure 400 {object} gin.H{"error": true, "msg": "invalid promotion id"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 404 {object} gin.H{"error": true, "msg": "promotion not found"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/admin/promotions/{id} [get]
+func (api *API) GetPromotion(c *gin.Context) {
+	log := logger.Get()
+
+	promotionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid promotion id"})
+		return
+	}
+
+	p, err := api.Q.GetPromotion(c, promotionID)
+	if err != nil {
+		log.Error("Error retrieving promotion", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+	if p == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": query.ErrPromotionNotFound.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"error": false, "promotion": p})
+}
+
+// UpdatePromotion godoc
+// @Summary Update a promotion
+// @Description Replace a promotion, including the products and categories it is scoped to. Orders already placed with it keep the discount they got.
+// @Tags promotions
+// @Accept json
+// @Produce json
+// @Param id path string true "Promotion ID"
+// @Param promotion body payload.PromotionPayload true "Promotion data"
+// @Security CookieAuth
+// @Success 200 {object} gin.H{"error": false, "promotion": query.Promotion}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 404 {object} gin.H{"error": true, "msg": "promotion not found"}
+// @Failure 409 {object} gin.H{"error": true, "msg": "a promotion with this code already exists"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/admin/promotions/{id} [put]
+func (api *API) UpdatePromotion(c *gin.Context) {
+	log := logger.Get()
+
+	promotionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid promotion id"})
+		return
+	}
+
+	var promotionPayload payload.PromotionPayload
+	if err := c.ShouldBindJSON(&promotionPayload); err != nil {
+		log.Error("Invalid JSON for promotion", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(promotionPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	p := &query.Promotion{ID: promotionID}
+	if !applyPromotionPayload(c, p, promotionPayload) {
+		return
+	}
+
+	if err := api.Q.UpdatePromotion(c, p); err != nil {
+		respondPromotionError(c, err)
+		return
+	}
+
+	api.audit(c, AuditPromotionUpdate, "promotion", p.ID.String(), gin.H{"code": p.Code, "active": p.Active})
+
+	log.Info("Promotion updated successfully", zap.String("promotion_id", p.ID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "promotion": p})
+}
+
+// DeletePromotion godoc
+// @Summary Delete a promotion
+// @Description Delete a promotion. Orders placed with it keep their discounts. Deactivate a promotion instead to keep counting its uses.
+// @Tags promotions
+// @Param id path string true "Promotion ID"
+// @Security CookieAuth
+// @Success 200 {object} gin.H{"error": false, "msg": "promotion deleted successfully"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "invalid promotion id"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 404 {object} gin.H{"error": true, "msg": "promotion not found"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/admin/promotions/{id} [delete]
+func (api *API) DeletePromotion(c *gin.Context) {
+	log := logger.Get()
+
+	promotionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid promotion id"})
+		return
+	}
+
+	if err := api.Q.DeletePromotion(c, promotionID); err != nil {
+		respondPromotionError(c, err)
+		return
+	}
+
+	api.audit(c, AuditPromotionDelete, "promotion", promotionID.String(), nil)
+
+	log.Info("Promotion deleted successfully", zap.String("promotion_id", promotionID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "promotion deleted successfully"})
+}
+
+// applyPromotionPayload checks the rules the validator cannot express and
+// copies the payload into p. It responds and returns false if the payload is
+// invalid.
+func applyPromotionPayload(c *gin.Context, p *query.Promotion, promotionPayload payload.PromotionPayload) bool {
+	fail := func(msg string) bool {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": msg})
+		return false
+	}
+
+	code := strings.ToUpper(strings.TrimSpace(promotionPayload.Code))
+	if !promotionCodePattern.MatchString(code) {
+		return fail("code may only contain letters, digits, hyphens and underscores")
+	}
+
+	typ := promotion.Type(promotionPayload.Type)
+	value := promotionPayload.Value
+	switch {
+	case value.IsNegative():
+		return fail("value cannot be negative")
+	case !value.Equal(value.Round(2)):
+		return fail("value cannot have more than two decimal places")
+	case typ == promotion.Percentage && (!value.IsPositive() || value.GreaterThan(decimal.NewFromInt(100))):
+		return fail("value of a percentage promotion must be between 0 and 100")
+	case typ == promotion.Fixed && !value.IsPositive():
+		return fail("value of a fixed promotion must be positive")
+	case typ == promotion.BuyXGetY && (promotionPayload.BuyQuantity == nil || promotionPayload.GetQuantity == nil):
+		return fail("buy_x_get_y promotions require buy_quantity and get_quantity")
+	}
+
+	minSubtotal := promotionPayload.MinSubtotal
+	if minSubtotal.IsNegative() {
+		return fail("min_subtotal cannot be negative")
+	}
+	if promotionPayload.StartsAt != nil && promotionPayload.EndsAt != nil && !promotionPayload.EndsAt.After(*promotionPayload.StartsAt) {
+		return fail("ends_at must be after starts_at")
+	}
+
+	p.Code = code
+	p.Type = typ
+	p.Value = value
+	p.BuyQuantity, p.GetQuantity = nil, nil
+	if typ == promotion.BuyXGetY {
+		p.BuyQuantity, p.GetQuantity = promotionPayload.BuyQuantity, promotionPayload.GetQuantity
+	}
+	if typ == promotion.BuyXGetY || typ == promotion.FreeShipping {
+		p.Value = decimal.Zero
+	}
+	p.MinSubtotal = minSubtotal
+	p.StartsAt, p.EndsAt = promotionPayload.StartsAt, promotionPayload.EndsAt
+	p.UsageLimit, p.PerUserLimit = promotionPayload.UsageLimit, promotionPayload.PerUserLimit
+	p.Stackable = promotionPayload.Stackable
+	p.Active = promotionPayload.Active == nil || *promotionPayload.Active
+	p.Description = nil
+	if promotionPayload.Description != "" {
+		p.Description = &promotionPayload.Description
+	}
+	p.ProductIDs, p.CategoryIDs = promotionPayload.ProductIDs, promotionPayload.CategoryIDs
+	if p.ProductIDs == nil {
+		p.ProductIDs = []uuid.UUID{}
+	}
+	if p.CategoryIDs == nil {
+		p.CategoryIDs = []uuid.UUID{}
+	}
+	return true
+}
+
+func respondPromotionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, query.ErrPromotionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": err.Error()})
+	case errors.Is(err, query.ErrPromotionScopeNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+	case errors.Is(err, query.ErrPromotionCodeTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": true, "msg": err.Error()})
+	default:
+		logger.Get().Error("Error saving promotion", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+	}
+}
@@ -0,0 +1,230 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/amosehiguese/ecommerce-api/routes"
+	"github.com/amosehiguese/ecommerce-api/server"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromotions(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	router := routes.SetUp(ta.DB, config.Get())
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	kettle, mug := uuid.New(), uuid.New()
+	for _, product := range []struct {
+		id    uuid.UUID
+		name  string
+		price string
+	}{{kettle, "Kettle", "40.00"}, {mug, "Mug", "5.00"}} {
+		_, err = ta.DB.Exec(`
+			INSERT INTO "product" (id, name, description, price, units_in_stock)
+			VALUES ($1, $2, 'A kitchen item', $3, 10)
+		`, product.id, product.name, product.price)
+		require.NoError(t, err)
+		_, err = ta.DB.Exec(`
+			INSERT INTO "product_variant" (product_id, sku, units_in_stock, is_default)
+			VALUES ($1, $2, 10, TRUE)
+		`, product.id, product.name)
+		require.NoError(t, err)
+	}
+
+	var res struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+		Promotion query.Promotion `json:"promotion"`
+		Quote     query.Quote     `json:"quote"`
+		Orders    []query.Order   `json:"orders"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	}
+	send := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, createJSONRequestBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	login := func(email string) string {
+		creds := payload.LoginPayload{Email: email, Password: "password123"}
+		w := postJSON(router, "/api/auth/login", creds)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		decode(w)
+		return res.Tokens.Access
+	}
+	amount := func(s string) decimal.Decimal {
+		return decimal.RequireFromString(s)
+	}
+
+	for _, email := range []string{"customer@example.com", "marketing@example.com"} {
+		w := postJSON(router, "/api/auth/register", payload.RegisterPayload{FirstName: "Test", Email: email, Password: "password123"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	_, err = ta.DB.Exec(`UPDATE "user" SET role = 'admin' WHERE email = 'marketing@example.com'`)
+	require.NoError(t, err)
+	customer, admin := login("customer@example.com"), login("marketing@example.com")
+
+	w := send("POST", "/api/me/addresses", customer, payload.AddressPayload{
+		FullName:   "Customer",
+		Line1:      "1 Market Street",
+		City:       "San Francisco",
+		PostalCode: "94105",
+		Country:    "US",
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	once := 1
+	spring := payload.PromotionPayload{
+		Code:         "spring10",
+		Type:         "percentage",
+		Value:        amount("10"),
+		MinSubtotal:  amount("20.00"),
+		PerUserLimit: &once,
+	}
+	w = send("POST", "/api/admin/promotions", customer, spring)
+	assert.Equal(t, http.StatusForbidden, w.Code, "customers cannot create promotions")
+
+	w = send("POST", "/api/admin/promotions", admin, spring)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, "SPRING10", res.Promotion.Code)
+	promotionPath := "/api/admin/promotions/" + res.Promotion.ID.String()
+
+	w = send("POST", "/api/admin/promotions", admin, spring)
+	assert.Equal(t, http.StatusConflict, w.Code, "codes are unique regardless of case")
+
+	invalid := spring
+	invalid.Code, invalid.Value = "HALFOFF", amount("150")
+	w = send("POST", "/api/admin/promotions", admin, invalid)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "percentages cannot exceed 100")
+
+	invalid = payload.PromotionPayload{Code: "B2G1", Type: "buy_x_get_y"}
+	w = send("POST", "/api/admin/promotions", admin, invalid)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "buy_x_get_y needs its quantities")
+
+	items := []payload.OrderItemPayload{{ProductID: kettle, Quantity: 1}, {ProductID: mug, Quantity: 2}}
+
+	w = send("POST", "/api/orders/quote", customer, payload.OrderPayload{Items: items, PromotionCodes: []string{"Spring10"}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	assert.True(t, res.Quote.Subtotal.Equal(amount("50.00")), res.Quote.Subtotal.String())
+	assert.True(t, res.Quote.Discount.Equal(amount("5.00")), res.Quote.Discount.String())
+	assert.True(t, res.Quote.Total.Equal(amount("45.00")), res.Quote.Total.String())
+	require.Len(t, res.Quote.Discounts, 1)
+	assert.Equal(t, "SPRING10", res.Quote.Discounts[0].Code)
+
+	w = send("POST", "/api/orders/quote", customer, payload.OrderPayload{
+		Items:          []payload.OrderItemPayload{{ProductID: mug, Quantity: 1}},
+		PromotionCodes: []string{"SPRING10"},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "the minimum spend is not met")
+
+	w = send("POST", "/api/orders/quote", customer, payload.OrderPayload{Items: items, PromotionCodes: []string{"NOPE"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "unknown codes are rejected")
+
+	// A promotion scoped to a category applies to its subcategories
+	kitchen, drinkware := uuid.New(), uuid.New()
+	_, err = ta.DB.Exec(`INSERT INTO "category" (id, name, slug) VALUES ($1, 'Kitchen', 'kitchen')`, kitchen)
+	require.NoError(t, err)
+	_, err = ta.DB.Exec(`INSERT INTO "category" (id, parent_id, name, slug) VALUES ($1, $2, 'Drinkware', 'drinkware')`, drinkware, kitchen)
+	require.NoError(t, err)
+	_, err = ta.DB.Exec(`INSERT INTO "product_category" (product_id, category_id) VALUES ($1, $2)`, mug, drinkware)
+	require.NoError(t, err)
+
+	w = send("POST", "/api/admin/promotions", admin, payload.PromotionPayload{
+		Code:        "KITCHEN5",
+		Type:        "fixed",
+		Value:       amount("5.00"),
+		CategoryIDs: []uuid.UUID{kitchen},
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = send("POST", "/api/orders/quote", customer, payload.OrderPayload{Items: items, PromotionCodes: []string{"KITCHEN5"}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	assert.True(t, res.Quote.Discount.Equal(amount("5.00")), res.Quote.Discount.String())
+	for _, line := range res.Quote.Lines {
+		if line.ProductID == kettle {
+			assert.True(t, line.Discount.IsZero(), "the kettle is not in the kitchen category")
+		}
+	}
+
+	w = send("POST", "/api/orders/quote", customer, payload.OrderPayload{
+		Items:          []payload.OrderItemPayload{{ProductID: kettle, Quantity: 1}},
+		PromotionCodes: []string{"KITCHEN5"},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "the promotion does not apply to the kettle")
+
+	w = send("POST", "/api/orders/quote", customer, payload.OrderPayload{
+		Items:          []payload.OrderItemPayload{{ProductID: mug, Quantity: 2000000000}},
+		PromotionCodes: []string{"SPRING10"},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "quantities are bounded")
+
+	w = send("POST", "/api/orders/quote", customer, payload.OrderPayload{
+		Items:          []payload.OrderItemPayload{{ProductID: kettle, Quantity: 11}},
+		PromotionCodes: []string{"SPRING10"},
+	})
+	assert.Equal(t, http.StatusConflict, w.Code, "stock is checked before promotions are applied")
+
+	w = send("POST", "/api/orders", customer, payload.OrderPayload{Items: items, PromotionCodes: []string{"spring10"}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = send("GET", "/api/orders", customer, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	require.Len(t, res.Orders, 1)
+	order := res.Orders[0]
+	assert.True(t, order.SubtotalAmount.Equal(amount("50.00")), order.SubtotalAmount.String())
+	assert.True(t, order.DiscountAmount.Equal(amount("5.00")), order.DiscountAmount.String())
+	assert.True(t, order.TotalAmount.Equal(amount("45.00")), order.TotalAmount.String())
+	require.Len(t, order.Discounts, 1)
+	assert.Equal(t, "SPRING10", order.Discounts[0].Code)
+	lineDiscounts := decimal.Zero
+	for _, item := range order.Items {
+		lineDiscounts = lineDiscounts.Add(item.DiscountAmount)
+	}
+	assert.True(t, lineDiscounts.Equal(order.DiscountAmount), "the line discounts add up to the order discount")
+
+	w = send("POST", "/api/orders", customer, payload.OrderPayload{Items: items, PromotionCodes: []string{"SPRING10"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "the code can only be used once per customer")
+
+	w = send("GET", promotionPath, admin, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, 1, res.Promotion.Uses)
+
+	w = send("DELETE", promotionPath, admin, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = send("GET", "/api/orders", customer, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	require.Len(t, res.Orders[0].Discounts, 1, "orders keep their discounts when the promotion is deleted")
+	assert.Nil(t, res.Orders[0].Discounts[0].PromotionID)
+}
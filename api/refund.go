@@ -0,0 +1,285 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/payment"
+	"github.com/amosehiguese/ecommerce-api/pkg/validator"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// RefundOrder godoc
+// @Summary      Refund an Order
+// @Description  Give money back for a paid order through the payment provider. Without items, everything not refunded yet is refunded; with items, the given units of each line are refunded at the price they were sold at. Refunds can never add up to more than the order total. With restock, the refunded units go back in stock. The order becomes refunded once all of its money is refunded, and partially_refunded before that. A refund whose outcome the payment provider does not report stays pending and is retried with /api/orders/{id}/refunds/{refund_id}/retry.
+// @Tags         Orders
+// @Accept       json
+// @Produce      json
+// @Param        id path string true "Order ID"
+// @Param        refundPayload body payload.RefundPayload true "Refund"
+// @Success      200 {object} map[string]interface{} "Order refunded successfully"
+// @Failure      400 {object} map[string]interface{} "Invalid order id, request body, validation error or unknown order line"
+// @Failure      401 {object} map[string]interface{} "Unauthorized, token expired"
+// @Failure      403 {object} map[string]interface{} "Permission denied"
+// @Failure      404 {object} map[string]interface{} "Order not found"
+// @Failure      409 {object} map[string]interface{} "Order cannot be refunded, or not by as much"
+// @Failure      500 {object} map[string]interface{} "Internal server error"
+// @Failure      502 {object} map[string]interface{} "Payment provider error, the refund is failed or left pending"
+// @Router       /api/orders/{id}/refunds [post]
+func (api *API) RefundOrder(c *gin.Context) {
+	// Once money moves, the refund is recorded even if the client goes away.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	principal := auth.CurrentPrincipal(c)
+
+	orderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid order id"})
+		return
+	}
+
+	var refundPayload payload.RefundPayload
+	if err := c.ShouldBindJSON(&refundPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(refundPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	lines := make([]query.RefundLine, 0, len(refundPayload.Items))
+	for _, item := range refundPayload.Items {
+		lines = append(lines, query.RefundLine{OrderItemID: item.OrderItemID, Quantity: item.Quantity})
+	}
+
+	r := &query.Refund{
+		ID:        uuid.New(),
+		OrderID:   orderID,
+		Reason:    refundPayload.Reason,
+		Restock:   refundPayload.Restock,
+		CreatedBy: &principal.UserID,
+	}
+	p, err := api.Q.CreateRefund(ctx, r, lines)
+	if err != nil {
+		respondRefundError(c, err)
+		return
+	}
+
+	if p.Provider != api.Payments.Name() || p.ProviderRef == nil {
+		api.failRefund(ctx, r, "the payment was taken by another payment provider")
+		c.JSON(http.StatusConflict, gin.H{"error": true, "msg": "the order was paid through another payment provider"})
+		return
+	}
+
+	api.sendRefund(ctx, c, r, p, &principal.UserID)
+}
+
+// RetryRefund godoc
+// @Summary      Retry a pending Refund
+// @Description  Send a refund left pending by a payment provider error again. The provider recognizes the refund by its ID, so a refund it already made is not made twice.
+// @Tags         Orders
+// @Produce      json
+// @Param        id path string true "Order ID"
+// @Param        refund_id path string true "Refund ID"
+// @Success      200 {object} map[string]interface{} "Order refunded successfully"
+// @Failure      400 {object} map[string]interface{} "Invalid order or refund id"
+// @Failure      401 {object} map[string]interface{} "Unauthorized, token expired"
+// @Failure      403 {object} map[string]interface{} "Permission denied"
+// @Failure      404 {object} map[string]interface{} "Refund not found"
+// @Failure      409 {object} map[string]interface{} "Refund is not pending, or was refused"
+// @Failure      500 {object} map[string]interface{} "Internal server error"
+// @Failure      502 {object} map[string]interface{} "Payment provider error, the refund is failed or left pending"
+// @Router       /api/orders/{id}/refunds/{refund_id}/retry [post]
+func (api *API) RetryRefund(c *gin.Context) {
+	log := logger.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	principal := auth.CurrentPrincipal(c)
+
+	orderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid order id"})
+		return
+	}
+	refundID, err := uuid.Parse(c.Param("refund_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid refund id"})
+		return
+	}
+
+	r, err := api.Q.GetRefund(ctx, orderID, refundID)
+	if err != nil {
+		log.Error("Error fetching refund", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+	if r == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": "refund not found"})
+		return
+	}
+	if r.Status != query.RefundPending {
+		respondRefundError(c, query.ErrRefundNotPending)
+		return
+	}
+
+	p, err := api.Q.GetPaymentByID(ctx, r.PaymentID)
+	if err != nil {
+		log.Error("Error fetching payment", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	api.sendRefund(ctx, c, r, p, &principal.UserID)
+}
+
+// sendRefund asks the payment provider to make a pending refund and records
+// the outcome. Only a refusal fails the refund. Any other error leaves the
+// outcome unknown, so the refund stays pending and keeps its amount reserved
+// until it is retried; the refund ID is the idempotency key, so a retry
+// returns a refund the provider already made instead of making another.
+func (api *API) sendRefund(ctx context.Context, c *gin.Context, r *query.Refund, p *query.Payment, changedBy *uuid.UUID) {
+	log := logger.Get()
+
+	refund, err := api.Payments.Refund(ctx, payment.RefundRequest{
+		IntentID:       *p.ProviderRef,
+		Amount:         payment.MinorUnits(r.Amount),
+		IdempotencyKey: r.ID.String(),
+	})
+	if err != nil {
+		log.Error("Payment provider refund error", zap.String("refund_id", r.ID.String()), zap.Error(err))
+		if errors.Is(err, payment.ErrInvalidRefund) || errors.Is(err, payment.ErrInvalidState) ||
+			errors.Is(err, payment.ErrDeclined) {
+			api.failRefund(ctx, r, err.Error())
+			respondRefundError(c, providerError(err))
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error":  true,
+			"msg":    "the payment provider did not confirm the refund, it stays pending until retried",
+			"refund": r,
+		})
+		return
+	}
+
+	status, err := api.Q.CompleteRefund(ctx, r, refund.ID, changedBy)
+	if err != nil {
+		if errors.Is(err, query.ErrRefundNotPending) {
+			respondRefundError(c, err)
+			return
+		}
+		// The refund stays pending, so its amount cannot be refunded twice.
+		log.Error("Error saving completed refund", zap.String("refund_id", r.ID.String()),
+			zap.String("provider_ref", refund.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "the refund was made but could not be saved"})
+		return
+	}
+
+	api.audit(c, AuditOrderRefund, "order", r.OrderID.String(), gin.H{
+		"refund_id": r.ID,
+		"amount":    r.Amount,
+		"reason":    r.Reason,
+		"restock":   r.Restock,
+	})
+
+	log.Info("Order refunded", zap.String("order_id", r.OrderID.String()), zap.String("refund_id", r.ID.String()),
+		zap.String("amount", r.Amount.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "Order refunded successfully", "status": status, "refund": r})
+}
+
+// ListOrderRefunds godoc
+// @Summary      List the refunds of an Order
+// @Description  Retrieve every refund of an order with its lines, oldest first
+// @Tags         Orders
+// @Produce      json
+// @Param        id path string true "Order ID"
+// @Success      200 {object} map[string]interface{} "Refunds retrieved successfully"
+// @Failure      400 {object} map[string]interface{} "Invalid order id"
+// @Failure      401 {object} map[string]interface{} "Unauthorized, token expired"
+// @Failure      403 {object} map[string]interface{} "Permission denied"
+// @Failure      404 {object} map[string]interface{} "Order not found"
+// @Failure      500 {object} map[string]interface{} "Internal server error"
+// @Router       /api/orders/{id}/refunds [get]
+func (api *API) ListOrderRefunds(c *gin.Context) {
+	log := logger.Get()
+
+	orderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid order id"})
+		return
+	}
+
+	order, err := api.Q.GetOrderByID(c, orderID)
+	if err != nil {
+		log.Error("Error fetching order", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+	if order == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": "order not found"})
+		return
+	}
+
+	refunds, err := api.Q.ListRefunds(c, orderID)
+	if err != nil {
+		log.Error("Error fetching refunds", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"error":        false,
+		"status":       order.Status,
+		"total_amount": order.TotalAmount,
+		"refunds":      refunds,
+	})
+}
+
+// failRefund records that a pending refund was not made.
+func (api *API) failRefund(ctx context.Context, r *query.Refund, reason string) {
+	err := api.Q.FailRefund(ctx, r, reason)
+	if errors.Is(err, query.ErrRefundNotPending) {
+		// A concurrent retry already recorded the outcome.
+		logger.Get().Warn("Refund outcome already recorded", zap.String("refund_id", r.ID.String()))
+		return
+	}
+	if err != nil {
+		logger.Get().Error("Error saving failed refund", zap.String("refund_id", r.ID.String()), zap.Error(err))
+	}
+}
+
+// respondRefundError maps an error from refunding an order to its HTTP
+// response.
+func respondRefundError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, query.ErrOrderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": err.Error()})
+	case errors.Is(err, query.ErrOrderItemNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+	case errors.Is(err, query.ErrOrderNotRefundable), errors.Is(err, query.ErrNoCapturedPayment),
+		errors.Is(err, query.ErrRefundExceedsOrder), errors.Is(err, query.ErrRefundNotPending),
+		errors.Is(err, payment.ErrInvalidRefund),
+		errors.Is(err, payment.ErrInvalidState):
+		c.JSON(http.StatusConflict, gin.H{"error": true, "msg": err.Error()})
+	case errors.Is(err, errPaymentProvider), errors.Is(err, payment.ErrDeclined):
+		c.JSON(http.StatusBadGateway, gin.H{"error": true, "msg": "the refund could not be processed, please try again"})
+	default:
+		logger.Get().Error("Error refunding order", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+	}
+}
@@ -0,0 +1,205 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/pkg/payment"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/amosehiguese/ecommerce-api/routes"
+	"github.com/amosehiguese/ecommerce-api/server"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefundOrder(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	gateway := payment.NewFakeProvider()
+	router := routes.SetUp(ta.DB, config.Get(), routes.WithPaymentProvider(gateway))
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	kettle, mug := uuid.New(), uuid.New()
+	for _, product := range []struct {
+		id    uuid.UUID
+		name  string
+		price string
+	}{{kettle, "Kettle", "10.00"}, {mug, "Mug", "5.50"}} {
+		_, err = ta.DB.Exec(`
+			INSERT INTO "product" (id, name, description, price, units_in_stock)
+			VALUES ($1, $2, 'A kitchen item', $3, 10)
+		`, product.id, product.name, product.price)
+		require.NoError(t, err)
+		_, err = ta.DB.Exec(`
+			INSERT INTO "product_variant" (product_id, sku, units_in_stock, is_default)
+			VALUES ($1, $2, 10, TRUE)
+		`, product.id, product.name)
+		require.NoError(t, err)
+	}
+
+	var res struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+		OrderID string          `json:"order_id"`
+		Status  string          `json:"status"`
+		Payment query.Payment   `json:"payment"`
+		Refund  query.Refund    `json:"refund"`
+		Refunds []query.Refund  `json:"refunds"`
+		Total   decimal.Decimal `json:"total_amount"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	}
+	send := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, createJSONRequestBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	login := func(email string) string {
+		creds := payload.LoginPayload{Email: email, Password: "password123"}
+		w := postJSON(router, "/api/auth/login", creds)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		decode(w)
+		return res.Tokens.Access
+	}
+
+	for _, email := range []string{"customer@example.com", "support@example.com"} {
+		w := postJSON(router, "/api/auth/register", payload.RegisterPayload{FirstName: "Test", Email: email, Password: "password123"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	_, err = ta.DB.Exec(`UPDATE "user" SET role = 'admin' WHERE email = 'support@example.com'`)
+	require.NoError(t, err)
+	customer, admin := login("customer@example.com"), login("support@example.com")
+
+	w := send("POST", "/api/me/addresses", customer, payload.AddressPayload{
+		FullName:   "Customer",
+		Line1:      "1 Market Street",
+		City:       "San Francisco",
+		PostalCode: "94105",
+		Country:    "US",
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = send("POST", "/api/orders", customer, payload.OrderPayload{Items: []payload.OrderItemPayload{
+		{ProductID: kettle, Quantity: 2},
+		{ProductID: mug, Quantity: 1},
+	}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	orderID := res.OrderID
+	refundsPath := "/api/orders/" + orderID + "/refunds"
+
+	var kettleLine, mugLine uuid.UUID
+	require.NoError(t, ta.DB.QueryRow(`SELECT id FROM "order_item" WHERE order_id = $1 AND product_id = $2`, orderID, kettle).Scan(&kettleLine))
+	require.NoError(t, ta.DB.QueryRow(`SELECT id FROM "order_item" WHERE order_id = $1 AND product_id = $2`, orderID, mug).Scan(&mugLine))
+
+	w = send("POST", refundsPath, admin, payload.RefundPayload{Reason: "not paid yet"})
+	assert.Equal(t, http.StatusConflict, w.Code, "unpaid orders cannot be refunded")
+
+	w = send("POST", "/api/orders/"+orderID+"/pay", customer, payload.PayOrderPayload{PaymentMethod: payment.FakeCardOK})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	intentID := *res.Payment.ProviderRef
+
+	w = send("POST", refundsPath, customer, payload.RefundPayload{Reason: "I want my money back"})
+	assert.Equal(t, http.StatusForbidden, w.Code, "customers cannot refund their own orders")
+
+	// One of the two kettles came back broken
+	w = send("POST", refundsPath, admin, payload.RefundPayload{
+		Items:   []payload.RefundItemPayload{{OrderItemID: kettleLine, Quantity: 1}},
+		Reason:  "arrived broken",
+		Restock: true,
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, "partially_refunded", res.Status)
+	assert.True(t, res.Refund.Amount.Equal(decimal.RequireFromString("10.00")), res.Refund.Amount.String())
+	assert.Equal(t, int64(1000), gateway.Refunded(intentID))
+
+	var stock int
+	require.NoError(t, ta.DB.QueryRow(`SELECT units_in_stock FROM "product" WHERE id = $1`, kettle).Scan(&stock))
+	assert.Equal(t, 9, stock, "the returned kettle is back in stock")
+
+	w = send("POST", refundsPath, admin, payload.RefundPayload{
+		Items:  []payload.RefundItemPayload{{OrderItemID: kettleLine, Quantity: 2}},
+		Reason: "both broken",
+	})
+	assert.Equal(t, http.StatusConflict, w.Code, "only one kettle is left to refund")
+
+	w = send("POST", refundsPath, admin, payload.RefundPayload{
+		Items:  []payload.RefundItemPayload{{OrderItemID: uuid.New(), Quantity: 1}},
+		Reason: "unknown line",
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// The provider makes the refund but its response is lost
+	gateway.TimeOutNextRefund()
+	w = send("POST", refundsPath, admin, payload.RefundPayload{
+		Items:  []payload.RefundItemPayload{{OrderItemID: mugLine, Quantity: 1}},
+		Reason: "chipped",
+	})
+	require.Equal(t, http.StatusBadGateway, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, query.RefundPending, res.Refund.Status, "an unknown outcome leaves the refund pending")
+	assert.Equal(t, int64(1550), gateway.Refunded(intentID))
+	retryPath := refundsPath + "/" + res.Refund.ID.String() + "/retry"
+
+	w = send("POST", retryPath, admin, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, query.RefundSucceeded, res.Refund.Status)
+	assert.Equal(t, int64(1550), gateway.Refunded(intentID), "the retry does not refund the mug twice")
+
+	w = send("POST", retryPath, admin, nil)
+	assert.Equal(t, http.StatusConflict, w.Code, "only pending refunds can be retried")
+
+	// Everything that is left
+	w = send("POST", refundsPath, admin, payload.RefundPayload{Reason: "customer unhappy"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, "refunded", res.Status)
+	assert.True(t, res.Refund.Amount.Equal(decimal.RequireFromString("10.00")), res.Refund.Amount.String())
+	assert.Len(t, res.Refund.Items, 1)
+	assert.Equal(t, int64(2550), gateway.Refunded(intentID))
+
+	require.NoError(t, ta.DB.QueryRow(`SELECT units_in_stock FROM "product" WHERE id = $1`, mug).Scan(&stock))
+	assert.Equal(t, 9, stock, "units are only restocked when asked to")
+
+	w = send("POST", refundsPath, admin, payload.RefundPayload{Reason: "again"})
+	assert.Equal(t, http.StatusConflict, w.Code, "refunded orders cannot be refunded again")
+
+	w = send("GET", refundsPath, admin, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	require.Len(t, res.Refunds, 3)
+	refunded := decimal.Zero
+	for _, refund := range res.Refunds {
+		assert.Equal(t, query.RefundSucceeded, refund.Status)
+		refunded = refunded.Add(refund.Amount)
+	}
+	assert.True(t, refunded.Equal(res.Total), "refunded %s of %s", refunded, res.Total)
+
+	var paymentStatus string
+	require.NoError(t, ta.DB.QueryRow(`SELECT status FROM "payment" WHERE provider_ref = $1`, intentID).Scan(&paymentStatus))
+	assert.Equal(t, "refunded", paymentStatus)
+}
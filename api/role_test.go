@@ -0,0 +1,166 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/amosehiguese/ecommerce-api/routes"
+	"github.com/amosehiguese/ecommerce-api/server"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoles(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	router := routes.SetUp(ta.DB, config.Get())
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	var res struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+		Role        query.Role         `json:"role"`
+		Roles       []query.Role       `json:"roles"`
+		Permissions []query.Permission `json:"permissions"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		res.Role, res.Roles, res.Permissions = query.Role{}, nil, nil
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	}
+	send := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, createJSONRequestBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	login := func(email string) string {
+		w := send("POST", "/api/auth/login", "", payload.LoginPayload{Email: email, Password: "password123"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		decode(w)
+		return res.Tokens.Access
+	}
+	roles := func(token string) map[string]query.Role {
+		w := send("GET", "/api/admin/roles", token, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		decode(w)
+		byName := make(map[string]query.Role, len(res.Roles))
+		for _, role := range res.Roles {
+			byName[role.Name] = role
+		}
+		return byName
+	}
+
+	for _, email := range []string{"member@example.com", "staff@example.com"} {
+		w := send("POST", "/api/auth/register", "", payload.RegisterPayload{FirstName: "Test", Email: email, Password: "password123"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	_, err = ta.DB.Exec(`UPDATE "user" SET role = 'admin' WHERE email = 'staff@example.com'`)
+	require.NoError(t, err)
+	var memberID uuid.UUID
+	require.NoError(t, ta.DB.QueryRow(`SELECT id FROM "user" WHERE email = 'member@example.com'`).Scan(&memberID))
+	member, admin := login("member@example.com"), login("staff@example.com")
+
+	w := send("GET", "/api/admin/roles", member, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "customers cannot manage roles")
+
+	w = send("GET", "/api/admin/permissions", admin, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	names := []string{}
+	for _, permission := range res.Permissions {
+		names = append(names, permission.Name)
+	}
+	assert.Contains(t, names, auth.RoleManageCredential)
+
+	// The built-in roles are seeded with the grants they had in code
+	all := roles(admin)
+	require.Contains(t, all, "user")
+	assert.True(t, all["user"].IsSystem)
+	assert.ElementsMatch(t, []string{auth.OrderCreateCredential, auth.OrderReadCredential, auth.OrderCancelCredential}, all["user"].Permissions)
+	assert.Contains(t, all["admin"].Permissions, auth.RoleManageCredential)
+
+	// Custom roles are named like slugs and only grant known permissions
+	w = send("POST", "/api/admin/roles", admin, payload.RolePayload{
+		Name:        "Support",
+		Permissions: []string{auth.OrderReadCredential},
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, "support", res.Role.Name)
+	assert.False(t, res.Role.IsSystem)
+
+	w = send("POST", "/api/admin/roles", admin, payload.RolePayload{Name: "support"})
+	assert.Equal(t, http.StatusConflict, w.Code, "role names are unique")
+	w = send("POST", "/api/admin/roles", admin, payload.RolePayload{Name: "Support Team!"})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "role names are slugs")
+	w = send("POST", "/api/admin/roles", admin, payload.RolePayload{Name: "wizard", Permissions: []string{"spell:cast"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "unknown permissions are rejected")
+	assert.NotContains(t, roles(admin), "wizard", "a rejected role is not created")
+
+	w = send("PUT", "/api/admin/roles/support", admin, payload.RoleUpdatePayload{
+		Description: "Handles customer questions",
+		Permissions: []string{auth.OrderReadCredential, auth.RoleManageCredential},
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("PUT", "/api/admin/roles/support", admin, payload.RoleUpdatePayload{Permissions: []string{"spell:cast"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "unknown permissions are rejected")
+	w = send("PUT", "/api/admin/roles/wizard", admin, payload.RoleUpdatePayload{Permissions: []string{}})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	support := roles(admin)["support"]
+	require.NotNil(t, support.Description)
+	assert.Equal(t, "Handles customer questions", *support.Description)
+	assert.ElementsMatch(t, []string{auth.OrderReadCredential, auth.RoleManageCredential}, support.Permissions)
+
+	// The admin role cannot give up role management
+	w = send("PUT", "/api/admin/roles/admin", admin, payload.RoleUpdatePayload{Permissions: []string{auth.OrderReadCredential}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, roles(admin)["admin"].Permissions, auth.RoleManageCredential)
+
+	// Assigning a role grants its permissions from the next login on
+	w = send("PUT", "/api/admin/users/"+memberID.String()+"/role", admin, payload.UserRolePayload{Role: "wizard"})
+	assert.Equal(t, http.StatusNotFound, w.Code, "only existing roles can be assigned")
+	w = send("PUT", "/api/admin/users/"+uuid.NewString()+"/role", admin, payload.UserRolePayload{Role: "support"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = send("PUT", "/api/admin/users/"+memberID.String()+"/role", admin, payload.UserRolePayload{Role: "support"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = send("GET", "/api/admin/roles", member, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "tokens issued for the old role are revoked")
+	member = login("member@example.com")
+	roles(member)
+
+	// System roles and roles that are still assigned cannot be deleted
+	w = send("DELETE", "/api/admin/roles/user", admin, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = send("DELETE", "/api/admin/roles/support", admin, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = send("DELETE", "/api/admin/roles/wizard", admin, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = send("PUT", "/api/admin/users/"+memberID.String()+"/role", admin, payload.UserRolePayload{Role: "user"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("DELETE", "/api/admin/roles/support", admin, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotContains(t, roles(admin), "support")
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/utils"
+	"github.com/amosehiguese/ecommerce-api/pkg/validator"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// SetupAdmin godoc
+// @Summary Create the first admin
+// @Description Creates the first admin account, authenticated by the ADMIN_SETUP_TOKEN of the configuration. The endpoint only works while no admin exists; further admins are appointed by an admin through /api/admin/users/{id}/role.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param setup body payload.SetupAdminPayload true "Setup token and admin account"
+// @Success 200 {object} gin.H{"error": false, "msg": "admin account created", "user": "user_id"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "invalid setup token"}
+// @Failure 404 {object} gin.H{"error": true, "msg": "admin setup is disabled"}
+// @Failure 409 {object} gin.H{"error": true, "msg": "an admin account already exists"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/auth/setup-admin [post]
+func (api *API) SetupAdmin(c *gin.Context) {
+	log := logger.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	setupToken := api.Cfg.Account.AdminSetupToken
+	if setupToken == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": "admin setup is disabled"})
+		return
+	}
+
+	var setupPayload payload.SetupAdminPayload
+	if err := c.ShouldBindJSON(&setupPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(setupPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(setupPayload.SetupToken), []byte(setupToken)) != 1 {
+		log.Warn("Admin setup attempted with an invalid token", zap.String("ip", c.ClientIP()))
+		c.JSON(http.StatusForbidden, gin.H{"error": true, "msg": "invalid setup token"})
+		return
+	}
+
+	if err := api.Passwords.Check(setupPayload.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	passwordHash, err := utils.HashPassword(setupPayload.Password)
+	if err != nil {
+		log.Error("Error hashing admin password", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to create admin account"})
+		return
+	}
+
+	admin := &query.User{
+		ID:           uuid.New(),
+		FirstName:    setupPayload.FirstName,
+		Email:        strings.TrimSpace(setupPayload.Email),
+		PasswordHash: passwordHash,
+		Role:         auth.AdminRole.String(),
+	}
+	if setupPayload.LastName != "" {
+		admin.LastName = &setupPayload.LastName
+	}
+
+	if err := api.Q.CreateFirstAdmin(ctx, admin); err != nil {
+		switch {
+		case errors.Is(err, query.ErrAdminExists), errors.Is(err, query.ErrEmailTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": true, "msg": err.Error()})
+		default:
+			log.Error("Error creating admin account", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to create admin account"})
+		}
+		return
+	}
+
+	api.audit(c, AuditAdminBootstrap, "user", admin.ID.String(), gin.H{"email": admin.Email})
+
+	log.Info("Admin account created with the setup token", zap.String("user_id", admin.ID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "admin account created", "user": admin.ID})
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/validator"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// SetProductVariants godoc
+// @Summary Set a product's variant matrix
+// @Description Replace the options and variants of a product. Variants listed with an id are updated, variants without one are created, and variants left out are removed. Exactly one variant must be the default.
+// @Tags products
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param variants body payload.ProductVariantsPayload true "Options and variants"
+// @Security CookieAuth
+// @Success 200 {object} gin.H{"error": false, "options": []query.ProductOption, "variants": []query.ProductVariant}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 401 {object} gin.H{"error": true, "msg": "unauthorized"}
+// @Failure 403 {object} gin.H{"error": true, "msg": "permission denied"}
+// @Failure 404 {object} gin.H{"error": true, "msg": "product not found"}
+// @Failure 409 {object} gin.H{"error": true, "msg": "sku or barcode already in use"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/products/{id}/variants [put]
+func (api *API) SetProductVariants(c *gin.Context) {
+	log := logger.Get()
+
+	productID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid product id"})
+		return
+	}
+
+	var variantsPayload payload.ProductVariantsPayload
+	if err := c.ShouldBindJSON(&variantsPayload); err != nil {
+		log.Error("Invalid JSON for product variants", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(variantsPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	options := make([]query.ProductOption, 0, len(variantsPayload.Options))
+	for _, optionPayload := range variantsPayload.Options {
+		options = append(options, query.ProductOption{
+			Name:     optionPayload.Name,
+			Values:   optionPayload.Values,
+			Position: optionPayload.Position,
+		})
+	}
+
+	variants := make([]query.ProductVariant, 0, len(variantsPayload.Variants))
+	for _, variantPayload := range variantsPayload.Variants {
+		variant := query.ProductVariant{
+			SKU:          variantPayload.SKU,
+			Price:        variantPayload.Price,
+			UnitsInStock: variantPayload.UnitsInStock,
+			Options:      variantPayload.Options,
+			IsDefault:    variantPayload.IsDefault,
+		}
+		if variantPayload.ID != nil {
+			variant.ID = *variantPayload.ID
+		}
+		if variantPayload.Barcode != "" {
+			barcode := variantPayload.Barcode
+			variant.Barcode = &barcode
+		}
+		variants = append(variants, variant)
+	}
+
+	if err := api.Q.SetProductVariants(c, productID, options, variants); err != nil {
+		switch {
+		case errors.Is(err, query.ErrProductNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": err.Error()})
+		case errors.Is(err, query.ErrInvalidVariants), errors.Is(err, query.ErrVariantNotFound):
+			c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		case errors.Is(err, query.ErrSKUTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": true, "msg": err.Error()})
+		default:
+			log.Error("Error saving product variants", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		}
+		return
+	}
+
+	saved, err := api.Q.GetProductVariants(c, productID)
+	if err != nil {
+		log.Error("Error retrieving product variants", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	log.Info("Product variants updated", zap.String("product_id", productID.String()), zap.Int("variants", len(saved)))
+	c.JSON(http.StatusOK, gin.H{"error": false, "options": options, "variants": saved})
+}
@@ -0,0 +1,232 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/amosehiguese/ecommerce-api/routes"
+	"github.com/amosehiguese/ecommerce-api/server"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProductVariants(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	router := routes.SetUp(ta.DB, config.Get())
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	var res struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+		Product  query.Product          `json:"product"`
+		Options  []query.ProductOption  `json:"options"`
+		Variants []query.ProductVariant `json:"variants"`
+		Quote    query.Quote            `json:"quote"`
+		Cart     query.Cart             `json:"cart"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		res.Options, res.Variants = nil, nil
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	}
+	send := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, createJSONRequestBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	login := func(email string) string {
+		w := send("POST", "/api/auth/login", "", payload.LoginPayload{Email: email, Password: "password123"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		decode(w)
+		return res.Tokens.Access
+	}
+	amount := func(s string) decimal.Decimal {
+		return decimal.RequireFromString(s)
+	}
+
+	for _, email := range []string{"customer@example.com", "staff@example.com"} {
+		w := send("POST", "/api/auth/register", "", payload.RegisterPayload{FirstName: "Test", Email: email, Password: "password123"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	_, err = ta.DB.Exec(`UPDATE "user" SET role = 'admin' WHERE email = 'staff@example.com'`)
+	require.NoError(t, err)
+	customer, admin := login("customer@example.com"), login("staff@example.com")
+
+	w := send("POST", "/api/me/addresses", customer, payload.AddressPayload{
+		FullName:   "Customer",
+		Line1:      "1 Market Street",
+		City:       "San Francisco",
+		PostalCode: "94105",
+		Country:    "US",
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// A new product sells through a single default variant
+	w = send("POST", "/api/products/", admin, payload.ProductPayload{Name: "Tee", Description: "A cotton tee", Price: 20, UnitsInStock: 10, SKU: "TEE"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	tee := res.Product.ID
+	productPath := "/api/products/" + tee.String()
+
+	getProduct := func() {
+		w := send("GET", productPath, admin, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		decode(w)
+	}
+	getProduct()
+	require.Len(t, res.Variants, 1)
+	small := res.Variants[0]
+	assert.True(t, small.IsDefault)
+	assert.Equal(t, "TEE", small.SKU)
+	assert.Equal(t, 10, small.UnitsInStock)
+	assert.True(t, small.EffectivePrice.Equal(amount("20")), small.EffectivePrice.String())
+
+	order := func(variantID *uuid.UUID) query.QuoteLine {
+		w := send("POST", "/api/orders", customer, payload.OrderPayload{
+			Items: []payload.OrderItemPayload{{ProductID: tee, VariantID: variantID, Quantity: 1}},
+		})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		decode(w)
+		require.Len(t, res.Quote.Lines, 1)
+		return res.Quote.Lines[0]
+	}
+	line := order(nil)
+	assert.Equal(t, small.ID, line.VariantID, "lines without a variant use the default one")
+	assert.Equal(t, "TEE", line.SKU)
+
+	// Splitting the product into sizes keeps the default variant's ID
+	mediumPrice := amount("25.00")
+	variants := payload.ProductVariantsPayload{
+		Options: []payload.ProductOptionPayload{{Name: "size", Values: []string{"S", "M"}}},
+		Variants: []payload.ProductVariantPayload{
+			{ID: &small.ID, SKU: "TEE-S", UnitsInStock: 9, Options: map[string]string{"size": "S"}, IsDefault: true},
+			{SKU: "TEE-M", Price: &mediumPrice, UnitsInStock: 3, Options: map[string]string{"size": "M"}},
+		},
+	}
+	w = send("PUT", productPath+"/variants", admin, variants)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	getProduct()
+	require.Len(t, res.Options, 1)
+	require.Len(t, res.Variants, 2)
+	assert.Equal(t, small.ID, res.Variants[0].ID, "the default variant is listed first")
+	assert.Equal(t, "TEE-S", res.Variants[0].SKU)
+	medium := res.Variants[1]
+	assert.Equal(t, "TEE-M", medium.SKU)
+	assert.True(t, medium.EffectivePrice.Equal(amount("25.00")), medium.EffectivePrice.String())
+	assert.Equal(t, 12, res.Product.UnitsInStock, "the product stock is the sum of its variants")
+
+	line = order(&medium.ID)
+	assert.Equal(t, medium.ID, line.VariantID)
+	assert.True(t, line.UnitPrice.Equal(amount("25.00")), line.UnitPrice.String())
+	line = order(nil)
+	assert.Equal(t, small.ID, line.VariantID)
+	assert.True(t, line.UnitPrice.Equal(amount("20")), line.UnitPrice.String())
+
+	w = send("POST", "/api/cart/items", customer, payload.CartItemPayload{ProductID: tee, Quantity: 1})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("GET", "/api/cart", customer, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	require.Len(t, res.Cart.Items, 1)
+	assert.Equal(t, small.ID, res.Cart.Items[0].VariantID, "the cart resolves the default variant too")
+
+	// Moving the default moves what variant-less lines resolve to
+	variants.Variants[0].IsDefault = false
+	variants.Variants[1].ID, variants.Variants[1].IsDefault = &medium.ID, true
+	w = send("PUT", productPath+"/variants", admin, variants)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	line = order(nil)
+	assert.Equal(t, medium.ID, line.VariantID)
+
+	var unitsInStock int
+	err = ta.DB.QueryRow(`SELECT units_in_stock FROM "product" WHERE id = $1`, tee).Scan(&unitsInStock)
+	require.NoError(t, err)
+	assert.Equal(t, 11, unitsInStock, "the matrix sets 9 small and 3 medium, and one medium was ordered since")
+
+	// Variants of another product are rejected
+	other := uuid.New()
+	_, err = ta.DB.Exec(`
+		INSERT INTO "product" (id, name, description, price, units_in_stock)
+		VALUES ($1, 'Hoodie', 'A fleece hoodie', 40.00, 5)
+	`, other)
+	require.NoError(t, err)
+	_, err = ta.DB.Exec(`
+		INSERT INTO "product_variant" (product_id, sku, units_in_stock, is_default)
+		VALUES ($1, 'HOODIE', 5, TRUE)
+	`, other)
+	require.NoError(t, err)
+	var hoodie uuid.UUID
+	require.NoError(t, ta.DB.QueryRow(`SELECT id FROM "product_variant" WHERE product_id = $1`, other).Scan(&hoodie))
+	w = send("POST", "/api/orders", customer, payload.OrderPayload{
+		Items: []payload.OrderItemPayload{{ProductID: tee, VariantID: &hoodie, Quantity: 1}},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Invalid matrices are rejected as a whole
+	for name, edit := range map[string]func(v *payload.ProductVariantsPayload){
+		"two defaults":    func(v *payload.ProductVariantsPayload) { v.Variants[0].IsDefault = true },
+		"unknown value":   func(v *payload.ProductVariantsPayload) { v.Variants[0].Options = map[string]string{"size": "XL"} },
+		"same options":    func(v *payload.ProductVariantsPayload) { v.Variants[0].Options = map[string]string{"size": "M"} },
+		"unknown variant": func(v *payload.ProductVariantsPayload) { id := uuid.New(); v.Variants[0].ID = &id },
+	} {
+		invalid := variants
+		invalid.Variants = append([]payload.ProductVariantPayload(nil), variants.Variants...)
+		edit(&invalid)
+		w = send("PUT", productPath+"/variants", admin, invalid)
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+	}
+	negative := amount("-1.00")
+	invalid := variants
+	invalid.Variants = append([]payload.ProductVariantPayload(nil), variants.Variants...)
+	invalid.Variants[1].Price = &negative
+	w = send("PUT", productPath+"/variants", admin, invalid)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "prices cannot be negative")
+
+	// Two variants can swap their SKUs in one update
+	swapped := variants
+	swapped.Variants = append([]payload.ProductVariantPayload(nil), variants.Variants...)
+	swapped.Variants[0].SKU, swapped.Variants[1].SKU = variants.Variants[1].SKU, variants.Variants[0].SKU
+	w = send("PUT", productPath+"/variants", admin, swapped)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	getProduct()
+	require.Len(t, res.Variants, 2)
+	assert.Equal(t, "TEE-S", res.Variants[0].SKU, "the medium default now carries the small SKU")
+	assert.Equal(t, "TEE-M", res.Variants[1].SKU)
+	w = send("PUT", productPath+"/variants", admin, variants)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	taken := variants
+	taken.Variants = append([]payload.ProductVariantPayload(nil), variants.Variants...)
+	taken.Variants[0].SKU = "HOODIE"
+	w = send("PUT", productPath+"/variants", admin, taken)
+	assert.Equal(t, http.StatusConflict, w.Code, "SKUs are unique across products")
+
+	// With several variants the stock is set per variant
+	w = send("PUT", productPath, admin, payload.ProductPayload{Name: "Tee", Description: "A cotton tee", Price: 20, UnitsInStock: 50})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = send("PUT", "/api/products/"+uuid.NewString(), admin, payload.ProductPayload{Name: "Tee", Description: "A cotton tee", Price: 20, UnitsInStock: 50})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/mailer"
+	"github.com/amosehiguese/ecommerce-api/pkg/utils"
+	"github.com/amosehiguese/ecommerce-api/pkg/validator"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+	// emailVerificationLimit caps the verification emails an account receives
+	// per hour.
+	emailVerificationLimit = 3
+)
+
+// sendVerificationEmail issues an email verification token for u and emails
+// the link that redeems it.
+func (api *API) sendVerificationEmail(ctx context.Context, u *query.User) error {
+	token, err := utils.GetURLTokenStr(32)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = api.Q.CreateUserToken(ctx, &query.UserToken{
+		ID:        uuid.New(),
+		UserID:    u.ID,
+		Purpose:   query.TokenPurposeEmailVerification,
+		TokenHash: utils.GetHash(token),
+		ExpiresAt: now.Add(emailVerificationTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	link := api.Cfg.Mail.APIURL + "/api/auth/verify?token=" + url.QueryEscape(token)
+	return api.Mailer.Send(ctx, mailer.Message{
+		To:      u.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm this is your email address by opening the link below "+
+			"within the next 24 hours:\n\n%s\n\n"+
+			"If you did not create an account, you can ignore this email.\n",
+			u.FirstName, link),
+	})
+}
+
+// requireVerifiedEmail answers 403 and returns false when orders are limited
+// to verified accounts and userID has not verified its email.
+func (api *API) requireVerifiedEmail(c *gin.Context, userID uuid.UUID) bool {
+	if !api.Cfg.Account.RequireVerifiedEmailForOrders {
+		return true
+	}
+
+	u, err := api.Q.GetUserByID(c, userID)
+	if err != nil {
+		logger.Get().Error("Error fetching user for email verification check", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the request"})
+		return false
+	}
+	if u == nil || u.EmailVerifiedAt == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": true, "msg": "please verify your email address before placing orders"})
+		return false
+	}
+	return true
+}
+
+// VerifyEmail godoc
+// @Summary Verify an email address
+// @Description Marks the email of an account as verified using the token from a verification email. The token works once.
+// @Tags auth
+// @Produce json
+// @Param token query string true "Verification token"
+// @Success 200 {object} gin.H{"error": false, "msg": "email address verified"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "invalid or expired token"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/auth/verify [get]
+func (api *API) VerifyEmail(c *gin.Context) {
+	log := logger.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token := c.Query("token")
+	if token == "" || len(token) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": query.ErrInvalidUserToken.Error()})
+		return
+	}
+
+	userID, err := api.Q.VerifyEmail(ctx, utils.GetHash(token))
+	if err != nil {
+		if errors.Is(err, query.ErrInvalidUserToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+			return
+		}
+		log.Error("Error verifying email", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to verify email address"})
+		return
+	}
+
+	log.Info("Email verified", zap.String("user_id", userID.String()))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "email address verified"})
+}
+
+// ResendVerification godoc
+// @Summary Resend the verification email
+// @Description Emails a new verification link to an account that has not verified its email yet. The response is the same whether or not the email belongs to such an account, and an account receives at most 3 verification emails per hour.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param resend body payload.ResendVerificationPayload true "Account email"
+// @Success 200 {object} gin.H{"error": false, "msg": "if an unverified account exists for this email, a verification link has been sent"}
+// @Failure 400 {object} gin.H{"error": true, "msg": "error message"}
+// @Failure 500 {object} gin.H{"error": true, "msg": "error message"}
+// @Router /api/auth/verify/resend [post]
+func (api *API) ResendVerification(c *gin.Context) {
+	log := logger.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var resendPayload payload.ResendVerificationPayload
+	if err := c.ShouldBindJSON(&resendPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(resendPayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	sent := gin.H{"error": false, "msg": "if an unverified account exists for this email, a verification link has been sent"}
+
+	u, err := api.Q.GetUserByEmail(ctx, resendPayload.Email)
+	if err != nil {
+		log.Error("Error fetching user for email verification", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the request"})
+		return
+	}
+	if u == nil || u.EmailVerifiedAt != nil {
+		c.JSON(http.StatusOK, sent)
+		return
+	}
+
+	count, err := api.Q.CountUserTokensSince(ctx, u.ID, query.TokenPurposeEmailVerification, time.Now().Add(-time.Hour))
+	if err != nil {
+		log.Error("Error counting verification emails", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the request"})
+		return
+	}
+	if count >= emailVerificationLimit {
+		log.Warn("Email verification rate limit reached", zap.String("user_id", u.ID.String()))
+		c.JSON(http.StatusOK, sent)
+		return
+	}
+
+	if err := api.sendVerificationEmail(ctx, u); err != nil {
+		log.Error("Error sending verification email", zap.String("user_id", u.ID.String()), zap.Error(err))
+	}
+
+	c.JSON(http.StatusOK, sent)
+}
@@ -0,0 +1,65 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/pkg/mailer"
+	"github.com/amosehiguese/ecommerce-api/routes"
+	"github.com/amosehiguese/ecommerce-api/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailVerification(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	outbox := mailer.NewMemoryMailer()
+	router := routes.SetUp(ta.DB, config.Get(), routes.WithMailer(outbox))
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	const email = "newcomer@example.com"
+	w := postJSON(router, "/api/auth/register", payload.RegisterPayload{
+		FirstName: "Newcomer",
+		Email:     email,
+		Password:  "password123",
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	first := lastEmailToken(t, outbox, email)
+
+	// Resending invalidates nothing; either link verifies the account
+	w = postJSON(router, "/api/auth/verify/resend", payload.ResendVerificationPayload{Email: email})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	second := lastEmailToken(t, outbox, email)
+	assert.NotEqual(t, first, second)
+
+	verify := func(token string) int {
+		req, _ := http.NewRequest("GET", "/api/auth/verify?token="+token, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusBadRequest, verify("not-a-token"))
+	assert.Equal(t, http.StatusOK, verify(second))
+	assert.Equal(t, http.StatusBadRequest, verify(second), "tokens are single-use")
+	assert.Equal(t, http.StatusBadRequest, verify(first), "other tokens stop working once verified")
+
+	// Verified accounts get the generic answer and no email
+	sent := len(outbox.Messages())
+	w = postJSON(router, "/api/auth/verify/resend", payload.ResendVerificationPayload{Email: email})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, outbox.Messages(), sent)
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/payment"
+	"github.com/amosehiguese/ecommerce-api/pkg/validator"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// maxWebhookSize caps the size of a webhook payload.
+const maxWebhookSize = 1 << 20
+
+// PaymentWebhook godoc
+// @Summary      Receive a payment webhook
+// @Description  Receives asynchronous payment results from the payment provider: authorized after customer authentication, succeeded, failed, disputed and dispute outcomes. The request must be signed by the provider within the last 5 minutes. Every event is stored as received and processed once, however often it is delivered; a 500 response makes the provider deliver it again.
+// @Tags         Payments
+// @Accept       json
+// @Produce      json
+// @Success      200 {object} map[string]interface{} "Event processed, or processed before"
+// @Failure      400 {object} map[string]interface{} "Invalid signature or payload"
+// @Failure      409 {object} map[string]interface{} "Event is being processed by another delivery"
+// @Failure      500 {object} map[string]interface{} "Event could not be processed"
+// @Router       /api/webhooks/payments [post]
+func (api *API) PaymentWebhook(c *gin.Context) {
+	log := logger.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "unable to read the request body"})
+		return
+	}
+
+	if err := api.Payments.VerifyWebhook(c.Request.Header, body, time.Now()); err != nil {
+		log.Warn("Rejected payment webhook", zap.String("ip", c.ClientIP()), zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid signature"})
+		return
+	}
+
+	event, err := api.Payments.ParseEvent(body)
+	if err != nil {
+		log.Warn("Invalid payment webhook payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid event"})
+		return
+	}
+
+	stored, created, err := api.Q.RecordPaymentEvent(ctx, &query.PaymentEvent{
+		ID:       uuid.New(),
+		Provider: api.Payments.Name(),
+		EventID:  event.ID,
+		Type:     event.ProviderType,
+		Payload:  body,
+	})
+	if err != nil {
+		log.Error("Error storing payment event", zap.String("event_id", event.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to store the event"})
+		return
+	}
+	if !created && stored.ProcessedAt != nil {
+		c.JSON(http.StatusOK, gin.H{"error": false, "msg": "event already processed"})
+		return
+	}
+
+	api.handlePaymentEvent(ctx, c, stored, event, false)
+}
+
+// ListPaymentEvents godoc
+// @Summary      List payment webhook events
+// @Description  Retrieve the most recently received payment webhook events, newest first
+// @Tags         Payments
+// @Produce      json
+// @Param        unprocessed query bool false "Only events that have not been processed successfully"
+// @Param        limit query int false "Number of events (1-200, default 50)"
+// @Success      200 {object} map[string]interface{} "Payment events retrieved successfully"
+// @Failure      400 {object} map[string]interface{} "Invalid query"
+// @Failure      401 {object} map[string]interface{} "Unauthorized, token expired"
+// @Failure      403 {object} map[string]interface{} "Permission denied"
+// @Failure      500 {object} map[string]interface{} "Internal server error"
+// @Router       /api/admin/payment-events [get]
+func (api *API) ListPaymentEvents(c *gin.Context) {
+	log := logger.Get()
+
+	var eventQuery payload.PaymentEventQuery
+	if err := c.ShouldBindQuery(&eventQuery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	validate := validator.NewValidator()
+	if err := validate.Struct(eventQuery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"msg":   validator.ValidatorErrors(err),
+		})
+		return
+	}
+
+	limit := eventQuery.Limit
+	if limit == 0 {
+		limit = 50
+	}
+
+	events, err := api.Q.ListPaymentEvents(c, eventQuery.Unprocessed, limit)
+	if err != nil {
+		log.Error("Error retrieving payment events", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"error": false, "events": events})
+}
+
+// ReplayPaymentEvent godoc
+// @Summary      Replay a payment webhook event
+// @Description  Process a stored payment webhook event again, for example after fixing what made it fail. Processing is idempotent, so replaying an event that was processed already changes nothing that is still current.
+// @Tags         Payments
+// @Produce      json
+// @Param        id path string true "Payment event ID"
+// @Success      200 {object} map[string]interface{} "Event processed"
+// @Failure      400 {object} map[string]interface{} "Invalid event id"
+// @Failure      401 {object} map[string]interface{} "Unauthorized, token expired"
+// @Failure      403 {object} map[string]interface{} "Permission denied"
+// @Failure      404 {object} map[string]interface{} "Event not found"
+// @Failure      409 {object} map[string]interface{} "Event is being processed, or belongs to another payment provider"
+// @Failure      500 {object} map[string]interface{} "Event could not be processed"
+// @Router       /api/admin/payment-events/{id}/replay [post]
+func (api *API) ReplayPaymentEvent(c *gin.Context) {
+	log := logger.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": true, "msg": "invalid event id"})
+		return
+	}
+
+	stored, err := api.Q.GetPaymentEvent(ctx, id)
+	if err != nil {
+		log.Error("Error fetching payment event", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": err.Error()})
+		return
+	}
+	if stored == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": true, "msg": "payment event not found"})
+		return
+	}
+	if stored.Provider != api.Payments.Name() {
+		c.JSON(http.StatusConflict, gin.H{"error": true, "msg": "the event belongs to another payment provider"})
+		return
+	}
+
+	event, err := api.Payments.ParseEvent(stored.Payload)
+	if err != nil {
+		log.Error("Error decoding stored payment event", zap.String("id", id.String()), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to decode the event"})
+		return
+	}
+
+	api.audit(c, AuditPaymentEventReplay, "payment_event", id.String(), gin.H{"event_id": stored.EventID, "type": stored.Type})
+	api.handlePaymentEvent(ctx, c, stored, event, true)
+}
+
+// handlePaymentEvent claims a stored event, processes it and records the
+// outcome. force processes an event again that was processed before.
+func (api *API) handlePaymentEvent(ctx context.Context, c *gin.Context, stored *query.PaymentEvent, event *payment.Event, force bool) {
+	log := logger.Get()
+
+	claimed, err := api.Q.ClaimPaymentEvent(ctx, stored.ID, force)
+	if err != nil {
+		log.Error("Error claiming payment event", zap.String("event_id", event.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the event"})
+		return
+	}
+	if !claimed {
+		c.JSON(http.StatusConflict, gin.H{"error": true, "msg": "the event is being processed"})
+		return
+	}
+
+	procErr := api.processPaymentEvent(ctx, event)
+	if err := api.Q.FinishPaymentEvent(ctx, stored.ID, procErr); err != nil {
+		log.Error("Error saving payment event outcome", zap.String("event_id", event.ID), zap.Error(err))
+	}
+	if procErr != nil {
+		log.Error("Error processing payment event", zap.String("event_id", event.ID), zap.String("type", string(event.Type)), zap.Error(procErr))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": true, "msg": "unable to process the event"})
+		return
+	}
+
+	log.Info("Payment event processed", zap.String("event_id", event.ID), zap.String("type", string(event.Type)))
+	c.JSON(http.StatusOK, gin.H{"error": false, "msg": "event processed"})
+}
+
+// processPaymentEvent applies an event to the payment of its intent and to
+// the order of the payment. Each change only applies to a payment in the
+// status it expects, so an event that is processed twice, or that reports
+// what PayOrder already did, changes nothing. Errors are returned only where
+// processing the event again may succeed.
+func (api *API) processPaymentEvent(ctx context.Context, event *payment.Event) error {
+	log := logger.Get()
+
+	if event.Type == payment.EventOther || event.IntentID == "" {
+		return nil
+	}
+
+	p, err := api.Q.GetPaymentByProviderRef(ctx, api.Payments.Name(), event.IntentID)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		// Events can arrive before PayOrder saved the intent, for intents
+		// PayOrder handles itself.
+		log.Warn("No payment for payment event", zap.String("event_id", event.ID), zap.String("intent_id", event.IntentID))
+		return nil
+	}
+
+	switch event.Type {
+	case payment.EventPaymentAuthorized:
+		// Only payments waiting for the customer are captured here; PayOrder
+		// captures the others itself.
+		ok, err := api.Q.SetPaymentStatusIf(ctx, p, query.PaymentRequiresAction, query.PaymentAuthorized)
+		if err != nil || !ok {
+			return err
+		}
+		err = api.capturePayment(ctx, p, nil)
+		if errors.Is(err, errPaymentProvider) {
+			return err
+		}
+		return nil
+
+	case payment.EventPaymentSucceeded:
+		if p.Status == query.PaymentCaptured || p.Status == query.PaymentRefunded || p.Status == query.PaymentDisputed {
+			return nil
+		}
+		// The provider captured a payment whose outcome was not recorded, for
+		// example because the capture request timed out.
+		err := api.Q.CompletePayment(ctx, p, nil)
+		var transitionErr *query.InvalidTransitionError
+		if errors.As(err, &transitionErr) {
+			api.refundPayment(ctx, p, event.Amount)
+			return nil
+		}
+		return err
+
+	case payment.EventPaymentFailed:
+		ok, err := api.Q.SetPaymentStatusIf(ctx, p, query.PaymentRequiresAction, query.PaymentFailed)
+		if err != nil || !ok {
+			return err
+		}
+		p.FailureReason = &event.Reason
+		return api.Q.UpdatePayment(ctx, p)
+
+	case payment.EventPaymentDisputed:
+		if p.Status != query.PaymentCaptured {
+			return nil
+		}
+		return api.Q.DisputePayment(ctx, p, event.Reason)
+
+	case payment.EventDisputeWon, payment.EventDisputeLost:
+		if p.Status != query.PaymentDisputed {
+			return nil
+		}
+		return api.Q.CloseDispute(ctx, p, event.Type == payment.EventDisputeWon)
+	}
+	return nil
+}
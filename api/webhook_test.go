@@ -0,0 +1,162 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/api/payload"
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/pkg/payment"
+	"github.com/amosehiguese/ecommerce-api/query"
+	"github.com/amosehiguese/ecommerce-api/routes"
+	"github.com/amosehiguese/ecommerce-api/server"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaymentWebhook(t *testing.T) {
+	// Set up the application
+	ta, err := server.SpawnApp()
+	if err != nil {
+		t.Fatalf("failed to spawn app: %v", err)
+	}
+	gateway := payment.NewFakeProvider()
+	router := routes.SetUp(ta.DB, config.Get(), routes.WithPaymentProvider(gateway))
+
+	// Ensure cleanup after the test
+	defer func() {
+		err := server.DropTestDatabase(ta.DB, ta.DB_Name)
+		if err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+	}()
+
+	productID := uuid.New()
+	_, err = ta.DB.Exec(`
+		INSERT INTO "product" (id, name, description, price, units_in_stock)
+		VALUES ($1, 'Kettle', 'An electric kettle', 25.99, 10)
+	`, productID)
+	require.NoError(t, err)
+	_, err = ta.DB.Exec(`
+		INSERT INTO "product_variant" (product_id, sku, units_in_stock, is_default)
+		VALUES ($1, 'KETTLE', 10, TRUE)
+	`, productID)
+	require.NoError(t, err)
+
+	login := payload.LoginPayload{Email: "hooked@example.com", Password: "password123"}
+	w := postJSON(router, "/api/auth/register", payload.RegisterPayload{FirstName: "Hooked", Email: login.Email, Password: login.Password})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var res struct {
+		Tokens struct {
+			Access string `json:"access"`
+		} `json:"tokens"`
+		OrderID string        `json:"order_id"`
+		Status  string        `json:"status"`
+		Payment query.Payment `json:"payment"`
+		Msg     string        `json:"msg"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	}
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, createJSONRequestBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+res.Tokens.Access)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	deliver := func(header http.Header, body []byte) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/webhooks/payments", bytes.NewReader(body))
+		req.Header = header
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	orderStatus := func() string {
+		w := send("GET", "/api/orders/"+res.OrderID+"/history", nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var history struct {
+			Status string `json:"status"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+		return history.Status
+	}
+
+	w = postJSON(router, "/api/auth/login", login)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+
+	w = send("POST", "/api/me/addresses", payload.AddressPayload{
+		FullName:   "Hooked",
+		Line1:      "1 Market Street",
+		City:       "San Francisco",
+		PostalCode: "94105",
+		Country:    "US",
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = send("POST", "/api/orders", payload.OrderPayload{Items: []payload.OrderItemPayload{{ProductID: productID, Quantity: 1}}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+
+	w = send("POST", "/api/orders/"+res.OrderID+"/pay", payload.PayOrderPayload{PaymentMethod: payment.FakeCardRequiresAction})
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	decode(w)
+	intentID := *res.Payment.ProviderRef
+
+	// The customer authenticates the payment and the provider reports it
+	_, err = gateway.Authenticate(intentID)
+	require.NoError(t, err)
+	header, body := gateway.Webhook(payment.EventPaymentAuthorized, intentID, "", time.Now())
+
+	forged := header.Clone()
+	forged.Set("Fake-Signature", payment.SignWebhook("whsec_other", body, time.Now()))
+	w = deliver(forged, body)
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	assert.Equal(t, "pending", orderStatus())
+
+	w = deliver(header, body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "paid", orderStatus())
+	intent, _ := gateway.Intent(intentID)
+	assert.Equal(t, payment.StatusCaptured, intent.Status)
+
+	// Redelivered events are not processed again
+	w = deliver(header, body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(w)
+	assert.Equal(t, "event already processed", res.Msg)
+
+	// The provider also reports the capture the webhook made
+	header, body = gateway.Webhook(payment.EventPaymentSucceeded, intentID, "", time.Now())
+	w = deliver(header, body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "paid", orderStatus())
+	assert.Zero(t, gateway.Refunded(intentID))
+
+	header, body = gateway.Webhook(payment.EventPaymentDisputed, intentID, "fraudulent", time.Now())
+	w = deliver(header, body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "disputed", orderStatus())
+
+	header, body = gateway.Webhook(payment.EventDisputeWon, intentID, "", time.Now())
+	w = deliver(header, body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "paid", orderStatus(), "a won dispute restores the order")
+
+	var status string
+	require.NoError(t, ta.DB.QueryRow(`SELECT status FROM "payment" WHERE provider_ref = $1`, intentID).Scan(&status))
+	assert.Equal(t, "captured", status)
+
+	var events, processed int
+	require.NoError(t, ta.DB.QueryRow(`SELECT COUNT(*), COUNT(processed_at) FROM "payment_event"`).Scan(&events, &processed))
+	assert.Equal(t, 4, events, "the forged delivery is not stored")
+	assert.Equal(t, 4, processed)
+}
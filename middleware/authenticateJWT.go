@@ -1,18 +1,27 @@
 package middleware
 
 import (
-	"fmt"
+	"context"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
-var jwtSecret = os.Getenv("JWT_SECRET_KEY")
+// RevocationChecker tells whether an access token was revoked before its
+// expiry, for example by a logout.
+type RevocationChecker interface {
+	IsAccessTokenRevoked(ctx context.Context, tokenID string, userID uuid.UUID, issuedAt time.Time) (bool, error)
+}
 
-func JWTProtected() gin.HandlerFunc {
+// JWTProtected verifies the access token of a request against keys and stores
+// its principal in the context for Require and the handlers.
+func JWTProtected(keys *auth.Keyring, revocations RevocationChecker) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -28,18 +37,27 @@ func JWTProtected() gin.HandlerFunc {
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return jwtSecret, nil
-		})
-		if err != nil || !token.Valid {
+		principal, err := auth.ParseAccessToken(keys, tokenString)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
 
+		revoked, err := revocations.IsAccessTokenRevoked(c, principal.TokenID, principal.UserID, principal.IssuedAt)
+		if err != nil {
+			logger.Get().Error("Error checking token revocation", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to verify token"})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			c.Abort()
+			return
+		}
+
+		auth.SetPrincipal(c, principal)
 		c.Next()
 	}
 }
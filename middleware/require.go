@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Require lets a request through only if its principal holds every one of
+// permissions. It must run after JWTProtected.
+func Require(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := auth.GetPrincipal(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			c.Abort()
+			return
+		}
+
+		for _, permission := range permissions {
+			if !principal.Can(permission) {
+				logger.Get().Warn("Permission denied",
+					zap.String("user_id", principal.UserID.String()),
+					zap.String("role", principal.Role),
+					zap.String("permission", permission),
+					zap.String("path", c.FullPath()),
+				)
+				c.JSON(http.StatusForbidden, gin.H{"error": "Access denied, " + permission + " permission required"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
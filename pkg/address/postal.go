@@ -0,0 +1,90 @@
+// Package address validates the parts of postal addresses that depend on the
+// country.
+package address
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrPostalCodeRequired = errors.New("postal code is required for this country")
+	ErrInvalidPostalCode  = errors.New("postal code does not match the format of this country")
+)
+
+// postalCodeFormats holds the postal code format of countries, by ISO 3166-1
+// alpha-2 code. Codes are matched after NormalizePostalCode.
+var postalCodeFormats = map[string]*regexp.Regexp{
+	"AT": regexp.MustCompile(`^\d{4}$`),
+	"AU": regexp.MustCompile(`^\d{4}$`),
+	"BE": regexp.MustCompile(`^\d{4}$`),
+	"BR": regexp.MustCompile(`^\d{5}-?\d{3}$`),
+	"CA": regexp.MustCompile(`^[ABCEGHJ-NPRSTVXY]\d[ABCEGHJ-NPRSTV-Z] ?\d[ABCEGHJ-NPRSTV-Z]\d$`),
+	"CH": regexp.MustCompile(`^\d{4}$`),
+	"CN": regexp.MustCompile(`^\d{6}$`),
+	"DE": regexp.MustCompile(`^\d{5}$`),
+	"DK": regexp.MustCompile(`^\d{4}$`),
+	"ES": regexp.MustCompile(`^\d{5}$`),
+	"FI": regexp.MustCompile(`^\d{5}$`),
+	"FR": regexp.MustCompile(`^\d{5}$`),
+	"GB": regexp.MustCompile(`^([A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}|GIR ?0AA)$`),
+	"IE": regexp.MustCompile(`^([AC-FHKNPRTV-Y]\d{2}|D6W) ?[0-9AC-FHKNPRTV-Y]{4}$`),
+	"IN": regexp.MustCompile(`^\d{6}$`),
+	"IT": regexp.MustCompile(`^\d{5}$`),
+	"JP": regexp.MustCompile(`^\d{3}-?\d{4}$`),
+	"KE": regexp.MustCompile(`^\d{5}$`),
+	"MX": regexp.MustCompile(`^\d{5}$`),
+	"NG": regexp.MustCompile(`^\d{6}$`),
+	"NL": regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`),
+	"NO": regexp.MustCompile(`^\d{4}$`),
+	"NZ": regexp.MustCompile(`^\d{4}$`),
+	"PL": regexp.MustCompile(`^\d{2}-\d{3}$`),
+	"PT": regexp.MustCompile(`^\d{4}-\d{3}$`),
+	"SE": regexp.MustCompile(`^\d{3} ?\d{2}$`),
+	"SG": regexp.MustCompile(`^\d{6}$`),
+	"US": regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+	"ZA": regexp.MustCompile(`^\d{4}$`),
+}
+
+// postalCodeOptional lists countries where addresses often or always go
+// without a postal code.
+var postalCodeOptional = map[string]bool{
+	"AE": true,
+	"GH": true,
+	"HK": true,
+	"IE": true,
+	"NG": true,
+	"QA": true,
+}
+
+// genericPostalCode is what a postal code of a country without a known format
+// must look like.
+var genericPostalCode = regexp.MustCompile(`^[A-Z0-9][A-Z0-9 -]{1,9}$`)
+
+// NormalizePostalCode uppercases a postal code and collapses its whitespace.
+func NormalizePostalCode(code string) string {
+	return strings.Join(strings.Fields(strings.ToUpper(code)), " ")
+}
+
+// ValidatePostalCode checks a normalized postal code against the format of
+// country, an ISO 3166-1 alpha-2 code.
+func ValidatePostalCode(country, code string) error {
+	country = strings.ToUpper(country)
+	if code == "" {
+		if postalCodeOptional[country] {
+			return nil
+		}
+		return fmt.Errorf("%w (%s)", ErrPostalCodeRequired, country)
+	}
+
+	format, ok := postalCodeFormats[country]
+	if !ok {
+		format = genericPostalCode
+	}
+	if !format.MatchString(code) {
+		return fmt.Errorf("%w (%s)", ErrInvalidPostalCode, country)
+	}
+	return nil
+}
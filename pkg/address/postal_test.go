@@ -0,0 +1,48 @@
+package address
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePostalCode(t *testing.T) {
+	valid := []struct{ country, code string }{
+		{"US", "94103"},
+		{"US", "94103-1234"},
+		{"GB", "SW1A 1AA"},
+		{"GB", "M11AE"},
+		{"CA", "K1A 0B1"},
+		{"NL", "1012 AB"},
+		{"DE", "10115"},
+		{"BR", "01310-100"},
+		{"NG", "100001"},
+		{"NG", ""},
+		{"AE", ""},
+		{"IS", "101"},
+	}
+	for _, tc := range valid {
+		assert.NoError(t, ValidatePostalCode(tc.country, NormalizePostalCode(tc.code)), "%s %q", tc.country, tc.code)
+	}
+
+	invalid := []struct {
+		country, code string
+		err           error
+	}{
+		{"US", "9410", ErrInvalidPostalCode},
+		{"US", "", ErrPostalCodeRequired},
+		{"GB", "12345", ErrInvalidPostalCode},
+		{"CA", "D1A 0B1", ErrInvalidPostalCode},
+		{"DE", "1011", ErrInvalidPostalCode},
+		{"NG", "10001", ErrInvalidPostalCode},
+		{"IS", "!", ErrInvalidPostalCode},
+	}
+	for _, tc := range invalid {
+		assert.ErrorIs(t, ValidatePostalCode(tc.country, NormalizePostalCode(tc.code)), tc.err, "%s %q", tc.country, tc.code)
+	}
+}
+
+func TestNormalizePostalCode(t *testing.T) {
+	assert.Equal(t, "SW1A 1AA", NormalizePostalCode("  sw1a   1aa "))
+	assert.Equal(t, "", NormalizePostalCode("   "))
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o600))
+}
+
+func parse(k *Keyring, token string) error {
+	_, err := jwt.Parse(token, k.Keyfunc, jwt.WithValidMethods(k.Methods()))
+	return err
+}
+
+func TestKeyringRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	writePEM(t, dir, "2025-01.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	writePEM(t, dir, "2025-02.pem", "PRIVATE KEY", der)
+
+	before, err := LoadKeyring(dir, "2025-01")
+	require.NoError(t, err)
+	oldToken, err := before.Sign(jwt.MapClaims{"sub": "user"})
+	require.NoError(t, err)
+
+	// Rotate: the Ed25519 key becomes active and the RSA key is retired to
+	// its public half.
+	pub, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	writePEM(t, dir, "2025-01.pem", "PUBLIC KEY", pub)
+
+	after, err := LoadKeyring(dir, "2025-02")
+	require.NoError(t, err)
+	newToken, err := after.Sign(jwt.MapClaims{"sub": "user"})
+	require.NoError(t, err)
+
+	assert.NoError(t, parse(after, oldToken), "tokens of a retired key stay valid")
+	assert.NoError(t, parse(after, newToken))
+	assert.NoError(t, parse(before, newToken), "the next key was published before it became active")
+
+	other, err := GenerateKeyring()
+	require.NoError(t, err)
+	foreignToken, err := other.Sign(jwt.MapClaims{"sub": "user"})
+	require.NoError(t, err)
+	assert.Error(t, parse(after, foreignToken), "unknown kid")
+
+	_, err = LoadKeyring(dir, "2025-01")
+	assert.Error(t, err, "a retired key cannot be active")
+
+	jwks := after.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, JWK{KeyType: "RSA", KeyID: "2025-01", Use: "sig", Algorithm: "RS256", N: jwks.Keys[0].N, E: "AQAB"}, jwks.Keys[0])
+	assert.Equal(t, "OKP", jwks.Keys[1].KeyType)
+	assert.Equal(t, "Ed25519", jwks.Keys[1].Curve)
+	assert.Equal(t, "EdDSA", jwks.Keys[1].Algorithm)
+}
+
+func TestKeyringRejectsMismatchedAlgorithm(t *testing.T) {
+	k, err := GenerateKeyring()
+	require.NoError(t, err)
+
+	// A token naming an Ed25519 key but claiming HS256 must not verify.
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "user"})
+	forged.Header["kid"] = k.ActiveKeyID()
+	token, err := forged.SignedString([]byte("secret"))
+	require.NoError(t, err)
+
+	assert.Error(t, parse(k, token))
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// MFAChallengeTTL is how long a user has to enter a second factor after their
+// password was accepted.
+const MFAChallengeTTL = 5 * time.Minute
+
+// MFAChallenge is the state carried by an mfa_required token between the two
+// steps of a login.
+type MFAChallenge struct {
+	UserID   uuid.UUID
+	DeviceID string
+	// Enroll is set when the user has to set up a second factor before they
+	// may log in.
+	Enroll bool
+	// ID and ExpiresAt are set by ParseMFAChallenge. A challenge is used up
+	// under its ID once it has been answered.
+	ID        string
+	ExpiresAt time.Time
+}
+
+// mfaAudience keeps challenge tokens from being accepted as access tokens.
+func mfaAudience() string {
+	return config.Get().JWT.Audience + "/mfa"
+}
+
+// GenerateMFAChallenge signs a short-lived token proving that the password of
+// challenge.UserID was checked.
+func GenerateMFAChallenge(keys *Keyring, challenge MFAChallenge) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":    config.Get().JWT.Issuer,
+		"aud":    mfaAudience(),
+		"sub":    challenge.UserID.String(),
+		"jti":    uuid.NewString(),
+		"iat":    now.Unix(),
+		"exp":    now.Add(MFAChallengeTTL).Unix(),
+		"device": challenge.DeviceID,
+		"enroll": challenge.Enroll,
+	}
+	return keys.Sign(claims)
+}
+
+// ParseMFAChallenge verifies a token made by GenerateMFAChallenge.
+func ParseMFAChallenge(keys *Keyring, tokenString string) (*MFAChallenge, error) {
+	token, err := jwt.Parse(tokenString, keys.Keyfunc,
+		jwt.WithValidMethods(keys.Methods()),
+		jwt.WithIssuer(config.Get().JWT.Issuer),
+		jwt.WithAudience(mfaAudience()),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	sub, _ := claims["sub"].(string)
+	userID, err := uuid.Parse(sub)
+	if err != nil {
+		return nil, err
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil, errors.New("token has no id")
+	}
+	exp, err := claims.GetExpirationTime()
+	if err != nil {
+		return nil, err
+	}
+	deviceID, _ := claims["device"].(string)
+	enroll, _ := claims["enroll"].(bool)
+
+	return &MFAChallenge{UserID: userID, DeviceID: deviceID, Enroll: enroll, ID: jti, ExpiresAt: exp.Time}, nil
+}
@@ -4,5 +4,6 @@ const (
 	OrderCreateCredential string = "order:create"
 	OrderReadCredential   string = "order:read"
 	OrderUpdateCredential string = "order:update"
-	OrderCancelCredential string = "order:update"
+	OrderCancelCredential string = "order:cancel"
+	OrderRefundCredential string = "order:refund"
 )
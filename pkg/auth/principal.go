@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const principalKey = "auth.principal"
+
+// Principal is the authenticated caller of a request, as described by its
+// access token.
+type Principal struct {
+	UserID      uuid.UUID
+	Role        string
+	Permissions map[string]bool
+	SessionID   uuid.UUID
+	TokenID     string
+	IssuedAt    time.Time
+	ExpiresAt   time.Time
+}
+
+// Can reports whether the principal holds a permission.
+func (p *Principal) Can(permission string) bool {
+	return p.Permissions[permission]
+}
+
+// SetPrincipal stores the authenticated caller in the request context.
+func SetPrincipal(c *gin.Context, p *Principal) {
+	c.Set(principalKey, p)
+}
+
+// GetPrincipal returns the authenticated caller of the request, if any.
+func GetPrincipal(c *gin.Context) (*Principal, bool) {
+	value, ok := c.Get(principalKey)
+	if !ok {
+		return nil, false
+	}
+	p, ok := value.(*Principal)
+	return p, ok
+}
+
+// CurrentPrincipal returns the authenticated caller of the request. It panics
+// when the route is not behind middleware.JWTProtected.
+func CurrentPrincipal(c *gin.Context) *Principal {
+	p, ok := GetPrincipal(c)
+	if !ok {
+		panic("auth: no principal in context, route is missing authentication middleware")
+	}
+	return p
+}
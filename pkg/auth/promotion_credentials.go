@@ -0,0 +1,5 @@
+package auth
+
+const (
+	PromotionManageCredential string = "promotion:manage"
+)
@@ -0,0 +1,5 @@
+package auth
+
+const (
+	RoleManageCredential string = "role:manage"
+)
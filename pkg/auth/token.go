@@ -1,17 +1,14 @@
 package auth
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"fmt"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/amosehiguese/ecommerce-api/pkg/config"
 	"github.com/amosehiguese/ecommerce-api/pkg/logger"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type Token struct {
@@ -19,93 +16,39 @@ type Token struct {
 	Refresh string
 }
 
-func GenerateNewToken(id string, role Role) (*Token, error) {
-	accessToken, err := GenerateAccessToken(id, role)
-	if err != nil {
-		return nil, err
-	}
-
-	refreshToken, err := GenerateRefreshToken()
-	if err != nil {
-		return nil, err
-	}
-
-	return &Token{
-		Access:  accessToken,
-		Refresh: refreshToken,
-	}, nil
-}
-
-func GenerateAccessToken(id string, role Role) (string, error) {
+// GenerateAccessToken signs an access token with the active key of keys for a
+// user holding role, which grants permissions. sessionID is the refresh token
+// family the access token belongs to, so that logging out of that session can
+// revoke both.
+func GenerateAccessToken(keys *Keyring, id string, role string, permissions []string, sessionID uuid.UUID) (string, error) {
 	config := config.Get().JWT
-	credentials, err := GetRoleCredentials(role)
-	if err != nil {
-		return "", err
-	}
 
 	minCount, err := strconv.Atoi(config.JwtSecretKeyExp)
 	if err != nil {
 		return "", err
 	}
 
+	now := time.Now()
 	claims := make(jwt.MapClaims)
-	claims["id"] = id
-	claims["exp"] = time.Now().Add(time.Minute * time.Duration(minCount)).Unix()
-	claims["role"] = role.String()
-
-	claims["product:create"] = false
-	claims["product:read"] = false
-	claims["product:update"] = false
-	claims["product:delete"] = false
-
-	claims["order:create"] = false
-	claims["order:read"] = false
-	claims["order:update"] = false
-
-	for _, credential := range credentials {
-		claims[credential] = true
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	t, err := token.SignedString([]byte(config.JwtSecretKey))
-	if err != nil {
-		return "", err
-	}
-
-	return t, nil
+	claims["iss"] = config.Issuer
+	claims["aud"] = config.Audience
+	claims["sub"] = id
+	claims["jti"] = uuid.NewString()
+	claims["sid"] = sessionID.String()
+	// iat keeps millisecond precision so a logout-all only catches tokens
+	// issued before it, not a login made right after.
+	claims["iat"] = float64(now.UnixMilli()) / 1000
+	claims["exp"] = now.Add(time.Minute * time.Duration(minCount)).Unix()
+	claims["role"] = role
+	claims["perms"] = permissions
+
+	return keys.Sign(claims)
 }
 
-func GenerateRefreshToken() (string, error) {
-	config := config.Get().JWT
-	hash := sha256.New()
-	refresh := config.JwtRefreshKey + time.Now().String()
-
-	_, err := hash.Write([]byte(refresh))
-	if err != nil {
-		return "", err
-	}
-
-	hoursCount, err := strconv.Atoi(config.JwtRefreshKeyExp)
-	if err != nil {
-		return "", err
-	}
-
-	expTime := fmt.Sprint(time.Now().Add(time.Hour * time.Duration(hoursCount)).Unix())
-	t := hex.EncodeToString(hash.Sum(nil)) + "." + expTime
-
-	return t, nil
-}
-
-func ParseRefreshToken(refreshToken string) (int64, error) {
-	return strconv.ParseInt(strings.Split(refreshToken, ".")[1], 0, 64)
-}
-
-func AttachToCookie(c *gin.Context, refreshToken string) {
+func AttachToCookie(c *gin.Context, refreshToken string, refreshExpiresAt time.Time) {
 	cfg := config.Get()
 	log := logger.Get()
 
-	refreshExpiresAt := time.Now().Add(30 * 24 * time.Hour)
-
 	c.SetCookie(
 		"refresh",
 		refreshToken,
@@ -120,6 +63,7 @@ func AttachToCookie(c *gin.Context, refreshToken string) {
 }
 
 func InvalidateTokenCookies(c *gin.Context) {
+	cfg := config.Get()
 	log := logger.Get()
 
 	c.SetCookie(
@@ -127,8 +71,8 @@ func InvalidateTokenCookies(c *gin.Context) {
 		"",
 		-1,
 		"/",
-		"",
-		true,
+		cfg.Domain,
+		cfg.Env == "prod",
 		true,
 	)
 
@@ -2,78 +2,69 @@ package auth
 
 import (
 	"errors"
-	"strings"
+	"fmt"
+	"math"
+	"time"
 
 	"github.com/amosehiguese/ecommerce-api/pkg/config"
-	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
-type TokenMetadata struct {
-	UserID      uuid.UUID
-	Credentials map[string]bool
-	Role        string
-	Exp         int64
-}
+// ParseAccessToken verifies the signature, issuer, audience and expiry of an
+// access token against keys and returns the principal it describes.
+func ParseAccessToken(keys *Keyring, tokenString string) (*Principal, error) {
+	config := config.Get().JWT
 
-func ExtractTokenMetadata(c *gin.Context) (*TokenMetadata, error) {
-	token, err := verifyToken(c)
+	token, err := jwt.Parse(tokenString, keys.Keyfunc,
+		jwt.WithValidMethods(keys.Methods()),
+		jwt.WithIssuer(config.Issuer),
+		jwt.WithAudience(config.Audience),
+		jwt.WithExpirationRequired(),
+	)
 	if err != nil {
 		return nil, err
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
-	if ok && token.Valid {
-		userID, err := uuid.Parse(claims["id"].(string))
-		if err != nil {
-			return nil, err
-		}
-
-		exp := int64(claims["exp"].(float64))
-		role := claims["role"].(string)
-
-		credentials := map[string]bool{
-			// Product Permissions
-			"product:create": claims["product:create"].(bool),
-			"product:read":   claims["product:read"].(bool),
-			"product:update": claims["product:update"].(bool),
-			"product:delete": claims["product:delete"].(bool),
-
-			// Order Permissions
-			"order:create": claims["order:create"].(bool),
-			"order:read":   claims["order:read"].(bool),
-			"order:update": claims["order:update"].(bool),
-		}
-
-		return &TokenMetadata{
-			UserID:      userID,
-			Credentials: credentials,
-			Role:        role,
-			Exp:         exp,
-		}, nil
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
 	}
 
-	return nil, errors.New("invalid token")
-}
-
-func verifyToken(c *gin.Context) (*jwt.Token, error) {
-	config := config.Get().JWT
-	tokenString := extractToken(c)
+	sub, _ := claims["sub"].(string)
+	userID, err := uuid.Parse(sub)
+	if err != nil {
+		return nil, err
+	}
 
-	token, _ := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		return []byte(config.JwtSecretKey), nil
-	})
+	exp, _ := claims["exp"].(float64)
+	role, _ := claims["role"].(string)
 
-	return token, nil
-}
+	tokenID, _ := claims["jti"].(string)
+	if tokenID == "" {
+		return nil, errors.New("token has no jti claim")
+	}
+	sessionID, _ := uuid.Parse(fmt.Sprint(claims["sid"]))
+	var issuedAt time.Time
+	if iat, ok := claims["iat"].(float64); ok {
+		issuedAt = time.UnixMilli(int64(math.Round(iat * 1000)))
+	}
 
-func extractToken(c *gin.Context) string {
-	bearer := c.GetHeader("Authorization")
-	token := strings.Split(bearer, " ")
-	if len(token) == 2 {
-		return token[1]
+	permissions := make(map[string]bool)
+	perms, _ := claims["perms"].([]interface{})
+	for _, perm := range perms {
+		if name, ok := perm.(string); ok {
+			permissions[name] = true
+		}
 	}
 
-	return ""
+	return &Principal{
+		UserID:      userID,
+		Role:        role,
+		Permissions: permissions,
+		SessionID:   sessionID,
+		TokenID:     tokenID,
+		IssuedAt:    issuedAt,
+		ExpiresAt:   time.Unix(int64(exp), 0),
+	}, nil
 }
@@ -0,0 +1,6 @@
+package auth
+
+const (
+	UserManageCredential string = "user:manage"
+	AuditReadCredential  string = "audit:read"
+)
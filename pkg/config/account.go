@@ -0,0 +1,37 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/amosehiguese/ecommerce-api/pkg/utils"
+)
+
+// minAdminSetupTokenLength keeps the setup token out of reach of guessing.
+const minAdminSetupTokenLength = 32
+
+type accountConfig struct {
+	// RequireVerifiedEmailForOrders stops accounts that have not verified
+	// their email from placing orders.
+	RequireVerifiedEmailForOrders bool
+	// RequireMFAForAdmins makes admins set up two-factor authentication
+	// before they can log in.
+	RequireMFAForAdmins bool
+	// MFAIssuer is the account name authenticator apps show.
+	MFAIssuer string
+	// AdminSetupToken lets POST /api/auth/setup-admin create the first admin
+	// account. The endpoint is disabled when it is empty, and refuses once an
+	// admin exists.
+	AdminSetupToken string
+}
+
+func setAccountConfig() *accountConfig {
+	var s accountConfig
+	s.RequireVerifiedEmailForOrders = utils.GetEnvAsBool("REQUIRE_VERIFIED_EMAIL_FOR_ORDERS", false)
+	s.RequireMFAForAdmins = utils.GetEnvAsBool("REQUIRE_MFA_FOR_ADMINS", false)
+	s.MFAIssuer = utils.GetEnv("MFA_ISSUER", "Ecommerce API")
+	s.AdminSetupToken = utils.GetEnv("ADMIN_SETUP_TOKEN", "")
+	if s.AdminSetupToken != "" && len(s.AdminSetupToken) < minAdminSetupTokenLength {
+		panic(fmt.Sprintf("ADMIN_SETUP_TOKEN must be at least %d characters long", minAdminSetupTokenLength))
+	}
+	return &s
+}
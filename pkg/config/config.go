@@ -10,6 +10,13 @@ type Config struct {
 	Server   *serverConfig
 	Database *databaseConfig
 	JWT      *jwtConfig
+	Session  *sessionConfig
+	Mail     *mailConfig
+	Account  *accountConfig
+	Lockout  *lockoutConfig
+	Password *passwordConfig
+	Payment  *paymentConfig
+	Shipping *shippingConfig
 }
 
 var c Config
@@ -18,6 +25,13 @@ func initConfig() *Config {
 	c.Server = setServerConfig()
 	c.Database = setDatabaseConfig()
 	c.JWT = setJwtConfig()
+	c.Session = setSessionConfig()
+	c.Mail = setMailConfig()
+	c.Account = setAccountConfig()
+	c.Lockout = setLockoutConfig()
+	c.Password = setPasswordConfig()
+	c.Payment = setPaymentConfig()
+	c.Shipping = setShippingConfig()
 	utils.MustMapEnv(&c.Env, "ECOMM_ENV")
 	utils.MustMapEnv(&c.Domain, "DOMAIN")
 
@@ -25,7 +39,8 @@ func initConfig() *Config {
 }
 
 func Get() *Config {
-	if c.Server == nil || c.Database == nil || c.JWT == nil {
+	if c.Server == nil || c.Database == nil || c.JWT == nil || c.Session == nil ||
+		c.Mail == nil || c.Account == nil || c.Lockout == nil || c.Password == nil || c.Payment == nil || c.Shipping == nil {
 		c = *initConfig()
 	}
 	return &c
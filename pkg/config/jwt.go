@@ -7,18 +7,26 @@ import (
 )
 
 type jwtConfig struct {
-	JwtSecretKey     string
+	// KeysDir holds the <kid>.pem signing keys. When empty outside
+	// production, a throwaway key is generated at startup.
+	KeysDir          string
+	ActiveKeyID      string
+	Issuer           string
+	Audience         string
 	JwtSecretKeyExp  string
-	JwtRefreshKey    string
 	JwtRefreshKeyExp string
 	CorsOrigins      []string
 }
 
 func setJwtConfig() *jwtConfig {
 	var s jwtConfig
-	utils.MustMapEnv(&s.JwtSecretKey, "JWT_SECRET_KEY")
+	s.KeysDir = utils.GetEnv("JWT_KEYS_DIR", "")
+	if s.KeysDir != "" {
+		utils.MustMapEnv(&s.ActiveKeyID, "JWT_ACTIVE_KEY_ID")
+	}
+	s.Issuer = utils.GetEnv("JWT_ISSUER", "ecommerce-api")
+	s.Audience = utils.GetEnv("JWT_AUDIENCE", "ecommerce-api")
 	utils.MustMapEnv(&s.JwtSecretKeyExp, "JWT_SECRET_KEY_EXPIRE_MINUTES_COUNT")
-	utils.MustMapEnv(&s.JwtRefreshKey, "JWT_REFRESH_KEY")
 	utils.MustMapEnv(&s.JwtRefreshKeyExp, "JWT_REFRESH_KEY_EXPIRE_HOURS_COUNT")
 
 	var coreStr string
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/pkg/utils"
+)
+
+type lockoutConfig struct {
+	// Store is the failed login counter backend: memory or redis.
+	Store    string
+	RedisURL string
+	// AccountMaxFailures and ClientMaxFailures are the failed logins allowed
+	// per account and per client address before they are locked out.
+	AccountMaxFailures int
+	ClientMaxFailures  int
+	// Lockout is the first lock, doubled by every further failure up to
+	// MaxLockout.
+	Lockout    time.Duration
+	MaxLockout time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+}
+
+func setLockoutConfig() *lockoutConfig {
+	var s lockoutConfig
+	s.Store = utils.GetEnv("LOCKOUT_STORE", "memory")
+	switch s.Store {
+	case "memory":
+	case "redis":
+		utils.MustMapEnv(&s.RedisURL, "REDIS_URL")
+	default:
+		panic(fmt.Sprintf("LOCKOUT_STORE must be memory or redis, got %q", s.Store))
+	}
+	s.AccountMaxFailures = utils.GetEnvAsIntOr("LOGIN_MAX_FAILURES", 5)
+	s.ClientMaxFailures = utils.GetEnvAsIntOr("LOGIN_MAX_FAILURES_PER_CLIENT", 20)
+	s.Lockout = utils.GetEnvAsDuration("LOGIN_LOCKOUT", time.Minute)
+	s.MaxLockout = utils.GetEnvAsDuration("LOGIN_MAX_LOCKOUT", time.Hour)
+	s.Window = utils.GetEnvAsDuration("LOGIN_FAILURE_WINDOW", 24*time.Hour)
+	return &s
+}
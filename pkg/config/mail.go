@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/amosehiguese/ecommerce-api/pkg/utils"
+)
+
+type mailConfig struct {
+	// Driver is the mailer backend: smtp or memory.
+	Driver string
+	// AppURL is the storefront base URL that emailed links point to.
+	AppURL string
+	// APIURL is the public base URL of this API, for links handled by the API
+	// itself.
+	APIURL       string
+	From         string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+}
+
+func setMailConfig() *mailConfig {
+	var s mailConfig
+	s.Driver = utils.GetEnv("MAILER", "memory")
+	s.AppURL = strings.TrimRight(utils.GetEnv("APP_URL", "http://localhost:5173"), "/")
+	s.APIURL = strings.TrimRight(utils.GetEnv("API_URL", "http://localhost:8000"), "/")
+	s.From = utils.GetEnv("MAIL_FROM", "no-reply@localhost")
+	switch s.Driver {
+	case "memory":
+	case "smtp":
+		utils.MustMapEnv(&s.SMTPHost, "SMTP_HOST")
+		s.SMTPPort = utils.GetEnv("SMTP_PORT", "587")
+		s.SMTPUsername = utils.GetEnv("SMTP_USERNAME", "")
+		s.SMTPPassword = utils.GetEnv("SMTP_PASSWORD", "")
+	default:
+		panic(fmt.Sprintf("MAILER must be smtp or memory, got %q", s.Driver))
+	}
+	return &s
+}
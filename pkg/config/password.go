@@ -0,0 +1,27 @@
+package config
+
+import "github.com/amosehiguese/ecommerce-api/pkg/utils"
+
+type passwordConfig struct {
+	// Argon2 cost parameters. Raising them upgrades each stored hash on the
+	// next successful login.
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+	MinLength         int
+	MaxLength         int
+	// BreachedListFile optionally names a file of known breached passwords,
+	// one per line, that cannot be chosen.
+	BreachedListFile string
+}
+
+func setPasswordConfig() *passwordConfig {
+	var s passwordConfig
+	s.Argon2Memory = uint32(utils.GetEnvAsIntOr("ARGON2_MEMORY_KIB", int(utils.DefaultArgon2idParams.Memory)))
+	s.Argon2Iterations = uint32(utils.GetEnvAsIntOr("ARGON2_ITERATIONS", int(utils.DefaultArgon2idParams.Iterations)))
+	s.Argon2Parallelism = uint8(utils.GetEnvAsIntOr("ARGON2_PARALLELISM", int(utils.DefaultArgon2idParams.Parallelism)))
+	s.MinLength = utils.GetEnvAsIntOr("PASSWORD_MIN_LENGTH", 8)
+	s.MaxLength = utils.GetEnvAsIntOr("PASSWORD_MAX_LENGTH", 128)
+	s.BreachedListFile = utils.GetEnv("BREACHED_PASSWORDS_FILE", "")
+	return &s
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/amosehiguese/ecommerce-api/pkg/utils"
+)
+
+var currencyCode = regexp.MustCompile(`^[a-z]{3}$`)
+
+type paymentConfig struct {
+	// Provider is the payment gateway: stripe or fake. The fake provider
+	// decides outcomes from test payment methods and never moves money.
+	Provider string
+	// Currency is the lowercase ISO 4217 code orders are charged in. Only
+	// currencies with two decimal places are supported.
+	Currency        string
+	StripeSecretKey string
+	// StripeWebhookSecret verifies the signature of webhooks sent to
+	// POST /api/webhooks/payments.
+	StripeWebhookSecret string
+}
+
+func setPaymentConfig() *paymentConfig {
+	var s paymentConfig
+	s.Provider = utils.GetEnv("PAYMENT_PROVIDER", "fake")
+	s.Currency = strings.ToLower(utils.GetEnv("PAYMENT_CURRENCY", "usd"))
+	if !currencyCode.MatchString(s.Currency) {
+		panic(fmt.Sprintf("PAYMENT_CURRENCY must be an ISO 4217 code, got %q", s.Currency))
+	}
+	switch s.Provider {
+	case "fake":
+	case "stripe":
+		utils.MustMapEnv(&s.StripeSecretKey, "STRIPE_SECRET_KEY")
+		utils.MustMapEnv(&s.StripeWebhookSecret, "STRIPE_WEBHOOK_SECRET")
+	default:
+		panic(fmt.Sprintf("PAYMENT_PROVIDER must be stripe or fake, got %q", s.Provider))
+	}
+	return &s
+}
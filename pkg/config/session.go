@@ -0,0 +1,26 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/amosehiguese/ecommerce-api/pkg/utils"
+)
+
+type sessionConfig struct {
+	// Store is the refresh token backend: postgres, redis or memory.
+	Store    string
+	RedisURL string
+}
+
+func setSessionConfig() *sessionConfig {
+	var s sessionConfig
+	s.Store = utils.GetEnv("SESSION_STORE", "postgres")
+	switch s.Store {
+	case "postgres", "memory":
+	case "redis":
+		utils.MustMapEnv(&s.RedisURL, "REDIS_URL")
+	default:
+		panic(fmt.Sprintf("SESSION_STORE must be postgres, redis or memory, got %q", s.Store))
+	}
+	return &s
+}
@@ -0,0 +1,25 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/amosehiguese/ecommerce-api/pkg/utils"
+	"github.com/shopspring/decimal"
+)
+
+type shippingConfig struct {
+	// FlatRate is charged on every order unless a free shipping promotion
+	// applies to it.
+	FlatRate decimal.Decimal
+}
+
+func setShippingConfig() *shippingConfig {
+	var s shippingConfig
+	raw := utils.GetEnv("SHIPPING_FLAT_RATE", "0")
+	rate, err := decimal.NewFromString(raw)
+	if err != nil || rate.IsNegative() || rate.Exponent() < -2 {
+		panic(fmt.Sprintf("SHIPPING_FLAT_RATE must be an amount such as 4.99, got %q", raw))
+	}
+	s.FlatRate = rate
+	return &s
+}
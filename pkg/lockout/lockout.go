@@ -0,0 +1,135 @@
+// Package lockout slows down password guessing. It counts failed logins per
+// account and per client and locks either out for a time that doubles with
+// every further failure.
+package lockout
+
+import (
+	"context"
+	"time"
+)
+
+// Store keeps failure counters and locks. Counters and locks expire on their
+// own, so a store never needs cleaning up.
+type Store interface {
+	// Incr counts a failure of key and returns the failures so far. The count
+	// is forgotten window after the last failure.
+	Incr(ctx context.Context, key string, window time.Duration) (int, error)
+	// Lock locks key for d.
+	Lock(ctx context.Context, key string, d time.Duration) error
+	// LockedFor returns how long key stays locked, or 0 if it is not.
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+	// Reset forgets the failures and lock of key and returns the failures it
+	// had.
+	Reset(ctx context.Context, key string) (int, error)
+}
+
+// Policy says when and for how long a key is locked.
+type Policy struct {
+	// MaxFailures is the number of failures allowed before the first lock.
+	MaxFailures int
+	// Lockout is the first lock. Every failure after it doubles the lock, up
+	// to MaxLockout.
+	Lockout    time.Duration
+	MaxLockout time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+}
+
+// lockoutAfter returns the lock due after failures, or 0.
+func (p Policy) lockoutAfter(failures int) time.Duration {
+	if failures < p.MaxFailures {
+		return 0
+	}
+	d := p.Lockout
+	for i := p.MaxFailures; i < failures && d < p.MaxLockout; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxLockout)
+}
+
+// Scope is what a counter is kept for.
+type Scope string
+
+const (
+	ScopeAccount Scope = "account"
+	ScopeClient  Scope = "client"
+)
+
+// Lock describes a key that a failure locked.
+type Lock struct {
+	Scope    Scope
+	Key      string
+	Failures int
+	Duration time.Duration
+}
+
+// Guard tracks failed logins by account and by client address.
+type Guard struct {
+	store   Store
+	account Policy
+	client  Policy
+}
+
+// NewGuard returns a guard applying account to the failures of each account
+// and client to those of each client address.
+func NewGuard(store Store, account, client Policy) *Guard {
+	return &Guard{store: store, account: account, client: client}
+}
+
+func key(scope Scope, name string) string {
+	return "login:" + string(scope) + ":" + name
+}
+
+// Check returns how long a login for account from client must wait, or 0 if
+// it may go ahead.
+func (g *Guard) Check(ctx context.Context, account, client string) (time.Duration, error) {
+	accountWait, err := g.store.LockedFor(ctx, key(ScopeAccount, account))
+	if err != nil {
+		return 0, err
+	}
+	clientWait, err := g.store.LockedFor(ctx, key(ScopeClient, client))
+	if err != nil {
+		return 0, err
+	}
+	return max(accountWait, clientWait), nil
+}
+
+// Fail counts a failed login for account from client and returns the locks it
+// caused.
+func (g *Guard) Fail(ctx context.Context, account, client string) ([]Lock, error) {
+	var locks []Lock
+	for _, c := range []struct {
+		scope  Scope
+		name   string
+		policy Policy
+	}{
+		{ScopeAccount, account, g.account},
+		{ScopeClient, client, g.client},
+	} {
+		k := key(c.scope, c.name)
+		failures, err := g.store.Incr(ctx, k, c.policy.Window)
+		if err != nil {
+			return locks, err
+		}
+		d := c.policy.lockoutAfter(failures)
+		if d == 0 {
+			continue
+		}
+		if err := g.store.Lock(ctx, k, d); err != nil {
+			return locks, err
+		}
+		locks = append(locks, Lock{Scope: c.scope, Key: c.name, Failures: failures, Duration: d})
+	}
+	return locks, nil
+}
+
+// Succeed forgets the failures of account after a successful login and
+// reports whether it had been locked. The client counter is kept, so that a
+// client cannot reset it by logging into an account of its own.
+func (g *Guard) Succeed(ctx context.Context, account string) (bool, error) {
+	failures, err := g.store.Reset(ctx, key(ScopeAccount, account))
+	if err != nil {
+		return false, err
+	}
+	return failures >= g.account.MaxFailures, nil
+}
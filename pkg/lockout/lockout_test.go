@@ -0,0 +1,82 @@
+package lockout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	accountPolicy = Policy{MaxFailures: 3, Lockout: time.Minute, MaxLockout: 5 * time.Minute, Window: time.Hour}
+	clientPolicy  = Policy{MaxFailures: 5, Lockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour}
+)
+
+func TestLockoutBacksOffExponentially(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	g := NewGuard(store, accountPolicy, clientPolicy)
+
+	var locks []Lock
+	for i := 0; i < 3; i++ {
+		wait, err := g.Check(ctx, "ada@example.com", "10.0.0.1")
+		require.NoError(t, err)
+		require.Zero(t, wait)
+		locks, err = g.Fail(ctx, "ada@example.com", "10.0.0.1")
+		require.NoError(t, err)
+	}
+	require.Equal(t, []Lock{{Scope: ScopeAccount, Key: "ada@example.com", Failures: 3, Duration: time.Minute}}, locks)
+
+	wait, err := g.Check(ctx, "ada@example.com", "10.0.0.2")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, wait, "the account is locked from every client")
+
+	// Each failure after a lock expires doubles the next lock, up to the cap
+	for _, want := range []time.Duration{2 * time.Minute, 4 * time.Minute, 5 * time.Minute} {
+		now = now.Add(locks[0].Duration)
+		locks, err = g.Fail(ctx, "ada@example.com", "10.0.0.2")
+		require.NoError(t, err)
+		require.Len(t, locks, 1)
+		assert.Equal(t, want, locks[0].Duration)
+	}
+
+	now = now.Add(5 * time.Minute)
+	unlocked, err := g.Succeed(ctx, "ada@example.com")
+	require.NoError(t, err)
+	assert.True(t, unlocked)
+	locks, err = g.Fail(ctx, "ada@example.com", "10.0.0.3")
+	require.NoError(t, err)
+	assert.Empty(t, locks, "a successful login starts the count over")
+}
+
+func TestLockoutPerClient(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	g := NewGuard(store, accountPolicy, clientPolicy)
+
+	// Spraying one password over many accounts locks the client
+	var locks []Lock
+	for _, account := range []string{"a", "b", "c", "d", "e"} {
+		var err error
+		locks, err = g.Fail(ctx, account, "10.0.0.1")
+		require.NoError(t, err)
+	}
+	require.Equal(t, []Lock{{Scope: ScopeClient, Key: "10.0.0.1", Failures: 5, Duration: time.Minute}}, locks)
+
+	wait, err := g.Check(ctx, "f", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, wait)
+
+	// Logging into an account of its own does not clear it
+	_, err = g.Succeed(ctx, "f")
+	require.NoError(t, err)
+	wait, err = g.Check(ctx, "f", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, wait)
+}
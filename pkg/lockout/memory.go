@@ -0,0 +1,89 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type counter struct {
+	failures    int
+	expiresAt   time.Time
+	lockedUntil time.Time
+}
+
+// MemoryStore keeps counters in process memory, which suits a single
+// instance. Counters are lost on restart.
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[string]*counter
+	now      func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: make(map[string]*counter), now: time.Now}
+}
+
+// get returns the live counter of key, dropping it if it expired.
+func (s *MemoryStore) get(key string) *counter {
+	c, ok := s.counters[key]
+	if !ok {
+		return nil
+	}
+	now := s.now()
+	if now.After(c.expiresAt) && now.After(c.lockedUntil) {
+		delete(s.counters, key)
+		return nil
+	}
+	return c
+}
+
+func (s *MemoryStore) Incr(ctx context.Context, key string, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.get(key)
+	if c == nil {
+		c = &counter{}
+		s.counters[key] = c
+	}
+	c.failures++
+	c.expiresAt = s.now().Add(window)
+	return c.failures, nil
+}
+
+func (s *MemoryStore) Lock(ctx context.Context, key string, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.get(key)
+	if c == nil {
+		c = &counter{}
+		s.counters[key] = c
+	}
+	c.lockedUntil = s.now().Add(d)
+	return nil
+}
+
+func (s *MemoryStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.get(key)
+	if c == nil {
+		return 0, nil
+	}
+	return max(c.lockedUntil.Sub(s.now()), 0), nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.get(key)
+	delete(s.counters, key)
+	if c == nil {
+		return 0, nil
+	}
+	return c.failures, nil
+}
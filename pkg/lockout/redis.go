@@ -0,0 +1,67 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore keeps counters in Redis so that every instance of a cluster
+// sees the same failures.
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func failuresKey(key string) string {
+	return "lockout:failures:" + key
+}
+
+func lockedKey(key string) string {
+	return "lockout:locked:" + key
+}
+
+func (s *RedisStore) Incr(ctx context.Context, key string, window time.Duration) (int, error) {
+	var incr *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, failuresKey(key))
+		pipe.Expire(ctx, failuresKey(key), window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(incr.Val()), nil
+}
+
+func (s *RedisStore) Lock(ctx context.Context, key string, d time.Duration) error {
+	return s.client.Set(ctx, lockedKey(key), 1, d).Err()
+}
+
+func (s *RedisStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, lockedKey(key)).Result()
+	if err != nil {
+		return 0, err
+	}
+	// PTTL answers negative durations for missing keys.
+	return max(ttl, 0), nil
+}
+
+func (s *RedisStore) Reset(ctx context.Context, key string) (int, error) {
+	var get *redis.StringCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, failuresKey(key))
+		pipe.Del(ctx, failuresKey(key), lockedKey(key))
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, err
+	}
+	failures, _ := get.Int()
+	return failures, nil
+}
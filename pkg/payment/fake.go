@@ -0,0 +1,325 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// FakeWebhookSecret signs the webhooks of FakeProvider. It is public, like
+// the test payment methods, so that local tools can send events too.
+const FakeWebhookSecret = "whsec_fake"
+
+// Test payment methods understood by FakeProvider. Any other payment method is
+// authorized and captured.
+const (
+	FakeCardOK                = "fake_card_ok"
+	FakeCardDeclined          = "fake_card_declined"
+	FakeCardInsufficientFunds = "fake_card_insufficient_funds"
+	// FakeCardRequiresAction is authorized only once the customer completes
+	// an extra step, simulated by Authenticate.
+	FakeCardRequiresAction = "fake_card_requires_action"
+	// FakeCardCaptureDeclined is authorized, but its capture is declined.
+	FakeCardCaptureDeclined = "fake_card_capture_declined"
+)
+
+// FakeProvider is an in-memory payment gateway with deterministic outcomes
+// picked by the payment method, so that payments can be tested offline. It is
+// meant for tests and local development.
+type FakeProvider struct {
+	mu      sync.Mutex
+	intents map[string]*fakeIntent
+	// keys maps idempotency keys to the intent or refund they created.
+	keys    map[string]string
+	refunds map[string]*Refund
+	seq     int
+	// timeOutRefund makes the next refund lose its response.
+	timeOutRefund bool
+}
+
+// ErrFakeTimeout is returned by a FakeProvider call whose response was lost.
+var ErrFakeTimeout = errors.New("fake provider: request timed out")
+
+type fakeIntent struct {
+	Intent
+	method   string
+	refunded int64
+	declined *DeclineError
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{
+		intents: make(map[string]*fakeIntent),
+		keys:    make(map[string]string),
+		refunds: make(map[string]*Refund),
+	}
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakeProvider) nextID(prefix string) string {
+	p.seq++
+	return fmt.Sprintf("%s_%06d", prefix, p.seq)
+}
+
+func (p *FakeProvider) CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error) {
+	if req.Amount <= 0 {
+		return nil, errors.New("amount must be positive")
+	}
+	if req.PaymentMethod == "" {
+		return nil, errors.New("payment method is required")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if req.IdempotencyKey != "" {
+		if id, ok := p.keys["pi:"+req.IdempotencyKey]; ok {
+			return p.intents[id].result()
+		}
+	}
+
+	intent := &fakeIntent{
+		Intent: Intent{
+			ID:       p.nextID("fake_pi"),
+			Status:   StatusAuthorized,
+			Amount:   req.Amount,
+			Currency: req.Currency,
+		},
+		method: req.PaymentMethod,
+	}
+	switch req.PaymentMethod {
+	case FakeCardDeclined:
+		intent.declined = &DeclineError{Code: "card_declined", Message: "your card was declined"}
+	case FakeCardInsufficientFunds:
+		intent.declined = &DeclineError{Code: "insufficient_funds", Message: "your card has insufficient funds"}
+	case FakeCardRequiresAction:
+		intent.Status = StatusRequiresAction
+		intent.ClientSecret = intent.ID + "_secret"
+	}
+	if intent.declined != nil {
+		intent.Status = StatusFailed
+		intent.FailureReason = intent.declined.Message
+	}
+
+	p.intents[intent.ID] = intent
+	if req.IdempotencyKey != "" {
+		p.keys["pi:"+req.IdempotencyKey] = intent.ID
+	}
+	return intent.result()
+}
+
+func (p *FakeProvider) Capture(ctx context.Context, intentID string) (*Intent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[intentID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if intent.Status != StatusAuthorized {
+		return nil, ErrInvalidState
+	}
+
+	if intent.method == FakeCardCaptureDeclined {
+		intent.declined = &DeclineError{Code: "capture_declined", Message: "the capture was declined"}
+		intent.Status = StatusFailed
+		intent.FailureReason = intent.declined.Message
+		return intent.result()
+	}
+
+	intent.Status = StatusCaptured
+	return intent.result()
+}
+
+func (p *FakeProvider) Void(ctx context.Context, intentID string) (*Intent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[intentID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if intent.Status != StatusAuthorized && intent.Status != StatusRequiresAction {
+		return nil, ErrInvalidState
+	}
+
+	intent.Status = StatusVoided
+	intent.ClientSecret = ""
+	return intent.result()
+}
+
+func (p *FakeProvider) Refund(ctx context.Context, req RefundRequest) (*Refund, error) {
+	if req.Amount <= 0 {
+		return nil, errors.New("refund amount must be positive")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if req.IdempotencyKey != "" {
+		if id, ok := p.keys["re:"+req.IdempotencyKey]; ok {
+			refund := *p.refunds[id]
+			return &refund, nil
+		}
+	}
+
+	intent, ok := p.intents[req.IntentID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if intent.Status != StatusCaptured {
+		return nil, ErrInvalidState
+	}
+	if intent.refunded+req.Amount > intent.Amount {
+		return nil, ErrInvalidRefund
+	}
+
+	intent.refunded += req.Amount
+	refund := &Refund{
+		ID:       p.nextID("fake_re"),
+		IntentID: intent.ID,
+		Amount:   req.Amount,
+		Status:   "succeeded",
+	}
+	p.refunds[refund.ID] = refund
+	if req.IdempotencyKey != "" {
+		p.keys["re:"+req.IdempotencyKey] = refund.ID
+	}
+
+	if p.timeOutRefund {
+		p.timeOutRefund = false
+		return nil, ErrFakeTimeout
+	}
+	result := *refund
+	return &result, nil
+}
+
+// TimeOutNextRefund makes the next refund go through but return
+// ErrFakeTimeout, as if the response had been lost on the way back.
+func (p *FakeProvider) TimeOutNextRefund() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.timeOutRefund = true
+}
+
+// Authenticate completes the action a FakeCardRequiresAction intent waits for,
+// as the customer would, and authorizes it.
+func (p *FakeProvider) Authenticate(intentID string) (*Intent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[intentID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if intent.Status != StatusRequiresAction {
+		return nil, ErrInvalidState
+	}
+
+	intent.Status = StatusAuthorized
+	intent.ClientSecret = ""
+	return intent.result()
+}
+
+// fakeEvent is the payload of a FakeProvider webhook.
+type fakeEvent struct {
+	ID       string    `json:"id"`
+	Type     EventType `json:"type"`
+	IntentID string    `json:"intent_id"`
+	Amount   int64     `json:"amount"`
+	Reason   string    `json:"reason,omitempty"`
+	Created  int64     `json:"created"`
+}
+
+// Webhook returns the signed headers and payload of a webhook reporting an
+// event about an intent, as the provider would send it at now.
+func (p *FakeProvider) Webhook(eventType EventType, intentID, reason string, now time.Time) (http.Header, []byte) {
+	p.mu.Lock()
+	event := fakeEvent{
+		ID:       p.nextID("fake_evt"),
+		Type:     eventType,
+		IntentID: intentID,
+		Reason:   reason,
+		Created:  now.Unix(),
+	}
+	if intent, ok := p.intents[intentID]; ok {
+		event.Amount = intent.Amount
+	}
+	p.mu.Unlock()
+
+	payload, _ := json.Marshal(event)
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Fake-Signature", SignWebhook(FakeWebhookSecret, payload, now))
+	return header, payload
+}
+
+func (p *FakeProvider) VerifyWebhook(header http.Header, payload []byte, now time.Time) error {
+	return VerifyWebhook(FakeWebhookSecret, header.Get("Fake-Signature"), payload, now)
+}
+
+func (p *FakeProvider) ParseEvent(payload []byte) (*Event, error) {
+	var e fakeEvent
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, fmt.Errorf("decoding event: %w", err)
+	}
+	if e.ID == "" {
+		return nil, errors.New("event has no id")
+	}
+
+	event := &Event{
+		ID:           e.ID,
+		Type:         e.Type,
+		ProviderType: string(e.Type),
+		IntentID:     e.IntentID,
+		Amount:       e.Amount,
+		Reason:       e.Reason,
+		Created:      time.Unix(e.Created, 0),
+	}
+	switch e.Type {
+	case EventPaymentAuthorized, EventPaymentSucceeded, EventPaymentFailed, EventPaymentDisputed,
+		EventDisputeWon, EventDisputeLost:
+	default:
+		event.Type = EventOther
+	}
+	return event, nil
+}
+
+// Intent returns a copy of an intent created by the provider.
+func (p *FakeProvider) Intent(intentID string) (Intent, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[intentID]
+	if !ok {
+		return Intent{}, false
+	}
+	return intent.Intent, true
+}
+
+// Refunded returns the total amount refunded on an intent.
+func (p *FakeProvider) Refunded(intentID string) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if intent, ok := p.intents[intentID]; ok {
+		return intent.refunded
+	}
+	return 0
+}
+
+// result returns a copy of the intent, or its decline.
+func (i *fakeIntent) result() (*Intent, error) {
+	if i.declined != nil {
+		return nil, i.declined
+	}
+	intent := i.Intent
+	return &intent, nil
+}
@@ -0,0 +1,94 @@
+package payment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeProviderPayAndRefund(t *testing.T) {
+	ctx := context.Background()
+	p := NewFakeProvider()
+
+	req := IntentRequest{Amount: 2599, Currency: "usd", PaymentMethod: FakeCardOK, IdempotencyKey: "order-1"}
+	intent, err := p.CreateIntent(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, StatusAuthorized, intent.Status)
+	assert.Equal(t, int64(2599), intent.Amount)
+
+	again, err := p.CreateIntent(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, intent.ID, again.ID, "a retry with the same key returns the same intent")
+
+	_, err = p.Refund(ctx, RefundRequest{IntentID: intent.ID, Amount: 100})
+	assert.ErrorIs(t, err, ErrInvalidState, "nothing is captured yet")
+
+	captured, err := p.Capture(ctx, intent.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCaptured, captured.Status)
+
+	_, err = p.Capture(ctx, intent.ID)
+	assert.ErrorIs(t, err, ErrInvalidState)
+	_, err = p.Void(ctx, intent.ID)
+	assert.ErrorIs(t, err, ErrInvalidState, "captured intents are refunded, not voided")
+
+	refund, err := p.Refund(ctx, RefundRequest{IntentID: intent.ID, Amount: 1000, IdempotencyKey: "refund-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "succeeded", refund.Status)
+	_, err = p.Refund(ctx, RefundRequest{IntentID: intent.ID, Amount: 1000, IdempotencyKey: "refund-1"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), p.Refunded(intent.ID), "a retried refund is not paid twice")
+
+	_, err = p.Refund(ctx, RefundRequest{IntentID: intent.ID, Amount: 1600})
+	assert.ErrorIs(t, err, ErrInvalidRefund)
+	_, err = p.Refund(ctx, RefundRequest{IntentID: intent.ID, Amount: 1599})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2599), p.Refunded(intent.ID))
+}
+
+func TestFakeProviderOutcomes(t *testing.T) {
+	ctx := context.Background()
+	p := NewFakeProvider()
+
+	_, err := p.CreateIntent(ctx, IntentRequest{Amount: 500, Currency: "usd", PaymentMethod: FakeCardDeclined})
+	var decline *DeclineError
+	require.ErrorAs(t, err, &decline)
+	assert.ErrorIs(t, err, ErrDeclined)
+	assert.Equal(t, "card_declined", decline.Code)
+
+	_, err = p.CreateIntent(ctx, IntentRequest{Amount: 500, Currency: "usd", PaymentMethod: FakeCardInsufficientFunds})
+	require.ErrorAs(t, err, &decline)
+	assert.Equal(t, "insufficient_funds", decline.Code)
+
+	intent, err := p.CreateIntent(ctx, IntentRequest{Amount: 500, Currency: "usd", PaymentMethod: FakeCardRequiresAction})
+	require.NoError(t, err)
+	assert.Equal(t, StatusRequiresAction, intent.Status)
+	assert.NotEmpty(t, intent.ClientSecret)
+	_, err = p.Capture(ctx, intent.ID)
+	assert.ErrorIs(t, err, ErrInvalidState)
+	voided, err := p.Void(ctx, intent.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusVoided, voided.Status)
+
+	intent, err = p.CreateIntent(ctx, IntentRequest{Amount: 500, Currency: "usd", PaymentMethod: FakeCardCaptureDeclined})
+	require.NoError(t, err)
+	assert.Equal(t, StatusAuthorized, intent.Status)
+	_, err = p.Capture(ctx, intent.ID)
+	assert.ErrorIs(t, err, ErrDeclined)
+	stored, ok := p.Intent(intent.ID)
+	require.True(t, ok)
+	assert.Equal(t, StatusFailed, stored.Status)
+
+	_, err = p.Capture(ctx, "fake_pi_missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMinorUnits(t *testing.T) {
+	assert.Equal(t, int64(1999), MinorUnits(decimal.RequireFromString("19.99")))
+	assert.Equal(t, int64(2000), MinorUnits(decimal.RequireFromString("19.995")))
+	assert.Equal(t, int64(500), MinorUnits(decimal.NewFromInt(5)))
+	assert.True(t, FromMinorUnits(1999).Equal(decimal.RequireFromString("19.99")))
+}
@@ -0,0 +1,134 @@
+// Package payment charges customers through a payment provider. An order is
+// paid by authorizing its total on the customer's payment method and then
+// capturing the authorized amount. An authorization that is no longer needed
+// is voided, and captured money goes back to the customer as a refund.
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Status is the state of a payment intent at the provider.
+type Status string
+
+const (
+	// StatusRequiresAction means the customer must complete a step such as
+	// 3-D Secure before the amount is authorized.
+	StatusRequiresAction Status = "requires_action"
+	StatusAuthorized     Status = "authorized"
+	StatusCaptured       Status = "captured"
+	StatusVoided         Status = "voided"
+	StatusFailed         Status = "failed"
+)
+
+var (
+	ErrDeclined      = errors.New("payment declined")
+	ErrNotFound      = errors.New("payment intent not found")
+	ErrInvalidState  = errors.New("payment intent is not in a state that allows this")
+	ErrInvalidRefund = errors.New("refund amount exceeds the captured amount")
+)
+
+// DeclineError is a payment the provider or the card issuer refused. It
+// matches ErrDeclined.
+type DeclineError struct {
+	// Code is the provider's reason, such as card_declined or
+	// insufficient_funds.
+	Code    string
+	Message string
+}
+
+func (e *DeclineError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("payment declined: %s", e.Code)
+	}
+	return fmt.Sprintf("payment declined: %s", e.Message)
+}
+
+func (e *DeclineError) Is(target error) bool {
+	return target == ErrDeclined
+}
+
+// IntentRequest asks a provider to authorize an amount on a payment method.
+type IntentRequest struct {
+	// Amount is in the minor unit of Currency, for example cents.
+	Amount int64
+	// Currency is a lowercase ISO 4217 code.
+	Currency string
+	// PaymentMethod is the provider's token for the customer's card, created
+	// client side so that card details never reach this API.
+	PaymentMethod string
+	// Reference identifies what is being paid for, usually the order ID.
+	Reference string
+	// IdempotencyKey makes retries of the same request return the intent
+	// created by the first one.
+	IdempotencyKey string
+}
+
+// Intent is a payment at the provider.
+type Intent struct {
+	ID       string
+	Status   Status
+	Amount   int64
+	Currency string
+	// ClientSecret lets the client complete a required action. It is only
+	// set when Status is StatusRequiresAction.
+	ClientSecret string
+	// FailureReason explains why the payment failed, when it did.
+	FailureReason string
+}
+
+// RefundRequest asks a provider to return captured money.
+type RefundRequest struct {
+	IntentID       string
+	Amount         int64
+	IdempotencyKey string
+}
+
+// Refund is money returned to the customer.
+type Refund struct {
+	ID       string
+	IntentID string
+	Amount   int64
+	// Status is succeeded, pending or failed.
+	Status string
+}
+
+// Provider is a payment gateway. Implementations must be safe for concurrent
+// use.
+type Provider interface {
+	// Name identifies the provider in stored payments.
+	Name() string
+	// CreateIntent authorizes an amount without capturing it. A declined
+	// payment method returns a *DeclineError.
+	CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error)
+	// Capture collects the full authorized amount of an intent.
+	Capture(ctx context.Context, intentID string) (*Intent, error)
+	// Void releases an authorization that has not been captured.
+	Void(ctx context.Context, intentID string) (*Intent, error)
+	// Refund returns part or all of a captured amount.
+	Refund(ctx context.Context, req RefundRequest) (*Refund, error)
+	// VerifyWebhook checks that a webhook request was signed by the provider
+	// recently. It returns an error matching ErrInvalidSignature if not.
+	VerifyWebhook(header http.Header, payload []byte, now time.Time) error
+	// ParseEvent decodes the payload of a webhook request. It does not
+	// verify the payload, so that stored events can be processed again.
+	ParseEvent(payload []byte) (*Event, error)
+}
+
+// MinorUnits converts an amount to the minor unit of a currency with two
+// decimal places, rounding half away from zero.
+func MinorUnits(amount decimal.Decimal) int64 {
+	return amount.Shift(2).Round(0).IntPart()
+}
+
+// FromMinorUnits converts an amount in the minor unit of a currency with two
+// decimal places back to a decimal.
+func FromMinorUnits(amount int64) decimal.Decimal {
+	return decimal.New(amount, -2)
+}
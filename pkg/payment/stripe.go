@@ -0,0 +1,278 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	stripeAPIURL = "https://api.stripe.com"
+	// stripeAPIVersion pins the shape of the responses parsed below.
+	stripeAPIVersion = "2024-06-20"
+)
+
+// StripeProvider takes payments through the Stripe PaymentIntents API. Intents
+// are confirmed on creation with manual capture, so that creating one
+// authorizes the amount and Capture collects it. Webhooks are verified with
+// the signing secret of the webhook endpoint.
+type StripeProvider struct {
+	secretKey     string
+	webhookSecret string
+	baseURL       string
+	client        *http.Client
+}
+
+func NewStripeProvider(secretKey, webhookSecret string) *StripeProvider {
+	return &StripeProvider{
+		secretKey:     secretKey,
+		webhookSecret: webhookSecret,
+		baseURL:       stripeAPIURL,
+		client:        &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *StripeProvider) Name() string {
+	return "stripe"
+}
+
+type stripeIntent struct {
+	ID               string `json:"id"`
+	Status           string `json:"status"`
+	Amount           int64  `json:"amount"`
+	Currency         string `json:"currency"`
+	ClientSecret     string `json:"client_secret"`
+	LastPaymentError *struct {
+		Message string `json:"message"`
+	} `json:"last_payment_error"`
+}
+
+type stripeRefund struct {
+	ID            string `json:"id"`
+	PaymentIntent string `json:"payment_intent"`
+	Amount        int64  `json:"amount"`
+	Status        string `json:"status"`
+}
+
+type stripeEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+type stripeDispute struct {
+	PaymentIntent string `json:"payment_intent"`
+	Amount        int64  `json:"amount"`
+	Reason        string `json:"reason"`
+	Status        string `json:"status"`
+}
+
+type stripeError struct {
+	Error struct {
+		Type        string `json:"type"`
+		Code        string `json:"code"`
+		DeclineCode string `json:"decline_code"`
+		Message     string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *StripeProvider) CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error) {
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(req.Amount, 10))
+	form.Set("currency", req.Currency)
+	form.Set("payment_method", req.PaymentMethod)
+	form.Set("confirm", "true")
+	form.Set("capture_method", "manual")
+	// Payment methods that redirect the customer need a return URL, which an
+	// API-only checkout does not have.
+	form.Set("automatic_payment_methods[enabled]", "true")
+	form.Set("automatic_payment_methods[allow_redirects]", "never")
+	if req.Reference != "" {
+		form.Set("metadata[reference]", req.Reference)
+	}
+
+	var intent stripeIntent
+	if err := p.post(ctx, "/v1/payment_intents", form, req.IdempotencyKey, &intent); err != nil {
+		return nil, err
+	}
+	return intent.toIntent()
+}
+
+func (p *StripeProvider) Capture(ctx context.Context, intentID string) (*Intent, error) {
+	var intent stripeIntent
+	path := "/v1/payment_intents/" + url.PathEscape(intentID) + "/capture"
+	if err := p.post(ctx, path, url.Values{}, "", &intent); err != nil {
+		return nil, err
+	}
+	return intent.toIntent()
+}
+
+func (p *StripeProvider) Void(ctx context.Context, intentID string) (*Intent, error) {
+	var intent stripeIntent
+	path := "/v1/payment_intents/" + url.PathEscape(intentID) + "/cancel"
+	if err := p.post(ctx, path, url.Values{}, "", &intent); err != nil {
+		return nil, err
+	}
+	return intent.toIntent()
+}
+
+func (p *StripeProvider) Refund(ctx context.Context, req RefundRequest) (*Refund, error) {
+	form := url.Values{}
+	form.Set("payment_intent", req.IntentID)
+	form.Set("amount", strconv.FormatInt(req.Amount, 10))
+
+	var refund stripeRefund
+	if err := p.post(ctx, "/v1/refunds", form, req.IdempotencyKey, &refund); err != nil {
+		return nil, err
+	}
+	return &Refund{
+		ID:       refund.ID,
+		IntentID: refund.PaymentIntent,
+		Amount:   refund.Amount,
+		Status:   refund.Status,
+	}, nil
+}
+
+func (p *StripeProvider) VerifyWebhook(header http.Header, payload []byte, now time.Time) error {
+	return VerifyWebhook(p.webhookSecret, header.Get("Stripe-Signature"), payload, now)
+}
+
+func (p *StripeProvider) ParseEvent(payload []byte) (*Event, error) {
+	var e stripeEvent
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, fmt.Errorf("stripe: decoding event: %w", err)
+	}
+	if e.ID == "" {
+		return nil, errors.New("stripe: event has no id")
+	}
+	event := &Event{ID: e.ID, Type: EventOther, ProviderType: e.Type, Created: time.Unix(e.Created, 0)}
+
+	switch e.Type {
+	case "payment_intent.amount_capturable_updated", "payment_intent.succeeded", "payment_intent.payment_failed":
+		var intent stripeIntent
+		if err := json.Unmarshal(e.Data.Object, &intent); err != nil {
+			return nil, fmt.Errorf("stripe: decoding event: %w", err)
+		}
+		event.IntentID = intent.ID
+		event.Amount = intent.Amount
+		switch e.Type {
+		case "payment_intent.amount_capturable_updated":
+			event.Type = EventPaymentAuthorized
+		case "payment_intent.succeeded":
+			event.Type = EventPaymentSucceeded
+		default:
+			event.Type = EventPaymentFailed
+			if intent.LastPaymentError != nil {
+				event.Reason = intent.LastPaymentError.Message
+			}
+		}
+	case "charge.dispute.created", "charge.dispute.closed":
+		var dispute stripeDispute
+		if err := json.Unmarshal(e.Data.Object, &dispute); err != nil {
+			return nil, fmt.Errorf("stripe: decoding event: %w", err)
+		}
+		event.IntentID = dispute.PaymentIntent
+		event.Amount = dispute.Amount
+		event.Reason = dispute.Reason
+		switch {
+		case e.Type == "charge.dispute.created":
+			event.Type = EventPaymentDisputed
+		case dispute.Status == "won":
+			event.Type = EventDisputeWon
+		case dispute.Status == "lost":
+			event.Type = EventDisputeLost
+		}
+	}
+	return event, nil
+}
+
+// post sends a form encoded request to the Stripe API and decodes the JSON
+// response into out.
+func (p *StripeProvider) post(ctx context.Context, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.secretKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Stripe-Version", stripeAPIVersion)
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("stripe: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("stripe: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		return stripeErrorFrom(resp.StatusCode, body)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("stripe: decoding response: %w", err)
+	}
+	return nil
+}
+
+// stripeErrorFrom maps an error response to the errors of this package.
+func stripeErrorFrom(status int, body []byte) error {
+	var e stripeError
+	if err := json.Unmarshal(body, &e); err != nil || e.Error.Message == "" {
+		return fmt.Errorf("stripe: unexpected status %d", status)
+	}
+
+	switch {
+	case e.Error.Type == "card_error":
+		code := e.Error.DeclineCode
+		if code == "" {
+			code = e.Error.Code
+		}
+		return &DeclineError{Code: code, Message: e.Error.Message}
+	case e.Error.Code == "resource_missing":
+		return fmt.Errorf("%w: %s", ErrNotFound, e.Error.Message)
+	case e.Error.Code == "payment_intent_unexpected_state":
+		return fmt.Errorf("%w: %s", ErrInvalidState, e.Error.Message)
+	case e.Error.Code == "charge_exceeds_source_limit", e.Error.Code == "amount_too_large":
+		return fmt.Errorf("%w: %s", ErrInvalidRefund, e.Error.Message)
+	}
+	return fmt.Errorf("stripe: %s (status %d)", e.Error.Message, status)
+}
+
+func (i *stripeIntent) toIntent() (*Intent, error) {
+	intent := &Intent{ID: i.ID, Amount: i.Amount, Currency: i.Currency}
+	switch i.Status {
+	case "requires_action":
+		intent.Status = StatusRequiresAction
+		intent.ClientSecret = i.ClientSecret
+	case "requires_capture":
+		intent.Status = StatusAuthorized
+	case "succeeded":
+		intent.Status = StatusCaptured
+	case "canceled":
+		intent.Status = StatusVoided
+	case "requires_payment_method", "requires_confirmation":
+		intent.Status = StatusFailed
+		if i.LastPaymentError != nil {
+			intent.FailureReason = i.LastPaymentError.Message
+		}
+	default:
+		return nil, errors.New("stripe: unexpected payment intent status " + strconv.Quote(i.Status))
+	}
+	return intent, nil
+}
@@ -0,0 +1,77 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStripeProvider(t *testing.T) {
+	var last *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		last = r
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/v1/payment_intents":
+			if r.PostForm.Get("payment_method") == "pm_card_chargeDeclined" {
+				w.WriteHeader(http.StatusPaymentRequired)
+				w.Write([]byte(`{"error": {"type": "card_error", "code": "card_declined", "decline_code": "generic_decline", "message": "Your card was declined."}}`))
+				return
+			}
+			w.Write([]byte(`{"id": "pi_123", "status": "requires_capture", "amount": 2599, "currency": "usd"}`))
+		case "/v1/payment_intents/pi_123/capture":
+			w.Write([]byte(`{"id": "pi_123", "status": "succeeded", "amount": 2599, "currency": "usd"}`))
+		case "/v1/payment_intents/pi_missing/cancel":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"type": "invalid_request_error", "code": "resource_missing", "message": "No such payment_intent"}}`))
+		case "/v1/refunds":
+			w.Write([]byte(`{"id": "re_1", "payment_intent": "pi_123", "amount": 1000, "status": "succeeded"}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	p := NewStripeProvider("sk_test_key", "whsec_test")
+	p.baseURL = server.URL
+	ctx := context.Background()
+
+	intent, err := p.CreateIntent(ctx, IntentRequest{
+		Amount:         2599,
+		Currency:       "usd",
+		PaymentMethod:  "pm_card_visa",
+		Reference:      "order-1",
+		IdempotencyKey: "key-1",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &Intent{ID: "pi_123", Status: StatusAuthorized, Amount: 2599, Currency: "usd"}, intent)
+	assert.Equal(t, "Bearer sk_test_key", last.Header.Get("Authorization"))
+	assert.Equal(t, "key-1", last.Header.Get("Idempotency-Key"))
+	assert.Equal(t, "manual", last.PostForm.Get("capture_method"))
+	assert.Equal(t, "true", last.PostForm.Get("confirm"))
+	assert.Equal(t, "order-1", last.PostForm.Get("metadata[reference]"))
+
+	_, err = p.CreateIntent(ctx, IntentRequest{Amount: 2599, Currency: "usd", PaymentMethod: "pm_card_chargeDeclined"})
+	var decline *DeclineError
+	require.ErrorAs(t, err, &decline)
+	assert.Equal(t, "generic_decline", decline.Code)
+
+	intent, err = p.Capture(ctx, "pi_123")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCaptured, intent.Status)
+
+	_, err = p.Void(ctx, "pi_missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	refund, err := p.Refund(ctx, RefundRequest{IntentID: "pi_123", Amount: 1000, IdempotencyKey: "refund-1"})
+	require.NoError(t, err)
+	assert.Equal(t, &Refund{ID: "re_1", IntentID: "pi_123", Amount: 1000, Status: "succeeded"}, refund)
+	assert.Equal(t, "1000", last.PostForm.Get("amount"))
+	assert.Equal(t, "refund-1", last.Header.Get("Idempotency-Key"))
+}
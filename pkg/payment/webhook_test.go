@@ -0,0 +1,85 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyWebhook(t *testing.T) {
+	now := time.Unix(1745000000, 0)
+	payload := []byte(`{"id": "evt_1"}`)
+	signature := SignWebhook("whsec_test", payload, now)
+
+	assert.NoError(t, VerifyWebhook("whsec_test", signature, payload, now))
+	assert.NoError(t, VerifyWebhook("whsec_test", signature, payload, now.Add(WebhookTolerance)))
+
+	assert.ErrorIs(t, VerifyWebhook("whsec_other", signature, payload, now), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifyWebhook("whsec_test", signature, []byte(`{"id": "evt_2"}`), now), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifyWebhook("whsec_test", signature, payload, now.Add(WebhookTolerance+time.Second)), ErrInvalidSignature,
+		"old deliveries cannot be replayed")
+	assert.ErrorIs(t, VerifyWebhook("", signature, payload, now), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifyWebhook("whsec_test", "v1=abc", payload, now), ErrInvalidSignature)
+
+	// While a secret is rotated the provider signs with both
+	rotated := signature + ",v1=" + webhookMAC("whsec_new", "1745000000", payload)
+	assert.NoError(t, VerifyWebhook("whsec_new", rotated, payload, now))
+	assert.NoError(t, VerifyWebhook("whsec_test", rotated, payload, now))
+}
+
+func TestStripeParseEvent(t *testing.T) {
+	p := NewStripeProvider("sk_test_key", "whsec_test")
+
+	payload := []byte(`{"id": "evt_1", "type": "payment_intent.payment_failed", "created": 1745000000,
+		"data": {"object": {"id": "pi_123", "status": "requires_payment_method", "amount": 2599,
+		"last_payment_error": {"message": "Your card was declined."}}}}`)
+	header := http.Header{}
+	header.Set("Stripe-Signature", SignWebhook("whsec_test", payload, time.Now()))
+	require.NoError(t, p.VerifyWebhook(header, payload, time.Now()))
+
+	event, err := p.ParseEvent(payload)
+	require.NoError(t, err)
+	assert.Equal(t, &Event{
+		ID:           "evt_1",
+		Type:         EventPaymentFailed,
+		ProviderType: "payment_intent.payment_failed",
+		IntentID:     "pi_123",
+		Amount:       2599,
+		Reason:       "Your card was declined.",
+		Created:      time.Unix(1745000000, 0),
+	}, event)
+
+	event, err = p.ParseEvent([]byte(`{"id": "evt_2", "type": "charge.dispute.closed",
+		"data": {"object": {"payment_intent": "pi_123", "amount": 2599, "reason": "fraudulent", "status": "lost"}}}`))
+	require.NoError(t, err)
+	assert.Equal(t, EventDisputeLost, event.Type)
+	assert.Equal(t, "pi_123", event.IntentID)
+
+	event, err = p.ParseEvent([]byte(`{"id": "evt_3", "type": "customer.created", "data": {"object": {}}}`))
+	require.NoError(t, err)
+	assert.Equal(t, EventOther, event.Type)
+}
+
+func TestFakeProviderWebhook(t *testing.T) {
+	p := NewFakeProvider()
+	intent, err := p.CreateIntent(context.Background(), IntentRequest{Amount: 500, Currency: "usd", PaymentMethod: FakeCardRequiresAction})
+	require.NoError(t, err)
+
+	_, err = p.Authenticate(intent.ID)
+	require.NoError(t, err)
+
+	now := time.Now()
+	header, payload := p.Webhook(EventPaymentAuthorized, intent.ID, "", now)
+	require.NoError(t, p.VerifyWebhook(header, payload, now))
+	assert.ErrorIs(t, p.VerifyWebhook(http.Header{}, payload, now), ErrInvalidSignature)
+
+	event, err := p.ParseEvent(payload)
+	require.NoError(t, err)
+	assert.Equal(t, EventPaymentAuthorized, event.Type)
+	assert.Equal(t, intent.ID, event.IntentID)
+	assert.Equal(t, int64(500), event.Amount)
+}
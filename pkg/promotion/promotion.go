@@ -0,0 +1,358 @@
+// Package promotion works out the discounts promotion codes give an order:
+// percentage off, a fixed amount off, buy X get Y free and free shipping,
+// subject to minimum spend, product and category scoping, validity windows,
+// usage limits and stacking rules.
+package promotion
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type Type string
+
+const (
+	// Percentage takes Value percent off the lines it applies to.
+	Percentage Type = "percentage"
+	// Fixed takes Value off the lines it applies to, spread over them.
+	Fixed Type = "fixed"
+	// BuyXGetY gives GetQuantity units free for every BuyQuantity units
+	// bought of the lines it applies to. The cheapest units are free.
+	BuyXGetY Type = "buy_x_get_y"
+	// FreeShipping waives the shipping charge.
+	FreeShipping Type = "free_shipping"
+)
+
+// applyOrder is the order promotions are applied in. Each discount is worked
+// out on what earlier discounts left of a line, so that discounts never add
+// up to more than the line.
+var applyOrder = map[Type]int{BuyXGetY: 0, Percentage: 1, Fixed: 2, FreeShipping: 3}
+
+// ErrInvalid is matched by every Error.
+var ErrInvalid = errors.New("promotion code cannot be applied")
+
+// Error reports why a promotion code cannot be applied to an order.
+type Error struct {
+	Code   string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("promotion code %s %s", e.Code, e.Reason)
+}
+
+func (e *Error) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+// Promotion is a promotion as the engine sees it.
+type Promotion struct {
+	ID          uuid.UUID
+	Code        string
+	Description string
+	Type        Type
+	// Value is the percentage off for Percentage promotions and the amount
+	// off for Fixed ones.
+	Value       decimal.Decimal
+	BuyQuantity int
+	GetQuantity int
+	// MinSubtotal is the order subtotal, before any discount, needed for the
+	// promotion to apply.
+	MinSubtotal decimal.Decimal
+	StartsAt    *time.Time
+	EndsAt      *time.Time
+	// UsageLimit and PerUserLimit are unlimited when nil.
+	UsageLimit   *int
+	PerUserLimit *int
+	Stackable    bool
+	Active       bool
+	// A promotion with ProductIDs or CategoryIDs only applies to lines of
+	// those products or of products in those categories.
+	ProductIDs  []uuid.UUID
+	CategoryIDs []uuid.UUID
+}
+
+// Usage is how often a promotion has been used, by anyone and by the
+// customer placing the order.
+type Usage struct {
+	Total  int
+	ByUser int
+}
+
+// Line is an order line.
+type Line struct {
+	ProductID   uuid.UUID
+	CategoryIDs []uuid.UUID
+	UnitPrice   decimal.Decimal
+	Quantity    int
+}
+
+func (l Line) total() decimal.Decimal {
+	return l.UnitPrice.Mul(decimal.NewFromInt(int64(l.Quantity)))
+}
+
+// Order is what promotions are applied to.
+type Order struct {
+	Lines    []Line
+	Shipping decimal.Decimal
+}
+
+// Discount is the discount one promotion gives an order.
+type Discount struct {
+	Promotion *Promotion
+	Amount    decimal.Decimal
+	// Lines holds the share of Amount of every order line. The discount of a
+	// FreeShipping promotion is on shipping, not on any line.
+	Lines []decimal.Decimal
+}
+
+// Result is the discounts all promotions give an order.
+type Result struct {
+	Discounts []Discount
+	// Lines holds the discount of every order line.
+	Lines    []decimal.Decimal
+	Items    decimal.Decimal
+	Shipping decimal.Decimal
+}
+
+// Total returns the discount on the lines and on shipping together.
+func (r *Result) Total() decimal.Decimal {
+	return r.Items.Add(r.Shipping)
+}
+
+// Apply checks that every promotion can be applied to the order at now and
+// works out the discounts they give it. usage holds the uses of the
+// promotions by ID. The same promotion given twice is applied once.
+func Apply(promotions []Promotion, usage map[uuid.UUID]Usage, order Order, now time.Time) (*Result, error) {
+	seen := make(map[uuid.UUID]bool)
+	unique := make([]*Promotion, 0, len(promotions))
+	for i := range promotions {
+		if !seen[promotions[i].ID] {
+			seen[promotions[i].ID] = true
+			unique = append(unique, &promotions[i])
+		}
+	}
+
+	subtotal := decimal.Zero
+	for _, line := range order.Lines {
+		subtotal = subtotal.Add(line.total())
+	}
+
+	for _, p := range unique {
+		if err := check(p, usage[p.ID], subtotal, now); err != nil {
+			return nil, err
+		}
+		if len(unique) > 1 && !p.Stackable {
+			return nil, &Error{Code: p.Code, Reason: "cannot be combined with other promotions"}
+		}
+	}
+
+	sort.SliceStable(unique, func(i, j int) bool {
+		return applyOrder[unique[i].Type] < applyOrder[unique[j].Type]
+	})
+
+	result := &Result{
+		Discounts: []Discount{},
+		Lines:     make([]decimal.Decimal, len(order.Lines)),
+		Items:     decimal.Zero,
+		Shipping:  decimal.Zero,
+	}
+	left := make([]decimal.Decimal, len(order.Lines))
+	for i, line := range order.Lines {
+		result.Lines[i] = decimal.Zero
+		left[i] = line.total()
+	}
+
+	for _, p := range unique {
+		eligible := make([]bool, len(order.Lines))
+		applies := false
+		for i, line := range order.Lines {
+			eligible[i] = p.appliesTo(line)
+			applies = applies || eligible[i]
+		}
+		if !applies {
+			return nil, &Error{Code: p.Code, Reason: "does not apply to any item of this order"}
+		}
+
+		discount := Discount{Promotion: p, Amount: decimal.Zero}
+		switch p.Type {
+		case FreeShipping:
+			discount.Amount = order.Shipping.Sub(result.Shipping)
+			result.Shipping = result.Shipping.Add(discount.Amount)
+			result.Discounts = append(result.Discounts, discount)
+			continue
+		case BuyXGetY:
+			discount.Lines = buyXGetY(p, order.Lines, eligible, left)
+			if isZero(discount.Lines) {
+				return nil, &Error{Code: p.Code, Reason: fmt.Sprintf("requires buying %d items", p.BuyQuantity+p.GetQuantity)}
+			}
+		case Percentage, Fixed:
+			base := decimal.Zero
+			weights := make([]decimal.Decimal, len(left))
+			for i := range left {
+				weights[i] = decimal.Zero
+				if eligible[i] {
+					weights[i] = left[i]
+					base = base.Add(left[i])
+				}
+			}
+			amount := p.Value
+			if p.Type == Percentage {
+				amount = base.Mul(p.Value).Div(decimal.NewFromInt(100)).Round(2)
+			}
+			if amount.GreaterThan(base) {
+				amount = base
+			}
+			discount.Lines = allocate(amount, weights)
+		}
+
+		for i, share := range discount.Lines {
+			left[i] = left[i].Sub(share)
+			result.Lines[i] = result.Lines[i].Add(share)
+			discount.Amount = discount.Amount.Add(share)
+		}
+		result.Items = result.Items.Add(discount.Amount)
+		result.Discounts = append(result.Discounts, discount)
+	}
+	return result, nil
+}
+
+// check reports why a promotion cannot be used for an order.
+func check(p *Promotion, usage Usage, subtotal decimal.Decimal, now time.Time) error {
+	switch {
+	case !p.Active:
+		return &Error{Code: p.Code, Reason: "is not active"}
+	case p.Type == BuyXGetY && (p.BuyQuantity < 1 || p.GetQuantity < 1):
+		return &Error{Code: p.Code, Reason: "has no buy and get quantities"}
+	case p.StartsAt != nil && now.Before(*p.StartsAt):
+		return &Error{Code: p.Code, Reason: "is not valid yet"}
+	case p.EndsAt != nil && !now.Before(*p.EndsAt):
+		return &Error{Code: p.Code, Reason: "has expired"}
+	case subtotal.LessThan(p.MinSubtotal):
+		return &Error{Code: p.Code, Reason: "requires a minimum spend of " + p.MinSubtotal.StringFixed(2)}
+	case p.UsageLimit != nil && usage.Total >= *p.UsageLimit:
+		return &Error{Code: p.Code, Reason: "has reached its usage limit"}
+	case p.PerUserLimit != nil && usage.ByUser >= *p.PerUserLimit:
+		return &Error{Code: p.Code, Reason: "has already been used the maximum number of times"}
+	}
+	return nil
+}
+
+func (p *Promotion) appliesTo(line Line) bool {
+	if len(p.ProductIDs) == 0 && len(p.CategoryIDs) == 0 {
+		return true
+	}
+	for _, id := range p.ProductIDs {
+		if id == line.ProductID {
+			return true
+		}
+	}
+	for _, id := range p.CategoryIDs {
+		for _, lineCategory := range line.CategoryIDs {
+			if id == lineCategory {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// buyXGetY returns the discount of every line when GetQuantity units are free
+// for every BuyQuantity units bought, floor(units/group)*GetQuantity units in
+// all. The units are grouped most expensive first, and the cheapest units of
+// each group are free. Lines are walked as runs of units rather than unit by
+// unit, so the work does not grow with the quantities ordered.
+func buyXGetY(p *Promotion, lines []Line, eligible []bool, left []decimal.Decimal) []decimal.Decimal {
+	var order []int
+	var units int64
+	for i, line := range lines {
+		if eligible[i] {
+			order = append(order, i)
+			units += int64(line.Quantity)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return lines[order[i]].UnitPrice.GreaterThan(lines[order[j]].UnitPrice)
+	})
+
+	buy, group := int64(p.BuyQuantity), int64(p.BuyQuantity+p.GetQuantity)
+	grouped := units / group * group
+	// free counts the free units among the first n units.
+	free := func(n int64) int64 {
+		if n > grouped {
+			n = grouped
+		}
+		return n/group*int64(p.GetQuantity) + max(0, n%group-buy)
+	}
+
+	shares := make([]decimal.Decimal, len(lines))
+	for i := range shares {
+		shares[i] = decimal.Zero
+	}
+	var start int64
+	for _, i := range order {
+		end := start + int64(lines[i].Quantity)
+		shares[i] = lines[i].UnitPrice.Mul(decimal.NewFromInt(free(end) - free(start)))
+		if shares[i].GreaterThan(left[i]) {
+			shares[i] = left[i]
+		}
+		start = end
+	}
+	return shares
+}
+
+// allocate spreads amount over lines in proportion to their weights, in whole
+// cents. The shares add up to amount exactly and no share exceeds its weight,
+// as long as amount does not exceed the sum of the weights.
+func allocate(amount decimal.Decimal, weights []decimal.Decimal) []decimal.Decimal {
+	shares := make([]decimal.Decimal, len(weights))
+	total := decimal.Zero
+	for _, w := range weights {
+		total = total.Add(w)
+	}
+
+	given := decimal.Zero
+	for i, w := range weights {
+		shares[i] = decimal.Zero
+		if total.IsZero() {
+			continue
+		}
+		shares[i] = amount.Mul(w).Div(total).RoundFloor(2)
+		given = given.Add(shares[i])
+	}
+
+	// Rounding down leaves a few cents, which go to the first lines with room
+	// for them.
+	cent := decimal.New(1, -2)
+	for rest := amount.Sub(given); rest.IsPositive(); {
+		progressed := false
+		for i := range shares {
+			if !rest.IsPositive() {
+				break
+			}
+			if shares[i].Add(cent).LessThanOrEqual(weights[i]) {
+				shares[i] = shares[i].Add(cent)
+				rest = rest.Sub(cent)
+				progressed = true
+			}
+		}
+		if !progressed {
+			break
+		}
+	}
+	return shares
+}
+
+func isZero(amounts []decimal.Decimal) bool {
+	for _, a := range amounts {
+		if !a.IsZero() {
+			return false
+		}
+	}
+	return true
+}
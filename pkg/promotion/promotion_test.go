@@ -0,0 +1,151 @@
+package promotion
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func amount(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+func assertAmount(t *testing.T, expected string, actual decimal.Decimal, msgAndArgs ...interface{}) {
+	t.Helper()
+	assert.True(t, amount(expected).Equal(actual), append([]interface{}{"expected %s, got %s", expected, actual}, msgAndArgs...)...)
+}
+
+func promo(code string, typ Type, value string) Promotion {
+	return Promotion{ID: uuid.New(), Code: code, Type: typ, Value: amount(value), MinSubtotal: decimal.Zero, Active: true}
+}
+
+func TestApply(t *testing.T) {
+	now := time.Now()
+	kettle, mug, tea := uuid.New(), uuid.New(), uuid.New()
+	drinks := uuid.New()
+	order := Order{
+		Lines: []Line{
+			{ProductID: kettle, UnitPrice: amount("40.00"), Quantity: 1},
+			{ProductID: mug, CategoryIDs: []uuid.UUID{drinks}, UnitPrice: amount("5.00"), Quantity: 3},
+			{ProductID: tea, CategoryIDs: []uuid.UUID{drinks}, UnitPrice: amount("3.33"), Quantity: 1},
+		},
+		Shipping: amount("4.99"),
+	}
+
+	t.Run("percentage", func(t *testing.T) {
+		result, err := Apply([]Promotion{promo("TEN", Percentage, "10")}, nil, order, now)
+		require.NoError(t, err)
+		assertAmount(t, "5.83", result.Items) // 10% of 58.33
+		assertAmount(t, "4.00", result.Lines[0])
+		assertAmount(t, "5.83", result.Lines[0].Add(result.Lines[1]).Add(result.Lines[2]), "line shares add up")
+		assertAmount(t, "0", result.Shipping)
+	})
+
+	t.Run("fixed scoped to a category", func(t *testing.T) {
+		p := promo("DRINKS", Fixed, "50.00")
+		p.CategoryIDs = []uuid.UUID{drinks}
+		result, err := Apply([]Promotion{p}, nil, order, now)
+		require.NoError(t, err)
+		assertAmount(t, "18.33", result.Items, "the discount is capped at the lines it applies to")
+		assertAmount(t, "0", result.Lines[0])
+	})
+
+	t.Run("buy two get one free", func(t *testing.T) {
+		p := promo("B2G1", BuyXGetY, "0")
+		p.BuyQuantity, p.GetQuantity = 2, 1
+		p.CategoryIDs = []uuid.UUID{drinks}
+		result, err := Apply([]Promotion{p}, nil, order, now)
+		require.NoError(t, err)
+		// Mug, mug, mug, tea: the cheapest unit of the first three is free
+		assertAmount(t, "5.00", result.Items)
+		assertAmount(t, "5.00", result.Lines[1])
+
+		// Large quantities are counted, not expanded unit by unit
+		bulk := Order{Lines: []Line{
+			{ProductID: mug, UnitPrice: amount("5.00"), Quantity: 2_000_000_000},
+			{ProductID: tea, UnitPrice: amount("3.33"), Quantity: 2},
+		}}
+		result, err = Apply([]Promotion{promo("BULK", BuyXGetY, "0")}, nil, bulk, now)
+		require.ErrorIs(t, err, ErrInvalid, "the promotion needs its quantities")
+		p.CategoryIDs = nil
+		result, err = Apply([]Promotion{p}, nil, bulk, now)
+		require.NoError(t, err)
+		// 2000000002 units make 666666667 groups with one free unit each; the
+		// last group is two mugs and a tea
+		assertAmount(t, "3333333330.00", result.Lines[0])
+		assertAmount(t, "3.33", result.Lines[1])
+
+		p.BuyQuantity = 4
+		p.CategoryIDs = []uuid.UUID{drinks}
+		_, err = Apply([]Promotion{p}, nil, order, now)
+		assert.ErrorIs(t, err, ErrInvalid, "too few items")
+	})
+
+	t.Run("stacking", func(t *testing.T) {
+		percent, ship := promo("TEN", Percentage, "10"), promo("SHIP", FreeShipping, "0")
+		_, err := Apply([]Promotion{percent, ship}, nil, order, now)
+		assert.ErrorIs(t, err, ErrInvalid, "promotions do not stack by default")
+
+		percent.Stackable, ship.Stackable = true, true
+		fixed := promo("FIVE", Fixed, "5.00")
+		fixed.Stackable = true
+		result, err := Apply([]Promotion{fixed, ship, percent}, nil, order, now)
+		require.NoError(t, err)
+		// 10% of 58.33, then 5.00 off what is left
+		assertAmount(t, "10.83", result.Items)
+		assertAmount(t, "4.99", result.Shipping)
+		assertAmount(t, "15.82", result.Total())
+		require.Len(t, result.Discounts, 3)
+		assert.Equal(t, "TEN", result.Discounts[0].Promotion.Code, "percentages apply before fixed amounts")
+
+		result, err = Apply([]Promotion{percent, percent}, nil, order, now)
+		require.NoError(t, err)
+		assert.Len(t, result.Discounts, 1, "a code given twice applies once")
+	})
+
+	t.Run("rules", func(t *testing.T) {
+		earlier, later := now.Add(-time.Hour), now.Add(time.Hour)
+		one := 1
+
+		for name, edit := range map[string]func(p *Promotion){
+			"inactive":       func(p *Promotion) { p.Active = false },
+			"not started":    func(p *Promotion) { p.StartsAt = &later },
+			"expired":        func(p *Promotion) { p.EndsAt = &earlier },
+			"minimum spend":  func(p *Promotion) { p.MinSubtotal = amount("100.00") },
+			"usage limit":    func(p *Promotion) { p.UsageLimit = &one },
+			"per user limit": func(p *Promotion) { p.PerUserLimit = &one },
+			"other products": func(p *Promotion) { p.ProductIDs = []uuid.UUID{uuid.New()} },
+		} {
+			p := promo("RULE", Percentage, "10")
+			edit(&p)
+			usage := map[uuid.UUID]Usage{p.ID: {Total: 1, ByUser: 1}}
+			if name == "usage limit" {
+				usage[p.ID] = Usage{Total: 1}
+			}
+			_, err := Apply([]Promotion{p}, usage, order, now)
+			assert.ErrorIs(t, err, ErrInvalid, name)
+		}
+
+		p := promo("OK", Percentage, "10")
+		p.StartsAt, p.EndsAt, p.MinSubtotal = &earlier, &later, amount("58.33")
+		p.UsageLimit, p.PerUserLimit = &one, &one
+		_, err := Apply([]Promotion{p}, map[uuid.UUID]Usage{}, order, now)
+		assert.NoError(t, err)
+	})
+}
+
+func TestAllocate(t *testing.T) {
+	shares := allocate(amount("1.00"), []decimal.Decimal{amount("1.00"), amount("1.00"), amount("1.00")})
+	assertAmount(t, "0.34", shares[0])
+	assertAmount(t, "0.33", shares[1])
+	assertAmount(t, "0.33", shares[2])
+
+	shares = allocate(amount("0.05"), []decimal.Decimal{amount("0.01"), amount("0"), amount("0.04")})
+	assertAmount(t, "0.01", shares[0])
+	assertAmount(t, "0", shares[1])
+	assertAmount(t, "0.04", shares[2])
+}
@@ -0,0 +1,134 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore keeps refresh tokens in process memory. It is meant for tests
+// and single-instance development setups; tokens are lost on restart.
+type MemoryStore struct {
+	mu           sync.Mutex
+	tokens       map[string]RefreshToken
+	families     map[uuid.UUID]bool
+	deniedTokens map[string]time.Time
+	deniedByUser map[uuid.UUID]time.Time
+	deniedUntil  map[uuid.UUID]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tokens:       make(map[string]RefreshToken),
+		families:     make(map[uuid.UUID]bool),
+		deniedTokens: make(map[string]time.Time),
+		deniedByUser: make(map[uuid.UUID]time.Time),
+		deniedUntil:  make(map[uuid.UUID]time.Time),
+	}
+}
+
+func (s *MemoryStore) SaveRefreshToken(ctx context.Context, token *RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token.TokenHash] = *token
+	return nil
+}
+
+func (s *MemoryStore) ConsumeRefreshToken(ctx context.Context, tokenHash string, now time.Time) (*RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[tokenHash]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	if s.families[token.FamilyID] {
+		return &token, ErrTokenRevoked
+	}
+	if token.UsedAt != nil {
+		return &token, ErrTokenReused
+	}
+	if !now.Before(token.ExpiresAt) {
+		return &token, ErrTokenExpired
+	}
+
+	token.UsedAt = &now
+	s.tokens[tokenHash] = token
+	return &token, nil
+}
+
+func (s *MemoryStore) RevokeTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.families[familyID] = true
+	return nil
+}
+
+func (s *MemoryStore) RevokeUserTokens(ctx context.Context, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.tokens {
+		if token.UserID == userID {
+			s.families[token.FamilyID] = true
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) DenyAccessToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deniedTokens[tokenID] = expiresAt
+	return nil
+}
+
+func (s *MemoryStore) DenyAccessTokensIssuedBefore(ctx context.Context, userID uuid.UUID, before, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if before.After(s.deniedByUser[userID]) {
+		s.deniedByUser[userID] = before
+	}
+	if expiresAt.After(s.deniedUntil[userID]) {
+		s.deniedUntil[userID] = expiresAt
+	}
+	return nil
+}
+
+func (s *MemoryStore) IsAccessTokenDenied(ctx context.Context, tokenID string, userID uuid.UUID, issuedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if expiresAt, ok := s.deniedTokens[tokenID]; ok {
+		if now.Before(expiresAt) {
+			return true, nil
+		}
+		delete(s.deniedTokens, tokenID)
+	}
+	if before, ok := s.deniedByUser[userID]; ok {
+		if now.Before(s.deniedUntil[userID]) {
+			return issuedAt.Before(before), nil
+		}
+		delete(s.deniedByUser, userID)
+		delete(s.deniedUntil, userID)
+	}
+	return false, nil
+}
+
+func (s *MemoryStore) ConsumeTokenID(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if deniedUntil, ok := s.deniedTokens[tokenID]; ok && time.Now().Before(deniedUntil) {
+		return false, nil
+	}
+	s.deniedTokens[tokenID] = expiresAt
+	return true, nil
+}
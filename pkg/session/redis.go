@@ -0,0 +1,223 @@
+package session
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// consumeScript marks a token hash as used unless it already was. It returns
+// 0 if the token does not exist, 1 if this call consumed it and 2 if it had
+// been consumed before.
+var consumeScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+if redis.call('HSETNX', KEYS[1], 'used_at', ARGV[1]) == 1 then
+	return 1
+end
+return 2
+`)
+
+// RedisStore keeps refresh tokens in Redis. Each token is a hash that expires
+// with the token; revoked families and the families of each user are tracked
+// in keys that live as long as a token can.
+type RedisStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewRedisStore returns a store on client. ttl must be at least the lifetime
+// of the refresh tokens saved in it.
+func NewRedisStore(client *redis.Client, ttl time.Duration) *RedisStore {
+	return &RedisStore{client: client, ttl: ttl}
+}
+
+func tokenKey(tokenHash string) string {
+	return "refresh:token:" + tokenHash
+}
+
+func revokedFamilyKey(familyID uuid.UUID) string {
+	return "refresh:family:" + familyID.String() + ":revoked"
+}
+
+func userFamiliesKey(userID uuid.UUID) string {
+	return "refresh:user:" + userID.String()
+}
+
+func deniedTokenKey(tokenID string) string {
+	return "denylist:token:" + tokenID
+}
+
+func deniedUserKey(userID uuid.UUID) string {
+	return "denylist:user:" + userID.String()
+}
+
+func (s *RedisStore) SaveRefreshToken(ctx context.Context, token *RefreshToken) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		key := tokenKey(token.TokenHash)
+		pipe.HSet(ctx, key,
+			"family_id", token.FamilyID.String(),
+			"user_id", token.UserID.String(),
+			"device_id", token.DeviceID,
+			"expires_at", token.ExpiresAt.Format(time.RFC3339Nano),
+			"created_at", token.CreatedAt.Format(time.RFC3339Nano),
+		)
+		pipe.ExpireAt(ctx, key, token.ExpiresAt)
+
+		families := userFamiliesKey(token.UserID)
+		pipe.SAdd(ctx, families, token.FamilyID.String())
+		pipe.Expire(ctx, families, s.ttl)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) ConsumeRefreshToken(ctx context.Context, tokenHash string, now time.Time) (*RefreshToken, error) {
+	key := tokenKey(tokenHash)
+	state, err := consumeScript.Run(ctx, s.client, []string{key}, now.Format(time.RFC3339Nano)).Int()
+	if err != nil {
+		return nil, err
+	}
+	if state == 0 {
+		return nil, ErrTokenNotFound
+	}
+
+	fields, err := s.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		// The key expired between the two calls.
+		return nil, ErrTokenNotFound
+	}
+	token, err := parseRedisToken(tokenHash, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := s.client.Exists(ctx, revokedFamilyKey(token.FamilyID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case revoked > 0:
+		return token, ErrTokenRevoked
+	case state == 2:
+		return token, ErrTokenReused
+	case !now.Before(token.ExpiresAt):
+		return token, ErrTokenExpired
+	}
+	return token, nil
+}
+
+func (s *RedisStore) RevokeTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	return s.client.Set(ctx, revokedFamilyKey(familyID), 1, s.ttl).Err()
+}
+
+func (s *RedisStore) RevokeUserTokens(ctx context.Context, userID uuid.UUID) error {
+	families, err := s.client.SMembers(ctx, userFamiliesKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, family := range families {
+			familyID, err := uuid.Parse(family)
+			if err != nil {
+				return err
+			}
+			pipe.Set(ctx, revokedFamilyKey(familyID), 1, s.ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) DenyAccessToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.client.Set(ctx, deniedTokenKey(tokenID), 1, ttl).Err()
+}
+
+func (s *RedisStore) ConsumeTokenID(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return false, nil
+	}
+	return s.client.SetNX(ctx, deniedTokenKey(tokenID), 1, ttl).Result()
+}
+
+// denyBeforeScript raises a user's revoked-before mark and extends its
+// lifetime, never lowering either.
+var denyBeforeScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+if tonumber(ARGV[1]) > current then
+	redis.call('SET', KEYS[1], ARGV[1], 'KEEPTTL')
+end
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < tonumber(ARGV[2]) then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+func (s *RedisStore) DenyAccessTokensIssuedBefore(ctx context.Context, userID uuid.UUID, before, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return denyBeforeScript.Run(ctx, s.client, []string{deniedUserKey(userID)}, before.UnixMilli(), ttl.Milliseconds()).Err()
+}
+
+func (s *RedisStore) IsAccessTokenDenied(ctx context.Context, tokenID string, userID uuid.UUID, issuedAt time.Time) (bool, error) {
+	values, err := s.client.MGet(ctx, deniedTokenKey(tokenID), deniedUserKey(userID)).Result()
+	if err != nil {
+		return false, err
+	}
+	if values[0] != nil {
+		return true, nil
+	}
+	if before, ok := values[1].(string); ok {
+		ms, err := strconv.ParseInt(before, 10, 64)
+		if err != nil {
+			return false, err
+		}
+		return issuedAt.UnixMilli() < ms, nil
+	}
+	return false, nil
+}
+
+func parseRedisToken(tokenHash string, fields map[string]string) (*RefreshToken, error) {
+	token := &RefreshToken{
+		TokenHash: tokenHash,
+		DeviceID:  fields["device_id"],
+	}
+
+	var err error
+	if token.FamilyID, err = uuid.Parse(fields["family_id"]); err != nil {
+		return nil, err
+	}
+	if token.UserID, err = uuid.Parse(fields["user_id"]); err != nil {
+		return nil, err
+	}
+	if token.ExpiresAt, err = time.Parse(time.RFC3339Nano, fields["expires_at"]); err != nil {
+		return nil, err
+	}
+	if token.CreatedAt, err = time.Parse(time.RFC3339Nano, fields["created_at"]); err != nil {
+		return nil, err
+	}
+	if usedAt, ok := fields["used_at"]; ok {
+		t, err := time.Parse(time.RFC3339Nano, usedAt)
+		if err != nil {
+			return nil, err
+		}
+		token.UsedAt = &t
+	}
+	return token, nil
+}
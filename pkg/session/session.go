@@ -0,0 +1,186 @@
+// Package session manages refresh tokens. A refresh token is an opaque random
+// value; only its SHA-256 hash is stored. Every refresh rotates the token, and
+// all tokens descending from one login form a family. Presenting a token that
+// was already rotated means it leaked, so the whole family is revoked.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrTokenNotFound = errors.New("refresh token not found")
+	ErrTokenExpired  = errors.New("refresh token expired")
+	ErrTokenRevoked  = errors.New("refresh token revoked")
+	ErrTokenReused   = errors.New("refresh token already used")
+	ErrTokenIDUsed   = errors.New("token already used")
+)
+
+// RefreshToken is the stored record of a refresh token.
+type RefreshToken struct {
+	TokenHash string
+	FamilyID  uuid.UUID
+	UserID    uuid.UUID
+	DeviceID  string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+// Store persists refresh tokens and the access token denylist.
+//
+// ConsumeRefreshToken marks a token as used and returns it. It must be atomic:
+// of two concurrent calls for the same token exactly one succeeds. A token
+// that was already used is returned together with ErrTokenReused.
+//
+// Denylist entries only need to be kept until expiresAt, after which the
+// access tokens they cover have expired on their own.
+//
+// ConsumeTokenID puts a single-use token on the denylist and reports whether
+// it was not there yet. Like ConsumeRefreshToken it must be atomic.
+type Store interface {
+	SaveRefreshToken(ctx context.Context, token *RefreshToken) error
+	ConsumeRefreshToken(ctx context.Context, tokenHash string, now time.Time) (*RefreshToken, error)
+	RevokeTokenFamily(ctx context.Context, familyID uuid.UUID) error
+	RevokeUserTokens(ctx context.Context, userID uuid.UUID) error
+
+	DenyAccessToken(ctx context.Context, tokenID string, expiresAt time.Time) error
+	DenyAccessTokensIssuedBefore(ctx context.Context, userID uuid.UUID, before, expiresAt time.Time) error
+	IsAccessTokenDenied(ctx context.Context, tokenID string, userID uuid.UUID, issuedAt time.Time) (bool, error)
+	ConsumeTokenID(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error)
+}
+
+// Manager issues, rotates and revokes refresh tokens on top of a Store, and
+// revokes the access tokens of ended sessions.
+type Manager struct {
+	store     Store
+	ttl       time.Duration
+	accessTTL time.Duration
+	now       func() time.Time
+}
+
+// NewManager returns a manager issuing refresh tokens valid for ttl.
+// accessTTL is the lifetime of access tokens, which bounds how long they must
+// stay on the denylist.
+func NewManager(store Store, ttl, accessTTL time.Duration) *Manager {
+	return &Manager{store: store, ttl: ttl, accessTTL: accessTTL, now: time.Now}
+}
+
+// TTL is how long a refresh token stays valid after it is issued.
+func (m *Manager) TTL() time.Duration {
+	return m.ttl
+}
+
+// Issue starts a new token family for a login of userID on deviceID.
+func (m *Manager) Issue(ctx context.Context, userID uuid.UUID, deviceID string) (string, *RefreshToken, error) {
+	return m.issue(ctx, uuid.New(), userID, deviceID)
+}
+
+// Rotate exchanges a refresh token for a new one in the same family. Reusing
+// a token that was already rotated revokes its whole family.
+func (m *Manager) Rotate(ctx context.Context, token string) (string, *RefreshToken, error) {
+	record, err := m.store.ConsumeRefreshToken(ctx, HashToken(token), m.now())
+	if errors.Is(err, ErrTokenReused) {
+		if revokeErr := m.store.RevokeTokenFamily(ctx, record.FamilyID); revokeErr != nil {
+			return "", nil, revokeErr
+		}
+		return "", nil, err
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	return m.issue(ctx, record.FamilyID, record.UserID, record.DeviceID)
+}
+
+// RevokeSession ends one login: it revokes the refresh token family and
+// denies the access token tokenID, which expires at accessExpiresAt.
+func (m *Manager) RevokeSession(ctx context.Context, familyID uuid.UUID, tokenID string, accessExpiresAt time.Time) error {
+	if err := m.store.RevokeTokenFamily(ctx, familyID); err != nil {
+		return err
+	}
+	if tokenID == "" {
+		return nil
+	}
+	return m.store.DenyAccessToken(ctx, tokenID, accessExpiresAt)
+}
+
+// RevokeUser ends every session of a user: all refresh tokens are revoked and
+// every access token issued until now is denied.
+func (m *Manager) RevokeUser(ctx context.Context, userID uuid.UUID) error {
+	if err := m.store.RevokeUserTokens(ctx, userID); err != nil {
+		return err
+	}
+	return m.RevokeAccessTokens(ctx, userID)
+}
+
+// RevokeAccessTokens denies every access token issued to a user until now but
+// keeps their sessions, so that their next renewal picks up changed
+// permissions.
+func (m *Manager) RevokeAccessTokens(ctx context.Context, userID uuid.UUID) error {
+	now := m.now()
+	return m.store.DenyAccessTokensIssuedBefore(ctx, userID, now, now.Add(m.accessTTL))
+}
+
+// ConsumeTokenID uses up a single-use token, such as a login challenge, that
+// expires at expiresAt. Using it again returns ErrTokenIDUsed.
+func (m *Manager) ConsumeTokenID(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	fresh, err := m.store.ConsumeTokenID(ctx, tokenID, expiresAt)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrTokenIDUsed
+	}
+	return nil
+}
+
+// IsAccessTokenRevoked reports whether an access token was revoked, either on
+// its own or by a revocation of all of its user's sessions.
+func (m *Manager) IsAccessTokenRevoked(ctx context.Context, tokenID string, userID uuid.UUID, issuedAt time.Time) (bool, error) {
+	return m.store.IsAccessTokenDenied(ctx, tokenID, userID, issuedAt)
+}
+
+func (m *Manager) issue(ctx context.Context, familyID, userID uuid.UUID, deviceID string) (string, *RefreshToken, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := m.now()
+	record := &RefreshToken{
+		TokenHash: HashToken(token),
+		FamilyID:  familyID,
+		UserID:    userID,
+		DeviceID:  deviceID,
+		ExpiresAt: now.Add(m.ttl),
+		CreatedAt: now,
+	}
+	if err := m.store.SaveRefreshToken(ctx, record); err != nil {
+		return "", nil, err
+	}
+	return token, record, nil
+}
+
+// HashToken returns the hex SHA-256 of a refresh token, which is the only
+// form in which tokens are stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
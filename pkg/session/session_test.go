@@ -0,0 +1,192 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateIssuesNewTokenInSameFamily(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(NewMemoryStore(), time.Hour, 15*time.Minute)
+	userID := uuid.New()
+
+	first, issued, err := m.Issue(ctx, userID, "laptop")
+	require.NoError(t, err)
+
+	second, rotated, err := m.Rotate(ctx, first)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, issued.FamilyID, rotated.FamilyID)
+	assert.Equal(t, userID, rotated.UserID)
+	assert.Equal(t, "laptop", rotated.DeviceID)
+	assert.Equal(t, HashToken(second), rotated.TokenHash)
+}
+
+func TestReusedTokenRevokesFamily(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(NewMemoryStore(), time.Hour, 15*time.Minute)
+	userID := uuid.New()
+
+	first, _, err := m.Issue(ctx, userID, "phone")
+	require.NoError(t, err)
+	other, _, err := m.Issue(ctx, userID, "laptop")
+	require.NoError(t, err)
+
+	second, _, err := m.Rotate(ctx, first)
+	require.NoError(t, err)
+
+	// Replaying the rotated token is reuse and kills the family ...
+	_, _, err = m.Rotate(ctx, first)
+	assert.ErrorIs(t, err, ErrTokenReused)
+
+	// ... including the token the legitimate holder got from the rotation.
+	_, _, err = m.Rotate(ctx, second)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+
+	// Other devices keep their own family.
+	_, _, err = m.Rotate(ctx, other)
+	assert.NoError(t, err)
+}
+
+func TestConcurrentRotateSucceedsOnce(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(NewMemoryStore(), time.Hour, 15*time.Minute)
+
+	token, _, err := m.Issue(ctx, uuid.New(), "default")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	results := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := m.Rotate(ctx, token)
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	succeeded := 0
+	for err := range results {
+		if err == nil {
+			succeeded++
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+}
+
+func TestExpiredAndUnknownTokens(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(NewMemoryStore(), time.Hour, 15*time.Minute)
+
+	token, _, err := m.Issue(ctx, uuid.New(), "default")
+	require.NoError(t, err)
+
+	m.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, _, err = m.Rotate(ctx, token)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+
+	_, _, err = m.Rotate(ctx, "not-a-token")
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+}
+
+func TestRevokeUser(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(NewMemoryStore(), time.Hour, 15*time.Minute)
+	userID := uuid.New()
+
+	phone, _, err := m.Issue(ctx, userID, "phone")
+	require.NoError(t, err)
+	laptop, _, err := m.Issue(ctx, userID, "laptop")
+	require.NoError(t, err)
+
+	require.NoError(t, m.RevokeUser(ctx, userID))
+
+	_, _, err = m.Rotate(ctx, phone)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+	_, _, err = m.Rotate(ctx, laptop)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+}
+
+func TestRevokeSessionDeniesAccessToken(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(NewMemoryStore(), time.Hour, 15*time.Minute)
+	userID := uuid.New()
+
+	token, issued, err := m.Issue(ctx, userID, "default")
+	require.NoError(t, err)
+
+	issuedAt := time.Now()
+	require.NoError(t, m.RevokeSession(ctx, issued.FamilyID, "jti-1", issuedAt.Add(15*time.Minute)))
+
+	_, _, err = m.Rotate(ctx, token)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+
+	revoked, err := m.IsAccessTokenRevoked(ctx, "jti-1", userID, issuedAt)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = m.IsAccessTokenRevoked(ctx, "jti-2", userID, issuedAt)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestRevokeUserDeniesEarlierAccessTokens(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(NewMemoryStore(), time.Hour, 15*time.Minute)
+	userID := uuid.New()
+
+	revokedAt := time.Now()
+	m.now = func() time.Time { return revokedAt }
+	require.NoError(t, m.RevokeUser(ctx, userID))
+
+	revoked, err := m.IsAccessTokenRevoked(ctx, "before", userID, revokedAt.Add(-time.Second))
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = m.IsAccessTokenRevoked(ctx, "after", userID, revokedAt.Add(time.Second))
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	revoked, err = m.IsAccessTokenRevoked(ctx, "other-user", uuid.New(), revokedAt.Add(-time.Second))
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestConcurrentConsumeTokenIDSucceedsOnce(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(NewMemoryStore(), time.Hour, 15*time.Minute)
+	expiresAt := time.Now().Add(5 * time.Minute)
+
+	var wg sync.WaitGroup
+	results := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- m.ConsumeTokenID(ctx, "challenge-1", expiresAt)
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	succeeded := 0
+	for err := range results {
+		if err == nil {
+			succeeded++
+		} else {
+			assert.ErrorIs(t, err, ErrTokenIDUsed)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+
+	assert.NoError(t, m.ConsumeTokenID(ctx, "challenge-2", expiresAt), "other tokens are unaffected")
+}
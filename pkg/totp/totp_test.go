@@ -0,0 +1,56 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA1 seed of the RFC 6238 test vectors.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeMatchesRFCVectors(t *testing.T) {
+	// The RFC lists eight digit codes; six digit codes are their last six.
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range vectors {
+		code, err := Code(rfcSecret, Step(time.Unix(unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, want, code, "t=%d", unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := Code(rfcSecret, Step(now))
+	require.NoError(t, err)
+
+	step, ok := Validate(rfcSecret, code, now, 0)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	_, ok = Validate(rfcSecret, code, now.Add(Period), 0)
+	assert.True(t, ok, "previous step is accepted")
+	_, ok = Validate(rfcSecret, code, now.Add(2*Period), 0)
+	assert.False(t, ok, "older steps are not")
+
+	_, ok = Validate(rfcSecret, code, now, step)
+	assert.False(t, ok, "a code cannot be used twice")
+
+	_, ok = Validate(rfcSecret, "12345", now, 0)
+	assert.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Shop", "ada@example.com", "JBSWY3DPEHPK3PXP")
+	assert.Equal(t, "otpauth://totp/Shop:ada@example.com?algorithm=SHA1&digits=6&issuer=Shop&period=30&secret=JBSWY3DPEHPK3PXP", uri)
+}
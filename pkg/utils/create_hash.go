@@ -30,3 +30,12 @@ func createTokenStr(length int) (string, error) {
 func GetTokenStr(length int) (string, error) {
 	return createTokenStr(length)
 }
+
+// GetURLTokenStr returns length random bytes encoded for use in URLs.
+func GetURLTokenStr(length int) (string, error) {
+	bytes := make([]byte, length)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("error generating random bytes")
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
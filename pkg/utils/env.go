@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 )
 
 func MustMapEnv(target *string, envKey string) {
@@ -28,3 +29,60 @@ func GetEnvAsInt(envKey string) int {
 
 	return val
 }
+
+// GetEnv returns the value of an optional environment variable, or fallback
+// if it is not set.
+func GetEnv(envKey, fallback string) string {
+	if v := os.Getenv(envKey); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// GetEnvAsBool returns the value of an optional boolean environment variable,
+// or fallback if it is not set.
+func GetEnvAsBool(envKey string, fallback bool) bool {
+	v := os.Getenv(envKey)
+	if v == "" {
+		return fallback
+	}
+
+	val, err := strconv.ParseBool(v)
+	if err != nil {
+		panic(fmt.Sprintf("failed to convert %q", envKey))
+	}
+
+	return val
+}
+
+// GetEnvAsIntOr returns the value of an optional integer environment variable,
+// or fallback if it is not set.
+func GetEnvAsIntOr(envKey string, fallback int) int {
+	v := os.Getenv(envKey)
+	if v == "" {
+		return fallback
+	}
+
+	val, err := strconv.Atoi(v)
+	if err != nil {
+		panic(fmt.Sprintf("failed to convert %q", envKey))
+	}
+
+	return val
+}
+
+// GetEnvAsDuration returns the value of an optional duration environment
+// variable such as "15m", or fallback if it is not set.
+func GetEnvAsDuration(envKey string, fallback time.Duration) time.Duration {
+	v := os.Getenv(envKey)
+	if v == "" {
+		return fallback
+	}
+
+	val, err := time.ParseDuration(v)
+	if err != nil {
+		panic(fmt.Sprintf("failed to convert %q", envKey))
+	}
+
+	return val
+}
@@ -1,19 +1,28 @@
 package utils
 
-import (
-	"golang.org/x/crypto/bcrypt"
-)
+var passwordHasher PasswordHasher = NewArgon2idHasher(DefaultArgon2idParams)
+
+// SetPasswordHasher replaces the hasher used by HashPassword and
+// VerifyPassword. It is meant to be called once at startup.
+func SetPasswordHasher(h PasswordHasher) {
+	passwordHasher = h
+}
 
 func NormalizePassword(p string) []byte {
 	return []byte(p)
 }
 
-func HashPassword(p string) string {
-	pwd := NormalizePassword(p)
-	hash, err := bcrypt.GenerateFromPassword(pwd, bcrypt.MinCost)
+// HashPassword hashes a password for storage.
+func HashPassword(p string) (string, error) {
+	return passwordHasher.Hash(p)
+}
+
+// VerifyPassword reports whether p matches hash, and whether hash should be
+// replaced by a new HashPassword of p.
+func VerifyPassword(p, hash string) (ok, rehash bool) {
+	ok, rehash, err := passwordHasher.Verify(p, hash)
 	if err != nil {
-		return err.Error()
+		return false, false
 	}
-
-	return string(hash)
+	return ok, rehash
 }
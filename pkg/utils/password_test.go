@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testParams = Argon2idParams{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2idHasher(t *testing.T) {
+	h := NewArgon2idHasher(testParams)
+
+	hash, err := h.Hash("correct horse")
+	require.NoError(t, err)
+	assert.Regexp(t, `^\$argon2id\$v=19\$m=1024,t=1,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`, hash)
+
+	ok, rehash, err := h.Verify("correct horse", hash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	ok, _, err = h.Verify("battery staple", hash)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Stronger parameters ask for existing hashes to be upgraded
+	stronger := testParams
+	stronger.Iterations = 2
+	ok, rehash, err = NewArgon2idHasher(stronger).Verify("correct horse", hash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	_, _, err = h.Verify("correct horse", "plaintext")
+	assert.ErrorIs(t, err, ErrUnknownPasswordHash)
+}
+
+func TestArgon2idHasherVerifiesBcrypt(t *testing.T) {
+	h := NewArgon2idHasher(testParams)
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	ok, rehash, err := h.Verify("correct horse", string(legacy))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	ok, _, err = h.Verify("battery staple", string(legacy))
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(path, []byte("password123\nletmein!\n\n"), 0o600))
+
+	p := NewPasswordPolicy(8, 16)
+	require.NoError(t, p.LoadBreachedPasswords(path))
+
+	assert.NoError(t, p.Check("correct horse"))
+	assert.Error(t, p.Check("pässwö"), "length counts characters, not bytes")
+	assert.Error(t, p.Check("short"))
+	assert.Error(t, p.Check("much too long for this policy"))
+	assert.ErrorIs(t, p.Check("Password123"), ErrPasswordBreached)
+}
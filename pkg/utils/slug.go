@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	slugInvalidChars = regexp.MustCompile(`[^a-z0-9]+`)
+	slugPattern      = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+)
+
+// Slugify lowercases s and joins its alphanumeric runs with hyphens,
+// e.g. "Men's Shoes & Boots" becomes "men-s-shoes-boots".
+func Slugify(s string) string {
+	return strings.Trim(slugInvalidChars.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+func IsSlug(s string) bool {
+	return slugPattern.MatchString(s)
+}
@@ -0,0 +1,122 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// deletedUserName replaces the first name of a deleted account.
+const deletedUserName = "Deleted"
+
+// UpdateUserNames sets the first and last name of a user.
+func (q *Query) UpdateUserNames(ctx context.Context, userID uuid.UUID, firstName string, lastName *string) error {
+	query := `
+		UPDATE "user"
+		SET first_name = $2, last_name = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	res, err := q.DB.ExecContext(ctx, query, userID, firstName, lastName)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// ChangeUserEmail sets the email of a user and marks it unverified. Links
+// emailed to the previous address, to verify it or to reset the password,
+// stop working.
+func (q *Query) ChangeUserEmail(ctx context.Context, userID uuid.UUID, email string) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now()
+
+		query := `
+			UPDATE "user"
+			SET email = $2, email_verified_at = NULL, updated_at = $3
+			WHERE id = $1 AND deleted_at IS NULL
+		`
+		res, err := tx.ExecContext(ctx, query, userID, email, now)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" { // unique_violation
+				return ErrEmailTaken
+			}
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrUserNotFound
+		}
+
+		if err := invalidateUserTokens(ctx, tx, userID, TokenPurposeEmailVerification, now); err != nil {
+			return err
+		}
+		return invalidateUserTokens(ctx, tx, userID, TokenPurposePasswordReset, now)
+	})
+}
+
+// ChangeUserPassword sets the password of a user. Outstanding password reset
+// links stop working.
+func (q *Query) ChangeUserPassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now()
+
+		query := `
+			UPDATE "user"
+			SET password_hash = $2, updated_at = $3
+			WHERE id = $1 AND deleted_at IS NULL
+		`
+		res, err := tx.ExecContext(ctx, query, userID, passwordHash, now)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrUserNotFound
+		}
+
+		return invalidateUserTokens(ctx, tx, userID, TokenPurposePasswordReset, now)
+	})
+}
+
+// DeleteUser anonymizes an account: its name and email are replaced, its
+// password stops working, and its address book, cart, two-factor settings and
+// emailed tokens are removed. The row itself stays, so that its orders keep their
+// customer for accounting. The last active admin cannot be deleted.
+func (q *Query) DeleteUser(ctx context.Context, userID uuid.UUID) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		if err := guardLastAdmin(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		// The placeholder email keeps the column unique and can never receive
+		// mail, and no password hashes to "!".
+		query := `
+			UPDATE "user"
+			SET first_name = $2, last_name = NULL, email = $3, password_hash = '!',
+				email_verified_at = NULL, deleted_at = $4, updated_at = $4
+			WHERE id = $1 AND deleted_at IS NULL
+		`
+		email := fmt.Sprintf("deleted-%s@deleted.invalid", userID)
+		res, err := tx.ExecContext(ctx, query, userID, deletedUserName, email, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrUserNotFound
+		}
+
+		for _, table := range []string{"address", "cart", "user_mfa", "user_recovery_code", "user_token"} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM "`+table+`" WHERE user_id = $1`, userID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
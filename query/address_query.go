@@ -0,0 +1,237 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxAddressesPerUser caps the size of an address book.
+const MaxAddressesPerUser = 20
+
+var (
+	ErrAddressNotFound = errors.New("address not found")
+	ErrAddressLimit    = errors.New("address book is full")
+)
+
+// PostalAddress is where an order is shipped or billed to. Orders keep a copy
+// of it as it was when they were placed.
+type PostalAddress struct {
+	FullName   string  `json:"full_name"`
+	Company    *string `json:"company,omitempty"`
+	Line1      string  `json:"line1"`
+	Line2      *string `json:"line2,omitempty"`
+	City       string  `json:"city"`
+	Region     *string `json:"region,omitempty"`
+	PostalCode string  `json:"postal_code"`
+	// Country is an ISO 3166-1 alpha-2 code.
+	Country string  `json:"country"`
+	Phone   *string `json:"phone,omitempty"`
+}
+
+// Address is an entry of a user's address book.
+type Address struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"-"`
+	Label  *string   `json:"label,omitempty"`
+	PostalAddress
+	DefaultShipping bool      `json:"default_shipping"`
+	DefaultBilling  bool      `json:"default_billing"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+const addressColumns = `id, user_id, label, full_name, company, line1, line2, city, region, postal_code, country, phone,
+	is_default_shipping, is_default_billing, created_at, updated_at`
+
+func scanAddress(row rowScanner) (*Address, error) {
+	var a Address
+	err := row.Scan(&a.ID, &a.UserID, &a.Label, &a.FullName, &a.Company, &a.Line1, &a.Line2, &a.City, &a.Region,
+		&a.PostalCode, &a.Country, &a.Phone, &a.DefaultShipping, &a.DefaultBilling, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// ListAddresses returns the address book of a user, defaults first.
+func (q *Query) ListAddresses(ctx context.Context, userID uuid.UUID) ([]Address, error) {
+	query := `
+		SELECT ` + addressColumns + `
+		FROM "address"
+		WHERE user_id = $1
+		ORDER BY is_default_shipping DESC, is_default_billing DESC, created_at
+	`
+	rows, err := q.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := []Address{}
+	for rows.Next() {
+		a, err := scanAddress(rows)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, *a)
+	}
+	return addresses, rows.Err()
+}
+
+// GetAddress fetches an address of a user. It returns nil if the user has no
+// such address.
+func (q *Query) GetAddress(ctx context.Context, userID, addressID uuid.UUID) (*Address, error) {
+	query := `SELECT ` + addressColumns + ` FROM "address" WHERE id = $1 AND user_id = $2`
+	a, err := scanAddress(q.DB.QueryRowContext(ctx, query, addressID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return a, err
+}
+
+// GetDefaultAddresses returns the default shipping and billing addresses of a
+// user, each nil when there is none.
+func (q *Query) GetDefaultAddresses(ctx context.Context, userID uuid.UUID) (shipping, billing *Address, err error) {
+	query := `
+		SELECT ` + addressColumns + `
+		FROM "address"
+		WHERE user_id = $1 AND (is_default_shipping OR is_default_billing)
+	`
+	rows, err := q.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAddress(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		if a.DefaultShipping {
+			shipping = a
+		}
+		if a.DefaultBilling {
+			billing = a
+		}
+	}
+	return shipping, billing, rows.Err()
+}
+
+// CreateAddress adds an address to a user's address book. The first address
+// of each kind becomes the default, and an address saved as a default takes
+// over from the previous one.
+func (q *Query) CreateAddress(ctx context.Context, a *Address) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		// Lock the user so concurrent additions cannot exceed the limit.
+		if _, err := tx.ExecContext(ctx, `SELECT 1 FROM "user" WHERE id = $1 FOR UPDATE`, a.UserID); err != nil {
+			return err
+		}
+
+		var count int
+		var hasShipping, hasBilling bool
+		query := `
+			SELECT COUNT(*), COALESCE(BOOL_OR(is_default_shipping), FALSE), COALESCE(BOOL_OR(is_default_billing), FALSE)
+			FROM "address"
+			WHERE user_id = $1
+		`
+		if err := tx.QueryRowContext(ctx, query, a.UserID).Scan(&count, &hasShipping, &hasBilling); err != nil {
+			return err
+		}
+		if count >= MaxAddressesPerUser {
+			return ErrAddressLimit
+		}
+		a.DefaultShipping = a.DefaultShipping || !hasShipping
+		a.DefaultBilling = a.DefaultBilling || !hasBilling
+
+		if err := clearDefaultAddresses(ctx, tx, a); err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO "address" (id, user_id, label, full_name, company, line1, line2, city, region, postal_code,
+				country, phone, is_default_shipping, is_default_billing, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $15)
+		`
+		_, err := tx.ExecContext(ctx, query, a.ID, a.UserID, a.Label, a.FullName, a.Company, a.Line1, a.Line2, a.City,
+			a.Region, a.PostalCode, a.Country, a.Phone, a.DefaultShipping, a.DefaultBilling, a.CreatedAt.UTC())
+		return err
+	})
+}
+
+// UpdateAddress replaces an address of a user's address book. Orders placed
+// with it keep the address they were placed with.
+func (q *Query) UpdateAddress(ctx context.Context, a *Address) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		if err := clearDefaultAddresses(ctx, tx, a); err != nil {
+			return err
+		}
+
+		query := `
+			UPDATE "address"
+			SET label = $3, full_name = $4, company = $5, line1 = $6, line2 = $7, city = $8, region = $9,
+				postal_code = $10, country = $11, phone = $12, is_default_shipping = $13, is_default_billing = $14,
+				updated_at = $15
+			WHERE id = $1 AND user_id = $2
+			RETURNING created_at
+		`
+		err := tx.QueryRowContext(ctx, query, a.ID, a.UserID, a.Label, a.FullName, a.Company, a.Line1, a.Line2, a.City,
+			a.Region, a.PostalCode, a.Country, a.Phone, a.DefaultShipping, a.DefaultBilling, a.UpdatedAt.UTC()).
+			Scan(&a.CreatedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAddressNotFound
+		}
+		return err
+	})
+}
+
+// clearDefaultAddresses removes the default flags a is about to take from the
+// other addresses of its user.
+func clearDefaultAddresses(ctx context.Context, tx *sql.Tx, a *Address) error {
+	query := `
+		UPDATE "address"
+		SET is_default_shipping = is_default_shipping AND NOT $3,
+			is_default_billing = is_default_billing AND NOT $4
+		WHERE user_id = $1 AND id <> $2 AND (($3 AND is_default_shipping) OR ($4 AND is_default_billing))
+	`
+	_, err := tx.ExecContext(ctx, query, a.UserID, a.ID, a.DefaultShipping, a.DefaultBilling)
+	return err
+}
+
+// DeleteAddress removes an address from a user's address book. Orders placed
+// with it keep their copy.
+func (q *Query) DeleteAddress(ctx context.Context, userID, addressID uuid.UUID) error {
+	res, err := q.DB.ExecContext(ctx, `DELETE FROM "address" WHERE id = $1 AND user_id = $2`, addressID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrAddressNotFound
+	}
+	return nil
+}
+
+// marshalAddress encodes an order address for a JSONB column; nil stays NULL.
+func marshalAddress(a *PostalAddress) (any, error) {
+	if a == nil {
+		return nil, nil
+	}
+	return json.Marshal(a)
+}
+
+// unmarshalAddress decodes an order address read from a JSONB column.
+func unmarshalAddress(raw []byte) (*PostalAddress, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	var a PostalAddress
+	if err := json.Unmarshal(raw, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
@@ -0,0 +1,132 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultAuditPageSize = 50
+	MaxAuditPageSize     = 200
+)
+
+// AuditEntry records one administrative change.
+type AuditEntry struct {
+	ID uuid.UUID `json:"id"`
+	// ActorID is the user who made the change, nil when it was not made by a
+	// user.
+	ActorID    *uuid.UUID      `json:"actor_id"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	Details    json.RawMessage `json:"details"`
+	IPAddress  *string         `json:"ip_address,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditFilter narrows an audit log listing. Zero values mean "no filter".
+type AuditFilter struct {
+	ActorID    *uuid.UUID
+	TargetType string
+	TargetID   string
+	Action     string
+	Limit      int
+	Cursor     string
+}
+
+type AuditPage struct {
+	Entries    []AuditEntry `json:"entries"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// CreateAuditEntry appends an entry to the audit log.
+func (q *Query) CreateAuditEntry(ctx context.Context, e *AuditEntry) error {
+	details := e.Details
+	if len(details) == 0 {
+		details = json.RawMessage("{}")
+	}
+
+	query := `
+		INSERT INTO "audit_log" (id, actor_id, action, target_type, target_id, details, ip_address, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err := q.DB.ExecContext(ctx, query, e.ID, e.ActorID, e.Action, e.TargetType, e.TargetID,
+		[]byte(details), e.IPAddress, e.CreatedAt.UTC())
+	return err
+}
+
+// ListAuditEntries returns one page of the audit log, newest first.
+func (q *Query) ListAuditEntries(ctx context.Context, f AuditFilter) (*AuditPage, error) {
+	if f.Limit <= 0 {
+		f.Limit = DefaultAuditPageSize
+	}
+	if f.Limit > MaxAuditPageSize {
+		f.Limit = MaxAuditPageSize
+	}
+
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.ActorID != nil {
+		where = append(where, "actor_id = "+arg(*f.ActorID))
+	}
+	if f.TargetType != "" {
+		where = append(where, "target_type = "+arg(f.TargetType))
+	}
+	if f.TargetID != "" {
+		where = append(where, "target_id = "+arg(f.TargetID))
+	}
+	if f.Action != "" {
+		where = append(where, "action = "+arg(f.Action))
+	}
+	if f.Cursor != "" {
+		cursor, err := decodeTimeCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, fmt.Sprintf("(created_at, id) < (%s::timestamp, %s)", arg(cursor.CreatedAt), arg(cursor.ID)))
+	}
+
+	query := `SELECT id, actor_id, action, target_type, target_id, details, ip_address, created_at FROM "audit_log"`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC LIMIT " + arg(f.Limit+1)
+
+	rows, err := q.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &AuditPage{Entries: []AuditEntry{}}
+	for rows.Next() {
+		var e AuditEntry
+		var details []byte
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.Action, &e.TargetType, &e.TargetID, &details,
+			&e.IPAddress, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Details = details
+		page.Entries = append(page.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Entries) > f.Limit {
+		page.Entries = page.Entries[:f.Limit]
+		last := page.Entries[f.Limit-1]
+		page.NextCursor = timeCursor{CreatedAt: formatTimestamp(last.CreatedAt), ID: last.ID}.encode()
+	}
+	return page, nil
+}
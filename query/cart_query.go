@@ -0,0 +1,253 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrCartEmpty        = errors.New("cart is empty")
+	ErrCartItemNotFound = errors.New("cart item not found")
+	ErrCartItemQuantity = fmt.Errorf("a cart item cannot hold more than %d units", MaxCartItemQuantity)
+)
+
+// MaxCartItemQuantity bounds the quantity of a single cart item, the same
+// bound the cart payloads put on a single request.
+const MaxCartItemQuantity = 1000
+
+type Cart struct {
+	ID        uuid.UUID       `json:"id"`
+	UserID    uuid.UUID       `json:"user_id"`
+	Items     []CartItem      `json:"items"`
+	Subtotal  decimal.Decimal `json:"subtotal"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// CartItem is a cart line joined with the live variant data, so prices and
+// stock always reflect the current catalog rather than the time of adding.
+type CartItem struct {
+	ID           uuid.UUID         `json:"id"`
+	ProductID    uuid.UUID         `json:"product_id"`
+	VariantID    uuid.UUID         `json:"variant_id"`
+	SKU          string            `json:"sku"`
+	Name         string            `json:"name"`
+	Options      map[string]string `json:"options,omitempty"`
+	UnitPrice    decimal.Decimal   `json:"unit_price"`
+	Quantity     int               `json:"quantity"`
+	LineTotal    decimal.Decimal   `json:"line_total"`
+	UnitsInStock int               `json:"units_in_stock"`
+	InStock      bool              `json:"in_stock"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// GetCart returns the user's cart, creating an empty one on first use.
+func (q *Query) GetCart(ctx context.Context, userID uuid.UUID) (*Cart, error) {
+	cart, err := upsertCart(ctx, q.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	cart.Items, err = getCartItems(ctx, q.DB, cart.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	cart.Subtotal = decimal.Zero
+	for _, item := range cart.Items {
+		cart.Subtotal = cart.Subtotal.Add(item.LineTotal)
+	}
+	return cart, nil
+}
+
+// AddCartItem adds quantity units of a product variant to the user's cart.
+// Adding a variant that is already in the cart increases its quantity, up to
+// MaxCartItemQuantity.
+func (q *Query) AddCartItem(ctx context.Context, userID uuid.UUID, variant *ProductVariant, quantity int) error {
+	cart, err := upsertCart(ctx, q.DB, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO "cart_item" (id, cart_id, product_id, variant_id, quantity)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (cart_id, variant_id)
+		DO UPDATE SET quantity = "cart_item".quantity + EXCLUDED.quantity, updated_at = CURRENT_TIMESTAMP
+		WHERE "cart_item".quantity + EXCLUDED.quantity <= $6
+	`
+	result, err := q.DB.ExecContext(ctx, query, uuid.New(), cart.ID, variant.ProductID, variant.ID, quantity, MaxCartItemQuantity)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrCartItemQuantity
+	}
+	return nil
+}
+
+// UpdateCartItemQuantity sets the quantity of a variant already in the cart.
+func (q *Query) UpdateCartItemQuantity(ctx context.Context, userID, variantID uuid.UUID, quantity int) error {
+	query := `
+		UPDATE "cart_item" ci
+		SET quantity = $1, updated_at = CURRENT_TIMESTAMP
+		FROM "cart" c
+		WHERE ci.cart_id = c.id AND c.user_id = $2 AND ci.variant_id = $3
+		RETURNING ci.id
+	`
+	var itemID uuid.UUID
+	err := q.DB.QueryRowContext(ctx, query, quantity, userID, variantID).Scan(&itemID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrCartItemNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// RemoveCartItem removes a variant from the user's cart.
+func (q *Query) RemoveCartItem(ctx context.Context, userID, variantID uuid.UUID) error {
+	query := `
+		DELETE FROM "cart_item" ci
+		USING "cart" c
+		WHERE ci.cart_id = c.id AND c.user_id = $1 AND ci.variant_id = $2
+	`
+	result, err := q.DB.ExecContext(ctx, query, userID, variantID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrCartItemNotFound
+	}
+	return nil
+}
+
+// ClearCart removes every item from the user's cart.
+func (q *Query) ClearCart(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		DELETE FROM "cart_item" ci
+		USING "cart" c
+		WHERE ci.cart_id = c.id AND c.user_id = $1
+	`
+	_, err := q.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// CheckoutCart turns the cart of order.UserID into the given order, which
+// carries everything but the items: its ID, addresses, shipping charge and
+// promotion codes. The order is placed through the same transactional path as
+// CreateOrder and the cart is emptied in the same transaction, so a failed
+// checkout leaves the cart untouched.
+func (q *Query) CheckoutCart(ctx context.Context, order *Order) (*Quote, error) {
+	var quote *Quote
+	err := q.withTx(ctx, func(tx *sql.Tx) error {
+		// Lock the cart so concurrent checkouts of the same cart serialize.
+		var cartID uuid.UUID
+		err := tx.QueryRowContext(ctx, `SELECT id FROM "cart" WHERE user_id = $1 FOR UPDATE`, order.UserID).Scan(&cartID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrCartEmpty
+			}
+			return err
+		}
+
+		items, err := getCartItems(ctx, tx, cartID)
+		if err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return ErrCartEmpty
+		}
+
+		for _, item := range items {
+			variantID := item.VariantID
+			order.Items = append(order.Items, OrderItem{
+				ProductID: item.ProductID,
+				VariantID: &variantID,
+				Quantity:  item.Quantity,
+				CreatedAt: time.Now(),
+			})
+		}
+
+		quote, err = placeOrder(ctx, tx, order)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM "cart_item" WHERE cart_id = $1`, cartID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return quote, nil
+}
+
+func upsertCart(ctx context.Context, db queryer, userID uuid.UUID) (*Cart, error) {
+	query := `
+		INSERT INTO "cart" (user_id)
+		VALUES ($1)
+		ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+		RETURNING id, user_id, created_at, updated_at
+	`
+	var cart Cart
+	err := db.QueryRowContext(ctx, query, userID).Scan(&cart.ID, &cart.UserID, &cart.CreatedAt, &cart.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &cart, nil
+}
+
+func getCartItems(ctx context.Context, db queryer, cartID uuid.UUID) ([]CartItem, error) {
+	query := `
+		SELECT ci.id, ci.product_id, ci.variant_id, v.sku, p.name, v.options, COALESCE(v.price, p.price), ci.quantity,
+			v.units_in_stock, ci.created_at, ci.updated_at
+		FROM "cart_item" ci
+		JOIN "product_variant" v ON v.id = ci.variant_id
+		JOIN "product" p ON p.id = ci.product_id
+		WHERE ci.cart_id = $1
+		ORDER BY ci.created_at, ci.id
+	`
+	rows, err := db.QueryContext(ctx, query, cartID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []CartItem{}
+	for rows.Next() {
+		var item CartItem
+		var options []byte
+		err := rows.Scan(&item.ID, &item.ProductID, &item.VariantID, &item.SKU, &item.Name, &options, &item.UnitPrice,
+			&item.Quantity, &item.UnitsInStock, &item.CreatedAt, &item.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(options, &item.Options); err != nil {
+			return nil, err
+		}
+		item.LineTotal = item.UnitPrice.Mul(decimal.NewFromInt(int64(item.Quantity)))
+		item.InStock = item.Quantity <= item.UnitsInStock
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,276 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrCategoryNotFound  = errors.New("category not found")
+	ErrCategorySlugTaken = errors.New("category slug already in use")
+	ErrCategoryCycle     = errors.New("a category cannot be moved under itself or one of its descendants")
+)
+
+type Category struct {
+	ID          uuid.UUID  `json:"id"`
+	ParentID    *uuid.UUID `json:"parent_id"`
+	Name        string     `json:"name"`
+	Slug        string     `json:"slug"`
+	Description *string    `json:"description,omitempty"`
+	Position    int        `json:"position"`
+	Children    []Category `json:"children,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// CreateCategory inserts a new category, as a child of ParentID when set.
+func (q *Query) CreateCategory(ctx context.Context, category *Category) error {
+	query := `
+		INSERT INTO "category" (id, parent_id, name, slug, description, position, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err := q.DB.ExecContext(ctx, query, category.ID, category.ParentID, category.Name, category.Slug,
+		category.Description, category.Position, category.CreatedAt, category.UpdatedAt)
+	return categoryError(err)
+}
+
+// GetCategoryByID fetches a category by its ID. It returns nil if the
+// category does not exist.
+func (q *Query) GetCategoryByID(ctx context.Context, id uuid.UUID) (*Category, error) {
+	return getCategory(ctx, q.DB, "id", id)
+}
+
+// GetCategoryBySlug fetches a category by its slug. It returns nil if the
+// category does not exist.
+func (q *Query) GetCategoryBySlug(ctx context.Context, slug string) (*Category, error) {
+	return getCategory(ctx, q.DB, "slug", slug)
+}
+
+func getCategory(ctx context.Context, db queryer, column string, value any) (*Category, error) {
+	query := `
+		SELECT id, parent_id, name, slug, description, position, created_at, updated_at
+		FROM "category"
+		WHERE ` + column + ` = $1
+	`
+	var category Category
+	err := db.QueryRowContext(ctx, query, value).Scan(&category.ID, &category.ParentID, &category.Name,
+		&category.Slug, &category.Description, &category.Position, &category.CreatedAt, &category.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &category, nil
+}
+
+// GetCategoryTree returns every category arranged as a forest of top-level
+// categories, with siblings ordered by position and then name.
+func (q *Query) GetCategoryTree(ctx context.Context) ([]Category, error) {
+	query := `
+		SELECT id, parent_id, name, slug, description, position, created_at, updated_at
+		FROM "category"
+		ORDER BY position, name
+	`
+	rows, err := q.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []Category
+	for rows.Next() {
+		var category Category
+		err := rows.Scan(&category.ID, &category.ParentID, &category.Name, &category.Slug,
+			&category.Description, &category.Position, &category.CreatedAt, &category.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	children := make(map[uuid.UUID][]Category)
+	var roots []Category
+	for _, category := range categories {
+		if category.ParentID == nil {
+			roots = append(roots, category)
+		} else {
+			children[*category.ParentID] = append(children[*category.ParentID], category)
+		}
+	}
+
+	var attach func(nodes []Category) []Category
+	attach = func(nodes []Category) []Category {
+		for i := range nodes {
+			nodes[i].Children = attach(children[nodes[i].ID])
+		}
+		return nodes
+	}
+	tree := attach(roots)
+	if tree == nil {
+		tree = []Category{}
+	}
+	return tree, nil
+}
+
+// UpdateCategory saves a category's details. Changing ParentID moves the
+// category together with its whole subtree; moves that would create a cycle,
+// and so detach the subtree from the tree, are rejected.
+func (q *Query) UpdateCategory(ctx context.Context, category *Category) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		// Serialize tree changes so two concurrent moves cannot form a cycle
+		// that neither of them would see on its own.
+		if _, err := tx.ExecContext(ctx, `LOCK TABLE "category" IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+			return err
+		}
+
+		if category.ParentID != nil {
+			var isDescendant bool
+			query := `
+				WITH RECURSIVE subtree AS (
+					SELECT id FROM "category" WHERE id = $1
+					UNION ALL
+					SELECT c.id FROM "category" c JOIN subtree s ON c.parent_id = s.id
+				)
+				SELECT EXISTS (SELECT 1 FROM subtree WHERE id = $2)
+			`
+			if err := tx.QueryRowContext(ctx, query, category.ID, *category.ParentID).Scan(&isDescendant); err != nil {
+				return err
+			}
+			if isDescendant {
+				return ErrCategoryCycle
+			}
+		}
+
+		query := `
+			UPDATE "category"
+			SET parent_id = $1, name = $2, slug = $3, description = $4, position = $5, updated_at = $6
+			WHERE id = $7
+		`
+		result, err := tx.ExecContext(ctx, query, category.ParentID, category.Name, category.Slug,
+			category.Description, category.Position, category.UpdatedAt, category.ID)
+		if err != nil {
+			return categoryError(err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrCategoryNotFound
+		}
+		return nil
+	})
+}
+
+// DeleteCategory removes a category. Its children move up to the deleted
+// category's parent, so no category is ever left orphaned.
+func (q *Query) DeleteCategory(ctx context.Context, id uuid.UUID) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `LOCK TABLE "category" IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+			return err
+		}
+
+		category, err := getCategory(ctx, tx, "id", id)
+		if err != nil {
+			return err
+		}
+		if category == nil {
+			return ErrCategoryNotFound
+		}
+
+		query := `
+			UPDATE "category"
+			SET parent_id = $1, updated_at = CURRENT_TIMESTAMP
+			WHERE parent_id = $2
+		`
+		if _, err := tx.ExecContext(ctx, query, category.ParentID, id); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM "category" WHERE id = $1`, id)
+		return err
+	})
+}
+
+// SetProductCategories replaces the categories a product belongs to.
+func (q *Query) SetProductCategories(ctx context.Context, productID uuid.UUID, categoryIDs []uuid.UUID) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		// Locking the product keeps it from being deleted meanwhile, so a
+		// foreign key violation below can only mean an unknown category.
+		var id uuid.UUID
+		err := tx.QueryRowContext(ctx, `SELECT id FROM "product" WHERE id = $1 FOR SHARE`, productID).Scan(&id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrProductNotFound
+			}
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM "product_category" WHERE product_id = $1`, productID); err != nil {
+			return err
+		}
+
+		for _, categoryID := range categoryIDs {
+			query := `
+				INSERT INTO "product_category" (product_id, category_id)
+				VALUES ($1, $2)
+				ON CONFLICT DO NOTHING
+			`
+			if _, err := tx.ExecContext(ctx, query, productID, categoryID); err != nil {
+				return categoryError(err)
+			}
+		}
+		return nil
+	})
+}
+
+// GetProductCategories returns the categories a product is directly linked to.
+func (q *Query) GetProductCategories(ctx context.Context, productID uuid.UUID) ([]Category, error) {
+	query := `
+		SELECT c.id, c.parent_id, c.name, c.slug, c.description, c.position, c.created_at, c.updated_at
+		FROM "category" c
+		JOIN "product_category" pc ON pc.category_id = c.id
+		WHERE pc.product_id = $1
+		ORDER BY c.position, c.name
+	`
+	rows, err := q.DB.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		var category Category
+		err := rows.Scan(&category.ID, &category.ParentID, &category.Name, &category.Slug,
+			&category.Description, &category.Position, &category.CreatedAt, &category.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+// categoryError translates constraint violations into the category errors.
+func categoryError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "23505": // unique_violation
+			return ErrCategorySlugTaken
+		case "23503": // foreign_key_violation
+			return ErrCategoryNotFound
+		}
+	}
+	return err
+}
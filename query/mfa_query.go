@@ -0,0 +1,200 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrMFANotStarted       = errors.New("two-factor authentication setup has not been started")
+	ErrMFACodeUsed         = errors.New("authentication code was already used")
+	ErrInvalidRecoveryCode = errors.New("invalid recovery code")
+)
+
+// MFA is the TOTP second factor of a user.
+type MFA struct {
+	UserID         uuid.UUID
+	Secret         string
+	EnabledAt      *time.Time
+	LastUsedStep   int64
+	FailedAttempts int
+	LockedUntil    *time.Time
+	CreatedAt      time.Time
+}
+
+// Enabled reports whether the second factor is required at login.
+func (m *MFA) Enabled() bool {
+	return m != nil && m.EnabledAt != nil
+}
+
+// GetMFA returns the second factor of a user, or nil if they never set one up.
+func (q *Query) GetMFA(ctx context.Context, userID uuid.UUID) (*MFA, error) {
+	query := `
+		SELECT user_id, secret, enabled_at, last_used_step, failed_attempts, locked_until, created_at
+		FROM "user_mfa"
+		WHERE user_id = $1
+	`
+	var m MFA
+	err := q.DB.QueryRowContext(ctx, query, userID).Scan(
+		&m.UserID,
+		&m.Secret,
+		&m.EnabledAt,
+		&m.LastUsedStep,
+		&m.FailedAttempts,
+		&m.LockedUntil,
+		&m.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// StartMFAEnrollment stores a new, not yet enabled secret for a user,
+// replacing any earlier unfinished setup.
+func (q *Query) StartMFAEnrollment(ctx context.Context, userID uuid.UUID, secret string) error {
+	query := `
+		INSERT INTO "user_mfa" (user_id, secret, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, failed_attempts = 0,
+			locked_until = NULL, created_at = EXCLUDED.created_at
+		WHERE "user_mfa".enabled_at IS NULL
+	`
+	res, err := q.DB.ExecContext(ctx, query, userID, secret, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+	return nil
+}
+
+// EnableMFA turns on the pending second factor of a user once a code from it
+// was checked, and replaces their recovery codes.
+func (q *Query) EnableMFA(ctx context.Context, userID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE "user_mfa"
+			SET enabled_at = $2, last_used_step = $3, failed_attempts = 0
+			WHERE user_id = $1 AND enabled_at IS NULL
+		`
+		res, err := tx.ExecContext(ctx, query, userID, time.Now().UTC(), step)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrMFANotStarted
+		}
+		return replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes)
+	})
+}
+
+// DisableMFA removes the second factor and recovery codes of a user.
+func (q *Query) DisableMFA(ctx context.Context, userID uuid.UUID) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM "user_mfa" WHERE user_id = $1 AND enabled_at IS NOT NULL`, userID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrMFANotEnabled
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM "user_recovery_code" WHERE user_id = $1`, userID)
+		return err
+	})
+}
+
+// UseMFAStep records that the code of a time step was accepted. It fails with
+// ErrMFACodeUsed if that step, or a later one, was used already.
+func (q *Query) UseMFAStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	query := `
+		UPDATE "user_mfa"
+		SET last_used_step = $2, failed_attempts = 0
+		WHERE user_id = $1 AND last_used_step < $2
+	`
+	res, err := q.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMFACodeUsed
+	}
+	return nil
+}
+
+// RecordMFAFailure counts a wrong code. When maxAttempts is reached the second
+// factor is locked until lockedUntil and the count starts over.
+func (q *Query) RecordMFAFailure(ctx context.Context, userID uuid.UUID, maxAttempts int, lockedUntil time.Time) error {
+	query := `
+		UPDATE "user_mfa"
+		SET failed_attempts = CASE WHEN failed_attempts + 1 >= $2 THEN 0 ELSE failed_attempts + 1 END,
+			locked_until = CASE WHEN failed_attempts + 1 >= $2 THEN $3 ELSE locked_until END
+		WHERE user_id = $1
+	`
+	_, err := q.DB.ExecContext(ctx, query, userID, maxAttempts, lockedUntil.UTC())
+	return err
+}
+
+// UseRecoveryCode marks an unused recovery code of a user as used.
+func (q *Query) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE "user_recovery_code"
+			SET used_at = $3
+			WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+		`
+		res, err := tx.ExecContext(ctx, query, userID, codeHash, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrInvalidRecoveryCode
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE "user_mfa" SET failed_attempts = 0 WHERE user_id = $1`, userID)
+		return err
+	})
+}
+
+// ReplaceRecoveryCodes swaps every recovery code of a user for new ones.
+func (q *Query) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		return replaceRecoveryCodes(ctx, tx, userID, codeHashes)
+	})
+}
+
+// CountRecoveryCodes returns how many recovery codes of a user are unused.
+func (q *Query) CountRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error) {
+	query := `SELECT COUNT(*) FROM "user_recovery_code" WHERE user_id = $1 AND used_at IS NULL`
+	var count int
+	err := q.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID uuid.UUID, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM "user_recovery_code" WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO "user_recovery_code" (id, user_id, code_hash, created_at)
+		VALUES ($1, $2, $3, $4)
+	`
+	now := time.Now().UTC()
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, query, uuid.New(), userID, hash, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
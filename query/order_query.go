@@ -3,60 +3,220 @@ package query
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
 	"time"
 
+	"github.com/amosehiguese/ecommerce-api/pkg/promotion"
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 )
 
 type Order struct {
-	ID          uuid.UUID       `json:"id" validate:"required,uuid4"`
-	UserID      uuid.UUID       `json:"user_id" validate:"required,uuid4"`
-	TotalAmount decimal.Decimal `json:"total_amount" validate:"required,gt=0"`
-	Status      string          `json:"status" validate:"required,oneof=pending completed cancelled"`
-	Items       []OrderItem     `json:"items" validate:"dive"`
-	CreatedAt   time.Time       `json:"created_at" validate:"required"`
-	UpdatedAt   time.Time       `json:"updated_at" validate:"required"`
+	ID     uuid.UUID `json:"id" validate:"required,uuid4"`
+	UserID uuid.UUID `json:"user_id" validate:"required,uuid4"`
+	// TotalAmount is SubtotalAmount less DiscountAmount plus ShippingAmount.
+	SubtotalAmount decimal.Decimal `json:"subtotal_amount"`
+	DiscountAmount decimal.Decimal `json:"discount_amount"`
+	ShippingAmount decimal.Decimal `json:"shipping_amount"`
+	TotalAmount    decimal.Decimal `json:"total_amount" validate:"required,gt=0"`
+	Status         string          `json:"status" validate:"required,oneof=pending paid fulfilling shipped delivered cancelled refunded disputed partially_refunded"`
+	Items          []OrderItem     `json:"items" validate:"dive"`
+	// ShippingAddress and BillingAddress are copies of the addresses the
+	// order was placed with. Orders placed before addresses existed have none.
+	ShippingAddress *PostalAddress  `json:"shipping_address,omitempty"`
+	BillingAddress  *PostalAddress  `json:"billing_address,omitempty"`
+	Discounts       []OrderDiscount `json:"discounts"`
+	// PromotionCodes are the codes the order is placed with.
+	PromotionCodes []string  `json:"-"`
+	CreatedAt      time.Time `json:"created_at" validate:"required"`
+	UpdatedAt      time.Time `json:"updated_at" validate:"required"`
 }
 
 type OrderItem struct {
 	ID        uuid.UUID       `json:"id" validate:"required,uuid4"`
 	OrderID   uuid.UUID       `json:"order_id" validate:"required,uuid4"`
 	ProductID uuid.UUID       `json:"product_id" validate:"required,uuid4"`
+	VariantID *uuid.UUID      `json:"variant_id"`
+	SKU       string          `json:"sku"`
 	Quantity  int             `json:"quantity" validate:"required,gt=0"`
 	Price     decimal.Decimal `json:"price" validate:"required,gt=0"`
-	CreatedAt time.Time       `json:"created_at" validate:"required"`
+	// DiscountAmount is the share of the line in the discounts of the order.
+	DiscountAmount decimal.Decimal `json:"discount_amount"`
+	CreatedAt      time.Time       `json:"created_at" validate:"required"`
 }
 
-func (q *Query) CreateOrder(ctx context.Context, order *Order) (string, error) {
-	var orderID uuid.UUID
+// OrderDiscount is a discount applied to an order, as it was applied.
+// PromotionID is nil once the promotion has been deleted.
+type OrderDiscount struct {
+	ID          uuid.UUID       `json:"id"`
+	PromotionID *uuid.UUID      `json:"promotion_id"`
+	Code        string          `json:"code"`
+	Type        promotion.Type  `json:"type"`
+	Description *string         `json:"description,omitempty"`
+	Amount      decimal.Decimal `json:"amount"`
+}
+
+var (
+	ErrProductNotFound   = errors.New("product not found")
+	ErrInsufficientStock = errors.New("insufficient stock")
+)
+
+// CreateOrder places the order and its items in a single transaction. The
+// variant rows referenced by the order are locked, every line is priced from
+// the locked rows and their stock is decremented, so two concurrent orders can
+// never sell the same unit twice. If any item is short on stock the whole order
+// is rolled back. The order's promotion codes are applied and recorded with it.
+// The returned quote is the price breakdown that was charged.
+func (q *Query) CreateOrder(ctx context.Context, order *Order) (*Quote, error) {
+	var quote *Quote
+	err := q.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		quote, err = placeOrder(ctx, tx, order)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return quote, nil
+}
+
+func placeOrder(ctx context.Context, tx *sql.Tx, order *Order) (*Quote, error) {
+	catalog, err := loadCatalog(ctx, tx, order.Items, true)
+	if err != nil {
+		return nil, err
+	}
+
+	quote, err := priceOrder(order, catalog)
+	if err != nil {
+		return nil, err
+	}
+	if err := reserveStock(ctx, tx, order.Items, catalog); err != nil {
+		return nil, err
+	}
+	if err := discountOrder(ctx, tx, order, quote, true); err != nil {
+		return nil, err
+	}
+
+	shipping, err := marshalAddress(order.ShippingAddress)
+	if err != nil {
+		return nil, err
+	}
+	billing, err := marshalAddress(order.BillingAddress)
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
-        INSERT INTO "order" (user_id, total_amount)
-        VALUES ($1, $2)
-        RETURNING id;
+        INSERT INTO "order" (id, user_id, subtotal_amount, discount_amount, shipping_amount, total_amount, shipping_address, billing_address)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        RETURNING status, created_at, updated_at;
     `
-	err := q.DB.QueryRowContext(ctx, query, order.UserID, order.TotalAmount).Scan(&orderID)
+	err = tx.QueryRowContext(ctx, query, order.ID, order.UserID, order.SubtotalAmount, order.DiscountAmount,
+		order.ShippingAmount, order.TotalAmount, shipping, billing).
+		Scan(&order.Status, &order.CreatedAt, &order.UpdatedAt)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	for i := range order.Items {
+		item := &order.Items[i]
+		item.ID = uuid.New()
+		item.OrderID = order.ID
+
+		query = `
+            INSERT INTO "order_item" (id, order_id, product_id, variant_id, sku, quantity, price, discount_amount, created_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);
+        `
+		_, err := tx.ExecContext(ctx, query, item.ID, item.OrderID, item.ProductID, item.VariantID, item.SKU, item.Quantity,
+			item.Price, item.DiscountAmount, item.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
 	}
-	for _, item := range order.Items {
+
+	order.Discounts = []OrderDiscount{}
+	for _, d := range quote.Discounts {
+		discount := OrderDiscount{ID: uuid.New(), PromotionID: &d.PromotionID, Code: d.Code, Type: d.Type, Amount: d.Amount}
+		if d.Description != "" {
+			discount.Description = &d.Description
+		}
+
 		query = `
-            INSERT INTO "order_item" (id, order_id, product_id, quantity, price, created_at)
-            VALUES ($1, $2, $3, $4, $5, $6);
+            INSERT INTO "order_discount" (id, order_id, promotion_id, code, type, description, amount)
+            VALUES ($1, $2, $3, $4, $5, $6, $7);
         `
-		_, err := q.DB.ExecContext(ctx, query, uuid.New(), orderID, item.ProductID, item.Quantity, item.Price, item.CreatedAt)
+		_, err := tx.ExecContext(ctx, query, discount.ID, order.ID, discount.PromotionID, discount.Code, discount.Type,
+			discount.Description, discount.Amount)
 		if err != nil {
-			return "", err
+			return nil, err
+		}
+		order.Discounts = append(order.Discounts, discount)
+	}
+
+	if err := recordOrderStatus(ctx, tx, order.ID, nil, OrderPending, &order.UserID, "order placed"); err != nil {
+		return nil, err
+	}
+	return quote, nil
+}
+
+// reserveStock decrements the stock of the variants in items. The catalog must
+// have been loaded with its rows locked for update. Rows are updated in the
+// order they were locked in, since every update also touches the product row
+// that holds the stock total.
+func reserveStock(ctx context.Context, tx *sql.Tx, items []OrderItem, catalog map[uuid.UUID]catalogEntry) error {
+	requested, err := checkStock(items, catalog)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]uuid.UUID, 0, len(requested))
+	for id := range requested {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := catalog[ids[i]].ProductID.String(), catalog[ids[j]].ProductID.String()
+		if a != b {
+			return a < b
+		}
+		return ids[i].String() < ids[j].String()
+	})
+
+	for _, id := range ids {
+		query := `
+            UPDATE "product_variant"
+            SET units_in_stock = units_in_stock - $1, updated_at = CURRENT_TIMESTAMP
+            WHERE id = $2;
+        `
+		if _, err := tx.ExecContext(ctx, query, requested[id], id); err != nil {
+			return err
 		}
 	}
-	return orderID.String(), nil
+	return nil
+}
+
+// checkStock returns the units requested of each variant in items, and fails
+// with ErrInsufficientStock if the catalog does not have them.
+func checkStock(items []OrderItem, catalog map[uuid.UUID]catalogEntry) (map[uuid.UUID]int, error) {
+	requested := make(map[uuid.UUID]int)
+	for _, item := range items {
+		requested[*item.VariantID] += item.Quantity
+	}
+	for id, quantity := range requested {
+		entry := catalog[id]
+		if quantity > entry.UnitsInStock {
+			return nil, fmt.Errorf("%w: %s has %d units, %d requested", ErrInsufficientStock, entry.SKU, entry.UnitsInStock, quantity)
+		}
+	}
+	return requested, nil
 }
 
 func (q *Query) GetOrdersByUserID(ctx context.Context, userID uuid.UUID) ([]Order, error) {
 	var orders []Order
 
 	query := `
-        SELECT id, user_id, status, total_amount, created_at
+        SELECT ` + orderColumns + `
         FROM "order"
         WHERE user_id = $1
         ORDER BY created_at DESC;
@@ -68,71 +228,122 @@ func (q *Query) GetOrdersByUserID(ctx context.Context, userID uuid.UUID) ([]Orde
 	defer rows.Close()
 
 	for rows.Next() {
-		var order Order
-		order.Items = []OrderItem{}
-
-		err = rows.Scan(&order.ID, &order.UserID, &order.Status, &order.TotalAmount, &order.CreatedAt)
+		order, err := scanOrder(rows)
 		if err != nil {
 			return nil, err
 		}
+		orders = append(orders, *order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-		itemsQuery := `
-			SELECT id, order_id, product_id, quantity, price, created_at
-			FROM "order_item"
-			WHERE order_id = $1;
-		`
-		itemsRows, err := q.DB.QueryContext(ctx, itemsQuery, order.ID)
+	for i := range orders {
+		orders[i].Items, err = getOrderItems(ctx, q.DB, orders[i].ID)
 		if err != nil {
 			return nil, err
 		}
-		defer itemsRows.Close()
-
-		for itemsRows.Next() {
-			var item OrderItem
-			err = itemsRows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.Quantity, &item.Price, &item.CreatedAt)
-			if err != nil {
-				return nil, err
-			}
-			order.Items = append(order.Items, item)
+		orders[i].Discounts, err = getOrderDiscounts(ctx, q.DB, orders[i].ID)
+		if err != nil {
+			return nil, err
 		}
-
-		orders = append(orders, order)
 	}
 
 	return orders, nil
 }
 
-func (q *Query) CancelOrderIfPending(ctx context.Context, orderID uuid.UUID) error {
+// GetOrderByID fetches an order and its items. It returns nil if the order
+// does not exist.
+func (q *Query) GetOrderByID(ctx context.Context, orderID uuid.UUID) (*Order, error) {
 	query := `
-        UPDATE "order"
-        SET status = 'cancelled', updated_at = CURRENT_TIMESTAMP
-        WHERE id = $1 AND status = 'pending'
-        RETURNING id;
+        SELECT ` + orderColumns + `
+        FROM "order"
+        WHERE id = $1;
     `
-	var updatedOrderID string
-	err := q.DB.QueryRowContext(ctx, query, orderID).Scan(&updatedOrderID)
+	order, err := scanOrder(q.DB.QueryRowContext(ctx, query, orderID))
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
 		}
-		return err
+		return nil, err
 	}
-	return nil
+
+	order.Items, err = getOrderItems(ctx, q.DB, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	order.Discounts, err = getOrderDiscounts(ctx, q.DB, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
 }
-func (q *Query) UpdateOrderStatus(ctx context.Context, orderID uuid.UUID, newStatus string) error {
+
+const orderColumns = `id, user_id, status, subtotal_amount, discount_amount, shipping_amount, total_amount,
+        shipping_address, billing_address, created_at, updated_at`
+
+func scanOrder(row rowScanner) (*Order, error) {
+	var order Order
+	var shipping, billing []byte
+	err := row.Scan(&order.ID, &order.UserID, &order.Status, &order.SubtotalAmount, &order.DiscountAmount,
+		&order.ShippingAmount, &order.TotalAmount, &shipping, &billing, &order.CreatedAt, &order.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if order.ShippingAddress, err = unmarshalAddress(shipping); err != nil {
+		return nil, err
+	}
+	if order.BillingAddress, err = unmarshalAddress(billing); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+func getOrderItems(ctx context.Context, db queryer, orderID uuid.UUID) ([]OrderItem, error) {
 	query := `
-        UPDATE "order"
-        SET status = $1, updated_at = CURRENT_TIMESTAMP
-        WHERE id = $2
-        RETURNING id;
+        SELECT id, order_id, product_id, variant_id, sku, quantity, price, discount_amount, created_at
+        FROM "order_item"
+        WHERE order_id = $1;
     `
-	var updatedOrderID string
-	err := q.DB.QueryRowContext(ctx, query, newStatus, orderID).Scan(&updatedOrderID)
+	rows, err := db.QueryContext(ctx, query, orderID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []OrderItem{}
+	for rows.Next() {
+		var item OrderItem
+		err = rows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.VariantID, &item.SKU, &item.Quantity, &item.Price,
+			&item.DiscountAmount, &item.CreatedAt)
+		if err != nil {
+			return nil, err
 		}
-		return err
+		items = append(items, item)
 	}
-	return nil
+	return items, rows.Err()
+}
+
+func getOrderDiscounts(ctx context.Context, db queryer, orderID uuid.UUID) ([]OrderDiscount, error) {
+	query := `
+        SELECT id, promotion_id, code, type, description, amount
+        FROM "order_discount"
+        WHERE order_id = $1
+        ORDER BY created_at, id;
+    `
+	rows, err := db.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	discounts := []OrderDiscount{}
+	for rows.Next() {
+		var d OrderDiscount
+		if err := rows.Scan(&d.ID, &d.PromotionID, &d.Code, &d.Type, &d.Description, &d.Amount); err != nil {
+			return nil, err
+		}
+		discounts = append(discounts, d)
+	}
+	return discounts, rows.Err()
 }
@@ -0,0 +1,238 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type OrderStatus string
+
+func (s OrderStatus) String() string {
+	return string(s)
+}
+
+const (
+	OrderPending    OrderStatus = "pending"
+	OrderPaid       OrderStatus = "paid"
+	OrderFulfilling OrderStatus = "fulfilling"
+	OrderShipped    OrderStatus = "shipped"
+	OrderDelivered  OrderStatus = "delivered"
+	OrderCancelled  OrderStatus = "cancelled"
+	OrderRefunded   OrderStatus = "refunded"
+	// OrderDisputed is an order whose payment the customer disputed with
+	// their bank. It returns to its previous status if the dispute is won.
+	OrderDisputed OrderStatus = "disputed"
+	// OrderPartiallyRefunded is an order that got part of its money back. It
+	// can still be fulfilled, and refunded further.
+	OrderPartiallyRefunded OrderStatus = "partially_refunded"
+)
+
+// orderTransitions is the order lifecycle. Every status change goes through
+// TransitionOrderStatus, which only allows the moves listed here.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderPending:           {OrderPaid, OrderCancelled},
+	OrderPaid:              {OrderFulfilling, OrderCancelled, OrderRefunded, OrderPartiallyRefunded, OrderDisputed},
+	OrderFulfilling:        {OrderShipped, OrderCancelled, OrderRefunded, OrderPartiallyRefunded, OrderDisputed},
+	OrderShipped:           {OrderDelivered, OrderRefunded, OrderPartiallyRefunded, OrderDisputed},
+	OrderDelivered:         {OrderRefunded, OrderPartiallyRefunded, OrderDisputed},
+	OrderPartiallyRefunded: {OrderFulfilling, OrderShipped, OrderDelivered, OrderRefunded, OrderDisputed},
+	OrderDisputed:          {OrderPaid, OrderFulfilling, OrderShipped, OrderDelivered, OrderPartiallyRefunded, OrderRefunded},
+	OrderCancelled:         {},
+	OrderRefunded:          {},
+}
+
+// AllowedTransitions returns the statuses an order can move to from s.
+func (s OrderStatus) AllowedTransitions() []OrderStatus {
+	return orderTransitions[s]
+}
+
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range orderTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+var ErrOrderNotFound = errors.New("order not found")
+
+// InvalidTransitionError is returned when a status change is not allowed by
+// the order lifecycle.
+type InvalidTransitionError struct {
+	From    OrderStatus
+	To      OrderStatus
+	Allowed []OrderStatus
+}
+
+func (e *InvalidTransitionError) Error() string {
+	allowed := make([]string, 0, len(e.Allowed))
+	for _, s := range e.Allowed {
+		allowed = append(allowed, s.String())
+	}
+	if len(allowed) == 0 {
+		return fmt.Sprintf("cannot change order status from %s to %s: %s is final", e.From, e.To, e.From)
+	}
+	return fmt.Sprintf("cannot change order status from %s to %s, allowed: %s", e.From, e.To, strings.Join(allowed, ", "))
+}
+
+// UnexpectedStatusError is returned when a status change only applies to
+// orders in a given status and the order is no longer in it.
+type UnexpectedStatusError struct {
+	Expected OrderStatus
+	Actual   OrderStatus
+	To       OrderStatus
+}
+
+func (e *UnexpectedStatusError) Error() string {
+	return fmt.Sprintf("only %s orders can be changed to %s, this order is %s", e.Expected, e.To, e.Actual)
+}
+
+type OrderStatusChange struct {
+	ID         uuid.UUID    `json:"id"`
+	OrderID    uuid.UUID    `json:"order_id"`
+	FromStatus *OrderStatus `json:"from_status"`
+	ToStatus   OrderStatus  `json:"to_status"`
+	ChangedBy  *uuid.UUID   `json:"changed_by"`
+	Reason     *string      `json:"reason,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+}
+
+// TransitionOrderStatus moves an order to a new status and records who made
+// the change and why. changedBy is nil for changes made by the system.
+func (q *Query) TransitionOrderStatus(ctx context.Context, orderID uuid.UUID, to OrderStatus, changedBy *uuid.UUID, reason string) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		return transitionOrderStatus(ctx, tx, orderID, to, changedBy, reason)
+	})
+}
+
+// TransitionOrderStatusFrom is TransitionOrderStatus for changes that only
+// apply while the order is in the expected status. The status is checked
+// under the same row lock as the change, so a payment landing in between
+// makes it fail with an UnexpectedStatusError.
+func (q *Query) TransitionOrderStatusFrom(ctx context.Context, orderID uuid.UUID, expected, to OrderStatus, changedBy *uuid.UUID, reason string) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		return transitionOrderStatusFrom(ctx, tx, orderID, expected, to, changedBy, reason)
+	})
+}
+
+func transitionOrderStatus(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, to OrderStatus, changedBy *uuid.UUID, reason string) error {
+	return transitionOrderStatusFrom(ctx, tx, orderID, "", to, changedBy, reason)
+}
+
+// transitionOrderStatusFrom moves the order to a new status if the lifecycle
+// allows it. An empty expected status accepts the order in any status.
+func transitionOrderStatusFrom(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, expected, to OrderStatus, changedBy *uuid.UUID, reason string) error {
+	var from OrderStatus
+	err := tx.QueryRowContext(ctx, `SELECT status FROM "order" WHERE id = $1 FOR UPDATE`, orderID).Scan(&from)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrOrderNotFound
+		}
+		return err
+	}
+
+	if expected != "" && from != expected {
+		return &UnexpectedStatusError{Expected: expected, Actual: from, To: to}
+	}
+	if !from.CanTransitionTo(to) {
+		return &InvalidTransitionError{From: from, To: to, Allowed: from.AllowedTransitions()}
+	}
+
+	query := `
+        UPDATE "order"
+        SET status = $1, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $2;
+    `
+	if _, err := tx.ExecContext(ctx, query, to, orderID); err != nil {
+		return err
+	}
+
+	// Cancelled orders have not shipped, so their units go back on the shelf.
+	// Lines whose variant has since been removed have nothing to restock, and
+	// units a refund restocked already are not restocked again.
+	if to == OrderCancelled {
+		if err := restockOrder(ctx, tx, orderID); err != nil {
+			return err
+		}
+	}
+
+	return recordOrderStatus(ctx, tx, orderID, &from, to, changedBy, reason)
+}
+
+// restockOrder puts the units of an order back on its variants. The variants
+// are locked in the same order placeOrder locks them in.
+func restockOrder(ctx context.Context, tx *sql.Tx, orderID uuid.UUID) error {
+	query := `
+        SELECT v.id
+        FROM "product_variant" v
+        WHERE v.id IN (SELECT variant_id FROM "order_item" WHERE order_id = $1)
+        ORDER BY v.product_id, v.id
+        FOR UPDATE;
+    `
+	if _, err := tx.ExecContext(ctx, query, orderID); err != nil {
+		return err
+	}
+
+	query = `
+        UPDATE "product_variant" v
+        SET units_in_stock = v.units_in_stock + oi.quantity, updated_at = CURRENT_TIMESTAMP
+        FROM (
+            SELECT i.variant_id, SUM(i.quantity - COALESCE(r.quantity, 0)) AS quantity
+            FROM "order_item" i
+            LEFT JOIN (
+                SELECT ri.order_item_id, SUM(ri.quantity) AS quantity
+                FROM "refund_item" ri
+                JOIN "refund" r ON r.id = ri.refund_id
+                WHERE r.order_id = $1 AND r.restock AND r.status = 'succeeded'
+                GROUP BY ri.order_item_id
+            ) r ON r.order_item_id = i.id
+            WHERE i.order_id = $1 AND i.variant_id IS NOT NULL
+            GROUP BY i.variant_id
+        ) oi
+        WHERE v.id = oi.variant_id AND oi.quantity > 0;
+    `
+	_, err := tx.ExecContext(ctx, query, orderID)
+	return err
+}
+
+func recordOrderStatus(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, from *OrderStatus, to OrderStatus, changedBy *uuid.UUID, reason string) error {
+	query := `
+        INSERT INTO "order_status_history" (id, order_id, from_status, to_status, changed_by, reason)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''));
+    `
+	_, err := tx.ExecContext(ctx, query, uuid.New(), orderID, from, to, changedBy, reason)
+	return err
+}
+
+// GetOrderStatusHistory returns every status change of an order, oldest first.
+func (q *Query) GetOrderStatusHistory(ctx context.Context, orderID uuid.UUID) ([]OrderStatusChange, error) {
+	query := `
+        SELECT id, order_id, from_status, to_status, changed_by, reason, created_at
+        FROM "order_status_history"
+        WHERE order_id = $1
+        ORDER BY created_at, id;
+    `
+	rows, err := q.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []OrderStatusChange{}
+	for rows.Next() {
+		var change OrderStatusChange
+		err := rows.Scan(&change.ID, &change.OrderID, &change.FromStatus, &change.ToStatus, &change.ChangedBy, &change.Reason, &change.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
@@ -0,0 +1,141 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// paymentEventClaimTTL is how long a delivery may take to process an event
+// before another delivery of it may try again.
+const paymentEventClaimTTL = 5 * time.Minute
+
+// PaymentEvent is a webhook event received from a payment provider, stored as
+// it arrived.
+type PaymentEvent struct {
+	ID       uuid.UUID `json:"id"`
+	Provider string    `json:"provider"`
+	// EventID is the provider's ID of the event.
+	EventID     string          `json:"event_id"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	ProcessedAt *time.Time      `json:"processed_at,omitempty"`
+	Attempts    int             `json:"attempts"`
+	LastError   *string         `json:"last_error,omitempty"`
+}
+
+const paymentEventColumns = `id, provider, event_id, type, payload, received_at, processed_at, attempts, last_error`
+
+func scanPaymentEvent(row rowScanner) (*PaymentEvent, error) {
+	var e PaymentEvent
+	var payload []byte
+	err := row.Scan(&e.ID, &e.Provider, &e.EventID, &e.Type, &payload, &e.ReceivedAt, &e.ProcessedAt, &e.Attempts, &e.LastError)
+	if err != nil {
+		return nil, err
+	}
+	e.Payload = payload
+	return &e, nil
+}
+
+// RecordPaymentEvent stores a received event. If the provider delivered the
+// event before, the stored event is returned instead and created is false.
+func (q *Query) RecordPaymentEvent(ctx context.Context, e *PaymentEvent) (stored *PaymentEvent, created bool, err error) {
+	query := `
+		INSERT INTO "payment_event" (id, provider, event_id, type, payload, received_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (provider, event_id) DO NOTHING
+		RETURNING ` + paymentEventColumns
+	stored, err = scanPaymentEvent(q.DB.QueryRowContext(ctx, query, e.ID, e.Provider, e.EventID, e.Type,
+		[]byte(e.Payload), time.Now()))
+	if err == nil {
+		return stored, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, err
+	}
+
+	query = `SELECT ` + paymentEventColumns + ` FROM "payment_event" WHERE provider = $1 AND event_id = $2`
+	stored, err = scanPaymentEvent(q.DB.QueryRowContext(ctx, query, e.Provider, e.EventID))
+	return stored, false, err
+}
+
+// GetPaymentEvent fetches a stored event. It returns nil if there is none.
+func (q *Query) GetPaymentEvent(ctx context.Context, id uuid.UUID) (*PaymentEvent, error) {
+	query := `SELECT ` + paymentEventColumns + ` FROM "payment_event" WHERE id = $1`
+	e, err := scanPaymentEvent(q.DB.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return e, err
+}
+
+// ListPaymentEvents returns the most recently received events, newest first.
+// With unprocessedOnly it only returns events that have not been processed
+// successfully.
+func (q *Query) ListPaymentEvents(ctx context.Context, unprocessedOnly bool, limit int) ([]PaymentEvent, error) {
+	query := `
+		SELECT ` + paymentEventColumns + `
+		FROM "payment_event"
+		WHERE NOT $1 OR processed_at IS NULL
+		ORDER BY received_at DESC, id DESC
+		LIMIT $2
+	`
+	rows, err := q.DB.QueryContext(ctx, query, unprocessedOnly, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []PaymentEvent{}
+	for rows.Next() {
+		e, err := scanPaymentEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *e)
+	}
+	return events, rows.Err()
+}
+
+// ClaimPaymentEvent reserves an event for processing. It returns false if
+// another delivery is processing it, or, unless force is set, if it has been
+// processed already.
+func (q *Query) ClaimPaymentEvent(ctx context.Context, id uuid.UUID, force bool) (bool, error) {
+	now := time.Now()
+	query := `
+		UPDATE "payment_event"
+		SET claimed_at = $2
+		WHERE id = $1 AND ($4 OR processed_at IS NULL) AND (claimed_at IS NULL OR claimed_at < $3)
+	`
+	res, err := q.DB.ExecContext(ctx, query, id, now, now.Add(-paymentEventClaimTTL), force)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// FinishPaymentEvent releases a claimed event and records the outcome of
+// processing it. An event that failed keeps its earlier processed_at, if any.
+func (q *Query) FinishPaymentEvent(ctx context.Context, id uuid.UUID, procErr error) error {
+	var lastError *string
+	if procErr != nil {
+		msg := procErr.Error()
+		lastError = &msg
+	}
+	query := `
+		UPDATE "payment_event"
+		SET claimed_at = NULL,
+			attempts = attempts + 1,
+			processed_at = CASE WHEN $2::TEXT IS NULL THEN $3 ELSE processed_at END,
+			last_error = $2
+		WHERE id = $1
+	`
+	_, err := q.DB.ExecContext(ctx, query, id, lastError, time.Now())
+	return err
+}
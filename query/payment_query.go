@@ -0,0 +1,266 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+type PaymentStatus string
+
+// A payment is pending while the provider is being called. The other
+// statuses are those of the provider's payment intent, plus refunded and
+// disputed.
+const (
+	PaymentPending        PaymentStatus = "pending"
+	PaymentRequiresAction PaymentStatus = "requires_action"
+	PaymentAuthorized     PaymentStatus = "authorized"
+	PaymentCaptured       PaymentStatus = "captured"
+	PaymentVoided         PaymentStatus = "voided"
+	PaymentFailed         PaymentStatus = "failed"
+	PaymentRefunded       PaymentStatus = "refunded"
+	PaymentDisputed       PaymentStatus = "disputed"
+)
+
+// ErrPaymentInProgress is returned when an order already has a payment that
+// may still take, or has taken, its money.
+var ErrPaymentInProgress = errors.New("a payment for this order is already in progress")
+
+// Payment is an attempt to charge an order through a payment provider.
+type Payment struct {
+	ID      uuid.UUID `json:"id"`
+	OrderID uuid.UUID `json:"order_id"`
+	// Provider is the name of the payment provider and ProviderRef the ID of
+	// its payment intent, unset until the provider has created one.
+	Provider      string          `json:"provider"`
+	ProviderRef   *string         `json:"provider_ref,omitempty"`
+	Amount        decimal.Decimal `json:"amount"`
+	Currency      string          `json:"currency"`
+	Status        PaymentStatus   `json:"status"`
+	FailureReason *string         `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+const paymentColumns = `id, order_id, provider, provider_ref, amount, currency, status, failure_reason, created_at, updated_at`
+
+func scanPayment(row rowScanner) (*Payment, error) {
+	var p Payment
+	err := row.Scan(&p.ID, &p.OrderID, &p.Provider, &p.ProviderRef, &p.Amount, &p.Currency, &p.Status,
+		&p.FailureReason, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// CreatePayment records a pending payment of an order. It fails with
+// ErrPaymentInProgress if the order has another payment that is not failed
+// or voided.
+func (q *Query) CreatePayment(ctx context.Context, p *Payment) error {
+	now := time.Now()
+	p.Status = PaymentPending
+	p.CreatedAt, p.UpdatedAt = now, now
+
+	query := `
+		INSERT INTO "payment" (id, order_id, provider, provider_ref, amount, currency, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+	_, err := q.DB.ExecContext(ctx, query, p.ID, p.OrderID, p.Provider, p.ProviderRef, p.Amount, p.Currency,
+		p.Status, p.CreatedAt, p.UpdatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" { // unique_violation
+			return ErrPaymentInProgress
+		}
+		return err
+	}
+	return nil
+}
+
+// GetActivePayment returns the payment of an order that may still take, or
+// has taken, its money. It returns nil if there is none.
+func (q *Query) GetActivePayment(ctx context.Context, orderID uuid.UUID) (*Payment, error) {
+	query := `
+		SELECT ` + paymentColumns + `
+		FROM "payment"
+		WHERE order_id = $1 AND status IN ('pending', 'requires_action', 'authorized', 'captured', 'disputed')
+	`
+	p, err := scanPayment(q.DB.QueryRowContext(ctx, query, orderID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return p, err
+}
+
+// GetPaymentByID fetches a payment. It returns nil if there is none.
+func (q *Query) GetPaymentByID(ctx context.Context, id uuid.UUID) (*Payment, error) {
+	query := `SELECT ` + paymentColumns + ` FROM "payment" WHERE id = $1`
+	p, err := scanPayment(q.DB.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return p, err
+}
+
+// GetPaymentByProviderRef fetches the payment of a provider's payment intent.
+// It returns nil if there is none.
+func (q *Query) GetPaymentByProviderRef(ctx context.Context, provider, providerRef string) (*Payment, error) {
+	query := `SELECT ` + paymentColumns + ` FROM "payment" WHERE provider = $1 AND provider_ref = $2`
+	p, err := scanPayment(q.DB.QueryRowContext(ctx, query, provider, providerRef))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return p, err
+}
+
+// UpdatePayment saves the provider reference, status and failure reason of a
+// payment.
+func (q *Query) UpdatePayment(ctx context.Context, p *Payment) error {
+	p.UpdatedAt = time.Now()
+	query := `
+		UPDATE "payment"
+		SET provider_ref = $2, status = $3, failure_reason = $4, updated_at = $5
+		WHERE id = $1
+	`
+	_, err := q.DB.ExecContext(ctx, query, p.ID, p.ProviderRef, p.Status, p.FailureReason, p.UpdatedAt)
+	return err
+}
+
+// CompletePayment marks a payment as captured and its order as paid in one
+// transaction. Completing a payment twice, for example from the request that
+// captured it and from the provider's webhook, changes nothing the second
+// time. If the order can no longer be paid, for example because it was
+// cancelled meanwhile, neither changes and the InvalidTransitionError is
+// returned; the captured money then has to be refunded.
+func (q *Query) CompletePayment(ctx context.Context, p *Payment, changedBy *uuid.UUID) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		var status PaymentStatus
+		err := tx.QueryRowContext(ctx, `SELECT status FROM "payment" WHERE id = $1 FOR UPDATE`, p.ID).Scan(&status)
+		if err != nil {
+			return err
+		}
+		if status == PaymentCaptured {
+			p.Status = status
+			return nil
+		}
+
+		now := time.Now()
+		query := `
+			UPDATE "payment"
+			SET provider_ref = $2, status = $3, failure_reason = NULL, updated_at = $4
+			WHERE id = $1
+		`
+		if _, err := tx.ExecContext(ctx, query, p.ID, p.ProviderRef, PaymentCaptured, now); err != nil {
+			return err
+		}
+
+		if err := transitionOrderStatus(ctx, tx, p.OrderID, OrderPaid, changedBy, "payment captured"); err != nil {
+			return err
+		}
+
+		p.Status = PaymentCaptured
+		p.FailureReason = nil
+		p.UpdatedAt = now
+		return nil
+	})
+}
+
+// DisputePayment marks a captured payment as disputed, and its order too
+// unless the order is in a status that cannot be disputed, such as refunded.
+func (q *Query) DisputePayment(ctx context.Context, p *Payment, reason string) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		if err := setPaymentStatus(ctx, tx, p, PaymentDisputed); err != nil {
+			return err
+		}
+		return transitionOrderIfAllowed(ctx, tx, p.OrderID, OrderDisputed, nil, "payment disputed: "+reason)
+	})
+}
+
+// CloseDispute records the outcome of a dispute. A won dispute leaves the
+// money with the shop and returns the order to the status it had before the
+// dispute; a lost one gave the money back to the customer, so the order is
+// refunded.
+func (q *Query) CloseDispute(ctx context.Context, p *Payment, won bool) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		if !won {
+			if err := setPaymentStatus(ctx, tx, p, PaymentRefunded); err != nil {
+				return err
+			}
+			return transitionOrderIfAllowed(ctx, tx, p.OrderID, OrderRefunded, nil, "payment dispute lost")
+		}
+
+		if err := setPaymentStatus(ctx, tx, p, PaymentCaptured); err != nil {
+			return err
+		}
+
+		var current OrderStatus
+		err := tx.QueryRowContext(ctx, `SELECT status FROM "order" WHERE id = $1 FOR UPDATE`, p.OrderID).Scan(&current)
+		if err != nil || current != OrderDisputed {
+			return err
+		}
+
+		var previous OrderStatus
+		query := `
+			SELECT from_status
+			FROM "order_status_history"
+			WHERE order_id = $1 AND to_status = $2 AND from_status IS NOT NULL
+			ORDER BY created_at DESC, id DESC
+			LIMIT 1
+		`
+		err = tx.QueryRowContext(ctx, query, p.OrderID, OrderDisputed).Scan(&previous)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return transitionOrderIfAllowed(ctx, tx, p.OrderID, previous, nil, "payment dispute won")
+	})
+}
+
+// SetPaymentStatusIf moves a payment from one status to another. It returns
+// false, changing nothing, if the payment is no longer in the from status, so
+// that of several concurrent changes only the first applies.
+func (q *Query) SetPaymentStatusIf(ctx context.Context, p *Payment, from, to PaymentStatus) (bool, error) {
+	now := time.Now()
+	query := `UPDATE "payment" SET status = $3, updated_at = $4 WHERE id = $1 AND status = $2`
+	res, err := q.DB.ExecContext(ctx, query, p.ID, from, to, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	p.Status = to
+	p.UpdatedAt = now
+	return true, nil
+}
+
+func setPaymentStatus(ctx context.Context, tx *sql.Tx, p *Payment, status PaymentStatus) error {
+	now := time.Now()
+	query := `UPDATE "payment" SET status = $2, updated_at = $3 WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, query, p.ID, status, now); err != nil {
+		return err
+	}
+	p.Status = status
+	p.UpdatedAt = now
+	return nil
+}
+
+// transitionOrderIfAllowed is transitionOrderStatus for changes that follow
+// from money moving, and so only apply if the order lifecycle allows them.
+func transitionOrderIfAllowed(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, to OrderStatus, changedBy *uuid.UUID, reason string) error {
+	err := transitionOrderStatus(ctx, tx, orderID, to, changedBy, reason)
+	var transitionErr *InvalidTransitionError
+	if errors.As(err, &transitionErr) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,237 @@
+package query
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type ProductSort string
+
+const (
+	ProductSortNewest    ProductSort = "newest"
+	ProductSortPriceAsc  ProductSort = "price_asc"
+	ProductSortPriceDesc ProductSort = "price_desc"
+	ProductSortNameAsc   ProductSort = "name_asc"
+	ProductSortNameDesc  ProductSort = "name_desc"
+)
+
+const (
+	DefaultProductPageSize = 20
+	MaxProductPageSize     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ProductFilter narrows and orders a product listing. Zero values mean "no
+// filter"; an empty Sort lists the newest products first.
+type ProductFilter struct {
+	MinPrice     *decimal.Decimal
+	MaxPrice     *decimal.Decimal
+	InStockOnly  bool
+	NameContains string
+	CreatedSince *time.Time
+	// CategoryID limits the listing to products in the category or any of
+	// its descendants.
+	CategoryID *uuid.UUID
+	Sort       ProductSort
+	Limit      int
+	Cursor     string
+}
+
+type ProductPage struct {
+	Products   []Product `json:"products"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+type productSortSpec struct {
+	column string
+	cast   string
+	desc   bool
+}
+
+var productSorts = map[ProductSort]productSortSpec{
+	ProductSortNewest:    {column: "created_at", cast: "timestamp", desc: true},
+	ProductSortPriceAsc:  {column: "price", cast: "numeric"},
+	ProductSortPriceDesc: {column: "price", cast: "numeric", desc: true},
+	ProductSortNameAsc:   {column: "name", cast: "text"},
+	ProductSortNameDesc:  {column: "name", cast: "text", desc: true},
+}
+
+// productCursor marks the last row of a page. It is handed to clients as an
+// opaque base64 string and is only valid for the sort it was issued for.
+type productCursor struct {
+	Sort  ProductSort `json:"s"`
+	Value string      `json:"v"`
+	ID    uuid.UUID   `json:"id"`
+}
+
+func (c productCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeProductCursor(s string, sort ProductSort) (*productCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c productCursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+
+	// The value is cast in SQL, so it must parse as the sort column's type
+	var valid bool
+	switch productSorts[sort].cast {
+	case "timestamp":
+		_, err = time.Parse(timestampLayout, c.Value)
+		valid = err == nil
+	case "numeric":
+		_, err = decimal.NewFromString(c.Value)
+		valid = err == nil
+	default:
+		valid = utf8.ValidString(c.Value) && !strings.ContainsRune(c.Value, 0)
+	}
+	if !valid {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// ListProducts returns one page of products using keyset pagination, so the
+// cost of a page does not grow with how deep into the catalog it is.
+func (q *Query) ListProducts(ctx context.Context, f ProductFilter) (*ProductPage, error) {
+	if f.Sort == "" {
+		f.Sort = ProductSortNewest
+	}
+	spec, ok := productSorts[f.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort %q", f.Sort)
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultProductPageSize
+	}
+	if f.Limit > MaxProductPageSize {
+		f.Limit = MaxProductPageSize
+	}
+
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.MinPrice != nil {
+		where = append(where, "price >= "+arg(*f.MinPrice))
+	}
+	if f.MaxPrice != nil {
+		where = append(where, "price <= "+arg(*f.MaxPrice))
+	}
+	if f.InStockOnly {
+		where = append(where, "units_in_stock > 0")
+	}
+	if f.NameContains != "" {
+		where = append(where, "name ILIKE "+arg("%"+escapeLike(f.NameContains)+"%"))
+	}
+	if f.CreatedSince != nil {
+		where = append(where, "created_at >= "+arg(formatTimestamp(*f.CreatedSince))+"::timestamp")
+	}
+	if f.CategoryID != nil {
+		where = append(where, `id IN (
+			WITH RECURSIVE subtree AS (
+				SELECT id FROM "category" WHERE id = `+arg(*f.CategoryID)+`
+				UNION ALL
+				SELECT c.id FROM "category" c JOIN subtree s ON c.parent_id = s.id
+			)
+			SELECT pc.product_id FROM "product_category" pc JOIN subtree ON pc.category_id = subtree.id
+		)`)
+	}
+
+	direction, comparison := "ASC", ">"
+	if spec.desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if f.Cursor != "" {
+		cursor, err := decodeProductCursor(f.Cursor, f.Sort)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, fmt.Sprintf("(%s, id) %s (%s::%s, %s)",
+			spec.column, comparison, arg(cursor.Value), spec.cast, arg(cursor.ID)))
+	}
+
+	query := `
+		SELECT id, name, description, price, units_in_stock, created_at, updated_at
+		FROM "product"
+	`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// Fetch one extra row to learn whether another page follows.
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %s", spec.column, direction, direction, arg(f.Limit+1))
+
+	rows, err := q.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &ProductPage{Products: []Product{}}
+	for rows.Next() {
+		var product Product
+		err := rows.Scan(&product.ID, &product.Name, &product.Description, &product.Price, &product.UnitsInStock, &product.CreatedAt, &product.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		page.Products = append(page.Products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Products) > f.Limit {
+		page.Products = page.Products[:f.Limit]
+		last := page.Products[f.Limit-1]
+		page.NextCursor = productCursor{
+			Sort:  f.Sort,
+			Value: productSortValue(last, spec.column),
+			ID:    last.ID,
+		}.encode()
+	}
+	return page, nil
+}
+
+func productSortValue(p Product, column string) string {
+	switch column {
+	case "price":
+		return p.Price.String()
+	case "name":
+		return p.Name
+	default:
+		return formatTimestamp(p.CreatedAt)
+	}
+}
+
+// formatTimestamp renders t the way Postgres stores a TIMESTAMP column, with
+// microsecond precision and no zone.
+func formatTimestamp(t time.Time) string {
+	return t.Format(timestampLayout)
+}
+
+const timestampLayout = "2006-01-02 15:04:05.999999"
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
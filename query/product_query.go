@@ -20,15 +20,31 @@ type Product struct {
 	UpdatedAt    time.Time       `json:"updated_at" validate:"required"`
 }
 
-// CreateProduct inserts a new product into the database.
-func (q *Query) CreateProduct(ctx context.Context, product *Product) error {
+// CreateProduct inserts a new product into the database together with its
+// default variant, which holds the product's stock. An empty sku gets a
+// generated one.
+func (q *Query) CreateProduct(ctx context.Context, product *Product, sku string, barcode *string) error {
+	if sku == "" {
+		sku = DefaultSKU(product.ID)
+	}
 
-	query := `
-		INSERT INTO "product" (id, name, description, price, units_in_stock, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`
-	_, err := q.DB.ExecContext(ctx, query, product.ID, product.Name, product.Description, product.Price, product.UnitsInStock, product.CreatedAt, product.UpdatedAt)
-	return err
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO "product" (id, name, description, price, units_in_stock, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`
+		_, err := tx.ExecContext(ctx, query, product.ID, product.Name, product.Description, product.Price, product.UnitsInStock, product.CreatedAt, product.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO "product_variant" (id, product_id, sku, barcode, units_in_stock, is_default, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, TRUE, $6, $7)
+		`
+		_, err = tx.ExecContext(ctx, query, uuid.New(), product.ID, sku, barcode, product.UnitsInStock, product.CreatedAt, product.UpdatedAt)
+		return variantError(err)
+	})
 }
 
 // GetProductByID fetches a product by its ID.
@@ -51,55 +67,71 @@ func (q *Query) GetProductByID(ctx context.Context, productID uuid.UUID) (*Produ
 	return &product, nil
 }
 
-// GetAllProducts fetches all products from the database.
-func (q *Query) GetAllProducts(ctx context.Context) ([]Product, error) {
-	query := `
-		SELECT id, name, description, price, units_in_stock, created_at, updated_at
-		FROM "product"
-	`
-	rows, err := q.DB.QueryContext(ctx, query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var products []Product
-	for rows.Next() {
-		var product Product
-		err := rows.Scan(&product.ID, &product.Name, &product.Description, &product.Price, &product.UnitsInStock, &product.CreatedAt, &product.UpdatedAt)
-		if err != nil {
-			return nil, err
+// UpdateProduct updates the product's details in the database. The stock of
+// a product with a single variant is written to that variant; a product with
+// several variants keeps its stock per variant and its total cannot be set
+// here.
+func (q *Query) UpdateProduct(ctx context.Context, product *Product) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		if err := lockProductVariants(ctx, tx, product.ID); err != nil {
+			return err
 		}
-		products = append(products, product)
-	}
-	return products, nil
-}
 
-// UpdateProduct updates the product's details in the database.
-func (q *Query) UpdateProduct(ctx context.Context, product *Product) error {
-	// Start a transaction to handle multiple operations atomically, if needed
-	query := `
-		UPDATE "product"
-		SET name = $1, description = $2, price = $3, units_in_stock = $4, updated_at = $5
-		WHERE id = $6
-		RETURNING id` // Use RETURNING to check if any rows were updated
+		query := `
+			UPDATE "product"
+			SET name = $1, description = $2, price = $3, updated_at = $4
+			WHERE id = $5
+			RETURNING id, units_in_stock` // Use RETURNING to check if any rows were updated
 
-	var updatedID string
-	err := q.DB.QueryRowContext(ctx, query, product.Name, product.Description, product.Price, product.UnitsInStock, product.UpdatedAt, product.ID).Scan(&updatedID)
+		var updatedID string
+		var unitsInStock int
+		err := tx.QueryRowContext(ctx, query, product.Name, product.Description, product.Price, product.UpdatedAt, product.ID).Scan(&updatedID, &unitsInStock)
 
-	// Check if any rows were updated
-	if err != nil {
-		if err == sql.ErrNoRows {
-			// No rows were updated, handle it (e.g., log an error or return a custom error message)
-			return fmt.Errorf("product with id %v not found", product.ID)
+		// Check if any rows were updated
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("product with id %v not found", product.ID)
+			}
+			return fmt.Errorf("failed to update product: %w", err)
+		}
+
+		if product.UnitsInStock == unitsInStock {
+			return nil
 		}
-		return fmt.Errorf("failed to update product: %w", err)
-	}
 
-	// You can also check the `updatedID` to confirm if it matches the expected product ID
-	fmt.Printf("Product with ID %s was successfully updated.\n", updatedID)
+		query = `
+			UPDATE "product_variant"
+			SET units_in_stock = $1, updated_at = $2
+			WHERE product_id = $3
+			AND (SELECT COUNT(*) FROM "product_variant" WHERE product_id = $3) = 1`
+		result, err := tx.ExecContext(ctx, query, product.UnitsInStock, product.UpdatedAt, product.ID)
+		if err != nil {
+			return fmt.Errorf("failed to update product stock: %w", err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrVariantStockManaged
+		}
+		return nil
+	})
+}
 
-	return nil
+// lockProductVariants locks the variants of a product. Orders lock variants
+// before the stock trigger locks their product, so anything that writes to
+// both must take the variant locks first, in the same product and variant
+// order as loadCatalog, or it can deadlock with a checkout.
+func lockProductVariants(ctx context.Context, tx *sql.Tx, productID uuid.UUID) error {
+	query := `
+		SELECT id FROM "product_variant"
+		WHERE product_id = $1
+		ORDER BY product_id, id
+		FOR UPDATE
+	`
+	_, err := tx.ExecContext(ctx, query, productID)
+	return err
 }
 
 // DeleteProduct deletes a product by its ID.
@@ -0,0 +1,98 @@
+package query
+
+import (
+	"context"
+	"html"
+	"strings"
+	"unicode"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 50
+)
+
+// highlightStart and highlightStop delimit the matches ts_headline finds.
+// They are stripped from the product text before highlighting, so once the
+// headline is HTML-escaped they can only come from ts_headline and are
+// turned into <mark></mark>.
+const (
+	highlightStart = "\x01"
+	highlightStop  = "\x02"
+)
+
+var highlighter = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+
+type ProductSearchResult struct {
+	Product
+	Rank float32 `json:"rank"`
+	// NameHighlight and Snippet are HTML-escaped and mark matched terms with
+	// <mark></mark>.
+	NameHighlight string `json:"name_highlight"`
+	Snippet       string `json:"snippet"`
+}
+
+// SearchProducts runs a full-text search over product names and descriptions.
+// Every term is matched as a prefix, so partial words work for type-ahead.
+// Name matches rank above description matches.
+func (q *Query) SearchProducts(ctx context.Context, term string, limit int) ([]ProductSearchResult, error) {
+	tsQuery := prefixTSQuery(term)
+	if tsQuery == "" {
+		return []ProductSearchResult{}, nil
+	}
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	query := `
+		WITH search AS (SELECT to_tsquery('english', $1) AS query)
+		SELECT p.id, p.name, p.description, p.price, p.units_in_stock, p.created_at, p.updated_at,
+			ts_rank(p.search_vector, search.query) AS rank,
+			ts_headline('english', translate(p.name, $3, ''), search.query, $4),
+			ts_headline('english', translate(coalesce(p.description, ''), $3, ''), search.query, $5)
+		FROM "product" p, search
+		WHERE p.search_vector @@ search.query
+		ORDER BY rank DESC, p.id
+		LIMIT $2
+	`
+	selection := "StartSel=" + highlightStart + ", StopSel=" + highlightStop
+	rows, err := q.DB.QueryContext(ctx, query, tsQuery, limit, highlightStart+highlightStop,
+		selection+", HighlightAll=true", selection+", MinWords=10, MaxWords=30, MaxFragments=2")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []ProductSearchResult{}
+	for rows.Next() {
+		var r ProductSearchResult
+		err := rows.Scan(&r.ID, &r.Name, &r.Description, &r.Price, &r.UnitsInStock, &r.CreatedAt, &r.UpdatedAt,
+			&r.Rank, &r.NameHighlight, &r.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		r.NameHighlight = highlighter.Replace(html.EscapeString(r.NameHighlight))
+		r.Snippet = highlighter.Replace(html.EscapeString(r.Snippet))
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// prefixTSQuery turns free text into a to_tsquery expression that requires
+// every word as a prefix, e.g. "red sho" becomes "red:* & sho:*". Anything
+// that is not a letter or digit separates words, so user input can never
+// inject tsquery operators.
+func prefixTSQuery(term string) string {
+	words := strings.FieldsFunc(strings.ToLower(term), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	parts := make([]string, 0, len(words))
+	for _, word := range words {
+		parts = append(parts, word+":*")
+	}
+	return strings.Join(parts, " & ")
+}
@@ -0,0 +1,403 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/pkg/promotion"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrPromotionNotFound      = errors.New("promotion not found")
+	ErrPromotionCodeTaken     = errors.New("a promotion with this code already exists")
+	ErrPromotionScopeNotFound = errors.New("a product or category of the promotion does not exist")
+)
+
+// Promotion is a discount customers get by entering its code. See package
+// promotion for how each type of promotion is applied.
+type Promotion struct {
+	ID          uuid.UUID       `json:"id"`
+	Code        string          `json:"code"`
+	Description *string         `json:"description,omitempty"`
+	Type        promotion.Type  `json:"type"`
+	Value       decimal.Decimal `json:"value"`
+	BuyQuantity *int            `json:"buy_quantity,omitempty"`
+	GetQuantity *int            `json:"get_quantity,omitempty"`
+	MinSubtotal decimal.Decimal `json:"min_subtotal"`
+	StartsAt    *time.Time      `json:"starts_at,omitempty"`
+	EndsAt      *time.Time      `json:"ends_at,omitempty"`
+	// UsageLimit and PerUserLimit are unlimited when nil.
+	UsageLimit   *int        `json:"usage_limit,omitempty"`
+	PerUserLimit *int        `json:"per_user_limit,omitempty"`
+	Stackable    bool        `json:"stackable"`
+	Active       bool        `json:"active"`
+	ProductIDs   []uuid.UUID `json:"product_ids"`
+	CategoryIDs  []uuid.UUID `json:"category_ids"`
+	// Uses counts the orders placed with the promotion, except cancelled ones.
+	Uses      int       `json:"uses"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// rule returns the promotion as package promotion applies it.
+func (p *Promotion) rule() promotion.Promotion {
+	r := promotion.Promotion{
+		ID:           p.ID,
+		Code:         p.Code,
+		Type:         p.Type,
+		Value:        p.Value,
+		MinSubtotal:  p.MinSubtotal,
+		StartsAt:     p.StartsAt,
+		EndsAt:       p.EndsAt,
+		UsageLimit:   p.UsageLimit,
+		PerUserLimit: p.PerUserLimit,
+		Stackable:    p.Stackable,
+		Active:       p.Active,
+		ProductIDs:   p.ProductIDs,
+		CategoryIDs:  p.CategoryIDs,
+	}
+	if p.Description != nil {
+		r.Description = *p.Description
+	}
+	if p.BuyQuantity != nil && p.GetQuantity != nil {
+		r.BuyQuantity, r.GetQuantity = *p.BuyQuantity, *p.GetQuantity
+	}
+	return r
+}
+
+const promotionColumns = `p.id, p.code, p.description, p.type, p.value, p.buy_quantity, p.get_quantity, p.min_subtotal,
+	p.starts_at, p.ends_at, p.usage_limit, p.per_user_limit, p.stackable, p.active, p.created_at, p.updated_at`
+
+func scanPromotion(row rowScanner) (*Promotion, error) {
+	var p Promotion
+	err := row.Scan(&p.ID, &p.Code, &p.Description, &p.Type, &p.Value, &p.BuyQuantity, &p.GetQuantity, &p.MinSubtotal,
+		&p.StartsAt, &p.EndsAt, &p.UsageLimit, &p.PerUserLimit, &p.Stackable, &p.Active, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.ProductIDs, p.CategoryIDs = []uuid.UUID{}, []uuid.UUID{}
+	return &p, nil
+}
+
+// CreatePromotion saves a new promotion and the products and categories it is
+// scoped to.
+func (q *Query) CreatePromotion(ctx context.Context, p *Promotion) error {
+	now := time.Now()
+	p.CreatedAt, p.UpdatedAt = now, now
+
+	err := q.withTx(ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO "promotion" (id, code, description, type, value, buy_quantity, get_quantity, min_subtotal,
+				starts_at, ends_at, usage_limit, per_user_limit, stackable, active, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		`
+		_, err := tx.ExecContext(ctx, query, p.ID, p.Code, p.Description, p.Type, p.Value, p.BuyQuantity, p.GetQuantity,
+			p.MinSubtotal, p.StartsAt, p.EndsAt, p.UsageLimit, p.PerUserLimit, p.Stackable, p.Active, p.CreatedAt, p.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		return setPromotionScope(ctx, tx, p)
+	})
+	return promotionError(err)
+}
+
+// UpdatePromotion saves the changes to a promotion. Orders already placed
+// with it keep the discount they got.
+func (q *Query) UpdatePromotion(ctx context.Context, p *Promotion) error {
+	p.UpdatedAt = time.Now()
+
+	err := q.withTx(ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE "promotion"
+			SET code = $2, description = $3, type = $4, value = $5, buy_quantity = $6, get_quantity = $7,
+				min_subtotal = $8, starts_at = $9, ends_at = $10, usage_limit = $11, per_user_limit = $12,
+				stackable = $13, active = $14, updated_at = $15
+			WHERE id = $1
+			RETURNING created_at
+		`
+		err := tx.QueryRowContext(ctx, query, p.ID, p.Code, p.Description, p.Type, p.Value, p.BuyQuantity, p.GetQuantity,
+			p.MinSubtotal, p.StartsAt, p.EndsAt, p.UsageLimit, p.PerUserLimit, p.Stackable, p.Active, p.UpdatedAt).
+			Scan(&p.CreatedAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrPromotionNotFound
+			}
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM "promotion_product" WHERE promotion_id = $1`, p.ID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM "promotion_category" WHERE promotion_id = $1`, p.ID); err != nil {
+			return err
+		}
+		return setPromotionScope(ctx, tx, p)
+	})
+	return promotionError(err)
+}
+
+func setPromotionScope(ctx context.Context, tx *sql.Tx, p *Promotion) error {
+	query := `
+		INSERT INTO "promotion_product" (promotion_id, product_id)
+		SELECT $1, id FROM UNNEST($2::uuid[]) AS id
+		ON CONFLICT DO NOTHING
+	`
+	if _, err := tx.ExecContext(ctx, query, p.ID, pq.Array(uuidStrings(p.ProductIDs))); err != nil {
+		return err
+	}
+	query = `
+		INSERT INTO "promotion_category" (promotion_id, category_id)
+		SELECT $1, id FROM UNNEST($2::uuid[]) AS id
+		ON CONFLICT DO NOTHING
+	`
+	_, err := tx.ExecContext(ctx, query, p.ID, pq.Array(uuidStrings(p.CategoryIDs)))
+	return err
+}
+
+// promotionError maps constraint violations of promotions to their errors.
+func promotionError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "23505": // unique_violation
+			return ErrPromotionCodeTaken
+		case "23503": // foreign_key_violation
+			return ErrPromotionScopeNotFound
+		}
+	}
+	return err
+}
+
+// DeletePromotion deletes a promotion. Orders placed with it keep their
+// discounts, with the code they were placed with.
+func (q *Query) DeletePromotion(ctx context.Context, id uuid.UUID) error {
+	res, err := q.DB.ExecContext(ctx, `DELETE FROM "promotion" WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrPromotionNotFound
+	}
+	return nil
+}
+
+// GetPromotion fetches a promotion. It returns nil if there is none.
+func (q *Query) GetPromotion(ctx context.Context, id uuid.UUID) (*Promotion, error) {
+	query := `SELECT ` + promotionColumns + ` FROM "promotion" p WHERE p.id = $1`
+	p, err := scanPromotion(q.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	promotions := []Promotion{*p}
+	if err := loadPromotionDetails(ctx, q.DB, promotions); err != nil {
+		return nil, err
+	}
+	return &promotions[0], nil
+}
+
+// ListPromotions returns every promotion, newest first.
+func (q *Query) ListPromotions(ctx context.Context) ([]Promotion, error) {
+	query := `SELECT ` + promotionColumns + ` FROM "promotion" p ORDER BY p.created_at DESC, p.id`
+	promotions, err := queryPromotions(ctx, q.DB, query)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadPromotionDetails(ctx, q.DB, promotions); err != nil {
+		return nil, err
+	}
+	return promotions, nil
+}
+
+func queryPromotions(ctx context.Context, db queryer, query string, args ...any) ([]Promotion, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	promotions := []Promotion{}
+	for rows.Next() {
+		p, err := scanPromotion(rows)
+		if err != nil {
+			return nil, err
+		}
+		promotions = append(promotions, *p)
+	}
+	return promotions, rows.Err()
+}
+
+// loadPromotionDetails fills in the scope and the number of uses of
+// promotions.
+func loadPromotionDetails(ctx context.Context, db queryer, promotions []Promotion) error {
+	if err := loadPromotionScopes(ctx, db, promotions); err != nil {
+		return err
+	}
+
+	ids := make([]uuid.UUID, 0, len(promotions))
+	for _, p := range promotions {
+		ids = append(ids, p.ID)
+	}
+	usage, err := promotionUsage(ctx, db, ids, uuid.Nil)
+	if err != nil {
+		return err
+	}
+	for i := range promotions {
+		promotions[i].Uses = usage[promotions[i].ID].Total
+	}
+	return nil
+}
+
+func loadPromotionScopes(ctx context.Context, db queryer, promotions []Promotion) error {
+	if len(promotions) == 0 {
+		return nil
+	}
+	index := make(map[uuid.UUID]int, len(promotions))
+	ids := make([]uuid.UUID, 0, len(promotions))
+	for i, p := range promotions {
+		index[p.ID] = i
+		ids = append(ids, p.ID)
+	}
+
+	query := `
+		SELECT promotion_id, product_id, 'product' FROM "promotion_product" WHERE promotion_id = ANY($1::uuid[])
+		UNION ALL
+		SELECT promotion_id, category_id, 'category' FROM "promotion_category" WHERE promotion_id = ANY($1::uuid[])
+	`
+	rows, err := db.QueryContext(ctx, query, pq.Array(uuidStrings(ids)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var promotionID, id uuid.UUID
+		var kind string
+		if err := rows.Scan(&promotionID, &id, &kind); err != nil {
+			return err
+		}
+		p := &promotions[index[promotionID]]
+		if kind == "product" {
+			p.ProductIDs = append(p.ProductIDs, id)
+		} else {
+			p.CategoryIDs = append(p.CategoryIDs, id)
+		}
+	}
+	return rows.Err()
+}
+
+// loadPromotions fetches the promotions of codes, in the order of the codes.
+// Codes are matched regardless of case. With forUpdate the promotions are
+// locked, so that concurrent orders cannot use one beyond its usage limits.
+func loadPromotions(ctx context.Context, db queryer, codes []string, forUpdate bool) ([]Promotion, error) {
+	upper := make([]string, 0, len(codes))
+	for _, code := range codes {
+		upper = append(upper, strings.ToUpper(strings.TrimSpace(code)))
+	}
+
+	query := `SELECT ` + promotionColumns + ` FROM "promotion" p WHERE UPPER(p.code) = ANY($1) ORDER BY p.id`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	found, err := queryPromotions(ctx, db, query, pq.Array(upper))
+	if err != nil {
+		return nil, err
+	}
+	if err := loadPromotionScopes(ctx, db, found); err != nil {
+		return nil, err
+	}
+
+	byCode := make(map[string]Promotion, len(found))
+	for _, p := range found {
+		byCode[strings.ToUpper(p.Code)] = p
+	}
+	promotions := make([]Promotion, 0, len(codes))
+	for i, code := range upper {
+		p, ok := byCode[code]
+		if !ok {
+			return nil, &promotion.Error{Code: codes[i], Reason: "does not exist"}
+		}
+		promotions = append(promotions, p)
+	}
+	return promotions, nil
+}
+
+// promotionUsage counts the orders placed with each promotion, overall and
+// by userID. Cancelled orders do not count.
+func promotionUsage(ctx context.Context, db queryer, ids []uuid.UUID, userID uuid.UUID) (map[uuid.UUID]promotion.Usage, error) {
+	query := `
+		SELECT d.promotion_id, COUNT(*), COUNT(*) FILTER (WHERE o.user_id = $2)
+		FROM "order_discount" d
+		JOIN "order" o ON o.id = d.order_id
+		WHERE d.promotion_id = ANY($1::uuid[]) AND o.status <> 'cancelled'
+		GROUP BY d.promotion_id
+	`
+	rows, err := db.QueryContext(ctx, query, pq.Array(uuidStrings(ids)), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := make(map[uuid.UUID]promotion.Usage)
+	for rows.Next() {
+		var id uuid.UUID
+		var u promotion.Usage
+		if err := rows.Scan(&id, &u.Total, &u.ByUser); err != nil {
+			return nil, err
+		}
+		usage[id] = u
+	}
+	return usage, rows.Err()
+}
+
+// productCategories returns the categories of products and their ancestor
+// categories, by product ID, so that a promotion scoped to a category also
+// applies to products filed under its subcategories.
+func productCategories(ctx context.Context, db queryer, productIDs []uuid.UUID) (map[uuid.UUID][]uuid.UUID, error) {
+	query := `
+		WITH RECURSIVE ancestors AS (
+			SELECT product_id, category_id FROM "product_category" WHERE product_id = ANY($1::uuid[])
+			UNION
+			SELECT a.product_id, c.parent_id
+			FROM ancestors a JOIN "category" c ON c.id = a.category_id
+			WHERE c.parent_id IS NOT NULL
+		)
+		SELECT product_id, category_id FROM ancestors
+	`
+	rows, err := db.QueryContext(ctx, query, pq.Array(uuidStrings(productIDs)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := make(map[uuid.UUID][]uuid.UUID)
+	for rows.Next() {
+		var productID, categoryID uuid.UUID
+		if err := rows.Scan(&productID, &categoryID); err != nil {
+			return nil, err
+		}
+		categories[productID] = append(categories[productID], categoryID)
+	}
+	return categories, rows.Err()
+}
+
+func uuidStrings(ids []uuid.UUID) []string {
+	s := make([]string, 0, len(ids))
+	for _, id := range ids {
+		s = append(s, id.String())
+	}
+	return s
+}
@@ -1,13 +1,39 @@
 package query
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+)
 
 type Query struct {
 	DB *sql.DB
 }
 
+// queryer is satisfied by both *sql.DB and *sql.Tx, so read helpers can be
+// shared between plain queries and transactions.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 func NewQuery(db *sql.DB) Query {
 	return Query{
 		DB: db,
 	}
 }
+
+// withTx runs fn inside a single database transaction. The transaction is
+// committed when fn returns nil and rolled back otherwise.
+func (q *Query) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := q.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,265 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/pkg/promotion"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+// Quote is the server-side price breakdown of a set of order lines. Every
+// price in it comes from the catalog, never from the client.
+// Total is Subtotal less Discount plus Shipping.
+type Quote struct {
+	Lines     []QuoteLine     `json:"lines"`
+	Subtotal  decimal.Decimal `json:"subtotal"`
+	Discounts []QuoteDiscount `json:"discounts"`
+	Discount  decimal.Decimal `json:"discount"`
+	Shipping  decimal.Decimal `json:"shipping"`
+	Total     decimal.Decimal `json:"total"`
+}
+
+type QuoteLine struct {
+	ProductID uuid.UUID         `json:"product_id"`
+	VariantID uuid.UUID         `json:"variant_id"`
+	SKU       string            `json:"sku"`
+	Name      string            `json:"name"`
+	Options   map[string]string `json:"options,omitempty"`
+	Quantity  int               `json:"quantity"`
+	UnitPrice decimal.Decimal   `json:"unit_price"`
+	LineTotal decimal.Decimal   `json:"line_total"`
+	// Discount is the share of the line in the discounts of the quote.
+	Discount decimal.Decimal `json:"discount"`
+}
+
+// QuoteDiscount is the discount one promotion code gives.
+type QuoteDiscount struct {
+	PromotionID uuid.UUID       `json:"promotion_id"`
+	Code        string          `json:"code"`
+	Type        promotion.Type  `json:"type"`
+	Description string          `json:"description,omitempty"`
+	Amount      decimal.Decimal `json:"amount"`
+}
+
+type catalogEntry struct {
+	ProductID    uuid.UUID
+	Name         string
+	SKU          string
+	Options      map[string]string
+	Price        decimal.Decimal
+	UnitsInStock int
+}
+
+// QuoteOrder prices the items of an order against the current catalog and
+// applies its promotion codes, without placing the order or reserving any
+// stock. Items short on stock fail with ErrInsufficientStock.
+func (q *Query) QuoteOrder(ctx context.Context, order *Order) (*Quote, error) {
+	catalog, err := loadCatalog(ctx, q.DB, order.Items, false)
+	if err != nil {
+		return nil, err
+	}
+
+	quote, err := priceOrder(order, catalog)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := checkStock(order.Items, catalog); err != nil {
+		return nil, err
+	}
+	if err := discountOrder(ctx, q.DB, order, quote, false); err != nil {
+		return nil, err
+	}
+	return quote, nil
+}
+
+// loadCatalog reads the variants referenced by items, keyed by variant ID.
+// Items without a variant are resolved to their product's default variant and
+// have VariantID set. With forUpdate set the variant rows are locked in
+// product and variant order, so that concurrent orders touching the same
+// products cannot deadlock each other.
+func loadCatalog(ctx context.Context, db queryer, items []OrderItem, forUpdate bool) (map[uuid.UUID]catalogEntry, error) {
+	var variantIDs, defaultProductIDs []string
+	for _, item := range items {
+		if item.VariantID != nil {
+			variantIDs = append(variantIDs, item.VariantID.String())
+		} else {
+			defaultProductIDs = append(defaultProductIDs, item.ProductID.String())
+		}
+	}
+
+	query := `
+        SELECT v.id, v.product_id, p.name, v.sku, v.options, COALESCE(v.price, p.price), v.units_in_stock, v.is_default
+        FROM "product_variant" v
+        JOIN "product" p ON p.id = v.product_id
+        WHERE v.id = ANY($1::uuid[]) OR (v.is_default AND v.product_id = ANY($2::uuid[]))
+        ORDER BY v.product_id, v.id
+    `
+	if forUpdate {
+		query += " FOR UPDATE OF v"
+	}
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(variantIDs), pq.Array(defaultProductIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	catalog := make(map[uuid.UUID]catalogEntry)
+	defaults := make(map[uuid.UUID]uuid.UUID)
+	for rows.Next() {
+		var id uuid.UUID
+		var entry catalogEntry
+		var options []byte
+		var isDefault bool
+		err := rows.Scan(&id, &entry.ProductID, &entry.Name, &entry.SKU, &options, &entry.Price, &entry.UnitsInStock, &isDefault)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(options, &entry.Options); err != nil {
+			return nil, err
+		}
+		catalog[id] = entry
+		if isDefault {
+			defaults[entry.ProductID] = id
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range items {
+		item := &items[i]
+		if item.VariantID == nil {
+			id, ok := defaults[item.ProductID]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrProductNotFound, item.ProductID)
+			}
+			item.VariantID = &id
+			continue
+		}
+
+		entry, ok := catalog[*item.VariantID]
+		if !ok || entry.ProductID != item.ProductID {
+			return nil, fmt.Errorf("%w: %s of product %s", ErrVariantNotFound, item.VariantID, item.ProductID)
+		}
+	}
+	return catalog, nil
+}
+
+// priceOrder sets the price and SKU of every item and the order totals from
+// the catalog and the order's shipping charge, and returns the matching
+// quote. Items must have been resolved to a variant by loadCatalog.
+func priceOrder(order *Order, catalog map[uuid.UUID]catalogEntry) (*Quote, error) {
+	quote := &Quote{
+		Lines:     make([]QuoteLine, 0, len(order.Items)),
+		Subtotal:  decimal.Zero,
+		Discounts: []QuoteDiscount{},
+		Discount:  decimal.Zero,
+		Shipping:  order.ShippingAmount,
+	}
+
+	for i := range order.Items {
+		item := &order.Items[i]
+		entry, ok := catalog[*item.VariantID]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrVariantNotFound, item.VariantID)
+		}
+
+		item.Price = entry.Price
+		item.SKU = entry.SKU
+		item.DiscountAmount = decimal.Zero
+		line := QuoteLine{
+			ProductID: item.ProductID,
+			VariantID: *item.VariantID,
+			SKU:       entry.SKU,
+			Name:      entry.Name,
+			Options:   entry.Options,
+			Quantity:  item.Quantity,
+			UnitPrice: entry.Price,
+			LineTotal: entry.Price.Mul(decimal.NewFromInt(int64(item.Quantity))),
+			Discount:  decimal.Zero,
+		}
+		quote.Lines = append(quote.Lines, line)
+		quote.Subtotal = quote.Subtotal.Add(line.LineTotal)
+	}
+
+	quote.Total = quote.Subtotal.Add(quote.Shipping)
+	order.SubtotalAmount = quote.Subtotal
+	order.DiscountAmount = decimal.Zero
+	order.TotalAmount = quote.Total
+	return quote, nil
+}
+
+// discountOrder applies the promotion codes of a priced order to it and its
+// quote. A code that cannot be applied fails with a *promotion.Error. With
+// forUpdate set the promotions stay locked until the transaction ends, so
+// that concurrent orders cannot use a promotion beyond its usage limits.
+func discountOrder(ctx context.Context, db queryer, order *Order, quote *Quote, forUpdate bool) error {
+	if len(order.PromotionCodes) == 0 {
+		return nil
+	}
+
+	promotions, err := loadPromotions(ctx, db, order.PromotionCodes, forUpdate)
+	if err != nil {
+		return err
+	}
+	rules := make([]promotion.Promotion, 0, len(promotions))
+	ids := make([]uuid.UUID, 0, len(promotions))
+	for i := range promotions {
+		rules = append(rules, promotions[i].rule())
+		ids = append(ids, promotions[i].ID)
+	}
+
+	usage, err := promotionUsage(ctx, db, ids, order.UserID)
+	if err != nil {
+		return err
+	}
+
+	productIDs := make([]uuid.UUID, 0, len(quote.Lines))
+	for _, line := range quote.Lines {
+		productIDs = append(productIDs, line.ProductID)
+	}
+	categories, err := productCategories(ctx, db, productIDs)
+	if err != nil {
+		return err
+	}
+
+	lines := make([]promotion.Line, 0, len(quote.Lines))
+	for _, line := range quote.Lines {
+		lines = append(lines, promotion.Line{
+			ProductID:   line.ProductID,
+			CategoryIDs: categories[line.ProductID],
+			UnitPrice:   line.UnitPrice,
+			Quantity:    line.Quantity,
+		})
+	}
+
+	result, err := promotion.Apply(rules, usage, promotion.Order{Lines: lines, Shipping: quote.Shipping}, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for i := range quote.Lines {
+		quote.Lines[i].Discount = result.Lines[i]
+		order.Items[i].DiscountAmount = result.Lines[i]
+	}
+	for _, d := range result.Discounts {
+		quote.Discounts = append(quote.Discounts, QuoteDiscount{
+			PromotionID: d.Promotion.ID,
+			Code:        d.Promotion.Code,
+			Type:        d.Promotion.Type,
+			Description: d.Promotion.Description,
+			Amount:      d.Amount,
+		})
+	}
+	quote.Discount = result.Total()
+	quote.Total = quote.Subtotal.Sub(quote.Discount).Add(quote.Shipping)
+	order.DiscountAmount = quote.Discount
+	order.TotalAmount = quote.Total
+	return nil
+}
@@ -0,0 +1,443 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type RefundStatus string
+
+// A refund is pending while the payment provider is being called.
+const (
+	RefundPending   RefundStatus = "pending"
+	RefundSucceeded RefundStatus = "succeeded"
+	RefundFailed    RefundStatus = "failed"
+)
+
+var (
+	ErrOrderNotRefundable = errors.New("order cannot be refunded")
+	ErrNoCapturedPayment  = errors.New("the order has no captured payment to refund")
+	ErrRefundExceedsOrder = errors.New("refund exceeds what is left to refund")
+	ErrOrderItemNotFound  = errors.New("order item not found")
+	ErrRefundNotPending   = errors.New("the refund is no longer pending")
+)
+
+// Refund gives back money paid for an order, either for some of its lines or
+// for everything not refunded yet.
+type Refund struct {
+	ID        uuid.UUID       `json:"id"`
+	OrderID   uuid.UUID       `json:"order_id"`
+	PaymentID uuid.UUID       `json:"payment_id"`
+	Amount    decimal.Decimal `json:"amount"`
+	Reason    string          `json:"reason"`
+	// Restock puts the refunded units back in stock.
+	Restock       bool         `json:"restock"`
+	Status        RefundStatus `json:"status"`
+	ProviderRef   *string      `json:"provider_ref,omitempty"`
+	FailureReason *string      `json:"failure_reason,omitempty"`
+	CreatedBy     *uuid.UUID   `json:"created_by"`
+	Items         []RefundItem `json:"items"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+}
+
+type RefundItem struct {
+	ID          uuid.UUID       `json:"id"`
+	RefundID    uuid.UUID       `json:"refund_id"`
+	OrderItemID uuid.UUID       `json:"order_item_id"`
+	Quantity    int             `json:"quantity"`
+	Amount      decimal.Decimal `json:"amount"`
+}
+
+// RefundLine asks for units of an order line to be refunded.
+type RefundLine struct {
+	OrderItemID uuid.UUID
+	Quantity    int
+}
+
+// refundableStatuses are the statuses of orders whose money can be refunded.
+// Disputed orders are refunded, or not, by the outcome of the dispute.
+var refundableStatuses = map[OrderStatus]bool{
+	OrderPaid:              true,
+	OrderFulfilling:        true,
+	OrderShipped:           true,
+	OrderDelivered:         true,
+	OrderPartiallyRefunded: true,
+}
+
+// CreateRefund records a pending refund of an order and returns the captured
+// payment it is to be refunded from. Without lines, everything not refunded
+// yet is refunded; otherwise each line is refunded at the price it was sold
+// at, less its share of the order's discounts. The order is locked while the
+// amount is worked out, and pending refunds count as refunded, so refunds can
+// never add up to more than the order total.
+func (q *Query) CreateRefund(ctx context.Context, r *Refund, lines []RefundLine) (*Payment, error) {
+	var p *Payment
+	err := q.withTx(ctx, func(tx *sql.Tx) error {
+		var status OrderStatus
+		var total decimal.Decimal
+		err := tx.QueryRowContext(ctx, `SELECT status, total_amount FROM "order" WHERE id = $1 FOR UPDATE`, r.OrderID).
+			Scan(&status, &total)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrOrderNotFound
+			}
+			return err
+		}
+		if !refundableStatuses[status] {
+			return fmt.Errorf("%w: the order is %s", ErrOrderNotRefundable, status)
+		}
+
+		query := `SELECT ` + paymentColumns + ` FROM "payment" WHERE order_id = $1 AND status = $2 FOR UPDATE`
+		p, err = scanPayment(tx.QueryRowContext(ctx, query, r.OrderID, PaymentCaptured))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNoCapturedPayment
+			}
+			return err
+		}
+
+		items, err := getOrderItems(ctx, tx, r.OrderID)
+		if err != nil {
+			return err
+		}
+		refundedLines, refunded, err := refundedSoFar(ctx, tx, r.OrderID)
+		if err != nil {
+			return err
+		}
+		left := total.Sub(refunded)
+
+		r.Items, r.Amount, err = refundItems(items, refundedLines, lines)
+		if err != nil {
+			return err
+		}
+		if len(lines) == 0 {
+			// The order total is what was charged, so a full refund gives
+			// back exactly what is left of it.
+			r.Amount = left
+		}
+		if !r.Amount.IsPositive() || r.Amount.GreaterThan(left) {
+			return fmt.Errorf("%w: %s of %s is left", ErrRefundExceedsOrder, left.StringFixed(2), total.StringFixed(2))
+		}
+
+		now := time.Now()
+		r.PaymentID = p.ID
+		r.Status = RefundPending
+		r.CreatedAt, r.UpdatedAt = now, now
+		query = `
+			INSERT INTO "refund" (id, order_id, payment_id, amount, reason, restock, status, created_by, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		`
+		_, err = tx.ExecContext(ctx, query, r.ID, r.OrderID, r.PaymentID, r.Amount, r.Reason, r.Restock, r.Status,
+			r.CreatedBy, r.CreatedAt, r.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		for i := range r.Items {
+			item := &r.Items[i]
+			item.ID = uuid.New()
+			item.RefundID = r.ID
+			query = `
+				INSERT INTO "refund_item" (id, refund_id, order_item_id, quantity, amount)
+				VALUES ($1, $2, $3, $4, $5)
+			`
+			if _, err := tx.ExecContext(ctx, query, item.ID, item.RefundID, item.OrderItemID, item.Quantity, item.Amount); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// refundedLine is what has been refunded of an order line.
+type refundedLine struct {
+	Quantity int
+	Amount   decimal.Decimal
+}
+
+// refundedSoFar returns what has been refunded per order line and the amount
+// refunded of an order, counting refunds that are still pending.
+func refundedSoFar(ctx context.Context, tx *sql.Tx, orderID uuid.UUID) (map[uuid.UUID]refundedLine, decimal.Decimal, error) {
+	var refunded decimal.Decimal
+	query := `SELECT COALESCE(SUM(amount), 0) FROM "refund" WHERE order_id = $1 AND status <> $2`
+	if err := tx.QueryRowContext(ctx, query, orderID, RefundFailed).Scan(&refunded); err != nil {
+		return nil, decimal.Zero, err
+	}
+
+	query = `
+		SELECT ri.order_item_id, SUM(ri.quantity), SUM(ri.amount)
+		FROM "refund_item" ri
+		JOIN "refund" r ON r.id = ri.refund_id
+		WHERE r.order_id = $1 AND r.status <> $2
+		GROUP BY ri.order_item_id
+	`
+	rows, err := tx.QueryContext(ctx, query, orderID, RefundFailed)
+	if err != nil {
+		return nil, decimal.Zero, err
+	}
+	defer rows.Close()
+
+	refundedLines := make(map[uuid.UUID]refundedLine)
+	for rows.Next() {
+		var id uuid.UUID
+		var line refundedLine
+		if err := rows.Scan(&id, &line.Quantity, &line.Amount); err != nil {
+			return nil, decimal.Zero, err
+		}
+		refundedLines[id] = line
+	}
+	return refundedLines, refunded, rows.Err()
+}
+
+// refundItems works out the lines of a refund and what they add up to.
+// Without lines, every unit not refunded yet is refunded. Units are refunded
+// at what was paid for them: the line total less the line's discount, spread
+// evenly over its units in whole cents, with the last units refunded getting
+// whatever is left of the line.
+func refundItems(items []OrderItem, refundedLines map[uuid.UUID]refundedLine, lines []RefundLine) ([]RefundItem, decimal.Decimal, error) {
+	requested := make(map[uuid.UUID]int)
+	for _, line := range lines {
+		requested[line.OrderItemID] += line.Quantity
+	}
+
+	byID := make(map[uuid.UUID]OrderItem, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+	for id := range requested {
+		if _, ok := byID[id]; !ok {
+			return nil, decimal.Zero, fmt.Errorf("%w: %s", ErrOrderItemNotFound, id)
+		}
+	}
+
+	refundItems := []RefundItem{}
+	amount := decimal.Zero
+	for _, item := range items {
+		refunded := refundedLines[item.ID]
+		left := item.Quantity - refunded.Quantity
+		quantity := left
+		if len(lines) > 0 {
+			quantity = requested[item.ID]
+			if quantity > left {
+				return nil, decimal.Zero, fmt.Errorf("%w: %s has %d units left to refund, %d requested",
+					ErrRefundExceedsOrder, item.SKU, left, quantity)
+			}
+		}
+		if quantity <= 0 {
+			continue
+		}
+
+		paid := item.Price.Mul(decimal.NewFromInt(int64(item.Quantity))).Sub(item.DiscountAmount)
+		line := RefundItem{OrderItemID: item.ID, Quantity: quantity}
+		if quantity == left {
+			line.Amount = paid.Sub(refunded.Amount)
+		} else {
+			line.Amount = paid.Mul(decimal.NewFromInt(int64(quantity))).Div(decimal.NewFromInt(int64(item.Quantity))).RoundFloor(2)
+		}
+		refundItems = append(refundItems, line)
+		amount = amount.Add(line.Amount)
+	}
+	return refundItems, amount, nil
+}
+
+// CompleteRefund records that the payment provider refunded a pending refund,
+// restocks its units if asked to, and moves the order to refunded once all of
+// its money has been refunded, or to partially_refunded before that. It
+// returns the status of the order afterwards.
+func (q *Query) CompleteRefund(ctx context.Context, r *Refund, providerRef string, changedBy *uuid.UUID) (OrderStatus, error) {
+	var status OrderStatus
+	err := q.withTx(ctx, func(tx *sql.Tx) error {
+		var total decimal.Decimal
+		err := tx.QueryRowContext(ctx, `SELECT status, total_amount FROM "order" WHERE id = $1 FOR UPDATE`, r.OrderID).
+			Scan(&status, &total)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		query := `UPDATE "refund" SET status = $2, provider_ref = $3, updated_at = $4 WHERE id = $1 AND status = $5`
+		res, err := tx.ExecContext(ctx, query, r.ID, RefundSucceeded, providerRef, now, RefundPending)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			// A concurrent retry already recorded the outcome.
+			return ErrRefundNotPending
+		}
+		r.Status = RefundSucceeded
+		r.ProviderRef = &providerRef
+		r.UpdatedAt = now
+
+		if r.Restock {
+			if err := restockRefund(ctx, tx, r.ID); err != nil {
+				return err
+			}
+		}
+
+		var refunded decimal.Decimal
+		query = `SELECT COALESCE(SUM(amount), 0) FROM "refund" WHERE order_id = $1 AND status = $2`
+		if err := tx.QueryRowContext(ctx, query, r.OrderID, RefundSucceeded).Scan(&refunded); err != nil {
+			return err
+		}
+
+		to := OrderPartiallyRefunded
+		if refunded.GreaterThanOrEqual(total) {
+			to = OrderRefunded
+			query = `UPDATE "payment" SET status = $2, updated_at = $3 WHERE id = $1`
+			if _, err := tx.ExecContext(ctx, query, r.PaymentID, PaymentRefunded, now); err != nil {
+				return err
+			}
+		}
+		if status == to {
+			return nil
+		}
+
+		// The money has gone back either way, so the refund is recorded even
+		// if the order has meanwhile moved to a status it cannot leave.
+		if err := transitionOrderIfAllowed(ctx, tx, r.OrderID, to, changedBy, r.Reason); err != nil {
+			return err
+		}
+		return tx.QueryRowContext(ctx, `SELECT status FROM "order" WHERE id = $1`, r.OrderID).Scan(&status)
+	})
+	return status, err
+}
+
+// restockRefund puts the refunded units of a refund back on their variants.
+// The variants are locked in the same order placeOrder locks them in.
+func restockRefund(ctx context.Context, tx *sql.Tx, refundID uuid.UUID) error {
+	query := `
+		SELECT v.id
+		FROM "product_variant" v
+		WHERE v.id IN (
+			SELECT oi.variant_id
+			FROM "refund_item" ri
+			JOIN "order_item" oi ON oi.id = ri.order_item_id
+			WHERE ri.refund_id = $1
+		)
+		ORDER BY v.product_id, v.id
+		FOR UPDATE
+	`
+	if _, err := tx.ExecContext(ctx, query, refundID); err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE "product_variant" v
+		SET units_in_stock = v.units_in_stock + ri.quantity, updated_at = CURRENT_TIMESTAMP
+		FROM (
+			SELECT oi.variant_id, SUM(ri.quantity) AS quantity
+			FROM "refund_item" ri
+			JOIN "order_item" oi ON oi.id = ri.order_item_id
+			WHERE ri.refund_id = $1 AND oi.variant_id IS NOT NULL
+			GROUP BY oi.variant_id
+		) ri
+		WHERE v.id = ri.variant_id
+	`
+	_, err := tx.ExecContext(ctx, query, refundID)
+	return err
+}
+
+// FailRefund records that the payment provider did not refund a pending
+// refund, so that its amount can be refunded again. It returns
+// ErrRefundNotPending if the outcome of the refund was already recorded.
+func (q *Query) FailRefund(ctx context.Context, r *Refund, reason string) error {
+	now := time.Now()
+	query := `UPDATE "refund" SET status = $2, failure_reason = $3, updated_at = $4 WHERE id = $1 AND status = $5`
+	res, err := q.DB.ExecContext(ctx, query, r.ID, RefundFailed, reason, now, RefundPending)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRefundNotPending
+	}
+	r.Status = RefundFailed
+	r.FailureReason = &reason
+	r.UpdatedAt = now
+	return nil
+}
+
+// GetRefund fetches a refund of an order with its lines. It returns nil if
+// there is none.
+func (q *Query) GetRefund(ctx context.Context, orderID, refundID uuid.UUID) (*Refund, error) {
+	refunds, err := q.ListRefunds(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range refunds {
+		if refunds[i].ID == refundID {
+			return &refunds[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// ListRefunds returns the refunds of an order with their lines, oldest first.
+func (q *Query) ListRefunds(ctx context.Context, orderID uuid.UUID) ([]Refund, error) {
+	query := `
+		SELECT id, order_id, payment_id, amount, reason, restock, status, provider_ref, failure_reason, created_by,
+			created_at, updated_at
+		FROM "refund"
+		WHERE order_id = $1
+		ORDER BY created_at, id
+	`
+	rows, err := q.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := []Refund{}
+	index := make(map[uuid.UUID]int)
+	for rows.Next() {
+		var r Refund
+		err := rows.Scan(&r.ID, &r.OrderID, &r.PaymentID, &r.Amount, &r.Reason, &r.Restock, &r.Status, &r.ProviderRef,
+			&r.FailureReason, &r.CreatedBy, &r.CreatedAt, &r.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		r.Items = []RefundItem{}
+		index[r.ID] = len(refunds)
+		refunds = append(refunds, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT ri.id, ri.refund_id, ri.order_item_id, ri.quantity, ri.amount
+		FROM "refund_item" ri
+		JOIN "refund" r ON r.id = ri.refund_id
+		WHERE r.order_id = $1
+		ORDER BY ri.id
+	`
+	itemRows, err := q.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		var item RefundItem
+		if err := itemRows.Scan(&item.ID, &item.RefundID, &item.OrderItemID, &item.Quantity, &item.Amount); err != nil {
+			return nil, err
+		}
+		r := &refunds[index[item.RefundID]]
+		r.Items = append(r.Items, item)
+	}
+	return refunds, itemRows.Err()
+}
@@ -0,0 +1,307 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrRoleNotFound      = errors.New("role not found")
+	ErrRoleNameTaken     = errors.New("role name already in use")
+	ErrRoleInUse         = errors.New("role is still assigned to users")
+	ErrSystemRole        = errors.New("system roles cannot be deleted")
+	ErrUnknownPermission = errors.New("unknown permission")
+	ErrUserNotFound      = errors.New("user not found")
+)
+
+// Role is a named set of permissions that can be assigned to users.
+type Role struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Description *string   `json:"description,omitempty"`
+	IsSystem    bool      `json:"is_system"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type Permission struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description,omitempty"`
+}
+
+// GetPermissions returns every permission that can be granted to a role.
+func (q *Query) GetPermissions(ctx context.Context) ([]Permission, error) {
+	query := `
+		SELECT name, description
+		FROM "permission"
+		ORDER BY name
+	`
+	rows, err := q.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []Permission{}
+	for rows.Next() {
+		var permission Permission
+		if err := rows.Scan(&permission.Name, &permission.Description); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+const roleSelect = `
+	SELECT r.id, r.name, r.description, r.is_system, r.created_at, r.updated_at,
+		COALESCE(array_agg(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}')
+	FROM "role" r
+	LEFT JOIN "role_permission" rp ON rp.role_id = r.id
+	LEFT JOIN "permission" p ON p.id = rp.permission_id
+`
+
+// GetRoles returns every role together with its permissions.
+func (q *Query) GetRoles(ctx context.Context) ([]Role, error) {
+	query := roleSelect + `
+		GROUP BY r.id
+		ORDER BY r.is_system DESC, r.name
+	`
+	rows, err := q.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, *role)
+	}
+	return roles, rows.Err()
+}
+
+// GetRoleByName fetches a role and its permissions. It returns nil if the role
+// does not exist.
+func (q *Query) GetRoleByName(ctx context.Context, name string) (*Role, error) {
+	query := roleSelect + `
+		WHERE r.name = $1
+		GROUP BY r.id
+	`
+	role, err := scanRole(q.DB.QueryRowContext(ctx, query, name))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return role, nil
+}
+
+// GetRolePermissions returns the names of the permissions granted to a role.
+func (q *Query) GetRolePermissions(ctx context.Context, name string) ([]string, error) {
+	role, err := q.GetRoleByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, ErrRoleNotFound
+	}
+	return role.Permissions, nil
+}
+
+// GetRoleUserIDs returns the IDs of the users holding a role.
+func (q *Query) GetRoleUserIDs(ctx context.Context, name string) ([]uuid.UUID, error) {
+	rows, err := q.DB.QueryContext(ctx, `SELECT id FROM "user" WHERE role = $1 ORDER BY id`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// CreateRole inserts a new role and grants it role.Permissions.
+func (q *Query) CreateRole(ctx context.Context, role *Role) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO "role" (id, name, description, is_system, created_at, updated_at)
+			VALUES ($1, $2, $3, FALSE, $4, $5)
+		`
+		_, err := tx.ExecContext(ctx, query, role.ID, role.Name, role.Description, role.CreatedAt, role.UpdatedAt)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" { // unique_violation
+				return ErrRoleNameTaken
+			}
+			return err
+		}
+		return setRolePermissions(ctx, tx, role.ID, role.Permissions)
+	})
+}
+
+// UpdateRole replaces the description and the permissions of a role. Access
+// tokens already issued keep the old permissions until they are renewed or
+// revoked.
+func (q *Query) UpdateRole(ctx context.Context, role *Role) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE "role"
+			SET description = $2, updated_at = $3
+			WHERE id = $1
+		`
+		res, err := tx.ExecContext(ctx, query, role.ID, role.Description, role.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrRoleNotFound
+		}
+		return setRolePermissions(ctx, tx, role.ID, role.Permissions)
+	})
+}
+
+// DeleteRole deletes a role that is neither a system role nor assigned to any
+// user.
+func (q *Query) DeleteRole(ctx context.Context, name string) error {
+	role, err := q.GetRoleByName(ctx, name)
+	if err != nil {
+		return err
+	}
+	if role == nil {
+		return ErrRoleNotFound
+	}
+	if role.IsSystem {
+		return ErrSystemRole
+	}
+
+	query := `
+		DELETE FROM "role"
+		WHERE id = $1 AND NOT is_system
+	`
+	if _, err := q.DB.ExecContext(ctx, query, role.ID); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" { // foreign_key_violation
+			return ErrRoleInUse
+		}
+		return err
+	}
+	return nil
+}
+
+// SetUserRole assigns a role to a user, replacing their current one. The last
+// active admin cannot be given another role.
+func (q *Query) SetUserRole(ctx context.Context, userID uuid.UUID, role string) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		if role != adminRole {
+			if err := guardLastAdmin(ctx, tx, userID); err != nil {
+				return err
+			}
+		}
+
+		query := `
+			UPDATE "user"
+			SET role = $2, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+		`
+		res, err := tx.ExecContext(ctx, query, userID, role)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23503" { // foreign_key_violation
+				return ErrRoleNotFound
+			}
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrUserNotFound
+		}
+		return nil
+	})
+}
+
+// setRolePermissions replaces the permissions granted to a role.
+func setRolePermissions(ctx context.Context, tx *sql.Tx, roleID uuid.UUID, permissions []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM "role_permission" WHERE role_id = $1`, roleID); err != nil {
+		return err
+	}
+
+	unique := make(map[string]bool, len(permissions))
+	names := make([]string, 0, len(permissions))
+	for _, name := range permissions {
+		if !unique[name] {
+			unique[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO "role_permission" (role_id, permission_id)
+		SELECT $1, id FROM "permission" WHERE name = ANY($2)
+	`
+	res, err := tx.ExecContext(ctx, query, roleID, pq.Array(names))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if int(n) != len(names) {
+		return unknownPermission(ctx, tx, names)
+	}
+	return nil
+}
+
+// unknownPermission names the first of names that is not a permission.
+func unknownPermission(ctx context.Context, tx *sql.Tx, names []string) error {
+	query := `
+		SELECT requested.name
+		FROM unnest($1::text[]) AS requested(name)
+		WHERE NOT EXISTS (SELECT 1 FROM "permission" p WHERE p.name = requested.name)
+		LIMIT 1
+	`
+	var name string
+	if err := tx.QueryRowContext(ctx, query, pq.Array(names)).Scan(&name); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownPermission, name)
+}
+
+func scanRole(row rowScanner) (*Role, error) {
+	var role Role
+	var permissions pq.StringArray
+	err := row.Scan(&role.ID, &role.Name, &role.Description, &role.IsSystem, &role.CreatedAt, &role.UpdatedAt,
+		&permissions)
+	if err != nil {
+		return nil, err
+	}
+	role.Permissions = []string(permissions)
+	return &role, nil
+}
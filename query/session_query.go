@@ -0,0 +1,169 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/amosehiguese/ecommerce-api/pkg/session"
+	"github.com/google/uuid"
+)
+
+// Query is the Postgres backend of session.Store.
+var _ session.Store = (*Query)(nil)
+
+// SaveRefreshToken stores a new refresh token record.
+func (q *Query) SaveRefreshToken(ctx context.Context, token *session.RefreshToken) error {
+	query := `
+		INSERT INTO "refresh_token" (token_hash, family_id, user_id, device_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := q.DB.ExecContext(ctx, query, token.TokenHash, token.FamilyID, token.UserID, token.DeviceID,
+		token.ExpiresAt.UTC(), token.CreatedAt.UTC())
+	return err
+}
+
+// ConsumeRefreshToken marks a refresh token as used. The conditional update
+// makes it safe against concurrent refreshes with the same token: only one of
+// them can flip used_at.
+func (q *Query) ConsumeRefreshToken(ctx context.Context, tokenHash string, now time.Time) (*session.RefreshToken, error) {
+	query := `
+		UPDATE "refresh_token"
+		SET used_at = $2
+		WHERE token_hash = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > $2
+		RETURNING token_hash, family_id, user_id, device_id, expires_at, created_at, used_at
+	`
+	token, err := scanRefreshToken(q.DB.QueryRowContext(ctx, query, tokenHash, now.UTC()))
+	if err == nil {
+		return token, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// Nothing was consumed; find out why.
+	query = `
+		SELECT token_hash, family_id, user_id, device_id, expires_at, created_at, used_at, revoked_at IS NOT NULL
+		FROM "refresh_token"
+		WHERE token_hash = $1
+	`
+	var revoked bool
+	token = &session.RefreshToken{}
+	err = q.DB.QueryRowContext(ctx, query, tokenHash).Scan(&token.TokenHash, &token.FamilyID, &token.UserID,
+		&token.DeviceID, &token.ExpiresAt, &token.CreatedAt, &token.UsedAt, &revoked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, session.ErrTokenNotFound
+		}
+		return nil, err
+	}
+
+	switch {
+	case revoked:
+		return token, session.ErrTokenRevoked
+	case token.UsedAt != nil:
+		return token, session.ErrTokenReused
+	default:
+		return token, session.ErrTokenExpired
+	}
+}
+
+// RevokeTokenFamily revokes every token that descends from the same login.
+func (q *Query) RevokeTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	query := `
+		UPDATE "refresh_token"
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`
+	_, err := q.DB.ExecContext(ctx, query, familyID)
+	return err
+}
+
+// RevokeUserTokens revokes every refresh token of a user.
+func (q *Query) RevokeUserTokens(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		UPDATE "refresh_token"
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`
+	_, err := q.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+func scanRefreshToken(row *sql.Row) (*session.RefreshToken, error) {
+	var token session.RefreshToken
+	err := row.Scan(&token.TokenHash, &token.FamilyID, &token.UserID, &token.DeviceID, &token.ExpiresAt,
+		&token.CreatedAt, &token.UsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// DenyAccessToken adds an access token to the denylist until it expires.
+// Entries of tokens that have expired since are cleared on the way.
+func (q *Query) DenyAccessToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM "access_token_denylist" WHERE expires_at < $1`, time.Now().UTC()); err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO "access_token_denylist" (token_id, expires_at)
+			VALUES ($1, $2)
+			ON CONFLICT (token_id) DO NOTHING
+		`
+		_, err := tx.ExecContext(ctx, query, tokenID, expiresAt.UTC())
+		return err
+	})
+}
+
+// ConsumeTokenID puts a single-use token on the denylist and reports whether
+// it was not there yet. An entry whose token expired no longer counts.
+func (q *Query) ConsumeTokenID(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	query := `
+		INSERT INTO "access_token_denylist" (token_id, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (token_id) DO UPDATE
+		SET expires_at = EXCLUDED.expires_at
+		WHERE "access_token_denylist".expires_at < $3
+	`
+	res, err := q.DB.ExecContext(ctx, query, tokenID, expiresAt.UTC(), time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// DenyAccessTokensIssuedBefore denies every access token of a user issued
+// before the given time. The mark is only ever moved forward.
+func (q *Query) DenyAccessTokensIssuedBefore(ctx context.Context, userID uuid.UUID, before, expiresAt time.Time) error {
+	query := `
+		INSERT INTO "user_token_revocation" (user_id, revoked_before, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET revoked_before = GREATEST("user_token_revocation".revoked_before, EXCLUDED.revoked_before),
+			expires_at = GREATEST("user_token_revocation".expires_at, EXCLUDED.expires_at)
+	`
+	_, err := q.DB.ExecContext(ctx, query, userID, before.UTC(), expiresAt.UTC())
+	return err
+}
+
+// IsAccessTokenDenied reports whether an access token is on the denylist or
+// was issued before its user's sessions were all revoked.
+func (q *Query) IsAccessTokenDenied(ctx context.Context, tokenID string, userID uuid.UUID, issuedAt time.Time) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM "access_token_denylist"
+			WHERE token_id = $1 AND expires_at > $4
+		) OR EXISTS (
+			SELECT 1 FROM "user_token_revocation"
+			WHERE user_id = $2 AND revoked_before > $3 AND expires_at > $4
+		)
+	`
+	var denied bool
+	err := q.DB.QueryRowContext(ctx, query, tokenID, userID, issuedAt.UTC(), time.Now().UTC()).Scan(&denied)
+	return denied, err
+}
@@ -0,0 +1,305 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrAdminExists   = errors.New("an admin account already exists")
+	ErrEmailTaken    = errors.New("an account with this email already exists")
+	ErrLastAdmin     = errors.New("the last active admin cannot be demoted or suspended")
+	ErrUserSuspended = errors.New("user is already suspended")
+	ErrNotSuspended  = errors.New("user is not suspended")
+)
+
+const (
+	DefaultUserPageSize = 20
+	MaxUserPageSize     = 100
+
+	// adminRole is the system role the bootstrap creates and that must always
+	// keep an active member.
+	adminRole = "admin"
+)
+
+// UserStatus filters a user listing by suspension.
+type UserStatus string
+
+const (
+	UserStatusActive    UserStatus = "active"
+	UserStatusSuspended UserStatus = "suspended"
+	UserStatusDeleted   UserStatus = "deleted"
+)
+
+// UserFilter narrows a user listing. Zero values mean "no filter".
+type UserFilter struct {
+	// Search matches part of the name or email.
+	Search string
+	Role   string
+	Status UserStatus
+	Limit  int
+	Cursor string
+}
+
+// UserSummary is a user as shown to admins.
+type UserSummary struct {
+	ID               uuid.UUID  `json:"id"`
+	FirstName        string     `json:"first_name"`
+	LastName         *string    `json:"last_name,omitempty"`
+	Email            string     `json:"email"`
+	Role             string     `json:"role"`
+	EmailVerifiedAt  *time.Time `json:"email_verified_at,omitempty"`
+	SuspendedAt      *time.Time `json:"suspended_at,omitempty"`
+	SuspensionReason *string    `json:"suspension_reason,omitempty"`
+	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+type UserPage struct {
+	Users      []UserSummary `json:"users"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// timeCursor marks the last row of a page ordered by creation, newest first.
+type timeCursor struct {
+	CreatedAt string    `json:"c"`
+	ID        uuid.UUID `json:"id"`
+}
+
+func (c timeCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeTimeCursor(s string) (*timeCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c timeCursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.CreatedAt == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+const userSummaryColumns = `id, first_name, last_name, email, role, email_verified_at, suspended_at, suspension_reason, deleted_at, created_at`
+
+func scanUserSummary(row rowScanner) (*UserSummary, error) {
+	var u UserSummary
+	err := row.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Role, &u.EmailVerifiedAt,
+		&u.SuspendedAt, &u.SuspensionReason, &u.DeletedAt, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ListUsers returns one page of users, newest first, using keyset pagination.
+func (q *Query) ListUsers(ctx context.Context, f UserFilter) (*UserPage, error) {
+	if f.Limit <= 0 {
+		f.Limit = DefaultUserPageSize
+	}
+	if f.Limit > MaxUserPageSize {
+		f.Limit = MaxUserPageSize
+	}
+
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.Search != "" {
+		pattern := arg("%" + escapeLike(f.Search) + "%")
+		where = append(where, fmt.Sprintf(
+			"(email ILIKE %[1]s OR first_name ILIKE %[1]s OR last_name ILIKE %[1]s OR first_name || ' ' || last_name ILIKE %[1]s)",
+			pattern))
+	}
+	if f.Role != "" {
+		where = append(where, "role = "+arg(f.Role))
+	}
+	switch f.Status {
+	case UserStatusActive:
+		where = append(where, "suspended_at IS NULL AND deleted_at IS NULL")
+	case UserStatusSuspended:
+		where = append(where, "suspended_at IS NOT NULL")
+	case UserStatusDeleted:
+		where = append(where, "deleted_at IS NOT NULL")
+	}
+	if f.Cursor != "" {
+		cursor, err := decodeTimeCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, fmt.Sprintf("(created_at, id) < (%s::timestamp, %s)", arg(cursor.CreatedAt), arg(cursor.ID)))
+	}
+
+	query := `SELECT ` + userSummaryColumns + ` FROM "user"`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// Fetch one extra row to learn whether another page follows.
+	query += " ORDER BY created_at DESC, id DESC LIMIT " + arg(f.Limit+1)
+
+	rows, err := q.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &UserPage{Users: []UserSummary{}}
+	for rows.Next() {
+		u, err := scanUserSummary(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Users = append(page.Users, *u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Users) > f.Limit {
+		page.Users = page.Users[:f.Limit]
+		last := page.Users[f.Limit-1]
+		page.NextCursor = timeCursor{CreatedAt: formatTimestamp(last.CreatedAt), ID: last.ID}.encode()
+	}
+	return page, nil
+}
+
+// GetUserSummary returns a user as shown to admins, or nil if there is none.
+func (q *Query) GetUserSummary(ctx context.Context, id uuid.UUID) (*UserSummary, error) {
+	row := q.DB.QueryRowContext(ctx, `SELECT `+userSummaryColumns+` FROM "user" WHERE id = $1`, id)
+	u, err := scanUserSummary(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return u, err
+}
+
+// CreateFirstAdmin creates an admin account with a verified email, unless an
+// admin exists already. Concurrent calls cannot both succeed.
+func (q *Query) CreateFirstAdmin(ctx context.Context, u *User) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('admin_bootstrap'))`); err != nil {
+			return err
+		}
+
+		var exists bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM "user" WHERE role = $1)`, adminRole).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrAdminExists
+		}
+
+		query := `
+			INSERT INTO "user" (id, first_name, last_name, email, password_hash, role, email_verified_at, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $7)
+		`
+		_, err = tx.ExecContext(ctx, query, u.ID, u.FirstName, u.LastName, u.Email, u.PasswordHash, adminRole, time.Now().UTC())
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" { // unique_violation
+			return ErrEmailTaken
+		}
+		return err
+	})
+}
+
+// guardLastAdmin fails with ErrLastAdmin if userID is the only active admin.
+// It locks the active admins until tx ends, so that two admins cannot demote
+// or suspend each other at the same time.
+func guardLastAdmin(ctx context.Context, tx *sql.Tx, userID uuid.UUID) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id FROM "user"
+		WHERE role = $1 AND suspended_at IS NULL
+		FOR UPDATE
+	`, adminRole)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var admins []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		admins = append(admins, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(admins) == 1 && admins[0] == userID {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// SuspendUser suspends an account. The last active admin cannot be suspended.
+func (q *Query) SuspendUser(ctx context.Context, userID uuid.UUID, reason string) error {
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		if err := guardLastAdmin(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		var suspendedAt *time.Time
+		err := tx.QueryRowContext(ctx, `SELECT suspended_at FROM "user" WHERE id = $1 FOR UPDATE`, userID).Scan(&suspendedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if suspendedAt != nil {
+			return ErrUserSuspended
+		}
+
+		query := `
+			UPDATE "user"
+			SET suspended_at = $2, suspension_reason = NULLIF($3, ''), updated_at = $2
+			WHERE id = $1
+		`
+		_, err = tx.ExecContext(ctx, query, userID, time.Now().UTC(), reason)
+		return err
+	})
+}
+
+// UnsuspendUser lifts the suspension of an account.
+func (q *Query) UnsuspendUser(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		UPDATE "user"
+		SET suspended_at = NULL, suspension_reason = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND suspended_at IS NOT NULL
+	`
+	res, err := q.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	var exists bool
+	if err := q.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM "user" WHERE id = $1)`, userID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUserNotFound
+	}
+	return ErrNotSuspended
+}
@@ -10,7 +10,6 @@ import (
 	"github.com/amosehiguese/ecommerce-api/pkg/utils"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
@@ -19,20 +18,53 @@ type User struct {
 	LastName     *string   `json:"last_name,omitempty" validate:"omitempty,min=2,max=100"`
 	Email        string    `json:"email" validate:"required,email,max=255"`
 	PasswordHash string    `json:"-" validate:"required,min=8,max=255"`
-	Role         string    `json:"role" validate:"required,oneof=user admin"`
-	CreatedAt    time.Time `json:"created_at" validate:"required"`
-	UpdatedAt    time.Time `json:"updated_at" validate:"required"`
+	Role         string    `json:"role" validate:"required,max=50"`
+	// EmailVerifiedAt is nil until the user follows the emailed verification link.
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	// SuspendedAt is set while an admin has suspended the account.
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at" validate:"required"`
+	UpdatedAt   time.Time  `json:"updated_at" validate:"required"`
+
+	// staleHash is the hash ComparePasswordHash replaced, until it is saved.
+	staleHash string
 }
 
+// ComparePasswordHash reports whether inputPwd is the password of u. A
+// matching password stored with an outdated algorithm or parameters is
+// rehashed into u.PasswordHash; SaveRehashedPassword persists it.
 func (u *User) ComparePasswordHash(inputPwd string) bool {
-	userPassword := utils.NormalizePassword(u.PasswordHash)
-	inputPassword := utils.NormalizePassword(inputPwd)
+	ok, rehash := utils.VerifyPassword(inputPwd, u.PasswordHash)
+	if !ok || !rehash {
+		return ok
+	}
 
-	if err := bcrypt.CompareHashAndPassword(userPassword, inputPassword); err != nil {
-		return false
+	hash, err := utils.HashPassword(inputPwd)
+	if err != nil {
+		logger.Get().Error("Failed to rehash password", zap.String("user_id", u.ID.String()), zap.Error(err))
+		return true
 	}
+	u.staleHash, u.PasswordHash = u.PasswordHash, hash
 	return true
+}
+
+// SaveRehashedPassword stores the hash ComparePasswordHash upgraded, if any.
+// The hash is left alone when the password changed in the meantime.
+func (q *Query) SaveRehashedPassword(ctx context.Context, u *User) error {
+	if u.staleHash == "" {
+		return nil
+	}
 
+	query := `
+		UPDATE "user"
+		SET password_hash = $3
+		WHERE id = $1 AND password_hash = $2
+	`
+	if _, err := q.DB.ExecContext(ctx, query, u.ID, u.staleHash, u.PasswordHash); err != nil {
+		return err
+	}
+	u.staleHash = ""
+	return nil
 }
 
 func (q *Query) CreateUser(ctx context.Context, user *User) (*User, error) {
@@ -60,7 +92,7 @@ func (q *Query) CreateUser(ctx context.Context, user *User) (*User, error) {
 func (q *Query) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	log := logger.Get()
 	query := `
-		SELECT id, first_name, last_name, email, password_hash, role, created_at, updated_at
+		SELECT id, first_name, last_name, email, password_hash, role, email_verified_at, suspended_at, created_at, updated_at
 		FROM "user"
 		WHERE email = $1
 	`
@@ -78,6 +110,8 @@ func (q *Query) GetUserByEmail(ctx context.Context, email string) (*User, error)
 		&user.Email,
 		&user.PasswordHash,
 		&user.Role,
+		&user.EmailVerifiedAt,
+		&user.SuspendedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -99,7 +133,7 @@ func (q *Query) GetUserByEmail(ctx context.Context, email string) (*User, error)
 func (q *Query) GetUserByID(ctx context.Context, id uuid.UUID) (*User, error) {
 	log := logger.Get()
 	query := `
-		SELECT id, first_name, last_name, email, password_hash, role, created_at, updated_at
+		SELECT id, first_name, last_name, email, password_hash, role, email_verified_at, suspended_at, created_at, updated_at
 		FROM "user"
 		WHERE id = $1
 	`
@@ -117,6 +151,8 @@ func (q *Query) GetUserByID(ctx context.Context, id uuid.UUID) (*User, error) {
 		&user.Email,
 		&user.PasswordHash,
 		&user.Role,
+		&user.EmailVerifiedAt,
+		&user.SuspendedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
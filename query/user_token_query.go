@@ -0,0 +1,138 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidUserToken is returned for a token that does not exist, has expired
+// or was already used.
+var ErrInvalidUserToken = errors.New("invalid or expired token")
+
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+)
+
+// UserToken is a single-use token emailed to a user. Only its hash is stored.
+type UserToken struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Purpose   string
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// CreateUserToken stores a new single-use token.
+func (q *Query) CreateUserToken(ctx context.Context, token *UserToken) error {
+	query := `
+		INSERT INTO "user_token" (id, user_id, purpose, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := q.DB.ExecContext(ctx, query, token.ID, token.UserID, token.Purpose, token.TokenHash,
+		token.ExpiresAt.UTC(), token.CreatedAt.UTC())
+	return err
+}
+
+// CountUserTokensSince counts the tokens issued to a user for a purpose since
+// the given time, used or not.
+func (q *Query) CountUserTokensSince(ctx context.Context, userID uuid.UUID, purpose string, since time.Time) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM "user_token"
+		WHERE user_id = $1 AND purpose = $2 AND created_at >= $3
+	`
+	var count int
+	err := q.DB.QueryRowContext(ctx, query, userID, purpose, since.UTC()).Scan(&count)
+	return count, err
+}
+
+// consumeUserToken marks a valid token as used and returns its user. The
+// conditional update lets only one of several concurrent uses succeed.
+func consumeUserToken(ctx context.Context, tx *sql.Tx, tokenHash, purpose string, now time.Time) (uuid.UUID, error) {
+	query := `
+		UPDATE "user_token"
+		SET used_at = $3
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3
+		RETURNING user_id
+	`
+	var userID uuid.UUID
+	err := tx.QueryRowContext(ctx, query, tokenHash, purpose, now.UTC()).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, ErrInvalidUserToken
+	}
+	return userID, err
+}
+
+// invalidateUserTokens marks every outstanding token of a user for a purpose
+// as used.
+func invalidateUserTokens(ctx context.Context, tx *sql.Tx, userID uuid.UUID, purpose string, now time.Time) error {
+	query := `
+		UPDATE "user_token"
+		SET used_at = $3
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+	`
+	_, err := tx.ExecContext(ctx, query, userID, purpose, now.UTC())
+	return err
+}
+
+// ResetPassword consumes a password reset token and sets the password of its
+// user. Every other reset token of the user stops working as well. It returns
+// the user whose password changed.
+func (q *Query) ResetPassword(ctx context.Context, tokenHash, passwordHash string) (uuid.UUID, error) {
+	var userID uuid.UUID
+	err := q.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now()
+
+		var err error
+		userID, err = consumeUserToken(ctx, tx, tokenHash, TokenPurposePasswordReset, now)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			UPDATE "user"
+			SET password_hash = $2, updated_at = $3
+			WHERE id = $1
+		`
+		if _, err := tx.ExecContext(ctx, query, userID, passwordHash, now); err != nil {
+			return err
+		}
+
+		return invalidateUserTokens(ctx, tx, userID, TokenPurposePasswordReset, now)
+	})
+	return userID, err
+}
+
+// VerifyEmail consumes an email verification token and marks the email of its
+// user as verified. It returns the verified user.
+func (q *Query) VerifyEmail(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	var userID uuid.UUID
+	err := q.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now()
+
+		var err error
+		userID, err = consumeUserToken(ctx, tx, tokenHash, TokenPurposeEmailVerification, now)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			UPDATE "user"
+			SET email_verified_at = $2, updated_at = $2
+			WHERE id = $1 AND email_verified_at IS NULL
+		`
+		if _, err := tx.ExecContext(ctx, query, userID, now); err != nil {
+			return err
+		}
+
+		return invalidateUserTokens(ctx, tx, userID, TokenPurposeEmailVerification, now)
+	})
+	return userID, err
+}
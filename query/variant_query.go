@@ -0,0 +1,322 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrVariantNotFound     = errors.New("variant not found")
+	ErrInvalidVariants     = errors.New("invalid variant matrix")
+	ErrSKUTaken            = errors.New("sku or barcode already in use")
+	ErrVariantStockManaged = errors.New("product has several variants, set stock on each variant instead")
+)
+
+// ProductOption is an axis of a product's variant matrix, such as size or
+// colour, together with the values a variant may take on it.
+type ProductOption struct {
+	Name     string   `json:"name"`
+	Values   []string `json:"values"`
+	Position int      `json:"position"`
+}
+
+// ProductVariant is the sellable unit of a product. A nil Price inherits the
+// product price; EffectivePrice is the price the variant sells at.
+type ProductVariant struct {
+	ID             uuid.UUID         `json:"id"`
+	ProductID      uuid.UUID         `json:"product_id"`
+	SKU            string            `json:"sku"`
+	Barcode        *string           `json:"barcode,omitempty"`
+	Price          *decimal.Decimal  `json:"price"`
+	EffectivePrice decimal.Decimal   `json:"effective_price"`
+	UnitsInStock   int               `json:"units_in_stock"`
+	Options        map[string]string `json:"options"`
+	IsDefault      bool              `json:"is_default"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+}
+
+// DefaultSKU is the SKU given to a product's default variant when none is
+// provided.
+func DefaultSKU(productID uuid.UUID) string {
+	hex := strings.ReplaceAll(productID.String(), "-", "")
+	return "SKU-" + strings.ToUpper(hex[:12])
+}
+
+// GetProductOptions returns the option axes of a product in display order.
+func (q *Query) GetProductOptions(ctx context.Context, productID uuid.UUID) ([]ProductOption, error) {
+	query := `
+		SELECT name, "values", position
+		FROM "product_option"
+		WHERE product_id = $1
+		ORDER BY position, name
+	`
+	rows, err := q.DB.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	options := []ProductOption{}
+	for rows.Next() {
+		var option ProductOption
+		if err := rows.Scan(&option.Name, pq.Array(&option.Values), &option.Position); err != nil {
+			return nil, err
+		}
+		options = append(options, option)
+	}
+	return options, rows.Err()
+}
+
+// GetProductVariants returns every variant of a product, default first.
+func (q *Query) GetProductVariants(ctx context.Context, productID uuid.UUID) ([]ProductVariant, error) {
+	return getProductVariants(ctx, q.DB, productID)
+}
+
+func getProductVariants(ctx context.Context, db queryer, productID uuid.UUID) ([]ProductVariant, error) {
+	query := `
+		SELECT v.id, v.product_id, v.sku, v.barcode, v.price, COALESCE(v.price, p.price), v.units_in_stock,
+			v.options, v.is_default, v.created_at, v.updated_at
+		FROM "product_variant" v
+		JOIN "product" p ON p.id = v.product_id
+		WHERE v.product_id = $1
+		ORDER BY v.is_default DESC, v.created_at, v.id
+	`
+	rows, err := db.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := []ProductVariant{}
+	for rows.Next() {
+		variant, err := scanVariant(rows)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, *variant)
+	}
+	return variants, rows.Err()
+}
+
+// ResolveVariant returns the variant an order or cart line refers to: the
+// given variant of the product, or the product's default variant when
+// variantID is nil. It returns nil if there is no such variant.
+func (q *Query) ResolveVariant(ctx context.Context, productID uuid.UUID, variantID *uuid.UUID) (*ProductVariant, error) {
+	query := `
+		SELECT v.id, v.product_id, v.sku, v.barcode, v.price, COALESCE(v.price, p.price), v.units_in_stock,
+			v.options, v.is_default, v.created_at, v.updated_at
+		FROM "product_variant" v
+		JOIN "product" p ON p.id = v.product_id
+		WHERE v.product_id = $1 AND (v.id = $2 OR ($2 IS NULL AND v.is_default))
+	`
+	variant, err := scanVariant(q.DB.QueryRowContext(ctx, query, productID, variantID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return variant, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVariant(row rowScanner) (*ProductVariant, error) {
+	var variant ProductVariant
+	var options []byte
+	err := row.Scan(&variant.ID, &variant.ProductID, &variant.SKU, &variant.Barcode, &variant.Price,
+		&variant.EffectivePrice, &variant.UnitsInStock, &options, &variant.IsDefault, &variant.CreatedAt, &variant.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(options, &variant.Options); err != nil {
+		return nil, err
+	}
+	return &variant, nil
+}
+
+// SetProductVariants replaces the option axes and the variant matrix of a
+// product. Variants are matched on ID: listed variants with a known ID are
+// updated, the others are created, and existing variants that are not listed
+// are removed. Past orders keep the SKU of a removed variant.
+func (q *Query) SetProductVariants(ctx context.Context, productID uuid.UUID, options []ProductOption, variants []ProductVariant) error {
+	if err := validateVariantMatrix(options, variants); err != nil {
+		return err
+	}
+
+	return q.withTx(ctx, func(tx *sql.Tx) error {
+		if err := lockProductVariants(ctx, tx, productID); err != nil {
+			return err
+		}
+
+		var id uuid.UUID
+		err := tx.QueryRowContext(ctx, `SELECT id FROM "product" WHERE id = $1 FOR UPDATE`, productID).Scan(&id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrProductNotFound
+			}
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM "product_option" WHERE product_id = $1`, productID); err != nil {
+			return err
+		}
+		for _, option := range options {
+			query := `
+				INSERT INTO "product_option" (product_id, name, "values", position)
+				VALUES ($1, $2, $3, $4)
+			`
+			if _, err := tx.ExecContext(ctx, query, productID, option.Name, pq.Array(option.Values), option.Position); err != nil {
+				return err
+			}
+		}
+
+		existing, err := getProductVariants(ctx, tx, productID)
+		if err != nil {
+			return err
+		}
+		listed := make(map[uuid.UUID]bool)
+		for _, variant := range variants {
+			if variant.ID != uuid.Nil {
+				listed[variant.ID] = true
+			}
+		}
+		known := make(map[uuid.UUID]bool)
+		for _, variant := range existing {
+			known[variant.ID] = true
+			if !listed[variant.ID] {
+				if _, err := tx.ExecContext(ctx, `DELETE FROM "product_variant" WHERE id = $1`, variant.ID); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Clear the default flag first so the one-default-per-product index
+		// holds while the rows are rewritten. SKUs and barcodes are parked on
+		// the variant ID for the same reason, so two variants can swap them.
+		query := `
+			UPDATE "product_variant"
+			SET is_default = FALSE, sku = id::text, barcode = NULL
+			WHERE product_id = $1
+		`
+		_, err = tx.ExecContext(ctx, query, productID)
+		if err != nil {
+			return err
+		}
+
+		for i := range variants {
+			variant := &variants[i]
+			variant.ProductID = productID
+			if variant.Options == nil {
+				variant.Options = map[string]string{}
+			}
+			encoded, err := json.Marshal(variant.Options)
+			if err != nil {
+				return err
+			}
+
+			if variant.ID != uuid.Nil && !known[variant.ID] {
+				return fmt.Errorf("%w: %s", ErrVariantNotFound, variant.ID)
+			}
+			if known[variant.ID] {
+				query := `
+					UPDATE "product_variant"
+					SET sku = $1, barcode = $2, price = $3, units_in_stock = $4, options = $5, is_default = $6,
+						updated_at = CURRENT_TIMESTAMP
+					WHERE id = $7
+				`
+				_, err = tx.ExecContext(ctx, query, variant.SKU, variant.Barcode, variant.Price, variant.UnitsInStock,
+					encoded, variant.IsDefault, variant.ID)
+			} else {
+				variant.ID = uuid.New()
+				query := `
+					INSERT INTO "product_variant" (id, product_id, sku, barcode, price, units_in_stock, options, is_default)
+					VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+				`
+				_, err = tx.ExecContext(ctx, query, variant.ID, productID, variant.SKU, variant.Barcode, variant.Price,
+					variant.UnitsInStock, encoded, variant.IsDefault)
+			}
+			if err != nil {
+				return variantError(err)
+			}
+		}
+		return nil
+	})
+}
+
+// validateVariantMatrix checks that no variant has a negative price, that
+// every variant picks exactly one allowed value on every option axis, that no two variants share the same
+// combination, and that exactly one variant is the default.
+func validateVariantMatrix(options []ProductOption, variants []ProductVariant) error {
+	if len(variants) == 0 {
+		return fmt.Errorf("%w: a product needs at least one variant", ErrInvalidVariants)
+	}
+
+	allowed := make(map[string]map[string]bool, len(options))
+	for _, option := range options {
+		if option.Name == "" || len(option.Values) == 0 {
+			return fmt.Errorf("%w: option %q needs a name and at least one value", ErrInvalidVariants, option.Name)
+		}
+		if allowed[option.Name] != nil {
+			return fmt.Errorf("%w: option %q is listed twice", ErrInvalidVariants, option.Name)
+		}
+		allowed[option.Name] = make(map[string]bool, len(option.Values))
+		for _, value := range option.Values {
+			allowed[option.Name][value] = true
+		}
+	}
+
+	defaults := 0
+	seen := make(map[string]bool, len(variants))
+	for _, variant := range variants {
+		if variant.IsDefault {
+			defaults++
+		}
+		if variant.Price != nil && variant.Price.IsNegative() {
+			return fmt.Errorf("%w: variant %s has a negative price", ErrInvalidVariants, variant.SKU)
+		}
+		if len(variant.Options) != len(options) {
+			return fmt.Errorf("%w: variant %s must set exactly one value for each option", ErrInvalidVariants, variant.SKU)
+		}
+
+		names := make([]string, 0, len(options))
+		for _, option := range options {
+			value, ok := variant.Options[option.Name]
+			if !ok || !allowed[option.Name][value] {
+				return fmt.Errorf("%w: variant %s has no valid value for option %q", ErrInvalidVariants, variant.SKU, option.Name)
+			}
+			names = append(names, option.Name+"="+value)
+		}
+		combination := strings.Join(names, "&")
+		if seen[combination] {
+			return fmt.Errorf("%w: more than one variant has options %s", ErrInvalidVariants, combination)
+		}
+		seen[combination] = true
+	}
+
+	if defaults != 1 {
+		return fmt.Errorf("%w: exactly one variant must be the default", ErrInvalidVariants)
+	}
+	return nil
+}
+
+// variantError translates constraint violations into the variant errors.
+func variantError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" { // unique_violation
+		return ErrSKUTaken
+	}
+	return err
+}
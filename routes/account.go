@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"github.com/amosehiguese/ecommerce-api/api"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterAccountRoutes(router *gin.RouterGroup, a api.API) {
+	router.GET("/me", a.GetProfile)
+	router.PATCH("/me", a.UpdateProfile)
+	router.POST("/me/email", a.ChangeEmail)
+	router.POST("/me/password", a.ChangePassword)
+	router.DELETE("/me", a.DeleteAccount)
+
+	router.GET("/me/addresses", a.ListAddresses)
+	router.POST("/me/addresses", a.CreateAddress)
+	router.GET("/me/addresses/:id", a.GetAddress)
+	router.PUT("/me/addresses/:id", a.UpdateAddress)
+	router.DELETE("/me/addresses/:id", a.DeleteAddress)
+}
@@ -2,16 +2,61 @@ package routes
 
 import (
 	"github.com/amosehiguese/ecommerce-api/api"
+	"github.com/amosehiguese/ecommerce-api/middleware"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
 	"github.com/gin-gonic/gin"
 )
 
 func RegisterAuthRoutes(router *gin.RouterGroup, a api.API) {
 	router.POST("/register", a.Register)
 	router.POST("/login", a.Login)
+	router.POST("/password/forgot", a.ForgotPassword)
+	router.POST("/password/reset", a.ResetPassword)
+	router.GET("/verify", a.VerifyEmail)
+	router.POST("/verify/resend", a.ResendVerification)
+	router.POST("/login/mfa", a.VerifyMFAChallenge)
+	router.POST("/login/mfa/setup", a.SetupMFAChallenge)
 
-	router.POST("/create-admin", a.CreateAdmin)
+	router.POST("/setup-admin", a.SetupAdmin)
 }
 
 func RegisterTokenRenewal(router *gin.RouterGroup, a api.API) {
 	router.POST("/renew-token", a.RenewTokens)
 }
+
+func RegisterSessionRoutes(router *gin.RouterGroup, a api.API) {
+	router.POST("/logout", a.Logout)
+	router.POST("/logout-all", a.LogoutAll)
+
+	router.GET("/mfa", a.MFAStatus)
+	router.POST("/mfa/setup", a.SetupMFA)
+	router.POST("/mfa/enable", a.EnableMFA)
+	router.POST("/mfa/disable", a.DisableMFA)
+	router.POST("/mfa/recovery-codes", a.RegenerateRecoveryCodes)
+}
+
+func RegisterRoleRoutes(router *gin.RouterGroup, a api.API) {
+	roles := router.Group("", middleware.Require(auth.RoleManageCredential))
+	{
+		roles.GET("/permissions", a.ListPermissions)
+
+		roles.GET("/roles", a.ListRoles)
+		roles.POST("/roles", a.CreateRole)
+		roles.PUT("/roles/:name", a.UpdateRole)
+		roles.DELETE("/roles/:name", a.DeleteRole)
+
+		roles.PUT("/users/:id/role", a.SetUserRole)
+	}
+}
+
+func RegisterUserAdminRoutes(router *gin.RouterGroup, a api.API) {
+	users := router.Group("/users", middleware.Require(auth.UserManageCredential))
+	{
+		users.GET("", a.ListUsers)
+		users.GET("/:id", a.GetUser)
+		users.POST("/:id/suspend", a.SuspendUser)
+		users.POST("/:id/unsuspend", a.UnsuspendUser)
+	}
+
+	router.GET("/audit-log", middleware.Require(auth.AuditReadCredential), a.ListAuditLog)
+}
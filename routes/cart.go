@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"github.com/amosehiguese/ecommerce-api/api"
+	"github.com/amosehiguese/ecommerce-api/middleware"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterCartRoutes(router *gin.RouterGroup, a api.API) {
+	// A cart is an order in the making, so it needs the order create permission
+	cart := router.Group("/cart", middleware.Require(auth.OrderCreateCredential))
+	{
+		cart.GET("", a.GetCart)
+		cart.DELETE("", a.ClearCart)
+		cart.POST("/items", a.AddCartItem)
+		cart.PATCH("/items/:variant_id", a.UpdateCartItem)
+		cart.DELETE("/items/:variant_id", a.RemoveCartItem)
+		cart.POST("/checkout", a.CheckoutCart)
+	}
+}
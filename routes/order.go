@@ -3,18 +3,24 @@ package routes
 import (
 	"github.com/amosehiguese/ecommerce-api/api"
 	"github.com/amosehiguese/ecommerce-api/middleware"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
 	"github.com/gin-gonic/gin"
 )
 
 func RegisterOrderRoutes(router *gin.RouterGroup, a api.API) {
-	// Order routes for all authenticated users
-	router.POST("/orders", a.CreateOrder)
-	router.GET("/orders", a.ListUserOrders)
-	router.PUT("/orders/:id/cancel", a.CancelOrder)
+	// Customer order routes
+	router.POST("/orders", middleware.Require(auth.OrderCreateCredential), a.CreateOrder)
+	router.POST("/orders/quote", middleware.Require(auth.OrderCreateCredential), a.QuoteOrder)
+	router.GET("/orders", middleware.Require(auth.OrderReadCredential), a.ListUserOrders)
+	router.POST("/orders/:id/pay", middleware.Require(auth.OrderCreateCredential), a.PayOrder)
+	router.PUT("/orders/:id/cancel", middleware.Require(auth.OrderCancelCredential), a.CancelOrder)
+	router.GET("/orders/:id/history", middleware.Require(auth.OrderReadCredential), a.GetOrderHistory)
 
-	// Admin-only order status management
-	admin := router.Group("/orders", middleware.AdminOnly())
-	{
-		admin.PUT("/:id/status", a.UpdateOrderStatus)
-	}
+	// Order status management
+	router.PUT("/orders/:id/status", middleware.Require(auth.OrderUpdateCredential), a.UpdateOrderStatus)
+
+	// Refunds
+	router.POST("/orders/:id/refunds", middleware.Require(auth.OrderRefundCredential), a.RefundOrder)
+	router.GET("/orders/:id/refunds", middleware.Require(auth.OrderRefundCredential), a.ListOrderRefunds)
+	router.POST("/orders/:id/refunds/:refund_id/retry", middleware.Require(auth.OrderRefundCredential), a.RetryRefund)
 }
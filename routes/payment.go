@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/amosehiguese/ecommerce-api/api"
+	"github.com/amosehiguese/ecommerce-api/middleware"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterWebhookRoutes mounts the endpoints called by third parties. They
+// authenticate requests by their signature rather than a token.
+func RegisterWebhookRoutes(router *gin.RouterGroup, a api.API) {
+	router.POST("/payments", a.PaymentWebhook)
+}
+
+func RegisterPaymentEventRoutes(router *gin.RouterGroup, a api.API) {
+	events := router.Group("/payment-events", middleware.Require(auth.OrderUpdateCredential))
+	{
+		events.GET("", a.ListPaymentEvents)
+		events.POST("/:id/replay", a.ReplayPaymentEvent)
+	}
+}
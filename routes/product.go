@@ -3,19 +3,29 @@ package routes
 import (
 	"github.com/amosehiguese/ecommerce-api/api"
 	"github.com/amosehiguese/ecommerce-api/middleware"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
 	"github.com/gin-gonic/gin"
 )
 
 func RegisterProductRoutes(router *gin.RouterGroup, a api.API) {
-	// Admin routes for product management
-	admin := router.Group("/products", middleware.AdminOnly())
-	{
-		admin.POST("/", a.CreateProduct)
-		admin.PUT("/:id", a.UpdateProduct)
-		admin.DELETE("/:id", a.DeleteProduct)
-	}
+	// Product management
+	router.POST("/products/", middleware.Require(auth.ProductCreateCredential), a.CreateProduct)
+	router.PUT("/products/:id", middleware.Require(auth.ProductUpdateCredential), a.UpdateProduct)
+	router.DELETE("/products/:id", middleware.Require(auth.ProductDeleteCredential), a.DeleteProduct)
+	router.PUT("/products/:id/categories", middleware.Require(auth.ProductUpdateCredential), a.SetProductCategories)
+	router.PUT("/products/:id/variants", middleware.Require(auth.ProductUpdateCredential), a.SetProductVariants)
+
+	// Category management
+	router.POST("/categories", middleware.Require(auth.ProductCreateCredential), a.CreateCategory)
+	router.PUT("/categories/:id", middleware.Require(auth.ProductUpdateCredential), a.UpdateCategory)
+	router.DELETE("/categories/:id", middleware.Require(auth.ProductDeleteCredential), a.DeleteCategory)
 
 	// General product routes
-	router.GET("/products", a.ListProducts)
-	router.GET("/products/:id", a.GetProduct)
+	router.GET("/products", middleware.Require(auth.ProductReadCredential), a.ListProducts)
+	router.GET("/products/search", middleware.Require(auth.ProductReadCredential), a.SearchProducts)
+	router.GET("/products/:id", middleware.Require(auth.ProductReadCredential), a.GetProduct)
+
+	// General category routes
+	router.GET("/categories", middleware.Require(auth.ProductReadCredential), a.ListCategories)
+	router.GET("/categories/:slug/products", middleware.Require(auth.ProductReadCredential), a.ListCategoryProducts)
 }
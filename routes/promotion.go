@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/amosehiguese/ecommerce-api/api"
+	"github.com/amosehiguese/ecommerce-api/middleware"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterPromotionRoutes(router *gin.RouterGroup, a api.API) {
+	promotions := router.Group("/promotions", middleware.Require(auth.PromotionManageCredential))
+	{
+		promotions.POST("", a.CreatePromotion)
+		promotions.GET("", a.ListPromotions)
+		promotions.GET("/:id", a.GetPromotion)
+		promotions.PUT("/:id", a.UpdatePromotion)
+		promotions.DELETE("/:id", a.DeletePromotion)
+	}
+}
@@ -2,20 +2,57 @@ package routes
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/amosehiguese/ecommerce-api/api"
 	"github.com/amosehiguese/ecommerce-api/middleware"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
 	"github.com/amosehiguese/ecommerce-api/pkg/config"
+	"github.com/amosehiguese/ecommerce-api/pkg/lockout"
+	"github.com/amosehiguese/ecommerce-api/pkg/logger"
+	"github.com/amosehiguese/ecommerce-api/pkg/mailer"
+	"github.com/amosehiguese/ecommerce-api/pkg/payment"
+	"github.com/amosehiguese/ecommerce-api/pkg/session"
+	"github.com/amosehiguese/ecommerce-api/pkg/utils"
 	"github.com/amosehiguese/ecommerce-api/query"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 
 	_ "github.com/amosehiguese/ecommerce-api/docs"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
-func SetUp(dbconn *sql.DB, cfg *config.Config) *gin.Engine {
+// Option overrides a dependency that SetUp would otherwise build from the
+// configuration.
+type Option func(*dependencies)
+
+type dependencies struct {
+	mailer   mailer.Mailer
+	payments payment.Provider
+}
+
+// WithMailer makes the API send email through m, for example a
+// mailer.MemoryMailer in tests.
+func WithMailer(m mailer.Mailer) Option {
+	return func(d *dependencies) {
+		d.mailer = m
+	}
+}
+
+// WithPaymentProvider makes the API take payments through p, for example a
+// payment.FakeProvider in tests.
+func WithPaymentProvider(p payment.Provider) Option {
+	return func(d *dependencies) {
+		d.payments = p
+	}
+}
+
+func SetUp(dbconn *sql.DB, cfg *config.Config, opts ...Option) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 
@@ -27,8 +64,46 @@ func SetUp(dbconn *sql.DB, cfg *config.Config) *gin.Engine {
 	// Initialize Query
 	q := query.NewQuery(dbconn)
 
+	// Initialize refresh token sessions
+	sessions, err := newSessionManager(cfg, &q)
+	if err != nil {
+		panic(fmt.Sprintf("failed to set up session store: %v", err))
+	}
+
+	// Initialize access token signing keys
+	keys, err := newKeyring(cfg)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load signing keys: %v", err))
+	}
+
+	// Initialize failed login tracking
+	lockoutGuard, err := newLockoutGuard(cfg)
+	if err != nil {
+		panic(fmt.Sprintf("failed to set up login lockout store: %v", err))
+	}
+
+	// Initialize password hashing and policy
+	passwords, err := newPasswordPolicy(cfg)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load password policy: %v", err))
+	}
+
+	// Initialize outgoing email
+	deps := dependencies{mailer: newMailer(cfg)}
+	for _, opt := range opts {
+		opt(&deps)
+	}
+
+	// Initialize the payment provider
+	if deps.payments == nil {
+		deps.payments, err = newPaymentProvider(cfg)
+		if err != nil {
+			panic(fmt.Sprintf("failed to set up payment provider: %v", err))
+		}
+	}
+
 	// Initialize API
-	a := api.NewAPI(q, cfg)
+	a := api.NewAPI(q, cfg, sessions, keys, deps.mailer, lockoutGuard, passwords, deps.payments)
 
 	// Swagger endpoint
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
@@ -36,17 +111,141 @@ func SetUp(dbconn *sql.DB, cfg *config.Config) *gin.Engine {
 	// Health
 	router.GET("/_healthz", a.HealthCheck)
 
+	// Public keys for verifying access tokens
+	router.GET("/.well-known/jwks.json", a.JWKS)
+
 	// Public routes
 	public := router.Group("/api/auth")
 	RegisterAuthRoutes(public, a)
 
+	// Token renewal authenticates with the refresh token alone, so that an
+	// expired access token can be renewed
+	RegisterTokenRenewal(router.Group("/api"), a)
+
+	// Payment provider webhooks, authenticated by their signature
+	RegisterWebhookRoutes(router.Group("/api/webhooks"), a)
+
+	// Session routes (authentication required)
+	sessionRoutes := router.Group("/api/auth", middleware.JWTProtected(keys, sessions))
+	RegisterSessionRoutes(sessionRoutes, a)
+
 	// Protected routes (authentication required)
-	auth := router.Group("/api", middleware.JWTProtected())
+	auth := router.Group("/api", middleware.JWTProtected(keys, sessions))
 	{
-		RegisterTokenRenewal(auth, a)
+		RegisterAccountRoutes(auth, a)
 		RegisterProductRoutes(auth, a)
 		RegisterOrderRoutes(auth, a)
+		RegisterCartRoutes(auth, a)
+		RegisterRoleRoutes(auth.Group("/admin"), a)
+		RegisterUserAdminRoutes(auth.Group("/admin"), a)
+		RegisterPaymentEventRoutes(auth.Group("/admin"), a)
+		RegisterPromotionRoutes(auth.Group("/admin"), a)
 	}
 
 	return router
 }
+
+// newSessionManager builds the refresh token manager on the configured store.
+func newSessionManager(cfg *config.Config, q *query.Query) (*session.Manager, error) {
+	hours, err := strconv.Atoi(cfg.JWT.JwtRefreshKeyExp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid refresh token lifetime: %w", err)
+	}
+	ttl := time.Duration(hours) * time.Hour
+
+	minutes, err := strconv.Atoi(cfg.JWT.JwtSecretKeyExp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access token lifetime: %w", err)
+	}
+	accessTTL := time.Duration(minutes) * time.Minute
+
+	var store session.Store
+	switch cfg.Session.Store {
+	case "redis":
+		opts, err := redis.ParseURL(cfg.Session.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		store = session.NewRedisStore(redis.NewClient(opts), ttl)
+	case "memory":
+		store = session.NewMemoryStore()
+	default:
+		store = q
+	}
+	return session.NewManager(store, ttl, accessTTL), nil
+}
+
+// newLockoutGuard builds the failed login tracker on the configured store.
+func newLockoutGuard(cfg *config.Config) (*lockout.Guard, error) {
+	var store lockout.Store = lockout.NewMemoryStore()
+	if cfg.Lockout.Store == "redis" {
+		opts, err := redis.ParseURL(cfg.Lockout.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		store = lockout.NewRedisStore(redis.NewClient(opts))
+	}
+
+	policy := func(maxFailures int) lockout.Policy {
+		return lockout.Policy{
+			MaxFailures: maxFailures,
+			Lockout:     cfg.Lockout.Lockout,
+			MaxLockout:  cfg.Lockout.MaxLockout,
+			Window:      cfg.Lockout.Window,
+		}
+	}
+	return lockout.NewGuard(store, policy(cfg.Lockout.AccountMaxFailures), policy(cfg.Lockout.ClientMaxFailures)), nil
+}
+
+// newPasswordPolicy configures password hashing and returns the policy new
+// passwords must meet.
+func newPasswordPolicy(cfg *config.Config) (*utils.PasswordPolicy, error) {
+	params := utils.DefaultArgon2idParams
+	params.Memory = cfg.Password.Argon2Memory
+	params.Iterations = cfg.Password.Argon2Iterations
+	params.Parallelism = cfg.Password.Argon2Parallelism
+	utils.SetPasswordHasher(utils.NewArgon2idHasher(params))
+
+	policy := utils.NewPasswordPolicy(cfg.Password.MinLength, cfg.Password.MaxLength)
+	if cfg.Password.BreachedListFile != "" {
+		if err := policy.LoadBreachedPasswords(cfg.Password.BreachedListFile); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// newKeyring loads the access token signing keys. Outside production a
+// throwaway key is generated when no key directory is configured.
+func newKeyring(cfg *config.Config) (*auth.Keyring, error) {
+	if cfg.JWT.KeysDir != "" {
+		return auth.LoadKeyring(cfg.JWT.KeysDir, cfg.JWT.ActiveKeyID)
+	}
+	if cfg.Env == "prod" {
+		return nil, errors.New("JWT_KEYS_DIR must be set in production")
+	}
+	logger.Get().Warn("JWT_KEYS_DIR not set, signing access tokens with a generated key")
+	return auth.GenerateKeyring()
+}
+
+// newMailer builds the configured mailer.
+func newMailer(cfg *config.Config) mailer.Mailer {
+	if cfg.Mail.Driver == "smtp" {
+		return mailer.NewSMTPMailer(cfg.Mail.SMTPHost, cfg.Mail.SMTPPort, cfg.Mail.SMTPUsername,
+			cfg.Mail.SMTPPassword, cfg.Mail.From)
+	}
+	return mailer.NewMemoryMailer()
+}
+
+// newPaymentProvider builds the configured payment provider. The fake provider
+// accepts test payment methods that anyone can use, so production refuses it.
+func newPaymentProvider(cfg *config.Config) (payment.Provider, error) {
+	if cfg.Payment.Provider == "stripe" {
+		return payment.NewStripeProvider(cfg.Payment.StripeSecretKey, cfg.Payment.StripeWebhookSecret), nil
+	}
+	if cfg.Env == "prod" {
+		return nil, errors.New("PAYMENT_PROVIDER must be stripe in production")
+	}
+	logger.Get().Warn("PAYMENT_PROVIDER is fake, payments are simulated")
+	return payment.NewFakeProvider(), nil
+}
@@ -0,0 +1,194 @@
+package routes
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/amosehiguese/ecommerce-api/api"
+	"github.com/amosehiguese/ecommerce-api/pkg/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// routePermissions lists the permission guarding each authenticated endpoint.
+// An empty value means any authenticated user may call it.
+var routePermissions = map[string]string{
+	"POST /api/auth/logout":     "",
+	"POST /api/auth/logout-all": "",
+
+	"GET /api/auth/mfa":                 "",
+	"POST /api/auth/mfa/setup":          "",
+	"POST /api/auth/mfa/enable":         "",
+	"POST /api/auth/mfa/disable":        "",
+	"POST /api/auth/mfa/recovery-codes": "",
+
+	"GET /api/me":           "",
+	"PATCH /api/me":         "",
+	"POST /api/me/email":    "",
+	"POST /api/me/password": "",
+	"DELETE /api/me":        "",
+
+	"GET /api/me/addresses":        "",
+	"POST /api/me/addresses":       "",
+	"GET /api/me/addresses/:id":    "",
+	"PUT /api/me/addresses/:id":    "",
+	"DELETE /api/me/addresses/:id": "",
+
+	"POST /api/products/":                auth.ProductCreateCredential,
+	"PUT /api/products/:id":              auth.ProductUpdateCredential,
+	"DELETE /api/products/:id":           auth.ProductDeleteCredential,
+	"PUT /api/products/:id/categories":   auth.ProductUpdateCredential,
+	"PUT /api/products/:id/variants":     auth.ProductUpdateCredential,
+	"GET /api/products":                  auth.ProductReadCredential,
+	"GET /api/products/search":           auth.ProductReadCredential,
+	"GET /api/products/:id":              auth.ProductReadCredential,
+	"POST /api/categories":               auth.ProductCreateCredential,
+	"PUT /api/categories/:id":            auth.ProductUpdateCredential,
+	"DELETE /api/categories/:id":         auth.ProductDeleteCredential,
+	"GET /api/categories":                auth.ProductReadCredential,
+	"GET /api/categories/:slug/products": auth.ProductReadCredential,
+	"POST /api/orders":                   auth.OrderCreateCredential,
+	"POST /api/orders/quote":             auth.OrderCreateCredential,
+	"GET /api/orders":                    auth.OrderReadCredential,
+	"PUT /api/orders/:id/cancel":         auth.OrderCancelCredential,
+	"GET /api/orders/:id/history":        auth.OrderReadCredential,
+	"PUT /api/orders/:id/status":         auth.OrderUpdateCredential,
+	"GET /api/cart":                      auth.OrderCreateCredential,
+	"DELETE /api/cart":                   auth.OrderCreateCredential,
+	"POST /api/cart/items":               auth.OrderCreateCredential,
+	"PATCH /api/cart/items/:variant_id":  auth.OrderCreateCredential,
+	"DELETE /api/cart/items/:variant_id": auth.OrderCreateCredential,
+	"POST /api/cart/checkout":            auth.OrderCreateCredential,
+	"GET /api/admin/permissions":         auth.RoleManageCredential,
+	"GET /api/admin/roles":               auth.RoleManageCredential,
+	"POST /api/admin/roles":              auth.RoleManageCredential,
+	"PUT /api/admin/roles/:name":         auth.RoleManageCredential,
+	"DELETE /api/admin/roles/:name":      auth.RoleManageCredential,
+	"PUT /api/admin/users/:id/role":      auth.RoleManageCredential,
+
+	"GET /api/admin/users":                auth.UserManageCredential,
+	"GET /api/admin/users/:id":            auth.UserManageCredential,
+	"POST /api/admin/users/:id/suspend":   auth.UserManageCredential,
+	"POST /api/admin/users/:id/unsuspend": auth.UserManageCredential,
+	"GET /api/admin/audit-log":            auth.AuditReadCredential,
+
+	"POST /api/orders/:id/pay": auth.OrderCreateCredential,
+
+	"GET /api/admin/payment-events":             auth.OrderUpdateCredential,
+	"POST /api/admin/payment-events/:id/replay": auth.OrderUpdateCredential,
+
+	"POST /api/orders/:id/refunds":                  auth.OrderRefundCredential,
+	"GET /api/orders/:id/refunds":                   auth.OrderRefundCredential,
+	"POST /api/orders/:id/refunds/:refund_id/retry": auth.OrderRefundCredential,
+
+	"POST /api/admin/promotions":       auth.PromotionManageCredential,
+	"GET /api/admin/promotions":        auth.PromotionManageCredential,
+	"GET /api/admin/promotions/:id":    auth.PromotionManageCredential,
+	"PUT /api/admin/promotions/:id":    auth.PromotionManageCredential,
+	"DELETE /api/admin/promotions/:id": auth.PromotionManageCredential,
+}
+
+var allPermissions = []string{
+	auth.ProductCreateCredential,
+	auth.ProductReadCredential,
+	auth.ProductUpdateCredential,
+	auth.ProductDeleteCredential,
+	auth.OrderCreateCredential,
+	auth.OrderReadCredential,
+	auth.OrderUpdateCredential,
+	auth.OrderCancelCredential,
+	auth.OrderRefundCredential,
+	auth.PromotionManageCredential,
+	auth.RoleManageCredential,
+	auth.UserManageCredential,
+	auth.AuditReadCredential,
+}
+
+// testRouter mounts the authenticated routes behind a stand-in for
+// JWTProtected that takes the caller's permissions from a header. Handlers run
+// without a database, so requests that get past the guards may fail in any
+// way other than 401 or 403.
+func testRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, _ any) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
+
+	authenticate := func(c *gin.Context) {
+		permissions := make(map[string]bool)
+		for _, permission := range strings.Split(c.GetHeader("X-Test-Permissions"), ",") {
+			if permission != "" {
+				permissions[permission] = true
+			}
+		}
+		auth.SetPrincipal(c, &auth.Principal{UserID: uuid.New(), Role: "test", Permissions: permissions})
+	}
+
+	a := api.API{}
+	RegisterSessionRoutes(router.Group("/api/auth", authenticate), a)
+	protected := router.Group("/api", authenticate)
+	{
+		RegisterAccountRoutes(protected, a)
+		RegisterProductRoutes(protected, a)
+		RegisterOrderRoutes(protected, a)
+		RegisterCartRoutes(protected, a)
+		RegisterRoleRoutes(protected.Group("/admin"), a)
+		RegisterUserAdminRoutes(protected.Group("/admin"), a)
+		RegisterPaymentEventRoutes(protected.Group("/admin"), a)
+		RegisterPromotionRoutes(protected.Group("/admin"), a)
+	}
+	return router
+}
+
+var pathParam = regexp.MustCompile(`:[a-z_]+`)
+
+func serve(router *gin.Engine, method, path string, permissions ...string) int {
+	req := httptest.NewRequest(method, pathParam.ReplaceAllString(path, uuid.Nil.String()), nil)
+	req.Header.Set("X-Test-Permissions", strings.Join(permissions, ","))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRoutePermissions(t *testing.T) {
+	router := testRouter()
+
+	registered := make(map[string]bool)
+	for _, route := range router.Routes() {
+		key := route.Method + " " + route.Path
+		registered[key] = true
+
+		permission, ok := routePermissions[key]
+		if !assert.True(t, ok, "route %s is missing from the permission table", key) {
+			continue
+		}
+
+		if permission == "" {
+			assert.NotEqual(t, http.StatusForbidden, serve(router, route.Method, route.Path), key)
+			continue
+		}
+
+		var others []string
+		for _, p := range allPermissions {
+			if p != permission {
+				others = append(others, p)
+			}
+		}
+		assert.Equal(t, http.StatusForbidden, serve(router, route.Method, route.Path, others...),
+			"%s must require %s", key, permission)
+
+		code := serve(router, route.Method, route.Path, permission)
+		assert.NotEqual(t, http.StatusForbidden, code, "%s must be allowed with %s", key, permission)
+		assert.NotEqual(t, http.StatusUnauthorized, code, key)
+	}
+
+	for key := range routePermissions {
+		assert.True(t, registered[key], "route %s is not registered", key)
+	}
+}
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Cart Table (one persistent cart per user)
+CREATE TABLE "cart" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES "user"(id) ON DELETE CASCADE
+);
+
+-- Cart Items Table
+CREATE TABLE "cart_item" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    cart_id UUID NOT NULL,
+    product_id UUID NOT NULL,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (cart_id) REFERENCES "cart"(id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES "product"(id) ON DELETE CASCADE,
+    UNIQUE (cart_id, product_id)
+);
+CREATE INDEX idx_cart_item_product_id ON "cart_item"(product_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS "cart_item";
+DROP TABLE IF EXISTS "cart";
+-- +goose StatementEnd
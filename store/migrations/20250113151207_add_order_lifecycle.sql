@@ -0,0 +1,47 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Replace the order status enum with the full order lifecycle. Existing
+-- 'completed' orders become 'delivered'.
+ALTER TABLE "order" ALTER COLUMN status DROP DEFAULT;
+ALTER TYPE order_status RENAME TO order_status_old;
+CREATE TYPE order_status AS ENUM ('pending', 'paid', 'fulfilling', 'shipped', 'delivered', 'cancelled', 'refunded');
+ALTER TABLE "order" ALTER COLUMN status TYPE order_status
+    USING (CASE status::text WHEN 'completed' THEN 'delivered' ELSE status::text END)::order_status;
+ALTER TABLE "order" ALTER COLUMN status SET DEFAULT 'pending';
+DROP TYPE order_status_old;
+
+-- Order Status History Table
+CREATE TABLE "order_status_history" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    order_id UUID NOT NULL,
+    from_status order_status,
+    to_status order_status NOT NULL,
+    changed_by UUID,
+    reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES "order"(id) ON DELETE CASCADE,
+    FOREIGN KEY (changed_by) REFERENCES "user"(id) ON DELETE SET NULL
+);
+CREATE INDEX idx_order_status_history_order_id ON "order_status_history"(order_id, created_at);
+
+-- Record the current status of existing orders as their first history entry
+INSERT INTO "order_status_history" (order_id, from_status, to_status, reason, created_at)
+SELECT id, NULL, status, 'recorded on migration', created_at FROM "order";
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS "order_status_history";
+
+ALTER TABLE "order" ALTER COLUMN status DROP DEFAULT;
+ALTER TYPE order_status RENAME TO order_status_new;
+CREATE TYPE order_status AS ENUM ('pending', 'completed', 'cancelled');
+ALTER TABLE "order" ALTER COLUMN status TYPE order_status
+    USING (CASE status::text
+        WHEN 'pending' THEN 'pending'
+        WHEN 'cancelled' THEN 'cancelled'
+        WHEN 'refunded' THEN 'cancelled'
+        ELSE 'completed' END)::order_status;
+ALTER TABLE "order" ALTER COLUMN status SET DEFAULT 'pending';
+DROP TYPE order_status_new;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Keyset pagination indexes, one per sort order. The id column breaks ties
+-- so every page boundary is unique.
+DROP INDEX IF EXISTS idx_product_name;
+CREATE INDEX idx_product_name_id ON "product"(name, id);
+CREATE INDEX idx_product_price_id ON "product"(price, id);
+CREATE INDEX idx_product_created_at_id ON "product"(created_at, id);
+
+-- Substring search on product names
+CREATE INDEX idx_product_name_trgm ON "product" USING GIN (name gin_trgm_ops);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_product_name_trgm;
+DROP INDEX IF EXISTS idx_product_created_at_id;
+DROP INDEX IF EXISTS idx_product_price_id;
+DROP INDEX IF EXISTS idx_product_name_id;
+CREATE INDEX idx_product_name ON "product"(name);
+-- +goose StatementEnd
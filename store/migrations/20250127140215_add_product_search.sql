@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Generated column, so Postgres keeps it current on every insert and update.
+ALTER TABLE "product" ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(description, '')), 'B')
+    ) STORED;
+CREATE INDEX idx_product_search_vector ON "product" USING GIN (search_vector);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_product_search_vector;
+ALTER TABLE "product" DROP COLUMN IF EXISTS search_vector;
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Category Table (adjacency list; a NULL parent is a top-level category)
+CREATE TABLE "category" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    parent_id UUID,
+    name VARCHAR(100) NOT NULL,
+    slug VARCHAR(120) NOT NULL UNIQUE,
+    description TEXT,
+    position INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (parent_id) REFERENCES "category"(id) ON DELETE RESTRICT,
+    CHECK (parent_id IS NULL OR parent_id <> id)
+);
+CREATE INDEX idx_category_parent_id ON "category"(parent_id, position);
+
+-- Product <-> Category link table
+CREATE TABLE "product_category" (
+    product_id UUID NOT NULL,
+    category_id UUID NOT NULL,
+    PRIMARY KEY (product_id, category_id),
+    FOREIGN KEY (product_id) REFERENCES "product"(id) ON DELETE CASCADE,
+    FOREIGN KEY (category_id) REFERENCES "category"(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_product_category_category_id ON "product_category"(category_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS "product_category";
+DROP TABLE IF EXISTS "category";
+-- +goose StatementEnd
@@ -0,0 +1,96 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Product Option Table (e.g. size: S, M, L)
+CREATE TABLE "product_option" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    "values" TEXT[] NOT NULL,
+    position INT NOT NULL DEFAULT 0,
+    FOREIGN KEY (product_id) REFERENCES "product"(id) ON DELETE CASCADE,
+    UNIQUE (product_id, name)
+);
+
+-- Product Variant Table (the sellable unit). A NULL price inherits the
+-- product price.
+CREATE TABLE "product_variant" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID NOT NULL,
+    sku VARCHAR(64) NOT NULL UNIQUE,
+    barcode VARCHAR(64) UNIQUE,
+    price NUMERIC(10, 2) CHECK (price >= 0),
+    units_in_stock INT NOT NULL DEFAULT 0 CHECK (units_in_stock >= 0),
+    options JSONB NOT NULL DEFAULT '{}',
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (product_id) REFERENCES "product"(id) ON DELETE CASCADE,
+    UNIQUE (product_id, options)
+);
+CREATE UNIQUE INDEX idx_product_variant_default ON "product_variant"(product_id) WHERE is_default;
+
+-- Every existing product becomes a product with a single default variant.
+INSERT INTO "product_variant" (product_id, sku, units_in_stock, is_default, created_at, updated_at)
+SELECT id, 'SKU-' || upper(substr(replace(id::text, '-', ''), 1, 12)), GREATEST(units_in_stock, 0), TRUE, created_at, updated_at
+FROM "product";
+
+-- product.units_in_stock is now the total over the product's variants and is
+-- kept in step by this trigger.
+CREATE FUNCTION sync_product_units_in_stock() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' OR (TG_OP = 'UPDATE' AND NEW.product_id <> OLD.product_id) THEN
+        UPDATE "product"
+        SET units_in_stock = (SELECT COALESCE(SUM(units_in_stock), 0) FROM "product_variant" WHERE product_id = OLD.product_id)
+        WHERE id = OLD.product_id;
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        UPDATE "product"
+        SET units_in_stock = (SELECT COALESCE(SUM(units_in_stock), 0) FROM "product_variant" WHERE product_id = NEW.product_id)
+        WHERE id = NEW.product_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER product_variant_units_in_stock
+AFTER INSERT OR UPDATE OF product_id, units_in_stock OR DELETE ON "product_variant"
+FOR EACH ROW EXECUTE FUNCTION sync_product_units_in_stock();
+
+-- Order lines reference the variant that was sold and keep its SKU, so the
+-- order stays readable if the variant is removed later.
+ALTER TABLE "order_item" ADD COLUMN variant_id UUID REFERENCES "product_variant"(id) ON DELETE SET NULL;
+ALTER TABLE "order_item" ADD COLUMN sku VARCHAR(64);
+UPDATE "order_item" oi
+SET variant_id = v.id, sku = v.sku
+FROM "product_variant" v
+WHERE v.product_id = oi.product_id AND v.is_default;
+ALTER TABLE "order_item" ALTER COLUMN sku SET NOT NULL;
+CREATE INDEX idx_order_item_variant_id ON "order_item"(variant_id);
+
+-- Cart lines are per variant, so a cart can hold two sizes of one product.
+ALTER TABLE "cart_item" ADD COLUMN variant_id UUID REFERENCES "product_variant"(id) ON DELETE CASCADE;
+UPDATE "cart_item" ci
+SET variant_id = v.id
+FROM "product_variant" v
+WHERE v.product_id = ci.product_id AND v.is_default;
+ALTER TABLE "cart_item" ALTER COLUMN variant_id SET NOT NULL;
+ALTER TABLE "cart_item" DROP CONSTRAINT cart_item_cart_id_product_id_key;
+ALTER TABLE "cart_item" ADD CONSTRAINT cart_item_cart_id_variant_id_key UNIQUE (cart_id, variant_id);
+CREATE INDEX idx_cart_item_variant_id ON "cart_item"(variant_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM "cart_item" a
+USING "cart_item" b
+WHERE a.cart_id = b.cart_id AND a.product_id = b.product_id AND a.id > b.id;
+ALTER TABLE "cart_item" DROP CONSTRAINT cart_item_cart_id_variant_id_key;
+ALTER TABLE "cart_item" ADD CONSTRAINT cart_item_cart_id_product_id_key UNIQUE (cart_id, product_id);
+ALTER TABLE "cart_item" DROP COLUMN IF EXISTS variant_id;
+ALTER TABLE "order_item" DROP COLUMN IF EXISTS sku;
+ALTER TABLE "order_item" DROP COLUMN IF EXISTS variant_id;
+DROP TRIGGER IF EXISTS product_variant_units_in_stock ON "product_variant";
+DROP FUNCTION IF EXISTS sync_product_units_in_stock();
+DROP TABLE IF EXISTS "product_variant";
+DROP TABLE IF EXISTS "product_option";
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Refresh Token Table. Only the SHA-256 of a token is stored. Tokens that
+-- descend from the same login share a family_id.
+CREATE TABLE "refresh_token" (
+    token_hash CHAR(64) PRIMARY KEY,
+    family_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    device_id VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES "user"(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_refresh_token_family_id ON "refresh_token"(family_id);
+CREATE INDEX idx_refresh_token_user_id ON "refresh_token"(user_id);
+CREATE INDEX idx_refresh_token_expires_at ON "refresh_token"(expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS "refresh_token";
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Access tokens revoked before their expiry, by jti.
+CREATE TABLE "access_token_denylist" (
+    token_id VARCHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX idx_access_token_denylist_expires_at ON "access_token_denylist"(expires_at);
+
+-- Per-user revocation of every access token issued before revoked_before.
+CREATE TABLE "user_token_revocation" (
+    user_id UUID PRIMARY KEY,
+    revoked_before TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES "user"(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS "user_token_revocation";
+DROP TABLE IF EXISTS "access_token_denylist";
+-- +goose StatementEnd
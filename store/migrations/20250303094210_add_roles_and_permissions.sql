@@ -0,0 +1,69 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Role Table (user and admin are system roles and cannot be deleted)
+CREATE TABLE "role" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(50) NOT NULL UNIQUE,
+    description TEXT,
+    is_system BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Permission Table (names match the permission checks in the API)
+CREATE TABLE "permission" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(100) NOT NULL UNIQUE,
+    description TEXT
+);
+
+-- Role <-> Permission link table
+CREATE TABLE "role_permission" (
+    role_id UUID NOT NULL,
+    permission_id UUID NOT NULL,
+    PRIMARY KEY (role_id, permission_id),
+    FOREIGN KEY (role_id) REFERENCES "role"(id) ON DELETE CASCADE,
+    FOREIGN KEY (permission_id) REFERENCES "permission"(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_role_permission_permission_id ON "role_permission"(permission_id);
+
+INSERT INTO "permission" (name, description) VALUES
+    ('product:create', 'Create products and categories'),
+    ('product:read', 'Browse the catalog'),
+    ('product:update', 'Edit products, variants and categories'),
+    ('product:delete', 'Delete products and categories'),
+    ('order:create', 'Use a cart and place orders'),
+    ('order:read', 'View orders'),
+    ('order:update', 'Change the status of any order'),
+    ('order:cancel', 'Cancel orders'),
+    ('role:manage', 'Manage roles and assign them to users');
+
+INSERT INTO "role" (name, description, is_system) VALUES
+    ('user', 'Customer account', TRUE),
+    ('admin', 'Full access', TRUE);
+
+-- Same grants as the credentials previously hard-coded per role
+INSERT INTO "role_permission" (role_id, permission_id)
+SELECT r.id, p.id
+FROM "role" r
+JOIN "permission" p ON p.name IN ('order:create', 'order:read', 'order:cancel')
+WHERE r.name = 'user';
+
+INSERT INTO "role_permission" (role_id, permission_id)
+SELECT r.id, p.id
+FROM "role" r
+CROSS JOIN "permission" p
+WHERE r.name = 'admin';
+
+-- Every user must hold an existing role
+ALTER TABLE "user" ADD CONSTRAINT fk_user_role
+    FOREIGN KEY (role) REFERENCES "role"(name) ON DELETE RESTRICT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "user" DROP CONSTRAINT IF EXISTS fk_user_role;
+DROP TABLE IF EXISTS "role_permission";
+DROP TABLE IF EXISTS "permission";
+DROP TABLE IF EXISTS "role";
+-- +goose StatementEnd
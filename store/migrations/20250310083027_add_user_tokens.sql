@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Single-use tokens emailed to users, such as password reset links. Only the
+-- SHA-256 hash of a token is stored.
+CREATE TABLE "user_token" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL,
+    purpose VARCHAR(30) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES "user"(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_user_token_user_purpose ON "user_token"(user_id, purpose, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS "user_token";
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Accounts created before verification existed are treated as verified.
+ALTER TABLE "user" ADD COLUMN email_verified_at TIMESTAMP;
+UPDATE "user" SET email_verified_at = created_at;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "user" DROP COLUMN IF EXISTS email_verified_at;
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+-- TOTP second factor of a user. enabled_at stays NULL until the user proves
+-- their authenticator works; last_used_step keeps a code from being replayed.
+CREATE TABLE "user_mfa" (
+    user_id UUID PRIMARY KEY,
+    secret VARCHAR(64) NOT NULL,
+    enabled_at TIMESTAMP,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    failed_attempts INT NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES "user"(id) ON DELETE CASCADE
+);
+
+-- Single-use recovery codes for when the authenticator is lost. Only the
+-- SHA-256 hash of a code is stored.
+CREATE TABLE "user_recovery_code" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES "user"(id) ON DELETE CASCADE,
+    UNIQUE (user_id, code_hash)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS "user_recovery_code";
+DROP TABLE IF EXISTS "user_mfa";
+-- +goose StatementEnd
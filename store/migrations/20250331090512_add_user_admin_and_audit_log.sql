@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Suspended accounts cannot log in until an admin lifts the suspension.
+ALTER TABLE "user" ADD COLUMN suspended_at TIMESTAMP;
+ALTER TABLE "user" ADD COLUMN suspension_reason TEXT;
+CREATE INDEX idx_user_created_at ON "user"(created_at DESC, id DESC);
+
+-- Audit trail of administrative changes. actor_id is NULL for changes not
+-- made by a user, such as the admin bootstrap.
+CREATE TABLE "audit_log" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    actor_id UUID,
+    action VARCHAR(100) NOT NULL,
+    target_type VARCHAR(50) NOT NULL,
+    target_id VARCHAR(255) NOT NULL,
+    details JSONB NOT NULL DEFAULT '{}',
+    ip_address VARCHAR(45),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (actor_id) REFERENCES "user"(id) ON DELETE SET NULL
+);
+CREATE INDEX idx_audit_log_created_at ON "audit_log"(created_at DESC, id DESC);
+CREATE INDEX idx_audit_log_target ON "audit_log"(target_type, target_id);
+CREATE INDEX idx_audit_log_actor_id ON "audit_log"(actor_id);
+
+INSERT INTO "permission" (name, description) VALUES
+    ('user:manage', 'List, search and suspend user accounts'),
+    ('audit:read', 'Read the audit log');
+
+INSERT INTO "role_permission" (role_id, permission_id)
+SELECT r.id, p.id
+FROM "role" r
+JOIN "permission" p ON p.name IN ('user:manage', 'audit:read')
+WHERE r.name = 'admin';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM "permission" WHERE name IN ('user:manage', 'audit:read');
+DROP TABLE IF EXISTS "audit_log";
+DROP INDEX IF EXISTS idx_user_created_at;
+ALTER TABLE "user" DROP COLUMN IF EXISTS suspension_reason;
+ALTER TABLE "user" DROP COLUMN IF EXISTS suspended_at;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Deleted accounts keep their row, stripped of personal data, so that their
+-- orders stay intact.
+ALTER TABLE "user" ADD COLUMN deleted_at TIMESTAMP;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "user" DROP COLUMN IF EXISTS deleted_at;
+-- +goose StatementEnd
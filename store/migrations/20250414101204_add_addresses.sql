@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Address book of each user
+CREATE TABLE "address" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL,
+    label VARCHAR(50),
+    full_name VARCHAR(200) NOT NULL,
+    company VARCHAR(200),
+    line1 VARCHAR(200) NOT NULL,
+    line2 VARCHAR(200),
+    city VARCHAR(100) NOT NULL,
+    region VARCHAR(100),
+    postal_code VARCHAR(20) NOT NULL DEFAULT '',
+    country CHAR(2) NOT NULL,
+    phone VARCHAR(32),
+    is_default_shipping BOOLEAN NOT NULL DEFAULT FALSE,
+    is_default_billing BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES "user"(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_address_user_id ON "address"(user_id, created_at);
+-- A user has at most one default address of each kind
+CREATE UNIQUE INDEX idx_address_default_shipping ON "address"(user_id) WHERE is_default_shipping;
+CREATE UNIQUE INDEX idx_address_default_billing ON "address"(user_id) WHERE is_default_billing;
+
+-- Orders keep a copy of the addresses they were placed with, so that later
+-- edits to the address book do not change them. Orders placed before
+-- addresses existed have none.
+ALTER TABLE "order" ADD COLUMN shipping_address JSONB;
+ALTER TABLE "order" ADD COLUMN billing_address JSONB;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "order" DROP COLUMN IF EXISTS billing_address;
+ALTER TABLE "order" DROP COLUMN IF EXISTS shipping_address;
+DROP TABLE IF EXISTS "address";
+-- +goose StatementEnd
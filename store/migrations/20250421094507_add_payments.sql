@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Payments of orders at the payment provider. A payment is pending while the
+-- provider is being called, and provider_ref is the provider's intent ID.
+CREATE TABLE "payment" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    order_id UUID NOT NULL,
+    provider VARCHAR(50) NOT NULL,
+    provider_ref VARCHAR(255),
+    amount NUMERIC(10, 2) NOT NULL CHECK (amount >= 0),
+    currency CHAR(3) NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('pending', 'requires_action', 'authorized', 'captured', 'voided', 'failed', 'refunded')),
+    failure_reason TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES "order"(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_payment_order_id ON "payment"(order_id, created_at);
+CREATE UNIQUE INDEX idx_payment_provider_ref ON "payment"(provider, provider_ref);
+-- An order has at most one payment that may still take or has taken money,
+-- so that concurrent attempts cannot charge it twice
+CREATE UNIQUE INDEX idx_payment_order_active ON "payment"(order_id)
+    WHERE status IN ('pending', 'requires_action', 'authorized', 'captured');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS "payment";
+-- +goose StatementEnd
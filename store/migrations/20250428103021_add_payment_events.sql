@@ -0,0 +1,65 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Webhook events received from payment providers, kept as they arrived so
+-- that they can be processed again. A provider may deliver an event more than
+-- once, so events are unique by provider and event ID.
+CREATE TABLE "payment_event" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    provider VARCHAR(50) NOT NULL,
+    event_id VARCHAR(255) NOT NULL,
+    type VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    received_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    -- claimed_at is set while a delivery is being processed, so that a
+    -- concurrent delivery of the same event does not process it twice
+    claimed_at TIMESTAMP,
+    processed_at TIMESTAMP,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    UNIQUE (provider, event_id)
+);
+CREATE INDEX idx_payment_event_received_at ON "payment_event"(received_at);
+
+-- Disputed payments and orders
+ALTER TABLE "payment" DROP CONSTRAINT payment_status_check;
+ALTER TABLE "payment" ADD CONSTRAINT payment_status_check
+    CHECK (status IN ('pending', 'requires_action', 'authorized', 'captured', 'voided', 'failed', 'refunded', 'disputed'));
+DROP INDEX idx_payment_order_active;
+CREATE UNIQUE INDEX idx_payment_order_active ON "payment"(order_id)
+    WHERE status IN ('pending', 'requires_action', 'authorized', 'captured', 'disputed');
+
+ALTER TABLE "order" ALTER COLUMN status DROP DEFAULT;
+ALTER TYPE order_status RENAME TO order_status_old;
+CREATE TYPE order_status AS ENUM ('pending', 'paid', 'fulfilling', 'shipped', 'delivered', 'cancelled', 'refunded', 'disputed');
+ALTER TABLE "order" ALTER COLUMN status TYPE order_status USING status::text::order_status;
+ALTER TABLE "order_status_history" ALTER COLUMN from_status TYPE order_status USING from_status::text::order_status;
+ALTER TABLE "order_status_history" ALTER COLUMN to_status TYPE order_status USING to_status::text::order_status;
+ALTER TABLE "order" ALTER COLUMN status SET DEFAULT 'pending';
+DROP TYPE order_status_old;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Disputed orders go back to paid
+ALTER TABLE "order" ALTER COLUMN status DROP DEFAULT;
+ALTER TYPE order_status RENAME TO order_status_new;
+CREATE TYPE order_status AS ENUM ('pending', 'paid', 'fulfilling', 'shipped', 'delivered', 'cancelled', 'refunded');
+ALTER TABLE "order" ALTER COLUMN status TYPE order_status
+    USING (CASE status::text WHEN 'disputed' THEN 'paid' ELSE status::text END)::order_status;
+ALTER TABLE "order_status_history" ALTER COLUMN from_status TYPE order_status
+    USING (CASE from_status::text WHEN 'disputed' THEN 'paid' ELSE from_status::text END)::order_status;
+ALTER TABLE "order_status_history" ALTER COLUMN to_status TYPE order_status
+    USING (CASE to_status::text WHEN 'disputed' THEN 'paid' ELSE to_status::text END)::order_status;
+ALTER TABLE "order" ALTER COLUMN status SET DEFAULT 'pending';
+DROP TYPE order_status_new;
+
+DROP INDEX idx_payment_order_active;
+CREATE UNIQUE INDEX idx_payment_order_active ON "payment"(order_id)
+    WHERE status IN ('pending', 'requires_action', 'authorized', 'captured');
+UPDATE "payment" SET status = 'captured' WHERE status = 'disputed';
+ALTER TABLE "payment" DROP CONSTRAINT payment_status_check;
+ALTER TABLE "payment" ADD CONSTRAINT payment_status_check
+    CHECK (status IN ('pending', 'requires_action', 'authorized', 'captured', 'voided', 'failed', 'refunded'));
+
+DROP TABLE IF EXISTS "payment_event";
+-- +goose StatementEnd
@@ -0,0 +1,77 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Refunds of paid orders. A refund is pending while the payment provider is
+-- being called; pending refunds count against what is left to refund, so that
+-- concurrent refunds can never add up to more than the order total.
+CREATE TABLE "refund" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    order_id UUID NOT NULL,
+    payment_id UUID NOT NULL,
+    amount NUMERIC(10, 2) NOT NULL CHECK (amount > 0),
+    reason TEXT NOT NULL,
+    restock BOOLEAN NOT NULL DEFAULT FALSE,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'succeeded', 'failed')),
+    provider_ref VARCHAR(255),
+    failure_reason TEXT,
+    created_by UUID,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES "order"(id) ON DELETE CASCADE,
+    FOREIGN KEY (payment_id) REFERENCES "payment"(id) ON DELETE CASCADE,
+    FOREIGN KEY (created_by) REFERENCES "user"(id) ON DELETE SET NULL
+);
+CREATE INDEX idx_refund_order_id ON "refund"(order_id);
+
+-- The order lines a refund gives money back for
+CREATE TABLE "refund_item" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    refund_id UUID NOT NULL,
+    order_item_id UUID NOT NULL,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    amount NUMERIC(10, 2) NOT NULL CHECK (amount >= 0),
+    FOREIGN KEY (refund_id) REFERENCES "refund"(id) ON DELETE CASCADE,
+    FOREIGN KEY (order_item_id) REFERENCES "order_item"(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_refund_item_refund_id ON "refund_item"(refund_id);
+CREATE INDEX idx_refund_item_order_item_id ON "refund_item"(order_item_id);
+
+ALTER TABLE "order" ALTER COLUMN status DROP DEFAULT;
+ALTER TYPE order_status RENAME TO order_status_old;
+CREATE TYPE order_status AS ENUM ('pending', 'paid', 'fulfilling', 'shipped', 'delivered', 'cancelled', 'refunded', 'disputed', 'partially_refunded');
+ALTER TABLE "order" ALTER COLUMN status TYPE order_status USING status::text::order_status;
+ALTER TABLE "order_status_history" ALTER COLUMN from_status TYPE order_status USING from_status::text::order_status;
+ALTER TABLE "order_status_history" ALTER COLUMN to_status TYPE order_status USING to_status::text::order_status;
+ALTER TABLE "order" ALTER COLUMN status SET DEFAULT 'pending';
+DROP TYPE order_status_old;
+
+INSERT INTO "permission" (name, description) VALUES
+    ('order:refund', 'Refund orders');
+
+INSERT INTO "role_permission" (role_id, permission_id)
+SELECT r.id, p.id
+FROM "role" r
+JOIN "permission" p ON p.name = 'order:refund'
+WHERE r.name = 'admin';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM "permission" WHERE name = 'order:refund';
+
+-- Partially refunded orders go back to paid
+ALTER TABLE "order" ALTER COLUMN status DROP DEFAULT;
+ALTER TYPE order_status RENAME TO order_status_new;
+CREATE TYPE order_status AS ENUM ('pending', 'paid', 'fulfilling', 'shipped', 'delivered', 'cancelled', 'refunded', 'disputed');
+ALTER TABLE "order" ALTER COLUMN status TYPE order_status
+    USING (CASE status::text WHEN 'partially_refunded' THEN 'paid' ELSE status::text END)::order_status;
+ALTER TABLE "order_status_history" ALTER COLUMN from_status TYPE order_status
+    USING (CASE from_status::text WHEN 'partially_refunded' THEN 'paid' ELSE from_status::text END)::order_status;
+ALTER TABLE "order_status_history" ALTER COLUMN to_status TYPE order_status
+    USING (CASE to_status::text WHEN 'partially_refunded' THEN 'paid' ELSE to_status::text END)::order_status;
+ALTER TABLE "order" ALTER COLUMN status SET DEFAULT 'pending';
+DROP TYPE order_status_new;
+
+DROP TABLE IF EXISTS "refund_item";
+DROP TABLE IF EXISTS "refund";
+-- +goose StatementEnd
@@ -0,0 +1,98 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Promotions are applied to an order by their code. value is the percentage
+-- off for percentage promotions and the amount off for fixed ones; buy_x_get_y
+-- promotions give get_quantity units free for every buy_quantity bought.
+CREATE TABLE "promotion" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    code VARCHAR(50) NOT NULL,
+    description TEXT,
+    type VARCHAR(20) NOT NULL CHECK (type IN ('percentage', 'fixed', 'buy_x_get_y', 'free_shipping')),
+    value NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (value >= 0),
+    buy_quantity INT CHECK (buy_quantity > 0),
+    get_quantity INT CHECK (get_quantity > 0),
+    min_subtotal NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (min_subtotal >= 0),
+    starts_at TIMESTAMP,
+    ends_at TIMESTAMP,
+    -- NULL limits are unlimited
+    usage_limit INT CHECK (usage_limit > 0),
+    per_user_limit INT CHECK (per_user_limit > 0),
+    -- Only stackable promotions can be combined with other promotions
+    stackable BOOLEAN NOT NULL DEFAULT FALSE,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (type <> 'percentage' OR value <= 100),
+    CHECK (type <> 'buy_x_get_y' OR (buy_quantity IS NOT NULL AND get_quantity IS NOT NULL)),
+    CHECK (ends_at IS NULL OR starts_at IS NULL OR ends_at > starts_at)
+);
+CREATE UNIQUE INDEX idx_promotion_code ON "promotion"(UPPER(code));
+
+-- A promotion scoped to products or categories only discounts matching
+-- lines; one without either applies to the whole order.
+CREATE TABLE "promotion_product" (
+    promotion_id UUID NOT NULL,
+    product_id UUID NOT NULL,
+    PRIMARY KEY (promotion_id, product_id),
+    FOREIGN KEY (promotion_id) REFERENCES "promotion"(id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES "product"(id) ON DELETE CASCADE
+);
+
+CREATE TABLE "promotion_category" (
+    promotion_id UUID NOT NULL,
+    category_id UUID NOT NULL,
+    PRIMARY KEY (promotion_id, category_id),
+    FOREIGN KEY (promotion_id) REFERENCES "promotion"(id) ON DELETE CASCADE,
+    FOREIGN KEY (category_id) REFERENCES "category"(id) ON DELETE CASCADE
+);
+
+-- The discounts applied to an order, kept as they were applied. They also
+-- count the uses of a promotion; uses by cancelled orders do not count.
+CREATE TABLE "order_discount" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    order_id UUID NOT NULL,
+    promotion_id UUID,
+    code VARCHAR(50) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    description TEXT,
+    amount NUMERIC(10, 2) NOT NULL CHECK (amount >= 0),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (order_id, promotion_id),
+    FOREIGN KEY (order_id) REFERENCES "order"(id) ON DELETE CASCADE,
+    FOREIGN KEY (promotion_id) REFERENCES "promotion"(id) ON DELETE SET NULL
+);
+CREATE INDEX idx_order_discount_promotion_id ON "order_discount"(promotion_id);
+
+-- total_amount = subtotal_amount - discount_amount + shipping_amount. An
+-- order line's discount_amount is its share of the discounts.
+ALTER TABLE "order"
+    ADD COLUMN subtotal_amount NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (subtotal_amount >= 0),
+    ADD COLUMN discount_amount NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (discount_amount >= 0),
+    ADD COLUMN shipping_amount NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (shipping_amount >= 0);
+UPDATE "order" SET subtotal_amount = total_amount;
+ALTER TABLE "order_item"
+    ADD COLUMN discount_amount NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (discount_amount >= 0);
+
+INSERT INTO "permission" (name, description) VALUES
+    ('promotion:manage', 'Create and edit promotions');
+
+INSERT INTO "role_permission" (role_id, permission_id)
+SELECT r.id, p.id
+FROM "role" r
+JOIN "permission" p ON p.name = 'promotion:manage'
+WHERE r.name = 'admin';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM "permission" WHERE name = 'promotion:manage';
+ALTER TABLE "order_item" DROP COLUMN IF EXISTS discount_amount;
+ALTER TABLE "order"
+    DROP COLUMN IF EXISTS shipping_amount,
+    DROP COLUMN IF EXISTS discount_amount,
+    DROP COLUMN IF EXISTS subtotal_amount;
+DROP TABLE IF EXISTS "order_discount";
+DROP TABLE IF EXISTS "promotion_category";
+DROP TABLE IF EXISTS "promotion_product";
+DROP TABLE IF EXISTS "promotion";
+-- +goose StatementEnd